@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/client"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"google.golang.org/protobuf/proto"
+)
+
+// clientOptions are the flags shared by the client commands
+type clientOptions struct {
+	configPath string
+	context    string
+	address    string
+	output     string
+	timeout    time.Duration
+}
+
+// addClientFlags registers the connection and output flags on a client
+// command group
+func addClientFlags(cmd *cobra.Command, opts *clientOptions) {
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&opts.configPath, "client-config", config.DefaultClientConfigPath(), "client config file path")
+	flags.StringVar(&opts.context, "context", "", "client context to use instead of the current one")
+	flags.StringVar(&opts.address, "address", "", "agent address, host:port or unix:///path (overrides the context)")
+	flags.StringVarP(&opts.output, "output", "o", string(client.FormatTable), "output format: table, json or yaml")
+	flags.DurationVar(&opts.timeout, "timeout", time.Minute, "timeout of each call")
+
+	// Arguments are validated by now, so failed calls don't print usage
+	cmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true // reported by main
+	}
+}
+
+// resolveContext returns the agent to talk to: the selected context, with
+// the address replaced by --address if given
+func (o *clientOptions) resolveContext() (*config.ClientContext, error) {
+	cfg, err := config.LoadClientConfig(o.configPath)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cfg.Context(o.context)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		c = &config.ClientContext{Address: client.DefaultAddress}
+	}
+	if o.address != "" {
+		c.Address = o.address
+	}
+	return c, nil
+}
+
+// run connects to the agent and calls fn with a context bounded by the
+// call timeout (or unbounded for streams) and cancelled on SIGINT/SIGTERM
+func (o *clientOptions) run(stream bool, fn func(ctx context.Context, c *client.Client, p *client.Printer) error) error {
+	format, err := client.ParseFormat(o.output)
+	if err != nil {
+		return err
+	}
+	cctx, err := o.resolveContext()
+	if err != nil {
+		return err
+	}
+	c, err := client.Dial(cctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if !stream && o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	return fn(ctx, c, client.NewPrinter(format, os.Stdout))
+}
+
+// printResult prints a lifecycle response and turns an error reported in
+// the response body into a command error
+func printResult(p *client.Printer, msg proto.Message, table func() client.Table, errorMessage string) error {
+	if err := p.Print(msg, table); err != nil {
+		return err
+	}
+	if errorMessage != "" {
+		return errors.New(errorMessage)
+	}
+	return nil
+}
+
+// newVMCmd creates the "vm" command group
+func newVMCmd() *cobra.Command {
+	opts := &clientOptions{}
+	cmd := &cobra.Command{
+		Use:   "vm",
+		Short: "Manage VMs on an agent",
+	}
+	addClientFlags(cmd, opts)
+
+	cmd.AddCommand(
+		newVMCreateCmd(opts),
+		newVMListCmd(opts),
+		&cobra.Command{
+			Use:   "get VM_ID",
+			Short: "Show a VM",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+					resp, err := c.GetVM(ctx, &pb.GetVMRequest{VmId: args[0]})
+					if err != nil {
+						return err
+					}
+					return p.Print(resp, func() client.Table { return client.VMTable(resp.Vm) })
+				})
+			},
+		},
+		&cobra.Command{
+			Use:   "history VM_ID",
+			Short: "Show the recent state transitions of a VM",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+					resp, err := c.GetVM(ctx, &pb.GetVMRequest{VmId: args[0]})
+					if err != nil {
+						return err
+					}
+					return p.Print(resp, func() client.Table { return client.TransitionTable(resp.Vm) })
+				})
+			},
+		},
+		&cobra.Command{
+			Use:   "start VM_ID",
+			Short: "Start a stopped VM",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+					resp, err := c.StartVM(ctx, &pb.StartVMRequest{VmId: args[0]})
+					if err != nil {
+						return err
+					}
+					return printResult(p, resp, func() client.Table { return client.VMStateTable(resp.VmId, resp.State) }, resp.ErrorMessage)
+				})
+			},
+		},
+		newVMStopCmd(opts),
+		newVMDeleteCmd(opts),
+		&cobra.Command{
+			Use:   "stats VM_ID",
+			Short: "Show resource usage of a VM",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+					resp, err := c.GetVMStats(ctx, &pb.GetVMStatsRequest{VmId: args[0]})
+					if err != nil {
+						return err
+					}
+					return p.Print(resp, func() client.Table { return client.StatsTable(resp.Stats) })
+				})
+			},
+		},
+	)
+	return cmd
+}
+
+func newVMCreateCmd(opts *clientOptions) *cobra.Command {
+	req := &pb.CreateVMRequest{}
+	var (
+		exclusive bool
+		numaNode  int32
+		restart   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create VM_ID",
+		Short: "Create and boot a VM",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req.VmId = args[0]
+			if exclusive || cmd.Flags().Changed("numa-node") {
+				req.Placement = &pb.PlacementRequest{Exclusive: exclusive}
+				if cmd.Flags().Changed("numa-node") {
+					req.Placement.NumaNode = &numaNode
+				}
+			}
+			if restart != "" {
+				policy, err := client.ParseRestartPolicy(restart)
+				if err != nil {
+					return err
+				}
+				req.RestartPolicy = policy
+			}
+
+			return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+				resp, err := c.CreateVM(ctx, req)
+				if err != nil {
+					return err
+				}
+				if resp.OperationId != "" {
+					return p.Print(resp, func() client.Table { return operationStartedTable(resp.VmId, resp.OperationId) })
+				}
+				return printResult(p, resp, func() client.Table { return client.VMStateTable(resp.VmId, resp.State) }, resp.ErrorMessage)
+			})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.Int32Var(&req.VcpuCount, "vcpus", 1, "number of vCPUs")
+	flags.Int32Var(&req.MemoryMb, "memory", 512, "memory in MiB")
+	flags.StringVar(&req.IpAddress, "ip", "", "guest IP address (allocated by the agent if empty)")
+	flags.StringVar(&req.KernelPath, "kernel", "", "kernel image (agent default if empty)")
+	flags.StringVar(&req.RootfsPath, "rootfs", "", "root filesystem image (agent default if empty)")
+	flags.StringToStringVarP(&req.Metadata, "label", "l", nil, "label as key=value, repeatable")
+	flags.BoolVar(&exclusive, "exclusive", false, "dedicate host CPUs to the VM's vCPUs")
+	flags.Int32Var(&numaNode, "numa-node", 0, "NUMA node to place the VM on")
+	flags.StringVar(&restart, "restart", "", "restart policy: never, on-failure[:max-retries] or always")
+	flags.StringVar(&req.RequestId, "request-id", "", "idempotency key, retries with the same key return the original result")
+	flags.BoolVar(&req.Async, "async", false, "return an operation ID instead of waiting for the VM to boot")
+	return cmd
+}
+
+func newVMDeleteCmd(opts *clientOptions) *cobra.Command {
+	req := &pb.DeleteVMRequest{}
+	cmd := &cobra.Command{
+		Use:   "delete VM_ID",
+		Short: "Delete a VM and its resources",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req.VmId = args[0]
+			return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+				resp, err := c.DeleteVM(ctx, req)
+				if err != nil {
+					return err
+				}
+				if resp.OperationId != "" {
+					return p.Print(resp, func() client.Table { return operationStartedTable(resp.VmId, resp.OperationId) })
+				}
+				return printResult(p, resp, func() client.Table {
+					return client.Table{Headers: []string{"ID", "DELETED"}, Rows: [][]string{{resp.VmId, fmt.Sprint(resp.Success)}}}
+				}, resp.ErrorMessage)
+			})
+		},
+	}
+	cmd.Flags().BoolVar(&req.Async, "async", false, "return an operation ID instead of waiting for the deletion")
+	return cmd
+}
+
+// operationStartedTable shows the operation an async call started
+func operationStartedTable(vmID, operationID string) client.Table {
+	return client.Table{Headers: []string{"ID", "OPERATION"}, Rows: [][]string{{vmID, operationID}}}
+}
+
+func newVMListCmd(opts *clientOptions) *cobra.Command {
+	var (
+		pageSize      int32
+		states        []string
+		createdAfter  time.Duration
+		createdBefore time.Duration
+	)
+	req := &pb.ListVMsRequest{}
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List VMs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+				vmStates, err := client.ParseVMStates(states)
+				if err != nil {
+					return err
+				}
+				req.States = vmStates
+				req.PageSize = pageSize
+				if createdAfter > 0 {
+					req.CreatedAfter = time.Now().Add(-createdAfter).Unix()
+				}
+				if createdBefore > 0 {
+					req.CreatedBefore = time.Now().Add(-createdBefore).Unix()
+				}
+
+				// Follow continuation tokens so the output covers every VM
+				all := &pb.ListVMsResponse{}
+				for {
+					resp, err := c.ListVMs(ctx, req)
+					if err != nil {
+						return err
+					}
+					all.Vms = append(all.Vms, resp.Vms...)
+					all.TotalCount = resp.TotalCount
+					if resp.NextPageToken == "" || resp.NextPageToken == req.PageToken {
+						break
+					}
+					req.PageToken = resp.NextPageToken
+				}
+				return p.Print(all, func() client.Table { return client.VMTable(all.Vms...) })
+			})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.Int32Var(&pageSize, "page-size", 100, "VMs fetched per call")
+	flags.StringSliceVar(&states, "state", nil, "only VMs in these states, e.g. running,error")
+	flags.StringVarP(&req.LabelSelector, "selector", "l", "", "only VMs whose labels match, e.g. env=prod,tier in (web,api)")
+	flags.StringVar(&req.NamePrefix, "prefix", "", "only VMs whose ID starts with this prefix")
+	flags.DurationVar(&createdAfter, "newer-than", 0, "only VMs created within this duration, e.g. 24h")
+	flags.DurationVar(&createdBefore, "older-than", 0, "only VMs created longer than this duration ago")
+	return cmd
+}
+
+func newVMStopCmd(opts *clientOptions) *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "stop VM_ID",
+		Short: "Stop a running VM",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+				resp, err := c.StopVM(ctx, &pb.StopVMRequest{VmId: args[0], Force: force})
+				if err != nil {
+					return err
+				}
+				return printResult(p, resp, func() client.Table { return client.VMStateTable(resp.VmId, resp.State) }, resp.ErrorMessage)
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "kill the VM instead of shutting it down")
+	return cmd
+}
+
+// newEventsCmd creates the "events" command group
+func newEventsCmd() *cobra.Command {
+	opts := &clientOptions{}
+	cmd := &cobra.Command{
+		Use:   "events",
+		Short: "Follow VM events",
+	}
+	addClientFlags(cmd, opts)
+
+	var (
+		vmID       string
+		since      uint64
+		types      []string
+		states     []string
+		selector   string
+		bufferSize uint32
+	)
+	watch := &cobra.Command{
+		Use:   "watch",
+		Short: "Stream VM lifecycle events until interrupted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(true, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+				eventTypes, err := client.ParseEventTypes(types)
+				if err != nil {
+					return err
+				}
+				vmStates, err := client.ParseVMStates(states)
+				if err != nil {
+					return err
+				}
+				req := &pb.WatchVMEventsRequest{
+					VmId:          vmID,
+					EventTypes:    eventTypes,
+					States:        vmStates,
+					LabelSelector: selector,
+					BufferSize:    bufferSize,
+				}
+				if cmd.Flags().Changed("since") {
+					req.SinceSequence = &since
+				}
+				stream, err := c.WatchVMEvents(ctx, req)
+				if err != nil {
+					return err
+				}
+				for {
+					event, err := stream.Recv()
+					if err == io.EOF || ctx.Err() != nil {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+					if err := p.PrintStream(event, func() client.Table { return client.EventTable(event) }); err != nil {
+						return err
+					}
+				}
+			})
+		},
+	}
+	watch.Flags().StringVar(&vmID, "vm", "", "only events of this VM")
+	watch.Flags().Uint64Var(&since, "since", 0, "replay retained events after this sequence first")
+	watch.Flags().StringSliceVar(&types, "type", nil, "only events of these types, e.g. crashed,deleted")
+	watch.Flags().StringSliceVar(&states, "state", nil, "only events reporting these VM states, e.g. error")
+	watch.Flags().StringVarP(&selector, "selector", "l", "", "only events of VMs matching this label selector")
+	watch.Flags().Uint32Var(&bufferSize, "buffer", 0, "events the agent queues for this watcher")
+
+	cmd.AddCommand(watch)
+	return cmd
+}
+
+// newHostCmd creates the "host" command group
+func newHostCmd() *cobra.Command {
+	opts := &clientOptions{}
+	cmd := &cobra.Command{
+		Use:   "host",
+		Short: "Inspect the agent host",
+	}
+	addClientFlags(cmd, opts)
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "info",
+			Short: "Show host capacity and allocation",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+					resp, err := c.GetHostInfo(ctx, &pb.GetHostInfoRequest{})
+					if err != nil {
+						return err
+					}
+					return p.Print(resp, func() client.Table { return client.HostTable(resp) })
+				})
+			},
+		},
+		&cobra.Command{
+			Use:   "health",
+			Short: "Show the agent health checks",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+					resp, err := c.HealthCheck(ctx, &pb.HealthCheckRequest{})
+					if err != nil {
+						return err
+					}
+					if err := p.Print(resp, func() client.Table { return client.HealthTable(resp) }); err != nil {
+						return err
+					}
+					if !resp.Healthy {
+						return errors.New("agent is unhealthy")
+					}
+					return nil
+				})
+			},
+		},
+	)
+	return cmd
+}
+
+// newAuditCmd creates the "audit" command group
+func newAuditCmd() *cobra.Command {
+	opts := &clientOptions{}
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Query the agent audit log",
+	}
+	addClientFlags(cmd, opts)
+
+	var (
+		since time.Duration
+		req   pb.QueryAuditLogRequest
+	)
+	query := &cobra.Command{
+		Use:   "query",
+		Short: "List audit records of mutating calls",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if since > 0 {
+				req.StartTime = time.Now().Add(-since).Unix()
+			}
+			return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+				resp, err := c.QueryAuditLog(ctx, &req)
+				if err != nil {
+					return err
+				}
+				return p.Print(resp, func() client.Table { return client.AuditTable(resp.Records...) })
+			})
+		},
+	}
+	query.Flags().DurationVar(&since, "since", 0, "only records newer than this, e.g. 1h")
+	query.Flags().StringVar(&req.VmId, "vm", "", "only records of this VM")
+	query.Flags().Int32Var(&req.Limit, "limit", 0, "most recent records to return")
+
+	cmd.AddCommand(query)
+	return cmd
+}
+
+// newOperationCmd creates the "operation" command group
+func newOperationCmd() *cobra.Command {
+	opts := &clientOptions{}
+	cmd := &cobra.Command{
+		Use:   "operation",
+		Short: "Track async VM creations and deletions",
+	}
+	addClientFlags(cmd, opts)
+
+	// printOperation prints an operation and fails if it did
+	printOperation := func(p *client.Printer, msg proto.Message, op *pb.Operation) error {
+		return printResult(p, msg, func() client.Table { return client.OperationTable(op) }, op.ErrorMessage)
+	}
+
+	var waitTimeout time.Duration
+	wait := &cobra.Command{
+		Use:   "wait OPERATION_ID",
+		Short: "Wait for an operation to finish",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+				resp, err := c.WaitOperation(ctx, &pb.WaitOperationRequest{
+					OperationId: args[0],
+					TimeoutMs:   waitTimeout.Milliseconds(),
+				})
+				if err != nil {
+					return err
+				}
+				return printOperation(p, resp, resp.Operation)
+			})
+		},
+	}
+	wait.Flags().DurationVar(&waitTimeout, "wait-timeout", 0, "return the running operation after this long (0 waits until --timeout)")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "get OPERATION_ID",
+			Short: "Show an operation",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+					resp, err := c.GetOperation(ctx, &pb.GetOperationRequest{OperationId: args[0]})
+					if err != nil {
+						return err
+					}
+					return printOperation(p, resp, resp.Operation)
+				})
+			},
+		},
+		wait,
+		&cobra.Command{
+			Use:   "cancel OPERATION_ID",
+			Short: "Cancel a running operation",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return opts.run(false, func(ctx context.Context, c *client.Client, p *client.Printer) error {
+					resp, err := c.CancelOperation(ctx, &pb.CancelOperationRequest{OperationId: args[0]})
+					if err != nil {
+						return err
+					}
+					return p.Print(resp, func() client.Table { return client.OperationTable(resp.Operation) })
+				})
+			},
+		},
+	)
+	return cmd
+}
+
+// newContextCmd creates the "context" command group, which selects the
+// agent the client commands talk to
+func newContextCmd() *cobra.Command {
+	var configPath string
+	cmd := &cobra.Command{
+		Use:   "context",
+		Short: "List and select client contexts",
+	}
+	cmd.PersistentFlags().StringVar(&configPath, "client-config", config.DefaultClientConfigPath(), "client config file path")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List contexts, marking the current one",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				cfg, err := config.LoadClientConfig(configPath)
+				if err != nil {
+					return err
+				}
+				t := client.Table{Headers: []string{"CURRENT", "NAME", "ADDRESS", "TLS"}}
+				for _, c := range cfg.Contexts {
+					current := ""
+					if c.Name == cfg.CurrentContext {
+						current = "*"
+					}
+					t.Rows = append(t.Rows, []string{current, c.Name, c.Address, fmt.Sprint(c.TLS.Enabled)})
+				}
+				return client.NewPrinter(client.FormatTable, os.Stdout).Print(nil, func() client.Table { return t })
+			},
+		},
+		&cobra.Command{
+			Use:   "use NAME",
+			Short: "Make a context the current one",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				cfg, err := config.LoadClientConfig(configPath)
+				if err != nil {
+					return err
+				}
+				if _, err := cfg.Context(args[0]); err != nil {
+					return err
+				}
+				cfg.CurrentContext = args[0]
+				if err := cfg.Save(configPath); err != nil {
+					return err
+				}
+				fmt.Printf("Switched to context %q\n", args[0])
+				return nil
+			},
+		},
+	)
+	return cmd
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spluca/firecracker-agent/internal/doctor"
+	"github.com/spluca/firecracker-agent/pkg/config"
+)
+
+// newDoctorCmd creates the "doctor" command, which validates the host
+// against the agent configuration
+func newDoctorCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:           "doctor",
+		Short:         "Check that the host meets the agent requirements",
+		Long:          `Checks kernel modules, KVM access, cgroup v2, Firecracker binaries, jail IDs, storage and networking, and prints a report with remediation hints`,
+		SilenceUsage:  true,
+		SilenceErrors: true, // reported by main
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load(cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+
+			report := doctor.New(cfg).Run()
+
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					return err
+				}
+			} else {
+				report.WriteText(os.Stdout)
+			}
+
+			if !report.OK() {
+				return fmt.Errorf("%d host checks failed", report.Failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&cfgFile, "config", "configs/agent.yaml", "config file path")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "print the report as JSON")
+
+	return cmd
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,6 +14,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spluca/firecracker-agent/internal/agent"
+	"github.com/spluca/firecracker-agent/internal/gateway"
 	"github.com/spluca/firecracker-agent/internal/monitor"
 	"github.com/spluca/firecracker-agent/internal/version"
 	"github.com/spluca/firecracker-agent/pkg/config"
@@ -38,6 +41,16 @@ func main() {
 	}
 
 	rootCmd.Flags().StringVar(&cfgFile, "config", "configs/agent.yaml", "config file path")
+	rootCmd.AddCommand(
+		newDoctorCmd(),
+		newVMCmd(),
+		newEventsCmd(),
+		newHostCmd(),
+		newAuditCmd(),
+		newOperationCmd(),
+		newContextCmd(),
+		newOpenAPICmd(),
+	)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -59,16 +72,82 @@ func run(cmd *cobra.Command, args []string) error {
 		"config":  cfgFile,
 	}).Info("Starting Firecracker Agent")
 
-	// Create gRPC server with interceptors
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(agent.LoggingInterceptor(log)),
-	)
-
 	// Create agent server
 	agentServer, err := agent.NewServer(cfg, log, startTime)
 	if err != nil {
 		return fmt.Errorf("failed to create agent server: %w", err)
 	}
+	defer agentServer.Close()
+
+	// Callers are identified by client certificate or token
+	tokenAuth, err := agent.NewTokenAuthenticator(cfg.Authentication, log)
+	if err != nil {
+		return fmt.Errorf("failed to configure authentication: %w", err)
+	}
+
+	// Create gRPC server with interceptors
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		agent.IdentityInterceptor(),
+		tokenAuth.UnaryInterceptor(),
+		agent.LoggingInterceptor(log),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		agent.IdentityStreamInterceptor(),
+		tokenAuth.StreamInterceptor(),
+	}
+
+	// Rate limits apply before auditing so throttled callers cannot flood the log
+	if cfg.RateLimit.Enabled {
+		rateLimiter := agent.NewRateLimiter(cfg.RateLimit)
+		unaryInterceptors = append(unaryInterceptors, rateLimiter.UnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, rateLimiter.StreamInterceptor())
+
+		log.WithFields(logrus.Fields{
+			"rate":                     cfg.RateLimit.PerPrincipal.Rate,
+			"burst":                    cfg.RateLimit.PerPrincipal.Burst,
+			"max_concurrent_mutations": cfg.RateLimit.MaxConcurrentMutations,
+		}).Info("Rate limiting enabled")
+	}
+	unaryInterceptors = append(unaryInterceptors, agentServer.AuditInterceptor())
+
+	var authorizer *agent.Authorizer
+	if cfg.Authorization.Enabled {
+		authorizer, err = agent.NewAuthorizer(cfg.Authorization.PolicyFile, agentServer.VMLabels, log)
+		if err != nil {
+			return fmt.Errorf("failed to load authorization policy: %w", err)
+		}
+		unaryInterceptors = append(unaryInterceptors, authorizer.UnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, authorizer.StreamInterceptor())
+
+		log.WithField("policy_file", cfg.Authorization.PolicyFile).Info("Authorization enabled")
+	}
+
+	// Retries are deduplicated once the caller is known to be allowed the call
+	unaryInterceptors = append(unaryInterceptors, agent.NewIdempotency(cfg.Idempotency).UnaryInterceptor())
+
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	var certReloader *agent.CertReloader
+	if cfg.Server.TLS.Enabled {
+		certReloader, err = agent.NewCertReloader(cfg.Server.TLS, log)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificates: %w", err)
+		}
+		go certReloader.Watch(cfg.Server.TLS.ReloadInterval, stopCh)
+		serverOpts = append(serverOpts, grpc.Creds(certReloader.Credentials()))
+
+		log.WithField("require_client_cert", cfg.Server.TLS.RequireClientCert).Info("TLS enabled")
+	} else {
+		log.Warn("TLS is disabled, gRPC traffic is unauthenticated and unencrypted")
+	}
+
+	grpcServer := grpc.NewServer(serverOpts...)
 
 	// Register gRPC service
 	agentServer.Register(grpcServer)
@@ -76,7 +155,7 @@ func run(cmd *cobra.Command, args []string) error {
 
 	// Start metrics server if enabled
 	if cfg.Monitoring.Enabled {
-		metricsServer := monitor.NewMetricsServer(cfg.Monitoring.MetricsPort, log)
+		metricsServer := monitor.NewMetricsServer(cfg.Monitoring.MetricsPort, agentServer.Healthy, log)
 		go func() {
 			log.WithField("port", cfg.Monitoring.MetricsPort).Info("Starting metrics server")
 			if err := metricsServer.Start(); err != nil {
@@ -85,20 +164,71 @@ func run(cmd *cobra.Command, args []string) error {
 		}()
 	}
 
-	// Start gRPC server
-	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	// Start gRPC server on every listener
+	listeners := make([]net.Listener, 0, len(cfg.Server.Listeners))
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for _, lc := range cfg.Server.Listeners {
+		listener, err := agent.Listen(lc)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s %s: %w", lc.Network, lc.Address, err)
+		}
+		listeners = append(listeners, listener)
+
+		log.WithFields(logrus.Fields{
+			"network": lc.Network,
+			"address": lc.Address,
+		}).Info("gRPC server listening")
 	}
 
-	log.WithField("address", addr).Info("gRPC server listening")
+	// Start servers in goroutines
+	errChan := make(chan error, len(listeners)+1)
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if err := grpcServer.Serve(listener); err != nil {
+				errChan <- err
+			}
+		}(listener)
+	}
+
+	// The REST gateway calls the service through the same interceptors
+	var gatewayServer *http.Server
+	if cfg.Server.Gateway.Enabled {
+		gatewayListener, err := net.Listen("tcp", cfg.Server.Gateway.Address)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", cfg.Server.Gateway.Address, err)
+		}
+		if certReloader != nil {
+			gatewayListener = tls.NewListener(gatewayListener, certReloader.TLSConfig())
+		}
+
+		gatewayServer = &http.Server{
+			Handler:           gateway.New(agentServer, unaryInterceptors, streamInterceptors, log),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := gatewayServer.Serve(gatewayListener); err != nil && err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}()
+
+		log.WithField("address", cfg.Server.Gateway.Address).Info("REST gateway listening")
+	}
 
-	// Start server in goroutine
-	errChan := make(chan error, 1)
+	// Reload certificates, credentials and policy on SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
 	go func() {
-		if err := grpcServer.Serve(listener); err != nil {
-			errChan <- err
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-hupChan:
+				reload(certReloader, tokenAuth, authorizer)
+			}
 		}
 	}()
 
@@ -117,6 +247,16 @@ func run(cmd *cobra.Command, args []string) error {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
+		// Event streams never go idle, so gateway connections still open
+		// after a short grace period are closed
+		if gatewayServer != nil {
+			gatewayCtx, gatewayCancel := context.WithTimeout(ctx, 5*time.Second)
+			if err := gatewayServer.Shutdown(gatewayCtx); err != nil {
+				gatewayServer.Close()
+			}
+			gatewayCancel()
+		}
+
 		// Stop gRPC server
 		stopped := make(chan struct{})
 		go func() {
@@ -135,3 +275,32 @@ func run(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 }
+
+// reload re-reads the TLS certificates, the authentication settings of the
+// config file and the authorization policy. Failures are logged and the
+// previous configuration stays in effect.
+func reload(certReloader *agent.CertReloader, tokenAuth *agent.TokenAuthenticator, authorizer *agent.Authorizer) {
+	if cfg, err := config.Load(cfgFile); err != nil {
+		log.WithError(err).Error("Failed to reload config")
+	} else if err := tokenAuth.Reload(cfg.Authentication); err != nil {
+		log.WithError(err).Error("Failed to reload authentication settings")
+	} else {
+		log.Info("Authentication settings reloaded")
+	}
+
+	if certReloader != nil {
+		if err := certReloader.Reload(); err != nil {
+			log.WithError(err).Error("Failed to reload TLS certificates")
+		} else {
+			log.Info("TLS certificates reloaded")
+		}
+	}
+
+	if authorizer != nil {
+		if err := authorizer.Reload(); err != nil {
+			log.WithError(err).Error("Failed to reload authorization policy")
+		} else {
+			log.Info("Authorization policy reloaded")
+		}
+	}
+}
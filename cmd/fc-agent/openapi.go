@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spluca/firecracker-agent/internal/gateway"
+)
+
+// newOpenAPICmd creates the "openapi" command, which prints the OpenAPI
+// document of the REST gateway
+func newOpenAPICmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "openapi",
+		Short: "Print the OpenAPI document of the REST gateway",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			doc, err := gateway.OpenAPI()
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(append(doc, '\n'))
+			return err
+		},
+	}
+}
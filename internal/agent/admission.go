@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+)
+
+var (
+	// ErrInsufficientCapacity is returned when a VM does not fit on the host
+	ErrInsufficientCapacity = errors.New("insufficient host capacity")
+
+	// ErrAlreadyAdmitted is returned when a VM ID already holds an allocation
+	ErrAlreadyAdmitted = errors.New("VM already admitted")
+)
+
+// CapacityUsage reports allocated and allocatable host capacity
+type CapacityUsage struct {
+	AllocatedVCPUs      int64
+	AllocatableVCPUs    int64
+	AllocatedMemoryMB   int64
+	AllocatableMemoryMB int64
+}
+
+// allocation is the capacity held by a single VM
+type allocation struct {
+	vcpus    int64
+	memoryMB int64
+}
+
+// AdmissionController tracks vCPUs and memory allocated to VMs against the
+// host capacity and rejects VMs that would exceed it. Allocatable capacity
+// is (host total - reserved) * overcommit ratio. A VM holds its allocation
+// from creation until it is deleted.
+type AdmissionController struct {
+	allocatableVCPUs    int64
+	allocatableMemoryMB int64
+	allocations         map[string]allocation
+	allocatedVCPUs      int64
+	allocatedMemoryMB   int64
+	mu                  sync.Mutex
+}
+
+// NewAdmissionController creates an admission controller for a host with the
+// given number of CPUs and memory
+func NewAdmissionController(cfg config.CapacityConfig, totalCPUs int, totalMemoryMB int64) *AdmissionController {
+	cpus := int64(totalCPUs) - int64(cfg.ReservedHostCPUs)
+	if cpus < 0 {
+		cpus = 0
+	}
+	memoryMB := totalMemoryMB - cfg.ReservedMemoryMB
+	if memoryMB < 0 {
+		memoryMB = 0
+	}
+
+	return &AdmissionController{
+		allocatableVCPUs:    int64(float64(cpus) * cfg.CPUOvercommitRatio),
+		allocatableMemoryMB: int64(float64(memoryMB) * cfg.MemoryOvercommitRatio),
+		allocations:         make(map[string]allocation),
+	}
+}
+
+// Admit reserves capacity for a VM or returns ErrInsufficientCapacity
+func (a *AdmissionController) Admit(vmID string, vcpus, memoryMB int32) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, exists := a.allocations[vmID]; exists {
+		return fmt.Errorf("%w: %s", ErrAlreadyAdmitted, vmID)
+	}
+
+	if a.allocatedVCPUs+int64(vcpus) > a.allocatableVCPUs {
+		return fmt.Errorf("%w: requested %d vCPUs, %d of %d available",
+			ErrInsufficientCapacity, vcpus, a.allocatableVCPUs-a.allocatedVCPUs, a.allocatableVCPUs)
+	}
+	if a.allocatedMemoryMB+int64(memoryMB) > a.allocatableMemoryMB {
+		return fmt.Errorf("%w: requested %d MB memory, %d of %d MB available",
+			ErrInsufficientCapacity, memoryMB, a.allocatableMemoryMB-a.allocatedMemoryMB, a.allocatableMemoryMB)
+	}
+
+	a.allocations[vmID] = allocation{vcpus: int64(vcpus), memoryMB: int64(memoryMB)}
+	a.allocatedVCPUs += int64(vcpus)
+	a.allocatedMemoryMB += int64(memoryMB)
+
+	return nil
+}
+
+// Release returns the capacity held by a VM
+func (a *AdmissionController) Release(vmID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	alloc, exists := a.allocations[vmID]
+	if !exists {
+		return
+	}
+
+	a.allocatedVCPUs -= alloc.vcpus
+	a.allocatedMemoryMB -= alloc.memoryMB
+	delete(a.allocations, vmID)
+}
+
+// Usage returns the current capacity usage
+func (a *AdmissionController) Usage() CapacityUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return CapacityUsage{
+		AllocatedVCPUs:      a.allocatedVCPUs,
+		AllocatableVCPUs:    a.allocatableVCPUs,
+		AllocatedMemoryMB:   a.allocatedMemoryMB,
+		AllocatableMemoryMB: a.allocatableMemoryMB,
+	}
+}
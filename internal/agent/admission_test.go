@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAdmissionController(t *testing.T) {
+	a := NewAdmissionController(config.CapacityConfig{
+		CPUOvercommitRatio:    4.0,
+		MemoryOvercommitRatio: 1.5,
+		ReservedHostCPUs:      2,
+		ReservedMemoryMB:      1024,
+	}, 8, 9216)
+
+	usage := a.Usage()
+	assert.Equal(t, int64(24), usage.AllocatableVCPUs)
+	assert.Equal(t, int64(12288), usage.AllocatableMemoryMB)
+	assert.Zero(t, usage.AllocatedVCPUs)
+	assert.Zero(t, usage.AllocatedMemoryMB)
+
+	// Reservations larger than the host leave nothing allocatable
+	a = NewAdmissionController(config.CapacityConfig{
+		CPUOvercommitRatio:    1.0,
+		MemoryOvercommitRatio: 1.0,
+		ReservedHostCPUs:      16,
+		ReservedMemoryMB:      16384,
+	}, 8, 8192)
+	usage = a.Usage()
+	assert.Zero(t, usage.AllocatableVCPUs)
+	assert.Zero(t, usage.AllocatableMemoryMB)
+}
+
+func TestAdmissionController_Admit(t *testing.T) {
+	a := NewAdmissionController(config.CapacityConfig{
+		CPUOvercommitRatio:    1.0,
+		MemoryOvercommitRatio: 1.0,
+	}, 4, 2048)
+
+	require.NoError(t, a.Admit("vm-1", 2, 1024))
+	require.NoError(t, a.Admit("vm-2", 2, 512))
+
+	err := a.Admit("vm-1", 1, 128)
+	assert.ErrorIs(t, err, ErrAlreadyAdmitted)
+
+	// Out of vCPUs
+	err = a.Admit("vm-3", 1, 128)
+	assert.ErrorIs(t, err, ErrInsufficientCapacity)
+
+	a.Release("vm-2")
+
+	// Out of memory
+	err = a.Admit("vm-3", 1, 1536)
+	assert.ErrorIs(t, err, ErrInsufficientCapacity)
+
+	require.NoError(t, a.Admit("vm-3", 2, 1024))
+
+	usage := a.Usage()
+	assert.Equal(t, int64(4), usage.AllocatedVCPUs)
+	assert.Equal(t, int64(2048), usage.AllocatedMemoryMB)
+}
+
+func TestAdmissionController_Release(t *testing.T) {
+	a := NewAdmissionController(config.CapacityConfig{
+		CPUOvercommitRatio:    2.0,
+		MemoryOvercommitRatio: 1.0,
+	}, 2, 1024)
+
+	require.NoError(t, a.Admit("vm-1", 2, 512))
+	a.Release("vm-1")
+	a.Release("vm-1") // releasing twice is a no-op
+	a.Release("unknown")
+
+	usage := a.Usage()
+	assert.Zero(t, usage.AllocatedVCPUs)
+	assert.Zero(t, usage.AllocatedMemoryMB)
+	require.NoError(t, a.Admit("vm-1", 4, 1024))
+}
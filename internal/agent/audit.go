@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/audit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// mutatingMethods are the FirecrackerAgent RPCs recorded in the audit log
+var mutatingMethods = map[string]bool{
+	"CreateVM":        true,
+	"StartVM":         true,
+	"StopVM":          true,
+	"DeleteVM":        true,
+	"CancelOperation": true,
+}
+
+// isAgentMethod reports whether fullMethod belongs to the FirecrackerAgent service
+func isAgentMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+pb.FirecrackerAgent_ServiceDesc.ServiceName+"/")
+}
+
+// isMutatingMethod reports whether fullMethod changes VM state
+func isMutatingMethod(fullMethod string) bool {
+	return isAgentMethod(fullMethod) && mutatingMethods[path.Base(fullMethod)]
+}
+
+// AuditInterceptor records mutating calls, including rejected ones, in the
+// audit log. It must run after authentication so the caller is known.
+func (s *Server) AuditInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if s.auditLog == nil || !isMutatingMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		callCtx := ctx
+		ctx = context.WithValue(ctx, auditCompletionKey{}, func(resp interface{}, err error) {
+			s.recordAudit(callCtx, info.FullMethod, req, resp, err, start, true)
+		})
+		resp, err := handler(ctx, req)
+		s.recordAudit(ctx, info.FullMethod, req, resp, err, start, false)
+
+		return resp, err
+	}
+}
+
+// auditCompletionKey is the context key of the function auditing the
+// outcome of work outliving an audited call
+type auditCompletionKey struct{}
+
+// auditCompletion returns the function recording the outcome of work that
+// finishes after the audited call in ctx returned, such as an async
+// operation or a call its caller gave up on. The record is marked as a
+// completion.
+func auditCompletion(ctx context.Context) func(resp interface{}, err error) {
+	if complete, ok := ctx.Value(auditCompletionKey{}).(func(interface{}, error)); ok {
+		return complete
+	}
+	return func(interface{}, error) {}
+}
+
+// recordAudit appends the outcome of a call to the audit log. A completion
+// records the outcome of work the call did not wait for.
+func (s *Server) recordAudit(ctx context.Context, fullMethod string, req, resp interface{}, err error, start time.Time, completion bool) {
+	record := audit.Record{
+		Time:       start,
+		Method:     fullMethod,
+		Code:       status.Code(err).String(),
+		DurationMs: time.Since(start).Milliseconds(),
+		Completion: completion,
+	}
+
+	if p, ok := PrincipalFromContext(ctx); ok {
+		record.Principal = p.Name
+		record.PrincipalSource = p.Source
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		record.Peer = p.Addr.String()
+	}
+	if r, ok := req.(interface{ GetVmId() string }); ok {
+		record.VMID = r.GetVmId()
+	}
+	if msg, ok := req.(proto.Message); ok {
+		record.Request = audit.SanitizeRequest(msg)
+	}
+
+	// Some handlers report failures in the response rather than the status
+	if err != nil {
+		record.Error = status.Convert(err).Message()
+	} else if r, ok := resp.(interface{ GetErrorMessage() string }); ok {
+		record.Error = r.GetErrorMessage()
+	}
+
+	if err := s.auditLog.Append(record); err != nil {
+		s.log.WithError(err).WithField("method", fullMethod).Error("Failed to write audit record")
+	}
+}
+
+// auditRecordToProto converts an audit record to its API representation
+func auditRecordToProto(r audit.Record) *pb.AuditRecord {
+	return &pb.AuditRecord{
+		Sequence:        r.Sequence,
+		Timestamp:       r.Time.UnixNano(),
+		Principal:       r.Principal,
+		PrincipalSource: r.PrincipalSource,
+		Peer:            r.Peer,
+		Method:          r.Method,
+		VmId:            r.VMID,
+		Request:         string(r.Request),
+		Code:            r.Code,
+		Error:           r.Error,
+		DurationMs:      r.DurationMs,
+		Completion:      r.Completion,
+		PrevHash:        r.PrevHash,
+		Hash:            r.Hash,
+	}
+}
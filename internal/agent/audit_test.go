@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/audit"
+	"github.com/spluca/firecracker-agent/internal/labels"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_AuditInterceptor(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	auditLog, err := audit.Open(config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.jsonl")})
+	require.NoError(t, err)
+	defer auditLog.Close()
+	s.auditLog = auditLog
+
+	interceptor := s.AuditInterceptor()
+	call := func(ctx context.Context, method string, req interface{}, handler grpc.UnaryHandler) error {
+		_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: agentMethod + method}, handler)
+		return err
+	}
+
+	ctx := ContextWithPrincipal(context.Background(), &Principal{Name: "ci-1", Source: PrincipalSourceAPIKey})
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 40000}})
+
+	createHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.CreateVM(ctx, req.(*pb.CreateVMRequest))
+	}
+	require.NoError(t, call(ctx, "CreateVM", &pb.CreateVMRequest{
+		VmId: "vm-1", VcpuCount: 1, MemoryMb: 128, Metadata: map[string]string{"secret": "x"},
+	}, createHandler))
+
+	// Rejected calls are recorded with their status
+	err = call(ctx, "DeleteVM", &pb.DeleteVMRequest{VmId: "vm-2"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Read-only calls are not recorded
+	require.NoError(t, call(ctx, "ListVMs", &pb.ListVMsRequest{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.ListVMs(ctx, req.(*pb.ListVMsRequest))
+	}))
+
+	resp, err := s.QueryAuditLog(context.Background(), &pb.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 2)
+
+	created := resp.Records[0]
+	assert.Equal(t, agentMethod+"CreateVM", created.Method)
+	assert.Equal(t, "vm-1", created.VmId)
+	assert.Equal(t, "ci-1", created.Principal)
+	assert.Equal(t, PrincipalSourceAPIKey, created.PrincipalSource)
+	assert.Equal(t, "10.0.0.5:40000", created.Peer)
+	assert.Equal(t, "OK", created.Code)
+	assert.Contains(t, created.Request, `"secret":"[REDACTED]"`)
+	assert.NotEmpty(t, created.Hash)
+
+	denied := resp.Records[1]
+	assert.Equal(t, "PermissionDenied", denied.Code)
+	assert.Equal(t, "denied", denied.Error)
+	assert.Equal(t, created.Hash, denied.PrevHash)
+
+	resp, err = s.QueryAuditLog(context.Background(), &pb.QueryAuditLogRequest{VmId: "vm-2"})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, agentMethod+"DeleteVM", resp.Records[0].Method)
+
+	// Callers limited to some VMs only see the records of those
+	scoped := contextWithVMAccess(context.Background(), []labels.Selector{mustParseSelector(t, "secret=x")})
+	resp, err = s.QueryAuditLog(scoped, &pb.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, "vm-1", resp.Records[0].VmId)
+
+	other := contextWithVMAccess(context.Background(), []labels.Selector{mustParseSelector(t, "secret=y")})
+	resp, err = s.QueryAuditLog(other, &pb.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Records)
+}
+
+func TestServer_AuditCompletion(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	auditLog, err := audit.Open(config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.jsonl")})
+	require.NoError(t, err)
+	defer auditLog.Close()
+	s.auditLog = auditLog
+
+	// The caller gives up on an idempotent call, which finishes later
+	i, _ := newTestIdempotency(100)
+	h := &countingHandler{release: make(chan struct{})}
+	info := &grpc.UnaryServerInfo{FullMethod: agentMethod + "CreateVM"}
+	ctx, cancel := context.WithCancel(principalContext("alice"))
+	cancel()
+	_, err = s.AuditInterceptor()(ctx, &pb.CreateVMRequest{VmId: "vm-1", RequestId: "req-1"}, info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return i.UnaryInterceptor()(ctx, req, info, h.handle)
+		})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	close(h.release)
+
+	var records []*pb.AuditRecord
+	require.Eventually(t, func() bool {
+		resp, err := s.QueryAuditLog(context.Background(), &pb.QueryAuditLogRequest{})
+		require.NoError(t, err)
+		records = resp.Records
+		return len(records) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "Canceled", records[0].Code)
+	assert.False(t, records[0].Completion)
+	assert.Equal(t, "OK", records[1].Code)
+	assert.True(t, records[1].Completion)
+	assert.Equal(t, "vm-1", records[1].VmId)
+	assert.Equal(t, "alice", records[1].Principal)
+}
+
+func TestServer_AuditAsyncOperation(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	auditLog, err := audit.Open(config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.jsonl")})
+	require.NoError(t, err)
+	defer auditLog.Close()
+	s.auditLog = auditLog
+
+	// The call is accepted, its operation fails later
+	resp, err := s.AuditInterceptor()(principalContext("alice"), &pb.DeleteVMRequest{VmId: "vm-1", Async: true},
+		&grpc.UnaryServerInfo{FullMethod: agentMethod + "DeleteVM"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.DeleteVM(ctx, req.(*pb.DeleteVMRequest))
+		})
+	require.NoError(t, err)
+	waited, err := s.WaitOperation(context.Background(), &pb.WaitOperationRequest{OperationId: resp.(*pb.DeleteVMResponse).OperationId})
+	require.NoError(t, err)
+	assert.Equal(t, pb.OperationStatus_OPERATION_STATUS_FAILED, waited.Operation.Status)
+
+	records, err := s.QueryAuditLog(context.Background(), &pb.QueryAuditLogRequest{})
+	require.NoError(t, err)
+	require.Len(t, records.Records, 2)
+
+	accepted, completed := records.Records[0], records.Records[1]
+	assert.Equal(t, "OK", accepted.Code)
+	assert.False(t, accepted.Completion)
+	assert.True(t, completed.Completion)
+	assert.Equal(t, "OK", completed.Code) // failure reported in the response
+	assert.Contains(t, completed.Error, "not found")
+	assert.Equal(t, "vm-1", completed.VmId)
+}
+
+func TestServer_QueryAuditLog_Validation(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := s.QueryAuditLog(ctx, &pb.QueryAuditLogRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	auditLog, err := audit.Open(config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.jsonl")})
+	require.NoError(t, err)
+	defer auditLog.Close()
+	s.auditLog = auditLog
+
+	_, err = s.QueryAuditLog(ctx, &pb.QueryAuditLogRequest{StartTime: 200, EndTime: 100})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.QueryAuditLog(ctx, &pb.QueryAuditLogRequest{Limit: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
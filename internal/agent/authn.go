@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// PrincipalSourceAPIKey marks principals identified by a static API key
+	PrincipalSourceAPIKey = "api-key"
+
+	// PrincipalSourceJWT marks principals identified by a bearer JWT
+	PrincipalSourceJWT = "jwt"
+
+	apiKeyHeader        = "x-api-key"
+	authorizationHeader = "authorization"
+)
+
+// errNoCredentials is returned by an Authenticator when the request carries
+// no credentials it handles
+var errNoCredentials = errors.New("no credentials")
+
+// Authenticator identifies callers from gRPC request metadata
+type Authenticator interface {
+	// Authenticate returns the caller identified by md, errNoCredentials if
+	// md holds no credentials for this authenticator, or another error if
+	// the credentials are invalid.
+	Authenticate(md metadata.MD) (*Principal, error)
+}
+
+// APIKeyAuthenticator validates static API keys sent in the x-api-key header
+// against their SHA-256 hashes
+type APIKeyAuthenticator struct {
+	keys []apiKey
+}
+
+type apiKey struct {
+	hash      []byte
+	principal *Principal
+}
+
+// NewAPIKeyAuthenticator creates an authenticator for the configured keys
+func NewAPIKeyAuthenticator(keys []config.APIKeyConfig) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{}
+	for _, k := range keys {
+		hash, err := hex.DecodeString(k.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("API key %q: invalid sha256 hash", k.Name)
+		}
+		a.keys = append(a.keys, apiKey{
+			hash:      hash,
+			principal: &Principal{Name: k.Name, Groups: k.Groups, Source: PrincipalSourceAPIKey},
+		})
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator
+func (a *APIKeyAuthenticator) Authenticate(md metadata.MD) (*Principal, error) {
+	values := md.Get(apiKeyHeader)
+	if len(values) == 0 {
+		return nil, errNoCredentials
+	}
+
+	sum := sha256.Sum256([]byte(values[0]))
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+			return k.principal, nil
+		}
+	}
+	return nil, errors.New("invalid API key")
+}
+
+// JWTAuthenticator validates bearer JWTs sent in the authorization header
+type JWTAuthenticator struct {
+	verifier *jwtVerifier
+}
+
+// NewJWTAuthenticator creates an authenticator using the configured JWKS file
+func NewJWTAuthenticator(cfg config.JWTConfig) (*JWTAuthenticator, error) {
+	verifier, err := newJWTVerifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTAuthenticator{verifier: verifier}, nil
+}
+
+// Authenticate implements Authenticator
+func (a *JWTAuthenticator) Authenticate(md metadata.MD) (*Principal, error) {
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 {
+		return nil, errNoCredentials
+	}
+
+	scheme, token, found := strings.Cut(values[0], " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, errNoCredentials
+	}
+
+	return a.verifier.Verify(strings.TrimSpace(token))
+}
+
+// TokenAuthenticator runs a set of authenticators on every call and attaches
+// the resulting principal to the request context. Calls without token
+// credentials keep the client certificate identity, if any.
+type TokenAuthenticator struct {
+	log            *logrus.Logger
+	authenticators atomic.Pointer[[]Authenticator]
+}
+
+// NewTokenAuthenticator creates a token authenticator from the configuration
+func NewTokenAuthenticator(cfg config.AuthenticationConfig, log *logrus.Logger) (*TokenAuthenticator, error) {
+	t := &TokenAuthenticator{log: log}
+	if err := t.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload replaces the authenticators, revoking keys no longer configured. On
+// failure the current authenticators are kept.
+func (t *TokenAuthenticator) Reload(cfg config.AuthenticationConfig) error {
+	var authenticators []Authenticator
+
+	if len(cfg.APIKeys) > 0 {
+		a, err := NewAPIKeyAuthenticator(cfg.APIKeys)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, a)
+	}
+
+	if cfg.JWT.Enabled {
+		a, err := NewJWTAuthenticator(cfg.JWT)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, a)
+	}
+
+	t.authenticators.Store(&authenticators)
+	return nil
+}
+
+// authenticate attaches the token principal to ctx. Invalid credentials are
+// rejected even when the caller also presented a client certificate.
+func (t *TokenAuthenticator) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx, nil
+	}
+
+	for _, a := range *t.authenticators.Load() {
+		principal, err := a.Authenticate(md)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		if err != nil {
+			t.log.WithError(err).WithField("method", fullMethod).Warn("Authentication failed")
+			return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+		}
+		return ContextWithPrincipal(ctx, principal), nil
+	}
+
+	return ctx, nil
+}
+
+// UnaryInterceptor authenticates unary calls
+func (t *TokenAuthenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := t.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor authenticates streaming calls
+func (t *TokenAuthenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := t.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
@@ -0,0 +1,343 @@
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// testSigner signs JWTs with a key published in a JWKS file
+type testSigner struct {
+	alg string
+	kid string
+	key crypto.Signer
+}
+
+// jwk returns the public JWK of the signer
+func (s *testSigner) jwk() map[string]string {
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": s.kid, "alg": s.alg, "use": "sig",
+			"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return map[string]string{"kty": "EC", "kid": s.kid, "crv": pub.Curve.Params().Name,
+			"x": b64(pub.X.FillBytes(make([]byte, size))), "y": b64(pub.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": s.kid, "crv": "Ed25519", "x": b64(pub)}
+	}
+	return nil
+}
+
+// sign returns a signed token with the given claims
+func (s *testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64(header) + "." + b64(payload)
+
+	var sig []byte
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, []byte(signed))
+	}
+	require.NoError(t, err)
+
+	return signed + "." + b64(sig)
+}
+
+func newTestSigners(t *testing.T) []*testSigner {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return []*testSigner{
+		{alg: "RS256", kid: "rsa-1", key: rsaKey},
+		{alg: "ES256", kid: "ec-1", key: ecKey},
+		{alg: "EdDSA", kid: "ed-1", key: edKey},
+	}
+}
+
+// writeJWKS writes the public keys of the signers to a JWKS file
+func writeJWKS(t *testing.T, signers ...*testSigner) string {
+	t.Helper()
+
+	var keys []map[string]string
+	for _, s := range signers {
+		keys = append(keys, s.jwk())
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(file, data, 0644))
+	return file
+}
+
+func testJWTConfig(jwksFile string) config.JWTConfig {
+	return config.JWTConfig{
+		Enabled:      true,
+		JWKSFile:     jwksFile,
+		Issuer:       "https://issuer.example",
+		Audience:     "fc-agent",
+		SubjectClaim: "sub",
+		GroupsClaim:  "groups",
+		Leeway:       30 * time.Second,
+	}
+}
+
+func validClaims(subject string) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":    subject,
+		"iss":    "https://issuer.example",
+		"aud":    []string{"fc-agent", "other"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": []string{"ci"},
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	a, err := NewAPIKeyAuthenticator([]config.APIKeyConfig{
+		{Name: "ci-bot", Groups: []string{"ci"}, SHA256: hashKey("s3cret")},
+	})
+	require.NoError(t, err)
+
+	p, err := a.Authenticate(metadata.Pairs(apiKeyHeader, "s3cret"))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "ci-bot", Groups: []string{"ci"}, Source: PrincipalSourceAPIKey}, p)
+
+	_, err = a.Authenticate(metadata.Pairs(apiKeyHeader, "wrong"))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errNoCredentials)
+
+	_, err = a.Authenticate(metadata.MD{})
+	assert.ErrorIs(t, err, errNoCredentials)
+
+	_, err = NewAPIKeyAuthenticator([]config.APIKeyConfig{{Name: "bad", SHA256: "zz"}})
+	assert.Error(t, err)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	signers := newTestSigners(t)
+	a, err := NewJWTAuthenticator(testJWTConfig(writeJWKS(t, signers...)))
+	require.NoError(t, err)
+
+	for _, s := range signers {
+		t.Run(s.alg, func(t *testing.T) {
+			token := s.sign(t, validClaims("runner-"+s.kid))
+			p, err := a.Authenticate(metadata.Pairs(authorizationHeader, "Bearer "+token))
+			require.NoError(t, err)
+			assert.Equal(t, "runner-"+s.kid, p.Name)
+			assert.Equal(t, []string{"ci"}, p.Groups)
+			assert.Equal(t, PrincipalSourceJWT, p.Source)
+		})
+	}
+
+	_, err = a.Authenticate(metadata.MD{})
+	assert.ErrorIs(t, err, errNoCredentials)
+	_, err = a.Authenticate(metadata.Pairs(authorizationHeader, "Basic dXNlcjpwYXNz"))
+	assert.ErrorIs(t, err, errNoCredentials)
+}
+
+func TestJWTAuthenticator_InvalidTokens(t *testing.T) {
+	signers := newTestSigners(t)
+	rsaSigner := signers[0]
+	a, err := NewJWTAuthenticator(testJWTConfig(writeJWKS(t, rsaSigner)))
+	require.NoError(t, err)
+
+	claims := func(modify func(map[string]interface{})) map[string]interface{} {
+		c := validClaims("runner")
+		modify(c)
+		return c
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	impostor := &testSigner{alg: "RS256", kid: "rsa-1", key: otherKey}
+
+	parts := strings.Split(rsaSigner.sign(t, validClaims("runner")), ".")
+	noneHeader, _ := json.Marshal(map[string]string{"alg": "none"})
+	elevated, _ := json.Marshal(claims(func(c map[string]interface{}) { c["groups"] = []string{"admins"} }))
+
+	tests := map[string]string{
+		"malformed":       "not-a-token",
+		"unknown signer":  impostor.sign(t, validClaims("runner")),
+		"unknown key id":  (&testSigner{alg: "ES256", kid: "ec-1", key: signers[1].key}).sign(t, validClaims("runner")),
+		"alg none":        b64(noneHeader) + "." + parts[1] + ".",
+		"tampered claims": parts[0] + "." + b64(elevated) + "." + parts[2],
+		"expired":         rsaSigner.sign(t, claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() })),
+		"no expiry":       rsaSigner.sign(t, claims(func(c map[string]interface{}) { delete(c, "exp") })),
+		"not yet valid":   rsaSigner.sign(t, claims(func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() })),
+		"wrong issuer":    rsaSigner.sign(t, claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example" })),
+		"wrong audience":  rsaSigner.sign(t, claims(func(c map[string]interface{}) { c["aud"] = "other" })),
+		"no subject":      rsaSigner.sign(t, claims(func(c map[string]interface{}) { delete(c, "sub") })),
+	}
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := a.Authenticate(metadata.Pairs(authorizationHeader, "Bearer "+token))
+			require.Error(t, err)
+			assert.NotErrorIs(t, err, errNoCredentials)
+		})
+	}
+}
+
+func TestNewJWTAuthenticator_InvalidJWKS(t *testing.T) {
+	_, err := NewJWTAuthenticator(testJWTConfig("/nonexistent/jwks.json"))
+	assert.Error(t, err)
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	for _, content := range []string{
+		`{"keys": []}`,
+		`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`,
+		`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+		`not json`,
+	} {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+		_, err := NewJWTAuthenticator(testJWTConfig(file))
+		assert.Error(t, err, content)
+	}
+}
+
+func TestTokenAuthenticator_Interceptor(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	ta, err := NewTokenAuthenticator(config.AuthenticationConfig{
+		APIKeys: []config.APIKeyConfig{{Name: "ci-bot", SHA256: hashKey("s3cret")}},
+	}, log)
+	require.NoError(t, err)
+	interceptor := ta.UnaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: agentMethod + "ListVMs"}
+
+	var got *Principal
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got, _ = PrincipalFromContext(ctx)
+		return nil, nil
+	}
+
+	withKey := metadata.NewIncomingContext(context.Background(), metadata.Pairs(apiKeyHeader, "s3cret"))
+	_, err = interceptor(withKey, nil, info, handler)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "ci-bot", got.Name)
+
+	// A valid key takes precedence over the client certificate identity
+	mtls := ContextWithPrincipal(withKey, &Principal{Name: "cert-user", Source: PrincipalSourceMTLS})
+	_, err = interceptor(mtls, nil, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "ci-bot", got.Name)
+
+	// Without token credentials the client certificate identity is kept
+	got = nil
+	_, err = interceptor(ContextWithPrincipal(context.Background(), &Principal{Name: "cert-user"}), nil, info, handler)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "cert-user", got.Name)
+
+	// Invalid credentials are rejected
+	bad := metadata.NewIncomingContext(context.Background(), metadata.Pairs(apiKeyHeader, "wrong"))
+	_, err = interceptor(bad, nil, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Reloading without the key revokes it
+	require.NoError(t, ta.Reload(config.AuthenticationConfig{}))
+
+	got = nil
+	_, err = interceptor(withKey, nil, info, handler)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestTokenAuthenticator_StreamInterceptor(t *testing.T) {
+	signers := newTestSigners(t)
+	ta, err := NewTokenAuthenticator(config.AuthenticationConfig{
+		JWT: testJWTConfig(writeJWKS(t, signers[2])),
+	}, logrus.New())
+	require.NoError(t, err)
+
+	interceptor := ta.StreamInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: agentMethod + "WatchVMEvents"}
+
+	var got *Principal
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		got, _ = PrincipalFromContext(ss.Context())
+		return nil
+	}
+
+	token := signers[2].sign(t, validClaims("watcher"))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, "Bearer "+token))
+	require.NoError(t, interceptor(nil, &fakeServerStream{ctx: ctx}, info, handler))
+	require.NotNil(t, got)
+	assert.Equal(t, "watcher", got.Name)
+}
+
+func TestIdentityAndTokenInterceptors(t *testing.T) {
+	ta, err := NewTokenAuthenticator(config.AuthenticationConfig{}, logrus.New())
+	require.NoError(t, err)
+
+	ctx := tlsPeerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "cert-user"}})
+	var got *Principal
+	chain := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return ta.UnaryInterceptor()(ctx, req, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			got, _ = PrincipalFromContext(ctx)
+			return nil, nil
+		})
+	}
+	_, err = IdentityInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, chain)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "cert-user", got.Name)
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/labels"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VMLabelsFunc returns the labels of an existing VM
+type VMLabelsFunc func(vmID string) (map[string]string, bool)
+
+// Authorizer enforces a Policy on incoming RPCs
+type Authorizer struct {
+	policyFile string
+	vmLabels   VMLabelsFunc
+	log        *logrus.Logger
+	policy     atomic.Pointer[Policy]
+}
+
+// NewAuthorizer loads the policy file and creates an authorizer. vmLabels
+// resolves the labels of the VM targeted by a request.
+func NewAuthorizer(policyFile string, vmLabels VMLabelsFunc, log *logrus.Logger) (*Authorizer, error) {
+	a := &Authorizer{
+		policyFile: policyFile,
+		vmLabels:   vmLabels,
+		log:        log,
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload re-reads the policy file. On failure the current policy is kept.
+func (a *Authorizer) Reload() error {
+	policy, err := LoadPolicy(a.policyFile)
+	if err != nil {
+		return err
+	}
+	a.policy.Store(policy)
+	return nil
+}
+
+// authorize checks a call and returns the selectors of the VMs the caller may
+// see. req is nil for streaming calls.
+func (a *Authorizer) authorize(ctx context.Context, fullMethod string, req interface{}) ([]labels.Selector, error) {
+	policy := a.policy.Load()
+	if policy.IsPublic(fullMethod) {
+		return []labels.Selector{{}}, nil
+	}
+
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	log := a.log.WithFields(logrus.Fields{
+		"method":    fullMethod,
+		"principal": principal.Name,
+	})
+
+	selectors := policy.Selectors(principal, fullMethod)
+	if len(selectors) == 0 {
+		log.Warn("Permission denied")
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not call %s", principal.Name, fullMethod)
+	}
+
+	// Requests targeting a VM must match a selector of an allowing rule
+	var vmID string
+	var vmLabels map[string]string
+	switch r := req.(type) {
+	case *pb.CreateVMRequest:
+		vmID, vmLabels = r.VmId, r.Metadata
+	case interface{ GetVmId() string }:
+		vmID = r.GetVmId()
+		vmLabels, _ = a.vmLabels(vmID)
+	}
+	if vmID != "" && !selectorsMatch(selectors, vmLabels) {
+		log.WithField("vm_id", vmID).Warn("Permission denied")
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not call %s on VM %s", principal.Name, fullMethod, vmID)
+	}
+
+	return selectors, nil
+}
+
+// UnaryInterceptor enforces the policy on unary calls
+func (a *Authorizer) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		selectors, err := a.authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(contextWithVMAccess(ctx, selectors), req)
+	}
+}
+
+// StreamInterceptor enforces the policy on streaming calls
+func (a *Authorizer) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		selectors, err := a.authorize(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: contextWithVMAccess(ss.Context(), selectors)})
+	}
+}
+
+type vmAccessKey struct{}
+
+// contextWithVMAccess restricts the VMs visible to a call
+func contextWithVMAccess(ctx context.Context, selectors []labels.Selector) context.Context {
+	return context.WithValue(ctx, vmAccessKey{}, selectors)
+}
+
+// vmAccessible reports whether a VM with the given labels is visible to the
+// caller. Without authorization every VM is visible.
+func vmAccessible(ctx context.Context, vmLabels map[string]string) bool {
+	selectors, ok := ctx.Value(vmAccessKey{}).([]labels.Selector)
+	if !ok {
+		return true
+	}
+	return selectorsMatch(selectors, vmLabels)
+}
+
+// vmAccessUnrestricted reports whether every VM, including deleted ones, is
+// visible to the caller
+func vmAccessUnrestricted(ctx context.Context) bool {
+	selectors, ok := ctx.Value(vmAccessKey{}).([]labels.Selector)
+	if !ok {
+		return true
+	}
+	for _, s := range selectors {
+		if s.Empty() {
+			return true
+		}
+	}
+	return false
+}
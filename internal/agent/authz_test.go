@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testPolicy = `
+public_methods: ["HealthCheck"]
+rules:
+  - name: ci-runners
+    groups: ["jwt:ci"]
+    methods: ["CreateVM", "DeleteVM", "GetVM", "ListVMs"]
+    vm_selector: "owner=${principal}"
+  - name: dashboards
+    principals: ["mtls:dashboard"]
+    methods: ["GetVM", "ListVMs", "WatchVMEvents", "GetHostInfo"]
+  - name: admins
+    groups: ["unix-peer:admins"]
+    methods: ["*"]
+`
+
+const agentMethod = "/firecracker.v1.FirecrackerAgent/"
+
+func mustParseSelector(t *testing.T, selector string) labels.Selector {
+	t.Helper()
+	s, err := labels.Parse(selector)
+	require.NoError(t, err)
+	return s
+}
+
+// writePolicy writes a policy file and returns its path
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	return file
+}
+
+func newTestAuthorizer(t *testing.T, vmLabels map[string]map[string]string) *Authorizer {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	a, err := NewAuthorizer(writePolicy(t, testPolicy), func(vmID string) (map[string]string, bool) {
+		l, ok := vmLabels[vmID]
+		return l, ok
+	}, log)
+	require.NoError(t, err)
+	return a
+}
+
+func TestLoadPolicy_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"no principals": "rules:\n  - name: r\n    methods: [\"*\"]\n",
+		"no methods":    "rules:\n  - name: r\n    groups: [\"jwt:ci\"]\n",
+		"bad selector":  "rules:\n  - name: r\n    groups: [\"jwt:ci\"]\n    methods: [\"*\"]\n    vm_selector: \"a in (b\"\n",
+		"unqualified":   "rules:\n  - name: r\n    principals: [\"alice\"]\n    methods: [\"*\"]\n",
+		"bad source":    "rules:\n  - name: r\n    groups: [\"ldap:ci\"]\n    methods: [\"*\"]\n",
+		"invalid yaml":  "rules: [",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadPolicy(writePolicy(t, content))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadPolicy("/nonexistent/policy.yaml")
+	assert.Error(t, err)
+}
+
+func TestLoadPolicy_Empty(t *testing.T) {
+	// An empty policy is valid and denies everything
+	p, err := LoadPolicy(writePolicy(t, ""))
+	require.NoError(t, err)
+	assert.Empty(t, p.Selectors(&Principal{Name: "x"}, agentMethod+"ListVMs"))
+	assert.False(t, p.IsPublic(agentMethod+"HealthCheck"))
+}
+
+func TestPolicy_Selectors(t *testing.T) {
+	p, err := LoadPolicy(writePolicy(t, testPolicy))
+	require.NoError(t, err)
+
+	ci := &Principal{Name: "ci-1", Groups: []string{"ci"}, Source: PrincipalSourceJWT}
+	selectors := p.Selectors(ci, agentMethod+"CreateVM")
+	require.Len(t, selectors, 1)
+	assert.True(t, selectors[0].Matches(map[string]string{"owner": "ci-1"}))
+	assert.False(t, selectors[0].Matches(map[string]string{"owner": "ci-2"}))
+
+	assert.Empty(t, p.Selectors(ci, agentMethod+"StopVM"))
+	assert.Empty(t, p.Selectors(nil, agentMethod+"ListVMs"))
+
+	// Names and groups only match callers authenticated by the same source
+	assert.Empty(t, p.Selectors(&Principal{Name: "ci-1", Groups: []string{"ci"}, Source: PrincipalSourceUnixPeer}, agentMethod+"CreateVM"))
+	assert.Empty(t, p.Selectors(&Principal{Name: "dashboard", Source: PrincipalSourceJWT}, agentMethod+"ListVMs"))
+
+	// Methods can be listed by full name or "*"
+	admin := &Principal{Name: "root", Groups: []string{"admins"}, Source: PrincipalSourceUnixPeer}
+	assert.Len(t, p.Selectors(admin, "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"), 1)
+
+	assert.True(t, p.IsPublic(agentMethod+"HealthCheck"))
+	assert.False(t, p.IsPublic(agentMethod+"ListVMs"))
+}
+
+func TestAuthorizer_UnaryInterceptor(t *testing.T) {
+	a := newTestAuthorizer(t, map[string]map[string]string{
+		"vm-1": {"owner": "ci-1"},
+		"vm-2": {"owner": "ci-2"},
+	})
+	interceptor := a.UnaryInterceptor()
+
+	ci := ContextWithPrincipal(context.Background(), &Principal{Name: "ci-1", Groups: []string{"ci"}, Source: PrincipalSourceJWT})
+	dashboard := ContextWithPrincipal(context.Background(), &Principal{Name: "dashboard", Source: PrincipalSourceMTLS})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		req      interface{}
+		expected codes.Code
+	}{
+		{"public method", context.Background(), "HealthCheck", &pb.HealthCheckRequest{}, codes.OK},
+		{"unauthenticated", context.Background(), "ListVMs", &pb.ListVMsRequest{}, codes.Unauthenticated},
+		{"create own VM", ci, "CreateVM", &pb.CreateVMRequest{VmId: "vm-3", Metadata: map[string]string{"owner": "ci-1"}}, codes.OK},
+		{"create VM for another owner", ci, "CreateVM", &pb.CreateVMRequest{VmId: "vm-3", Metadata: map[string]string{"owner": "ci-2"}}, codes.PermissionDenied},
+		{"delete own VM", ci, "DeleteVM", &pb.DeleteVMRequest{VmId: "vm-1"}, codes.OK},
+		{"delete other VM", ci, "DeleteVM", &pb.DeleteVMRequest{VmId: "vm-2"}, codes.PermissionDenied},
+		{"delete unknown VM", ci, "DeleteVM", &pb.DeleteVMRequest{VmId: "vm-9"}, codes.PermissionDenied},
+		{"method not granted", ci, "StopVM", &pb.StopVMRequest{VmId: "vm-1"}, codes.PermissionDenied},
+		{"read-only get", dashboard, "GetVM", &pb.GetVMRequest{VmId: "vm-2"}, codes.OK},
+		{"read-only delete", dashboard, "DeleteVM", &pb.DeleteVMRequest{VmId: "vm-2"}, codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			}
+
+			_, err := interceptor(tt.ctx, tt.req, &grpc.UnaryServerInfo{FullMethod: agentMethod + tt.method}, handler)
+			assert.Equal(t, tt.expected, status.Code(err))
+			assert.Equal(t, tt.expected == codes.OK, called)
+		})
+	}
+}
+
+func TestAuthorizer_ListVisibility(t *testing.T) {
+	a := newTestAuthorizer(t, nil)
+	interceptor := a.UnaryInterceptor()
+
+	var visible []bool
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		visible = []bool{
+			vmAccessible(ctx, map[string]string{"owner": "ci-1"}),
+			vmAccessible(ctx, map[string]string{"owner": "ci-2"}),
+		}
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: agentMethod + "ListVMs"}
+
+	ci := ContextWithPrincipal(context.Background(), &Principal{Name: "ci-1", Groups: []string{"ci"}, Source: PrincipalSourceJWT})
+	_, err := interceptor(ci, &pb.ListVMsRequest{}, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, visible)
+
+	dashboard := ContextWithPrincipal(context.Background(), &Principal{Name: "dashboard", Source: PrincipalSourceMTLS})
+	_, err = interceptor(dashboard, &pb.ListVMsRequest{}, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true}, visible)
+
+	// Without authorization every VM is visible
+	assert.True(t, vmAccessible(context.Background(), nil))
+}
+
+func TestAuthorizer_StreamInterceptor(t *testing.T) {
+	a := newTestAuthorizer(t, nil)
+	interceptor := a.StreamInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: agentMethod + "WatchVMEvents"}
+
+	handler := func(srv interface{}, ss grpc.ServerStream) error { return nil }
+
+	ci := ContextWithPrincipal(context.Background(), &Principal{Name: "ci-1", Groups: []string{"ci"}, Source: PrincipalSourceJWT})
+	err := interceptor(nil, &fakeServerStream{ctx: ci}, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	dashboard := ContextWithPrincipal(context.Background(), &Principal{Name: "dashboard", Source: PrincipalSourceMTLS})
+	err = interceptor(nil, &fakeServerStream{ctx: dashboard}, info, handler)
+	assert.NoError(t, err)
+}
+
+func TestAuthorizer_Reload(t *testing.T) {
+	file := writePolicy(t, testPolicy)
+	a, err := NewAuthorizer(file, func(string) (map[string]string, bool) { return nil, false }, logrus.New())
+	require.NoError(t, err)
+
+	dashboard := &Principal{Name: "dashboard", Source: PrincipalSourceMTLS}
+	assert.NotEmpty(t, a.policy.Load().Selectors(dashboard, agentMethod+"GetHostInfo"))
+
+	require.NoError(t, os.WriteFile(file, []byte("rules: []\n"), 0644))
+	require.NoError(t, a.Reload())
+	assert.Empty(t, a.policy.Load().Selectors(dashboard, agentMethod+"GetHostInfo"))
+
+	// A broken policy keeps the current one
+	require.NoError(t, os.WriteFile(file, []byte("rules: ["), 0644))
+	assert.Error(t, a.Reload())
+	assert.NotNil(t, a.policy.Load())
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/events"
+	"github.com/spluca/firecracker-agent/internal/monitor"
+)
+
+// EventStream numbers VM events, records them in the journal and fans them
+// out to subscribers
+type EventStream struct {
+	journal    *events.Journal
+	bufferSize int
+	log        *logrus.Logger
+
+	mu          sync.Mutex
+	nextID      uint64
+	subscribers map[uint64]*Subscription
+}
+
+// maxSubscriberBuffer caps the buffer size a watcher may ask for
+const maxSubscriberBuffer = 10000
+
+// EventFilter selects the events queued for a subscriber
+type EventFilter func(event *pb.VMEvent) bool
+
+// Subscription receives the matching events broadcast after it was created.
+// When its buffer is full, Lagged is signalled and no more events are
+// queued until Resume is called, so the subscriber can catch up from the
+// journal without receiving events out of order.
+type Subscription struct {
+	id     uint64
+	filter EventFilter
+	Events chan *pb.VMEvent
+	Lagged chan struct{}
+	// Head is the sequence of the last event before the subscription
+	Head uint64
+
+	lagging bool // guarded by EventStream.mu
+}
+
+// NewEventStream creates an event stream backed by journal, queueing up to
+// bufferSize events per subscriber
+func NewEventStream(journal *events.Journal, bufferSize int, log *logrus.Logger) *EventStream {
+	if bufferSize < 1 {
+		bufferSize = 100
+	}
+	return &EventStream{
+		journal:     journal,
+		bufferSize:  bufferSize,
+		log:         log,
+		subscribers: make(map[uint64]*Subscription),
+	}
+}
+
+// Subscribe adds a new subscriber queueing the events filter accepts, or
+// every event if filter is nil. bufferSize overrides the default buffer
+// size when positive.
+func (es *EventStream) Subscribe(filter EventFilter, bufferSize int) *Subscription {
+	if bufferSize < 1 {
+		bufferSize = es.bufferSize
+	}
+	bufferSize = min(bufferSize, maxSubscriberBuffer)
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	es.nextID++
+	sub := &Subscription{
+		id:     es.nextID,
+		filter: filter,
+		Events: make(chan *pb.VMEvent, bufferSize),
+		Lagged: make(chan struct{}, 1),
+		Head:   es.journal.LastSequence(),
+	}
+	es.subscribers[sub.id] = sub
+	return sub
+}
+
+// Unsubscribe removes a subscriber
+func (es *EventStream) Unsubscribe(sub *Subscription) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	delete(es.subscribers, sub.id)
+}
+
+// Resume queues events for a lagging subscriber again. The subscriber must
+// then catch up from the journal: events broadcast before Resume were not
+// queued.
+func (es *EventStream) Resume(sub *Subscription) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	sub.lagging = false
+}
+
+// Broadcast assigns the event its sequence number, journals it and sends it
+// to the subscribers it matches
+func (es *EventStream) Broadcast(event *pb.VMEvent) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if err := es.journal.Append(event); err != nil {
+		es.log.WithError(err).WithField("sequence", event.Sequence).Error("Failed to journal event")
+	}
+
+	for _, sub := range es.subscribers {
+		if sub.lagging || (sub.filter != nil && !sub.filter(event)) {
+			continue
+		}
+		select {
+		case sub.Events <- event:
+		default:
+			monitor.EventSubscriberOverflows.Inc()
+			sub.lagging = true
+			select {
+			case sub.Lagged <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Since returns the journaled events after a sequence, and whether none of
+// them were dropped from the journal
+func (es *EventStream) Since(after uint64) ([]*pb.VMEvent, bool) {
+	return es.journal.Since(after)
+}
+
+// LastSequence returns the sequence of the last journaled event
+func (es *EventStream) LastSequence() uint64 {
+	return es.journal.LastSequence()
+}
+
+// Close closes the journal
+func (es *EventStream) Close() error {
+	return es.journal.Close()
+}
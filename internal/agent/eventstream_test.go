@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// eventRecorder is a WatchVMEvents stream collecting sent events
+type eventRecorder struct {
+	fakeServerStream
+	sent chan *pb.VMEvent
+}
+
+func (r *eventRecorder) Send(event *pb.VMEvent) error {
+	r.sent <- event
+	return nil
+}
+
+// watch runs WatchVMEvents until the test ends
+func watch(t *testing.T, s *Server, req *pb.WatchVMEventsRequest) *eventRecorder {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := &eventRecorder{fakeServerStream: fakeServerStream{ctx: ctx}, sent: make(chan *pb.VMEvent, 100)}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, s.WatchVMEvents(req, rec))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return rec
+}
+
+func (r *eventRecorder) next(t *testing.T) *pb.VMEvent {
+	t.Helper()
+	select {
+	case event := <-r.sent:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+// waitSubscribers waits until n watchers are subscribed
+func waitSubscribers(t *testing.T, es *EventStream, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		es.mu.Lock()
+		defer es.mu.Unlock()
+		return len(es.subscribers) == n
+	}, 5*time.Second, time.Millisecond)
+}
+
+// broadcastEvent broadcasts an event about a VM with the given labels
+func broadcastEvent(s *Server, vmID string, vmLabels map[string]string, state pb.VMState, eventType pb.EventType, message string) {
+	s.eventStream.Broadcast(&pb.VMEvent{
+		VmId:      vmID,
+		State:     state,
+		Message:   message,
+		Timestamp: time.Now().Unix(),
+		Type:      eventType,
+		Labels:    vmLabels,
+	})
+}
+
+func broadcast(s *Server, vmID string, n int) {
+	for i := 0; i < n; i++ {
+		broadcastEvent(s, vmID, nil, pb.VMState_VM_STATE_RUNNING, pb.EventType_EVENT_TYPE_STARTED, "VM started")
+	}
+}
+
+func TestWatchVMEvents_Sequence(t *testing.T) {
+	s, _ := newTestServer(t)
+	broadcast(s, "vm-1", 3)
+
+	// Without since_sequence only new events are streamed
+	rec := watch(t, s, &pb.WatchVMEventsRequest{})
+	waitSubscribers(t, s.eventStream, 1)
+	broadcast(s, "vm-1", 1)
+	assert.Equal(t, uint64(4), rec.next(t).Sequence)
+}
+
+func TestWatchVMEvents_Resume(t *testing.T) {
+	s, _ := newTestServer(t)
+	broadcast(s, "vm-1", 2)
+	broadcast(s, "vm-2", 2)
+
+	since := uint64(1)
+	rec := watch(t, s, &pb.WatchVMEventsRequest{VmId: "vm-2", SinceSequence: &since})
+	assert.Equal(t, uint64(3), rec.next(t).Sequence)
+	assert.Equal(t, uint64(4), rec.next(t).Sequence)
+
+	// Replayed events are not sent again once live events flow
+	waitSubscribers(t, s.eventStream, 1)
+	broadcast(s, "vm-1", 1)
+	broadcast(s, "vm-2", 1)
+	event := rec.next(t)
+	assert.Equal(t, uint64(6), event.Sequence)
+	assert.Equal(t, "vm-2", event.VmId)
+}
+
+func TestWatchVMEvents_EventsLost(t *testing.T) {
+	s, _ := newTestServer(t)
+	broadcast(s, "vm-1", 150) // the test journal retains 100
+
+	since := uint64(10)
+	rec := watch(t, s, &pb.WatchVMEventsRequest{SinceSequence: &since})
+
+	lost := rec.next(t)
+	assert.Equal(t, pb.EventType_EVENT_TYPE_EVENTS_LOST, lost.Type)
+	assert.Zero(t, lost.Sequence)
+	assert.Equal(t, uint64(51), rec.next(t).Sequence, "the retained events follow")
+}
+
+func TestWatchVMEvents_SlowSubscriberCatchesUp(t *testing.T) {
+	s, _ := newTestServer(t)
+	journal, err := events.Open("", 100)
+	require.NoError(t, err)
+	s.eventStream = NewEventStream(journal, 1, s.log)
+
+	// The recorder blocks the watcher until the test reads
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := &eventRecorder{fakeServerStream: fakeServerStream{ctx: ctx}, sent: make(chan *pb.VMEvent)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.WatchVMEvents(&pb.WatchVMEventsRequest{}, rec)
+	}()
+	waitSubscribers(t, s.eventStream, 1)
+
+	// Most of these overflow the single slot buffer
+	broadcast(s, "vm-1", 20)
+	for seq := uint64(1); seq <= 20; seq++ {
+		assert.Equal(t, seq, rec.next(t).Sequence)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestWatchVMEvents_Filters(t *testing.T) {
+	s, _ := newTestServer(t)
+	prod := map[string]string{"env": "prod"}
+	broadcastEvent(s, "vm-1", prod, pb.VMState_VM_STATE_RUNNING, pb.EventType_EVENT_TYPE_STARTED, "")
+	broadcastEvent(s, "vm-2", nil, pb.VMState_VM_STATE_ERROR, pb.EventType_EVENT_TYPE_CRASHED, "")
+	broadcastEvent(s, "vm-1", prod, pb.VMState_VM_STATE_ERROR, pb.EventType_EVENT_TYPE_CRASHED, "")
+	broadcastEvent(s, "vm-1", prod, pb.VMState_VM_STATE_STOPPED, pb.EventType_EVENT_TYPE_STOPPED, "")
+
+	since := uint64(0)
+	tests := []struct {
+		name string
+		req  *pb.WatchVMEventsRequest
+		want []uint64
+	}{
+		{"types", &pb.WatchVMEventsRequest{EventTypes: []pb.EventType{pb.EventType_EVENT_TYPE_CRASHED}}, []uint64{2, 3}},
+		{"states", &pb.WatchVMEventsRequest{States: []pb.VMState{pb.VMState_VM_STATE_RUNNING, pb.VMState_VM_STATE_STOPPED}}, []uint64{1, 4}},
+		{"labels", &pb.WatchVMEventsRequest{LabelSelector: "env=prod", EventTypes: []pb.EventType{pb.EventType_EVENT_TYPE_CRASHED}}, []uint64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.SinceSequence = &since
+			rec := watch(t, s, tt.req)
+			for _, seq := range tt.want {
+				assert.Equal(t, seq, rec.next(t).Sequence)
+			}
+			select {
+			case event := <-rec.sent:
+				t.Fatalf("unexpected event %d", event.Sequence)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+
+	err := s.WatchVMEvents(&pb.WatchVMEventsRequest{LabelSelector: "env in (prod"}, &eventRecorder{fakeServerStream: fakeServerStream{ctx: context.Background()}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestEventStream_FiltersBeforeQueueing(t *testing.T) {
+	s, _ := newTestServer(t)
+	journal, err := events.Open("", 100)
+	require.NoError(t, err)
+	es := NewEventStream(journal, 1, s.log)
+
+	narrow := es.Subscribe(func(event *pb.VMEvent) bool { return event.VmId == "vm-2" }, 0)
+	wide := es.Subscribe(nil, 5)
+	assert.Equal(t, 1, cap(narrow.Events))
+	assert.Equal(t, 5, cap(wide.Events))
+	assert.Equal(t, maxSubscriberBuffer, cap(es.Subscribe(nil, 1<<30).Events))
+
+	// Unrelated events do not fill the buffer of a narrow subscriber
+	for i := 0; i < 10; i++ {
+		es.Broadcast(&pb.VMEvent{VmId: "vm-1"})
+	}
+	es.Broadcast(&pb.VMEvent{VmId: "vm-2"})
+	assert.Equal(t, uint64(11), (<-narrow.Events).Sequence)
+	assert.Empty(t, narrow.Lagged)
+
+	// An overflowing subscriber is not sent more events until resumed
+	assert.Len(t, wide.Events, 5)
+	assert.Len(t, wide.Lagged, 1)
+	for i := 0; i < 5; i++ {
+		<-wide.Events
+	}
+	es.Broadcast(&pb.VMEvent{VmId: "vm-1"})
+	assert.Empty(t, wide.Events)
+	es.Resume(wide)
+	es.Broadcast(&pb.VMEvent{VmId: "vm-1"})
+	assert.Equal(t, uint64(13), (<-wide.Events).Sequence)
+}
@@ -2,35 +2,74 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
+	"sort"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
 	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/audit"
+	"github.com/spluca/firecracker-agent/internal/firecracker"
+	"github.com/spluca/firecracker-agent/internal/labels"
 	"github.com/spluca/firecracker-agent/internal/monitor"
 	"github.com/spluca/firecracker-agent/internal/version"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
-// broadcastEvent builds and broadcasts a VMEvent to all subscribers.
-func (s *Server) broadcastEvent(vmID string, state pb.VMState, eventType pb.EventType, message string) {
-	s.eventStream.Broadcast(&pb.VMEvent{
-		VmId:      vmID,
-		State:     state,
-		Message:   message,
-		Timestamp: time.Now().Unix(),
-		Type:      eventType,
-	})
+// VMLabels returns the metadata labels of an existing VM
+func (s *Server) VMLabels(vmID string) (map[string]string, bool) {
+	vmInfo, err := s.fcManager.GetVM(vmID)
+	if err != nil {
+		return nil, false
+	}
+	return vmInfo.Metadata, true
+}
+
+// lifecycleError turns a failed VM operation into a status error if the
+// state of the VM did not allow it, and logs it otherwise
+func (s *Server) lifecycleError(vmID, action string, err error) error {
+	if errors.Is(err, firecracker.ErrInvalidTransition) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	s.log.WithError(err).WithField("vm_id", vmID).Error("Failed to " + action)
+	return nil
 }
 
-// broadcastError broadcasts an error event and logs the error.
-func (s *Server) broadcastError(vmID string, action string, err error) {
-	s.log.WithError(err).Error("Failed to " + action)
-	s.broadcastEvent(vmID, pb.VMState_VM_STATE_ERROR, pb.EventType_EVENT_TYPE_ERROR, err.Error())
+// validateResourceLimits checks the optional per-VM cgroup limits
+func validateResourceLimits(limits *pb.ResourceLimits) error {
+	if limits == nil {
+		return nil
+	}
+	if limits.CpuQuotaPercent < 0 || limits.CpuPeriodUs < 0 || limits.MemoryOverheadMb < 0 || limits.PidsMax < 0 {
+		return status.Error(codes.InvalidArgument, "resource_limits values must not be negative")
+	}
+	if limits.CpuPeriodUs != 0 && (limits.CpuPeriodUs < 1000 || limits.CpuPeriodUs > 1000000) {
+		return status.Error(codes.InvalidArgument, "resource_limits.cpu_period_us must be between 1000 and 1000000")
+	}
+	if limits.CpuWeight < 0 || limits.CpuWeight > 10000 {
+		return status.Error(codes.InvalidArgument, "resource_limits.cpu_weight must be between 1 and 10000")
+	}
+	return nil
+}
+
+// validateRestartPolicy checks the optional restart policy
+func validateRestartPolicy(policy *pb.RestartPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if _, ok := pb.RestartMode_name[int32(policy.Mode)]; !ok {
+		return status.Error(codes.InvalidArgument, "restart_policy.mode is unknown")
+	}
+	if policy.MaxRetries < 0 || policy.InitialBackoffMs < 0 || policy.MaxBackoffMs < 0 {
+		return status.Error(codes.InvalidArgument, "restart_policy values must not be negative")
+	}
+	return nil
 }
 
 // CreateVM creates a new Firecracker VM
@@ -47,29 +86,75 @@ func (s *Server) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.Cre
 	if req.MemoryMb < 128 {
 		return nil, status.Error(codes.InvalidArgument, "memory_mb must be at least 128")
 	}
+	if err := validateResourceLimits(req.ResourceLimits); err != nil {
+		return nil, err
+	}
+	if err := validateRestartPolicy(req.RestartPolicy); err != nil {
+		return nil, err
+	}
+	if req.GetPlacement().GetNumaNode() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "placement.numa_node must not be negative")
+	}
+	if req.GetResourceLimits().GetCpusetCpus() != "" &&
+		(req.GetPlacement().GetExclusive() || req.GetPlacement() != nil && req.Placement.NumaNode != nil) {
+		return nil, status.Error(codes.InvalidArgument, "placement cannot be combined with resource_limits.cpuset_cpus")
+	}
+
+	// Reserve host capacity before provisioning
+	if err := s.admission.Admit(req.VmId, req.VcpuCount, req.MemoryMb); err != nil {
+		if errors.Is(err, ErrInsufficientCapacity) {
+			monitor.VMsAdmissionRejected.Inc()
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return nil, status.Errorf(codes.AlreadyExists, "VM %s already exists", req.VmId)
+	}
+
+	if req.Async {
+		// The operation holds the concurrency slot of the call until it
+		// finishes, then audits its outcome
+		release := keepMutationSlot(ctx)
+		complete := auditCompletion(ctx)
+		op := s.operations.Start(pb.OperationType_OPERATION_TYPE_CREATE_VM, req.VmId, req.Metadata, func(ctx context.Context) proto.Message {
+			defer release()
+			resp := s.createVM(ctx, req)
+			var err error
+			if resp.ErrorMessage != "" && ctx.Err() != nil {
+				err = status.Error(codes.Canceled, resp.ErrorMessage)
+			}
+			complete(resp, err)
+			return resp
+		})
+		return &pb.CreateVMResponse{
+			VmId:        req.VmId,
+			State:       pb.VMState_VM_STATE_CREATING,
+			OperationId: op.OperationId,
+		}, nil
+	}
+	return s.createVM(ctx, req), nil
+}
 
-	// Create VM using Firecracker manager
+// createVM provisions and boots an admitted VM
+func (s *Server) createVM(ctx context.Context, req *pb.CreateVMRequest) *pb.CreateVMResponse {
 	vmInfo, err := s.fcManager.CreateVM(ctx, req)
 	if err != nil {
-		s.broadcastError(req.VmId, "create VM", err)
+		s.admission.Release(req.VmId)
+		s.log.WithError(err).Error("Failed to create VM")
 
 		return &pb.CreateVMResponse{
 			VmId:         req.VmId,
 			State:        pb.VMState_VM_STATE_ERROR,
 			ErrorMessage: err.Error(),
-		}, nil
+		}
 	}
 
-	s.broadcastEvent(req.VmId, pb.VMState_VM_STATE_RUNNING, pb.EventType_EVENT_TYPE_CREATED, "VM created successfully")
 	monitor.VMsCreated.Inc()
-	monitor.VMsRunning.Inc()
 
 	return &pb.CreateVMResponse{
 		VmId:       vmInfo.VmId,
 		State:      vmInfo.State,
 		SocketPath: vmInfo.SocketPath,
 		CreatedAt:  vmInfo.CreatedAt,
-	}, nil
+	}
 }
 
 // StartVM starts an existing VM
@@ -82,8 +167,9 @@ func (s *Server) StartVM(ctx context.Context, req *pb.StartVMRequest) (*pb.Start
 
 	err := s.fcManager.StartVM(ctx, req.VmId)
 	if err != nil {
-		s.broadcastError(req.VmId, "start VM", err)
-
+		if err := s.lifecycleError(req.VmId, "start VM", err); err != nil {
+			return nil, err
+		}
 		return &pb.StartVMResponse{
 			VmId:         req.VmId,
 			State:        pb.VMState_VM_STATE_ERROR,
@@ -91,8 +177,6 @@ func (s *Server) StartVM(ctx context.Context, req *pb.StartVMRequest) (*pb.Start
 		}, nil
 	}
 
-	s.broadcastEvent(req.VmId, pb.VMState_VM_STATE_RUNNING, pb.EventType_EVENT_TYPE_STARTED, "VM started")
-
 	return &pb.StartVMResponse{
 		VmId:  req.VmId,
 		State: pb.VMState_VM_STATE_RUNNING,
@@ -109,8 +193,9 @@ func (s *Server) StopVM(ctx context.Context, req *pb.StopVMRequest) (*pb.StopVMR
 
 	err := s.fcManager.StopVM(ctx, req.VmId, req.Force)
 	if err != nil {
-		s.broadcastError(req.VmId, "stop VM", err)
-
+		if err := s.lifecycleError(req.VmId, "stop VM", err); err != nil {
+			return nil, err
+		}
 		return &pb.StopVMResponse{
 			VmId:         req.VmId,
 			State:        pb.VMState_VM_STATE_ERROR,
@@ -118,9 +203,6 @@ func (s *Server) StopVM(ctx context.Context, req *pb.StopVMRequest) (*pb.StopVMR
 		}, nil
 	}
 
-	s.broadcastEvent(req.VmId, pb.VMState_VM_STATE_STOPPED, pb.EventType_EVENT_TYPE_STOPPED, "VM stopped")
-	monitor.VMsRunning.Dec()
-
 	return &pb.StopVMResponse{
 		VmId:  req.VmId,
 		State: pb.VMState_VM_STATE_STOPPED,
@@ -135,22 +217,47 @@ func (s *Server) DeleteVM(ctx context.Context, req *pb.DeleteVMRequest) (*pb.Del
 		return nil, status.Error(codes.InvalidArgument, "vm_id is required")
 	}
 
-	err := s.fcManager.DeleteVM(ctx, req.VmId)
-	if err != nil {
-		s.log.WithError(err).Error("Failed to delete VM")
+	vmLabels, _ := s.VMLabels(req.VmId)
+
+	if req.Async {
+		release := keepMutationSlot(ctx)
+		complete := auditCompletion(ctx)
+		op := s.operations.Start(pb.OperationType_OPERATION_TYPE_DELETE_VM, req.VmId, vmLabels, func(ctx context.Context) proto.Message {
+			defer release()
+			resp, err := s.deleteVM(ctx, req.VmId)
+			complete(resp, err)
+			if err != nil {
+				return &pb.DeleteVMResponse{VmId: req.VmId, ErrorMessage: status.Convert(err).Message()}
+			}
+			return resp
+		})
+		return &pb.DeleteVMResponse{
+			VmId:        req.VmId,
+			OperationId: op.OperationId,
+		}, nil
+	}
+	return s.deleteVM(ctx, req.VmId)
+}
 
+// deleteVM tears down a VM and releases its capacity. It returns an error
+// only if the state of the VM does not allow the deletion.
+func (s *Server) deleteVM(ctx context.Context, vmID string) (*pb.DeleteVMResponse, error) {
+	err := s.fcManager.DeleteVM(ctx, vmID)
+	if err != nil {
+		if err := s.lifecycleError(vmID, "delete VM", err); err != nil {
+			return nil, err
+		}
 		return &pb.DeleteVMResponse{
-			VmId:         req.VmId,
+			VmId:         vmID,
 			Success:      false,
 			ErrorMessage: err.Error(),
 		}, nil
 	}
 
-	s.broadcastEvent(req.VmId, pb.VMState_VM_STATE_DELETING, pb.EventType_EVENT_TYPE_DELETED, "VM deleted")
-	monitor.VMsRunning.Dec()
+	s.admission.Release(vmID)
 
 	return &pb.DeleteVMResponse{
-		VmId:    req.VmId,
+		VmId:    vmID,
 		Success: true,
 	}, nil
 }
@@ -177,11 +284,70 @@ func (s *Server) GetVM(ctx context.Context, req *pb.GetVMRequest) (*pb.GetVMResp
 func (s *Server) ListVMs(ctx context.Context, req *pb.ListVMsRequest) (*pb.ListVMsResponse, error) {
 	s.log.Debug("Listing VMs")
 
-	vms := s.fcManager.ListVMs()
+	if req.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+	pageSize := defaultListPageSize
+	if req.PageSize != 0 {
+		pageSize = min(int(req.PageSize), maxListPageSize)
+	}
+	match, err := newVMListFilter(req)
+	if err != nil {
+		return nil, err
+	}
+	filters := listFiltersHash(req)
+	var token pageToken
+	if req.PageToken != "" {
+		if token, err = decodePageToken(req.PageToken, filters); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only VMs the caller is authorized to see are listed
+	var vms []*pb.VMInfo
+	for _, vm := range s.fcManager.ListVMs() {
+		if match(vm) && vmAccessible(ctx, vm.Metadata) {
+			vms = append(vms, vm)
+		}
+	}
+	sortVMs(vms)
+
+	start := 0
+	if req.PageToken != "" {
+		start = sort.Search(len(vms), func(i int) bool { return token.after(vms[i]) })
+	}
+	end := min(start+pageSize, len(vms))
 
-	return &pb.ListVMsResponse{
-		Vms:        vms,
+	resp := &pb.ListVMsResponse{
+		Vms:        vms[start:end],
 		TotalCount: int32(len(vms)),
+	}
+	if end < len(vms) {
+		last := vms[end-1]
+		resp.NextPageToken = pageToken{CreatedAt: last.CreatedAt, VMID: last.VmId, Filters: filters}.encode()
+	}
+	return resp, nil
+}
+
+// GetVMStats returns host resource usage for a VM
+func (s *Server) GetVMStats(ctx context.Context, req *pb.GetVMStatsRequest) (*pb.GetVMStatsResponse, error) {
+	s.log.WithField("vm_id", req.VmId).Debug("Getting VM stats")
+
+	if req.VmId == "" {
+		return nil, status.Error(codes.InvalidArgument, "vm_id is required")
+	}
+
+	if _, err := s.fcManager.GetVM(req.VmId); err != nil {
+		return nil, status.Errorf(codes.NotFound, "VM not found: %v", err)
+	}
+
+	stats, err := s.fcManager.GetVMStats(req.VmId)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to read VM stats: %v", err)
+	}
+
+	return &pb.GetVMStatsResponse{
+		Stats: stats,
 	}, nil
 }
 
@@ -189,22 +355,38 @@ func (s *Server) ListVMs(ctx context.Context, req *pb.ListVMsRequest) (*pb.ListV
 func (s *Server) WatchVMEvents(req *pb.WatchVMEventsRequest, stream pb.FirecrackerAgent_WatchVMEventsServer) error {
 	s.log.WithField("vm_id", req.VmId).Info("Client watching VM events")
 
-	// Subscribe to events
-	subscriberID := fmt.Sprintf("watch-%d", time.Now().UnixNano())
-	eventChan := s.eventStream.Subscribe(subscriberID)
-	defer s.eventStream.Unsubscribe(subscriberID)
+	filter, err := newEventFilter(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	// Subscribe before replaying so no event falls between the two
+	sub := s.eventStream.Subscribe(filter, int(req.BufferSize))
+	defer s.eventStream.Unsubscribe(sub)
+
+	w := &eventWatcher{server: s, filter: filter, stream: stream, last: sub.Head}
+	if req.SinceSequence != nil {
+		w.last = *req.SinceSequence
+		if err := w.catchUp(); err != nil {
+			return err
+		}
+	}
 
 	// Stream events
 	for {
 		select {
-		case event := <-eventChan:
-			// Filter by VM ID if specified
-			if req.VmId != "" && event.VmId != req.VmId {
-				continue
+		case event := <-sub.Events:
+			if event.Sequence <= w.last {
+				continue // already replayed
+			}
+			if err := w.send(event); err != nil {
+				return err
 			}
 
-			if err := stream.Send(event); err != nil {
-				s.log.WithError(err).Error("Failed to send event")
+		case <-sub.Lagged:
+			// Events were dropped from the buffer, the journal has them
+			s.eventStream.Resume(sub)
+			if err := w.catchUp(); err != nil {
 				return err
 			}
 
@@ -215,6 +397,85 @@ func (s *Server) WatchVMEvents(req *pb.WatchVMEventsRequest, stream pb.Firecrack
 	}
 }
 
+// newEventFilter returns the filter selecting the events a WatchVMEvents
+// client asked for and may see
+func newEventFilter(ctx context.Context, req *pb.WatchVMEventsRequest) (EventFilter, error) {
+	selector, err := labels.Parse(req.LabelSelector)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid label_selector: %v", err)
+	}
+	types := make(map[pb.EventType]bool, len(req.EventTypes))
+	for _, t := range req.EventTypes {
+		types[t] = true
+	}
+	states := make(map[pb.VMState]bool, len(req.States))
+	for _, st := range req.States {
+		states[st] = true
+	}
+
+	return func(event *pb.VMEvent) bool {
+		if req.VmId != "" && event.VmId != req.VmId {
+			return false
+		}
+		if len(types) > 0 && !types[event.Type] {
+			return false
+		}
+		if len(states) > 0 && !states[event.State] {
+			return false
+		}
+		return selector.Matches(event.Labels) && vmAccessible(ctx, event.Labels)
+	}, nil
+}
+
+// eventWatcher delivers events to one WatchVMEvents client in sequence
+// order, tracking the last sequence it has seen
+type eventWatcher struct {
+	server *Server
+	filter EventFilter
+	stream pb.FirecrackerAgent_WatchVMEventsServer
+	last   uint64
+}
+
+// send delivers an event the client asked for and records its sequence
+func (w *eventWatcher) send(event *pb.VMEvent) error {
+	w.last = event.Sequence
+	if !w.filter(event) {
+		return nil
+	}
+
+	if err := w.stream.Send(event); err != nil {
+		w.server.log.WithError(err).Error("Failed to send event")
+		return err
+	}
+	return nil
+}
+
+// catchUp replays the journaled events after the last one seen. If some
+// are no longer retained, the client is told to resync first.
+func (w *eventWatcher) catchUp() error {
+	missed, complete := w.server.eventStream.Since(w.last)
+	if !complete {
+		monitor.EventsLostTotal.Inc()
+		w.server.log.WithField("after_sequence", w.last).Warn("Events lost for watcher, requesting resync")
+
+		lost := &pb.VMEvent{
+			Type:      pb.EventType_EVENT_TYPE_EVENTS_LOST,
+			Message:   fmt.Sprintf("events after sequence %d are no longer retained, resync before relying on later events", w.last),
+			Timestamp: time.Now().Unix(),
+		}
+		if err := w.stream.Send(lost); err != nil {
+			return err
+		}
+	}
+
+	for _, event := range missed {
+		if err := w.send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetHostInfo returns host system information
 func (s *Server) GetHostInfo(ctx context.Context, req *pb.GetHostInfoRequest) (*pb.GetHostInfoResponse, error) {
 	s.log.Debug("Getting host info")
@@ -234,6 +495,8 @@ func (s *Server) GetHostInfo(ctx context.Context, req *pb.GetHostInfoRequest) (*
 	// Get running VMs count
 	vms := s.fcManager.ListVMs()
 
+	usage := s.admission.Usage()
+
 	return &pb.GetHostInfoResponse{
 		Hostname:          hostname,
 		TotalCpus:         int32(cpuCount),
@@ -242,16 +505,153 @@ func (s *Server) GetHostInfo(ctx context.Context, req *pb.GetHostInfoRequest) (*
 		RunningVms:        int32(len(vms)),
 		CpuUsage:          float32(cpuPercent[0]),
 		Version:           version.Version,
+
+		AllocatedVcpus:      usage.AllocatedVCPUs,
+		AllocatableVcpus:    usage.AllocatableVCPUs,
+		FreeVcpus:           max(usage.AllocatableVCPUs-usage.AllocatedVCPUs, 0),
+		AllocatedMemoryMb:   usage.AllocatedMemoryMB,
+		AllocatableMemoryMb: usage.AllocatableMemoryMB,
+		FreeMemoryMb:        max(usage.AllocatableMemoryMB-usage.AllocatedMemoryMB, 0),
 	}, nil
 }
 
-// HealthCheck returns agent health status
+// HealthCheck returns agent health status with the latest result of each
+// dependency check
 func (s *Server) HealthCheck(ctx context.Context, req *pb.HealthCheckRequest) (*pb.HealthCheckResponse, error) {
 	uptime := int64(time.Since(s.startTime).Seconds())
+	results, healthy := s.health.Results(ctx)
+
+	checks := make([]*pb.HealthCheckResult, 0, len(results))
+	for _, r := range results {
+		checks = append(checks, &pb.HealthCheckResult{
+			Name:       r.Name,
+			Healthy:    r.Healthy,
+			Message:    r.Message,
+			CheckedAt:  r.CheckedAt.Unix(),
+			DurationMs: r.Duration.Milliseconds(),
+		})
+	}
 
 	return &pb.HealthCheckResponse{
-		Healthy:       true,
+		Healthy:       healthy,
 		Version:       version.Version,
 		UptimeSeconds: uptime,
+		Checks:        checks,
 	}, nil
 }
+
+// Limits on the number of records returned by QueryAuditLog
+const (
+	defaultAuditQueryLimit = 1000
+	maxAuditQueryLimit     = 10000
+)
+
+// QueryAuditLog returns audit records matching the time and VM filters
+func (s *Server) QueryAuditLog(ctx context.Context, req *pb.QueryAuditLogRequest) (*pb.QueryAuditLogResponse, error) {
+	if s.auditLog == nil {
+		return nil, status.Error(codes.FailedPrecondition, "audit log is disabled")
+	}
+	if req.StartTime < 0 || req.EndTime < 0 || req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "start_time, end_time and limit must not be negative")
+	}
+	if req.EndTime != 0 && req.EndTime < req.StartTime {
+		return nil, status.Error(codes.InvalidArgument, "end_time must not be before start_time")
+	}
+
+	filter := audit.Filter{
+		VMID:  req.VmId,
+		Limit: defaultAuditQueryLimit,
+	}
+	if req.StartTime != 0 {
+		filter.Since = time.Unix(req.StartTime, 0)
+	}
+	if req.EndTime != 0 {
+		filter.Until = time.Unix(req.EndTime, 0)
+	}
+	if req.Limit != 0 {
+		filter.Limit = min(int(req.Limit), maxAuditQueryLimit)
+	}
+	if !vmAccessUnrestricted(ctx) {
+		// Callers limited to some VMs see the records of those VMs only.
+		// The labels of deleted VMs are gone, so their records are hidden.
+		accessible := make(map[string]bool)
+		filter.Match = func(r *audit.Record) bool {
+			if r.VMID == "" {
+				return false
+			}
+			ok, seen := accessible[r.VMID]
+			if !seen {
+				vmLabels, exists := s.VMLabels(r.VMID)
+				ok = exists && vmAccessible(ctx, vmLabels)
+				accessible[r.VMID] = ok
+			}
+			return ok
+		}
+	}
+
+	records, err := s.auditLog.Query(filter)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to query audit log")
+		return nil, status.Error(codes.Internal, "failed to read audit log")
+	}
+
+	resp := &pb.QueryAuditLogResponse{Records: make([]*pb.AuditRecord, 0, len(records))}
+	for _, r := range records {
+		resp.Records = append(resp.Records, auditRecordToProto(r))
+	}
+	return resp, nil
+}
+
+// GetOperation returns the state of a long-running operation
+func (s *Server) GetOperation(ctx context.Context, req *pb.GetOperationRequest) (*pb.GetOperationResponse, error) {
+	op, err := s.getOperation(ctx, req.OperationId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetOperationResponse{Operation: op}, nil
+}
+
+// WaitOperation waits for a long-running operation to finish
+func (s *Server) WaitOperation(ctx context.Context, req *pb.WaitOperationRequest) (*pb.WaitOperationResponse, error) {
+	if req.TimeoutMs < 0 {
+		return nil, status.Error(codes.InvalidArgument, "timeout_ms must not be negative")
+	}
+	if _, err := s.getOperation(ctx, req.OperationId); err != nil {
+		return nil, err
+	}
+
+	op, ok := s.operations.Wait(ctx, req.OperationId, time.Duration(req.TimeoutMs)*time.Millisecond)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "operation %s not found", req.OperationId)
+	}
+	return &pb.WaitOperationResponse{Operation: op}, nil
+}
+
+// CancelOperation asks a running operation to stop
+func (s *Server) CancelOperation(ctx context.Context, req *pb.CancelOperationRequest) (*pb.CancelOperationResponse, error) {
+	if _, err := s.getOperation(ctx, req.OperationId); err != nil {
+		return nil, err
+	}
+
+	op, cancelled := s.operations.Cancel(req.OperationId)
+	if op == nil {
+		return nil, status.Errorf(codes.NotFound, "operation %s not found", req.OperationId)
+	}
+	if !cancelled {
+		return nil, status.Errorf(codes.FailedPrecondition, "operation %s already finished", req.OperationId)
+	}
+	s.log.WithField("operation_id", op.OperationId).Info("Operation cancellation requested")
+	return &pb.CancelOperationResponse{Operation: op}, nil
+}
+
+// getOperation looks up an operation on a VM the caller may see
+func (s *Server) getOperation(ctx context.Context, id string) (*pb.Operation, error) {
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "operation_id is required")
+	}
+	op, vmLabels, ok := s.operations.Get(id)
+	if !ok || !vmAccessible(ctx, vmLabels) {
+		return nil, status.Errorf(codes.NotFound, "operation %s not found", id)
+	}
+	return op, nil
+}
@@ -0,0 +1,445 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/events"
+	"github.com/spluca/firecracker-agent/internal/firecracker"
+	"github.com/spluca/firecracker-agent/internal/health"
+	"github.com/spluca/firecracker-agent/internal/labels"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// fakeVMManager is an in-memory firecracker.VMManager
+type fakeVMManager struct {
+	vms map[string]*pb.VMInfo
+	mu  sync.Mutex
+}
+
+func newFakeVMManager() *fakeVMManager {
+	return &fakeVMManager{vms: make(map[string]*pb.VMInfo)}
+}
+
+func (f *fakeVMManager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VMInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.vms[req.VmId]; exists {
+		return nil, fmt.Errorf("VM %s already exists", req.VmId)
+	}
+	vm := &pb.VMInfo{
+		VmId:      req.VmId,
+		State:     pb.VMState_VM_STATE_RUNNING,
+		VcpuCount: req.VcpuCount,
+		MemoryMb:  req.MemoryMb,
+		CreatedAt: time.Now().Unix(),
+		Metadata:  req.Metadata,
+	}
+	f.vms[req.VmId] = vm
+	return vm, nil
+}
+
+func (f *fakeVMManager) StartVM(ctx context.Context, vmID string) error {
+	return f.setState(vmID, pb.VMState_VM_STATE_RUNNING, pb.VMState_VM_STATE_RUNNING)
+}
+
+func (f *fakeVMManager) StopVM(ctx context.Context, vmID string, force bool) error {
+	return f.setState(vmID, pb.VMState_VM_STATE_STOPPED,
+		pb.VMState_VM_STATE_RUNNING, pb.VMState_VM_STATE_STOPPED, pb.VMState_VM_STATE_ERROR)
+}
+
+// setState moves a VM in one of the states from to state to
+func (f *fakeVMManager) setState(vmID string, to pb.VMState, from ...pb.VMState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vm, exists := f.vms[vmID]
+	if !exists {
+		return fmt.Errorf("VM %s not found", vmID)
+	}
+	allowed := false
+	for _, state := range from {
+		allowed = allowed || vm.State == state
+	}
+	if !allowed {
+		return fmt.Errorf("%w: VM %s is %s", firecracker.ErrInvalidTransition, vmID, vm.State)
+	}
+	vm.State = to
+	return nil
+}
+
+func (f *fakeVMManager) DeleteVM(ctx context.Context, vmID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vm, exists := f.vms[vmID]
+	if !exists {
+		return fmt.Errorf("VM %s not found", vmID)
+	}
+	if vm.State == pb.VMState_VM_STATE_DELETING {
+		return fmt.Errorf("%w: VM %s is %s", firecracker.ErrInvalidTransition, vmID, vm.State)
+	}
+	delete(f.vms, vmID)
+	return nil
+}
+
+func (f *fakeVMManager) GetVM(vmID string) (*pb.VMInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vm, exists := f.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM %s not found", vmID)
+	}
+	return vm, nil
+}
+
+func (f *fakeVMManager) ListVMs() []*pb.VMInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vms := make([]*pb.VMInfo, 0, len(f.vms))
+	for _, vm := range f.vms {
+		vms = append(vms, vm)
+	}
+	sort.Slice(vms, func(i, j int) bool { return vms[i].VmId < vms[j].VmId })
+	return vms
+}
+
+func (f *fakeVMManager) GetVMStats(vmID string) (*pb.VMStats, error) {
+	if _, err := f.GetVM(vmID); err != nil {
+		return nil, err
+	}
+	return &pb.VMStats{VmId: vmID, Source: "process"}, nil
+}
+
+func (f *fakeVMManager) Close() error {
+	return nil
+}
+
+// newTestServer creates a server backed by a fake VM manager
+func newTestServer(t *testing.T) (*Server, *fakeVMManager) {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	journal, err := events.Open("", 100)
+	require.NoError(t, err)
+
+	fcManager := newFakeVMManager()
+	s := &Server{
+		cfg:         &config.Config{},
+		log:         log,
+		fcManager:   fcManager,
+		startTime:   time.Now(),
+		eventStream: NewEventStream(journal, 100, log),
+		admission: NewAdmissionController(config.CapacityConfig{
+			CPUOvercommitRatio:    1.0,
+			MemoryOvercommitRatio: 1.0,
+		}, 8, 8192),
+		operations: NewOperations(),
+		health:     health.NewChecker(nil, nil),
+	}
+	return s, fcManager
+}
+
+func TestServer_CreateVM_Admission(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := s.CreateVM(ctx, &pb.CreateVMRequest{VmId: "vm-1", VcpuCount: 4, MemoryMb: 4096})
+	require.NoError(t, err)
+
+	_, err = s.CreateVM(ctx, &pb.CreateVMRequest{VmId: "vm-1", VcpuCount: 1, MemoryMb: 128})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = s.CreateVM(ctx, &pb.CreateVMRequest{VmId: "vm-2", VcpuCount: 8, MemoryMb: 128})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = s.DeleteVM(ctx, &pb.DeleteVMRequest{VmId: "vm-1"})
+	require.NoError(t, err)
+
+	_, err = s.CreateVM(ctx, &pb.CreateVMRequest{VmId: "vm-2", VcpuCount: 8, MemoryMb: 128})
+	require.NoError(t, err)
+}
+
+func TestServer_CreateVM_RestartPolicy(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	for _, policy := range []*pb.RestartPolicy{
+		{Mode: pb.RestartMode(42)},
+		{Mode: pb.RestartMode_RESTART_MODE_ON_FAILURE, MaxRetries: -1},
+		{Mode: pb.RestartMode_RESTART_MODE_ALWAYS, InitialBackoffMs: -1},
+	} {
+		_, err := s.CreateVM(ctx, &pb.CreateVMRequest{VmId: "vm-1", VcpuCount: 1, MemoryMb: 128, RestartPolicy: policy})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "policy %v", policy)
+	}
+
+	_, err := s.CreateVM(ctx, &pb.CreateVMRequest{
+		VmId:          "vm-1",
+		VcpuCount:     1,
+		MemoryMb:      128,
+		RestartPolicy: &pb.RestartPolicy{Mode: pb.RestartMode_RESTART_MODE_ON_FAILURE, MaxRetries: 3},
+	})
+	require.NoError(t, err)
+}
+
+func TestServer_CreateVM_Placement(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+	numaNode := int32(0)
+
+	// Placement requests are not silently dropped for an explicit cpuset
+	for _, placement := range []*pb.PlacementRequest{
+		{Exclusive: true},
+		{NumaNode: &numaNode},
+	} {
+		_, err := s.CreateVM(ctx, &pb.CreateVMRequest{
+			VmId: "vm-1", VcpuCount: 1, MemoryMb: 128,
+			ResourceLimits: &pb.ResourceLimits{CpusetCpus: "0-1"},
+			Placement:      placement,
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "placement %v", placement)
+	}
+}
+
+func TestServer_AsyncOperations(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	created, err := s.CreateVM(ctx, &pb.CreateVMRequest{
+		VmId: "vm-1", VcpuCount: 1, MemoryMb: 128, Async: true,
+		Metadata: map[string]string{"owner": "ci-1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, pb.VMState_VM_STATE_CREATING, created.State)
+	require.NotEmpty(t, created.OperationId)
+
+	waited, err := s.WaitOperation(ctx, &pb.WaitOperationRequest{OperationId: created.OperationId})
+	require.NoError(t, err)
+	op := waited.Operation
+	assert.Equal(t, pb.OperationType_OPERATION_TYPE_CREATE_VM, op.Type)
+	assert.Equal(t, pb.OperationStatus_OPERATION_STATUS_SUCCEEDED, op.Status)
+	assert.Equal(t, pb.VMState_VM_STATE_RUNNING, op.GetCreateVm().State)
+
+	// Operations on VMs the caller cannot see are hidden
+	other := contextWithVMAccess(ctx, []labels.Selector{mustParseSelector(t, "owner=ci-2")})
+	_, err = s.GetOperation(other, &pb.GetOperationRequest{OperationId: created.OperationId})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = s.GetOperation(ctx, &pb.GetOperationRequest{OperationId: "op-unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = s.GetOperation(ctx, &pb.GetOperationRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.CancelOperation(ctx, &pb.CancelOperationRequest{OperationId: created.OperationId})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	deleted, err := s.DeleteVM(ctx, &pb.DeleteVMRequest{VmId: "vm-1", Async: true})
+	require.NoError(t, err)
+	waited, err = s.WaitOperation(ctx, &pb.WaitOperationRequest{OperationId: deleted.OperationId, TimeoutMs: 5000})
+	require.NoError(t, err)
+	assert.Equal(t, pb.OperationStatus_OPERATION_STATUS_SUCCEEDED, waited.Operation.Status)
+	assert.True(t, waited.Operation.GetDeleteVm().Success)
+}
+
+func TestServer_InvalidTransitions(t *testing.T) {
+	s, fcManager := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := s.CreateVM(ctx, &pb.CreateVMRequest{VmId: "vm-1", VcpuCount: 1, MemoryMb: 128})
+	require.NoError(t, err)
+	stopped, err := s.StopVM(ctx, &pb.StopVMRequest{VmId: "vm-1"})
+	require.NoError(t, err)
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPED, stopped.State)
+
+	_, err = s.StartVM(ctx, &pb.StartVMRequest{VmId: "vm-1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Stopping a stopped VM cancels a pending restart
+	_, err = s.StopVM(ctx, &pb.StopVMRequest{VmId: "vm-1"})
+	require.NoError(t, err)
+
+	fcManager.vms["vm-1"].State = pb.VMState_VM_STATE_DELETING
+	_, err = s.StopVM(ctx, &pb.StopVMRequest{VmId: "vm-1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = s.DeleteVM(ctx, &pb.DeleteVMRequest{VmId: "vm-1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Unknown VMs still fail in the response
+	resp, err := s.StopVM(ctx, &pb.StopVMRequest{VmId: "vm-2"})
+	require.NoError(t, err)
+	assert.Contains(t, resp.ErrorMessage, "not found")
+}
+
+func TestServer_ListVMs_VMAccess(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	for _, req := range []*pb.CreateVMRequest{
+		{VmId: "vm-1", VcpuCount: 1, MemoryMb: 128, Metadata: map[string]string{"owner": "ci-1"}},
+		{VmId: "vm-2", VcpuCount: 1, MemoryMb: 128, Metadata: map[string]string{"owner": "ci-2"}},
+	} {
+		_, err := s.CreateVM(ctx, req)
+		require.NoError(t, err)
+	}
+
+	resp, err := s.ListVMs(ctx, &pb.ListVMsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, resp.Vms, 2)
+
+	selector := mustParseSelector(t, "owner=ci-1")
+	resp, err = s.ListVMs(contextWithVMAccess(ctx, []labels.Selector{selector}), &pb.ListVMsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Vms, 1)
+	assert.Equal(t, "vm-1", resp.Vms[0].VmId)
+	assert.Equal(t, int32(1), resp.TotalCount)
+}
+
+// addVMs registers VMs with the given creation times in the fake manager
+func addVMs(fake *fakeVMManager, vms ...*pb.VMInfo) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, vm := range vms {
+		if vm.State == pb.VMState_VM_STATE_UNSPECIFIED {
+			vm.State = pb.VMState_VM_STATE_RUNNING
+		}
+		fake.vms[vm.VmId] = vm
+	}
+}
+
+func vmIDs(vms []*pb.VMInfo) []string {
+	ids := make([]string, len(vms))
+	for i, vm := range vms {
+		ids[i] = vm.VmId
+	}
+	return ids
+}
+
+func TestServer_ListVMs_Pagination(t *testing.T) {
+	s, fake := newTestServer(t)
+	ctx := context.Background()
+	addVMs(fake,
+		&pb.VMInfo{VmId: "c", CreatedAt: 100},
+		&pb.VMInfo{VmId: "a", CreatedAt: 200},
+		&pb.VMInfo{VmId: "b", CreatedAt: 100},
+		&pb.VMInfo{VmId: "d", CreatedAt: 300},
+		&pb.VMInfo{VmId: "e", CreatedAt: 400},
+	)
+
+	resp, err := s.ListVMs(ctx, &pb.ListVMsRequest{PageSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, vmIDs(resp.Vms))
+	assert.Equal(t, int32(5), resp.TotalCount)
+	require.NotEmpty(t, resp.NextPageToken)
+
+	// Deleting a listed VM does not shift the next page
+	require.NoError(t, fake.DeleteVM(ctx, "b"))
+	resp, err = s.ListVMs(ctx, &pb.ListVMsRequest{PageSize: 2, PageToken: resp.NextPageToken})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "d"}, vmIDs(resp.Vms))
+	assert.Equal(t, int32(4), resp.TotalCount)
+
+	resp, err = s.ListVMs(ctx, &pb.ListVMsRequest{PageSize: 2, PageToken: resp.NextPageToken})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"e"}, vmIDs(resp.Vms))
+	assert.Empty(t, resp.NextPageToken)
+
+	_, err = s.ListVMs(ctx, &pb.ListVMsRequest{PageToken: "garbage"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// A token only continues a listing with the same filters
+	resp, err = s.ListVMs(ctx, &pb.ListVMsRequest{PageSize: 1})
+	require.NoError(t, err)
+	_, err = s.ListVMs(ctx, &pb.ListVMsRequest{PageSize: 1, PageToken: resp.NextPageToken, NamePrefix: "a"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_ListVMs_Filters(t *testing.T) {
+	s, fake := newTestServer(t)
+	ctx := context.Background()
+	addVMs(fake,
+		&pb.VMInfo{VmId: "web-1", CreatedAt: 100, Metadata: map[string]string{"env": "prod", "tier": "web"}},
+		&pb.VMInfo{VmId: "web-2", CreatedAt: 200, Metadata: map[string]string{"env": "dev", "tier": "web"}, State: pb.VMState_VM_STATE_STOPPED},
+		&pb.VMInfo{VmId: "api-1", CreatedAt: 300, Metadata: map[string]string{"env": "prod", "tier": "api"}},
+		&pb.VMInfo{VmId: "db-1", CreatedAt: 400, Metadata: map[string]string{"env": "prod", "tier": "db"}, State: pb.VMState_VM_STATE_ERROR},
+	)
+
+	tests := []struct {
+		name string
+		req  *pb.ListVMsRequest
+		want []string
+	}{
+		{"states", &pb.ListVMsRequest{States: []pb.VMState{pb.VMState_VM_STATE_STOPPED, pb.VMState_VM_STATE_ERROR}}, []string{"web-2", "db-1"}},
+		{"equality selector", &pb.ListVMsRequest{LabelSelector: "env=prod"}, []string{"web-1", "api-1", "db-1"}},
+		{"set selector", &pb.ListVMsRequest{LabelSelector: "tier in (web,api),env!=dev"}, []string{"web-1", "api-1"}},
+		{"created range", &pb.ListVMsRequest{CreatedAfter: 200, CreatedBefore: 400}, []string{"web-2", "api-1"}},
+		{"name prefix", &pb.ListVMsRequest{NamePrefix: "web-"}, []string{"web-1", "web-2"}},
+		{"combined", &pb.ListVMsRequest{NamePrefix: "web-", States: []pb.VMState{pb.VMState_VM_STATE_RUNNING}}, []string{"web-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.ListVMs(ctx, tt.req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, vmIDs(resp.Vms))
+			assert.Equal(t, int32(len(tt.want)), resp.TotalCount)
+		})
+	}
+
+	for _, req := range []*pb.ListVMsRequest{
+		{PageSize: -1},
+		{LabelSelector: "tier in (web"},
+		{CreatedAfter: 300, CreatedBefore: 200},
+		{CreatedAfter: -1},
+	} {
+		_, err := s.ListVMs(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), req.String())
+	}
+}
+
+func TestServer_HealthCheck(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.grpcHealth = grpchealth.NewServer()
+
+	kvmErr := errors.New("KVM is not accessible")
+	s.health = health.NewChecker([]health.Check{
+		{Name: "bridge", Run: func(ctx context.Context) error { return nil }},
+		{Name: "kvm", Run: func(ctx context.Context) error { return kvmErr }},
+	}, s.setServingStatus)
+
+	resp, err := s.HealthCheck(context.Background(), &pb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.False(t, resp.Healthy)
+	require.Len(t, resp.Checks, 2)
+	assert.True(t, resp.Checks[0].Healthy)
+	assert.Equal(t, "kvm", resp.Checks[1].Name)
+	assert.Equal(t, "KVM is not accessible", resp.Checks[1].Message)
+
+	servingStatus := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := s.grpcHealth.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(pb.FirecrackerAgent_ServiceDesc.ServiceName))
+
+	kvmErr = nil
+	s.health.Run(context.Background())
+	assert.True(t, s.Healthy())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(""))
+}
@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/spluca/firecracker-agent/internal/monitor"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// replayHeader is set on responses replayed from an earlier call
+const replayHeader = "x-idempotent-replay"
+
+// idempotencyKeyField is the request field holding the idempotency key
+const idempotencyKeyField = "request_id"
+
+// Idempotency remembers the outcome of mutating calls made with a
+// request_id, so a retry of a call that timed out on the client gets its
+// result instead of running it again. Keys are scoped to the caller and
+// method.
+type Idempotency struct {
+	ttl     time.Duration
+	maxKeys int
+	now     func() time.Time
+
+	mu    sync.Mutex
+	calls map[string]*idempotentCall
+}
+
+// idempotentCall is a call in progress or its remembered outcome
+type idempotentCall struct {
+	fingerprint string // request without its key, to reject reused keys
+	done        chan struct{}
+	resp        interface{}
+	err         error
+	finished    time.Time
+}
+
+// NewIdempotency creates the idempotency key store
+func NewIdempotency(cfg config.IdempotencyConfig) *Idempotency {
+	return &Idempotency{
+		ttl:     cfg.TTL,
+		maxKeys: cfg.MaxKeys,
+		now:     time.Now,
+		calls:   make(map[string]*idempotentCall),
+	}
+}
+
+// UnaryInterceptor deduplicates mutating calls carrying a request_id. The
+// first call runs to completion even if its caller goes away, holding its
+// concurrency slot and auditing its outcome once known; duplicates arriving
+// meanwhile wait for it. It must run after authorization so a caller only
+// gets results of calls it was allowed to make.
+func (i *Idempotency) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		r, ok := req.(interface{ GetRequestId() string })
+		if !ok || r.GetRequestId() == "" || !isMutatingMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		key := callerKey(ctx) + "\x00" + info.FullMethod + "\x00" + r.GetRequestId()
+		fingerprint := requestFingerprint(req)
+
+		for {
+			call, first, err := i.begin(key, fingerprint)
+			if err != nil {
+				return nil, err
+			}
+			if first {
+				go i.run(context.WithoutCancel(ctx), key, call, req, handler, keepMutationSlot(ctx))
+			}
+
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				if first {
+					complete := auditCompletion(ctx)
+					go func() {
+						<-call.done
+						complete(call.resp, call.err)
+					}()
+				}
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+			if first {
+				return call.resp, call.err
+			}
+			if call.forgotten() {
+				continue // the outcome is not replayed, run the call again
+			}
+			monitor.IdempotentReplays.WithLabelValues(info.FullMethod).Inc()
+			grpc.SetHeader(ctx, metadata.Pairs(replayHeader, "true"))
+			return call.resp, call.err
+		}
+	}
+}
+
+// begin returns the call recorded for key, or records a new one the caller
+// has to run
+func (i *Idempotency) begin(key, fingerprint string) (*idempotentCall, bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if call, ok := i.calls[key]; ok && !i.expired(call) {
+		if call.fingerprint != fingerprint {
+			return nil, false, status.Error(codes.InvalidArgument, "request_id was already used for a different request")
+		}
+		return call, false, nil
+	}
+
+	i.evict()
+	call := &idempotentCall{fingerprint: fingerprint, done: make(chan struct{})}
+	i.calls[key] = call
+	return call, true, nil
+}
+
+// run executes a call and records its outcome, then lets go of the
+// concurrency slot of the call. Outcomes a retry could change, such as rate
+// limiting or a cancelled call, are not remembered.
+func (i *Idempotency) run(ctx context.Context, key string, call *idempotentCall, req interface{}, handler grpc.UnaryHandler, release func()) {
+	defer release()
+	resp, err := handler(ctx, req)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	call.resp, call.err = resp, err
+	if replayable(err) {
+		call.finished = i.now()
+	} else {
+		delete(i.calls, key)
+	}
+	close(call.done)
+}
+
+// forgotten reports whether a finished call was not remembered. It must be
+// called once done is closed.
+func (c *idempotentCall) forgotten() bool {
+	return c.finished.IsZero()
+}
+
+// expired reports whether a finished call is too old to be replayed. The
+// caller must hold i.mu.
+func (i *Idempotency) expired(call *idempotentCall) bool {
+	return !call.finished.IsZero() && i.now().Sub(call.finished) > i.ttl
+}
+
+// evict makes room for a new call by dropping expired calls, then the
+// oldest finished ones. The caller must hold i.mu.
+func (i *Idempotency) evict() {
+	if len(i.calls) < i.maxKeys {
+		return
+	}
+	for key, call := range i.calls {
+		if i.expired(call) {
+			delete(i.calls, key)
+		}
+	}
+	for len(i.calls) >= i.maxKeys {
+		oldestKey := ""
+		var oldest time.Time
+		for key, call := range i.calls {
+			if !call.finished.IsZero() && (oldestKey == "" || call.finished.Before(oldest)) {
+				oldestKey, oldest = key, call.finished
+			}
+		}
+		if oldestKey == "" {
+			return // only calls in progress
+		}
+		delete(i.calls, oldestKey)
+	}
+}
+
+// replayable reports whether a retry gets the outcome of the first call
+func replayable(err error) bool {
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return false
+	}
+	return true
+}
+
+// requestFingerprint hashes a request without its idempotency key
+func requestFingerprint(req interface{}) string {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	msg = proto.Clone(msg)
+	m := msg.ProtoReflect()
+	if fd := m.Descriptor().Fields().ByName(idempotencyKeyField); fd != nil {
+		m.Clear(fd)
+	}
+	data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
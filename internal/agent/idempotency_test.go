@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestIdempotency returns a key store driven by a manual clock
+func newTestIdempotency(maxKeys int) (*Idempotency, func(time.Duration)) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	i := NewIdempotency(config.IdempotencyConfig{TTL: time.Minute, MaxKeys: maxKeys})
+	i.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	return i, func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+// countingHandler creates VMs, counting its calls
+type countingHandler struct {
+	calls   atomic.Int32
+	release chan struct{} // if set, calls block until it is closed
+	err     error
+}
+
+func (h *countingHandler) handle(ctx context.Context, req interface{}) (interface{}, error) {
+	n := h.calls.Add(1)
+	if h.release != nil {
+		<-h.release
+	}
+	if h.err != nil {
+		return nil, h.err
+	}
+	return &pb.CreateVMResponse{VmId: req.(*pb.CreateVMRequest).VmId, CreatedAt: int64(n)}, nil
+}
+
+func createVMCall(ctx context.Context, i *Idempotency, h *countingHandler, req *pb.CreateVMRequest) (*pb.CreateVMResponse, error) {
+	resp, err := i.UnaryInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: agentMethod + "CreateVM"}, h.handle)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.CreateVMResponse), nil
+}
+
+func TestIdempotency_ReplaysResult(t *testing.T) {
+	i, _ := newTestIdempotency(100)
+	h := &countingHandler{}
+	alice := principalContext("alice")
+	req := &pb.CreateVMRequest{VmId: "vm-1", RequestId: "req-1"}
+
+	first, err := createVMCall(alice, i, h, req)
+	require.NoError(t, err)
+	retry, err := createVMCall(alice, i, h, req)
+	require.NoError(t, err)
+	assert.Equal(t, first, retry)
+	assert.Equal(t, int32(1), h.calls.Load())
+
+	// A reused key with a different request is rejected
+	_, err = createVMCall(alice, i, h, &pb.CreateVMRequest{VmId: "vm-2", RequestId: "req-1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Keys are scoped to the caller, and calls without a key always run
+	_, err = createVMCall(principalContext("bob"), i, h, req)
+	require.NoError(t, err)
+	_, err = createVMCall(alice, i, h, &pb.CreateVMRequest{VmId: "vm-1"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), h.calls.Load())
+}
+
+func TestIdempotency_ConcurrentDuplicatesWait(t *testing.T) {
+	i, _ := newTestIdempotency(100)
+	h := &countingHandler{release: make(chan struct{})}
+	req := &pb.CreateVMRequest{VmId: "vm-1", RequestId: "req-1"}
+
+	var wg sync.WaitGroup
+	responses := make([]*pb.CreateVMResponse, 5)
+	for n := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := createVMCall(principalContext("alice"), i, h, req)
+			assert.NoError(t, err)
+			responses[n] = resp
+		}()
+	}
+
+	require.Eventually(t, func() bool { return h.calls.Load() == 1 }, 5*time.Second, time.Millisecond)
+	close(h.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), h.calls.Load())
+	for _, resp := range responses {
+		assert.Same(t, responses[0], resp)
+	}
+}
+
+func TestIdempotency_CallOutlivesCaller(t *testing.T) {
+	i, _ := newTestIdempotency(100)
+	h := &countingHandler{release: make(chan struct{})}
+	req := &pb.CreateVMRequest{VmId: "vm-1", RequestId: "req-1"}
+
+	// The client gives up, the VM is still created
+	ctx, cancel := context.WithCancel(principalContext("alice"))
+	cancel()
+	_, err := createVMCall(ctx, i, h, req)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	close(h.release)
+	resp, err := createVMCall(principalContext("alice"), i, h, req)
+	require.NoError(t, err)
+	assert.Equal(t, "vm-1", resp.VmId)
+	assert.Equal(t, int32(1), h.calls.Load())
+}
+
+func TestIdempotency_TransientErrorsAreRetried(t *testing.T) {
+	i, _ := newTestIdempotency(100)
+	h := &countingHandler{err: status.Error(codes.ResourceExhausted, "too many VM operations in progress")}
+	req := &pb.CreateVMRequest{VmId: "vm-1", RequestId: "req-1"}
+
+	_, err := createVMCall(principalContext("alice"), i, h, req)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	h.err = nil
+	_, err = createVMCall(principalContext("alice"), i, h, req)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), h.calls.Load())
+
+	// Other failures are replayed
+	h.err = status.Error(codes.AlreadyExists, "VM vm-2 already exists")
+	req = &pb.CreateVMRequest{VmId: "vm-2", RequestId: "req-2"}
+	for n := 0; n < 2; n++ {
+		_, err = createVMCall(principalContext("alice"), i, h, req)
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	}
+	assert.Equal(t, int32(3), h.calls.Load())
+}
+
+func TestIdempotency_Expiry(t *testing.T) {
+	i, advance := newTestIdempotency(2)
+	h := &countingHandler{}
+	alice := principalContext("alice")
+	call := func(id string) {
+		_, err := createVMCall(alice, i, h, &pb.CreateVMRequest{VmId: "vm-1", RequestId: id})
+		require.NoError(t, err)
+	}
+
+	call("req-1")
+	advance(2 * time.Minute)
+	call("req-1")
+	assert.Equal(t, int32(2), h.calls.Load(), "expired keys run again")
+
+	// The oldest result is forgotten first when the store is full
+	advance(time.Second)
+	call("req-2")
+	advance(time.Second)
+	call("req-3")
+	call("req-3")
+	call("req-2")
+	assert.Equal(t, int32(4), h.calls.Load())
+	call("req-1")
+	assert.Equal(t, int32(5), h.calls.Load())
+}
+
+func TestIdempotency_AbandonedCallKeepsSlot(t *testing.T) {
+	i, _ := newTestIdempotency(100)
+	r, _ := newTestRateLimiter(config.RateLimitConfig{
+		PerPrincipal:           config.RateLimit{Rate: 100, Burst: 100},
+		MaxConcurrentMutations: 1,
+	})
+	h := &countingHandler{release: make(chan struct{})}
+	info := &grpc.UnaryServerInfo{FullMethod: agentMethod + "CreateVM"}
+	call := func(ctx context.Context, req *pb.CreateVMRequest) error {
+		_, err := r.UnaryInterceptor()(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return i.UnaryInterceptor()(ctx, req, info, h.handle)
+		})
+		return err
+	}
+
+	// The caller gives up while its VM is still being created
+	ctx, cancel := context.WithCancel(principalContext("alice"))
+	cancel()
+	err := call(ctx, &pb.CreateVMRequest{VmId: "vm-1", RequestId: "req-1"})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	err = call(principalContext("alice"), &pb.CreateVMRequest{VmId: "vm-2"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// The slot is released once the creation finishes
+	close(h.release)
+	assert.Eventually(t, func() bool {
+		return call(principalContext("alice"), &pb.CreateVMRequest{VmId: "vm-2"}) == nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"crypto/x509"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Principal identifies the authenticated caller of an RPC
+type Principal struct {
+	Name   string   // Caller identity, e.g. the client certificate common name
+	Groups []string // Groups the caller belongs to
+	Source string   // How the caller was authenticated
+}
+
+// PrincipalSourceMTLS marks principals identified by a client certificate
+const PrincipalSourceMTLS = "mtls"
+
+// principalSources are the ways a caller can be authenticated
+var principalSources = []string{
+	PrincipalSourceMTLS,
+	PrincipalSourceAPIKey,
+	PrincipalSourceJWT,
+	PrincipalSourceUnixPeer,
+}
+
+// QualifiedName returns the name of the principal prefixed with its source,
+// e.g. "jwt:alice", so callers of the same name authenticated in different
+// ways stay apart
+func (p *Principal) QualifiedName() string {
+	return p.Source + ":" + p.Name
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller attached to ctx, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// certificatePrincipal builds a principal from a verified client certificate.
+// The name is the common name, falling back to the first URI or DNS SAN;
+// groups are the certificate organizations and organizational units.
+func certificatePrincipal(cert *x509.Certificate) *Principal {
+	name := cert.Subject.CommonName
+	if name == "" && len(cert.URIs) > 0 {
+		name = cert.URIs[0].String()
+	}
+	if name == "" && len(cert.DNSNames) > 0 {
+		name = cert.DNSNames[0]
+	}
+
+	var groups []string
+	groups = append(groups, cert.Subject.Organization...)
+	groups = append(groups, cert.Subject.OrganizationalUnit...)
+
+	return &Principal{
+		Name:   name,
+		Groups: groups,
+		Source: PrincipalSourceMTLS,
+	}
+}
+
+// peerPrincipal returns the principal of a TLS peer that presented a client
+// certificate verified against the client CA or, failing that, of a local
+// process connected over a Unix socket
+func peerPrincipal(ctx context.Context) (*Principal, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		chains := tlsInfo.State.VerifiedChains
+		if len(chains) > 0 && len(chains[0]) > 0 {
+			return certificatePrincipal(chains[0][0]), true
+		}
+	}
+
+	if addr, ok := p.Addr.(*UnixPeerAddr); ok {
+		return addr.Principal(), true
+	}
+
+	return nil, false
+}
+
+// withPeerPrincipal attaches the TLS peer principal to ctx when present
+func withPeerPrincipal(ctx context.Context) context.Context {
+	if p, ok := peerPrincipal(ctx); ok {
+		return ContextWithPrincipal(ctx, p)
+	}
+	return ctx
+}
+
+// IdentityInterceptor attaches the client certificate or Unix peer identity
+// to the request context so handlers can retrieve it with PrincipalFromContext.
+func IdentityInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		return handler(withPeerPrincipal(ctx), req)
+	}
+}
+
+// IdentityStreamInterceptor is the streaming counterpart of IdentityInterceptor
+func IdentityStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: withPeerPrincipal(ss.Context())})
+	}
+}
+
+// contextStream overrides the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
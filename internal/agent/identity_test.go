@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// tlsPeerContext returns a context whose peer presented the given verified certificate
+func tlsPeerContext(cert *x509.Certificate) context.Context {
+	state := tls.ConnectionState{}
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
+
+func TestCertificatePrincipal(t *testing.T) {
+	p := certificatePrincipal(&x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "ci-runner-1",
+			Organization:       []string{"ci"},
+			OrganizationalUnit: []string{"builders"},
+		},
+	})
+	assert.Equal(t, "ci-runner-1", p.Name)
+	assert.Equal(t, []string{"ci", "builders"}, p.Groups)
+	assert.Equal(t, PrincipalSourceMTLS, p.Source)
+
+	spiffeID, _ := url.Parse("spiffe://example.org/dashboard")
+	p = certificatePrincipal(&x509.Certificate{URIs: []*url.URL{spiffeID}, DNSNames: []string{"dashboard.local"}})
+	assert.Equal(t, "spiffe://example.org/dashboard", p.Name)
+
+	p = certificatePrincipal(&x509.Certificate{DNSNames: []string{"dashboard.local"}})
+	assert.Equal(t, "dashboard.local", p.Name)
+}
+
+func TestIdentityInterceptor(t *testing.T) {
+	interceptor := IdentityInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/firecracker.v1.FirecrackerAgent/ListVMs"}
+
+	var got *Principal
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got, _ = PrincipalFromContext(ctx)
+		return nil, nil
+	}
+
+	ctx := tlsPeerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "dashboard"}})
+	_, err := interceptor(ctx, nil, info, handler)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "dashboard", got.Name)
+
+	// Peers without a verified client certificate stay anonymous
+	got = nil
+	_, err = interceptor(tlsPeerContext(nil), nil, info, handler)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	_, err = interceptor(context.Background(), nil, info, handler)
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+// fakeServerStream is a grpc.ServerStream that only carries a context
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestIdentityStreamInterceptor(t *testing.T) {
+	interceptor := IdentityStreamInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/firecracker.v1.FirecrackerAgent/WatchVMEvents"}
+
+	var got *Principal
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		got, _ = PrincipalFromContext(ss.Context())
+		return nil
+	}
+
+	ctx := tlsPeerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "watcher"}})
+	require.NoError(t, interceptor(nil, &fakeServerStream{ctx: ctx}, info, handler))
+	require.NotNil(t, got)
+	assert.Equal(t, "watcher", got.Name)
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultListPageSize = 100
+	maxListPageSize     = 1000
+)
+
+// pageToken is the position after which the next ListVMs page starts. It
+// is keyed on the list order rather than an offset, so VMs created or
+// deleted between calls do not shift the pages.
+type pageToken struct {
+	CreatedAt int64  `json:"c"`
+	VMID      string `json:"v"`
+	Filters   string `json:"f"` // listFiltersHash of the request it continues
+}
+
+// encode returns the opaque form sent to clients
+func (t pageToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken parses a page token of a request with the given filters
+func decodePageToken(s, filters string) (pageToken, error) {
+	var t pageToken
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &t)
+	}
+	if err != nil {
+		return pageToken{}, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+	if t.Filters != filters {
+		return pageToken{}, status.Error(codes.InvalidArgument, "page_token was issued for different filters")
+	}
+	return t, nil
+}
+
+// after reports whether a VM comes after the token position
+func (t pageToken) after(vm *pb.VMInfo) bool {
+	if vm.CreatedAt != t.CreatedAt {
+		return vm.CreatedAt > t.CreatedAt
+	}
+	return vm.VmId > t.VMID
+}
+
+// listFiltersHash identifies the filters of a ListVMs request, so a page
+// token is not continued with other filters
+func listFiltersHash(req *pb.ListVMsRequest) string {
+	filters := proto.Clone(req).(*pb.ListVMsRequest)
+	filters.PageSize, filters.PageToken = 0, ""
+	data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(filters)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// newVMListFilter returns the filter selecting the VMs a ListVMs request
+// asked for
+func newVMListFilter(req *pb.ListVMsRequest) (func(*pb.VMInfo) bool, error) {
+	if req.CreatedAfter < 0 || req.CreatedBefore < 0 {
+		return nil, status.Error(codes.InvalidArgument, "created_after and created_before must not be negative")
+	}
+	if req.CreatedBefore != 0 && req.CreatedBefore <= req.CreatedAfter {
+		return nil, status.Error(codes.InvalidArgument, "created_before must be after created_after")
+	}
+	selector, err := labels.Parse(req.LabelSelector)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid label_selector: %v", err)
+	}
+	states := make(map[pb.VMState]bool, len(req.States))
+	for _, st := range req.States {
+		states[st] = true
+	}
+
+	return func(vm *pb.VMInfo) bool {
+		if len(states) > 0 && !states[vm.State] {
+			return false
+		}
+		if vm.CreatedAt < req.CreatedAfter {
+			return false
+		}
+		if req.CreatedBefore != 0 && vm.CreatedAt >= req.CreatedBefore {
+			return false
+		}
+		return strings.HasPrefix(vm.VmId, req.NamePrefix) && selector.Matches(vm.Metadata)
+	}, nil
+}
+
+// sortVMs orders VMs by creation time, then ID
+func sortVMs(vms []*pb.VMInfo) {
+	sort.Slice(vms, func(i, j int) bool {
+		if vms[i].CreatedAt != vms[j].CreatedAt {
+			return vms[i].CreatedAt < vms[j].CreatedAt
+		}
+		return vms[i].VmId < vms[j].VmId
+	})
+}
@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// PrincipalSourceUnixPeer marks principals identified by the credentials of
+// the process on the other end of a Unix socket
+const PrincipalSourceUnixPeer = "unix-peer"
+
+// UnixPeerAddr is the remote address of a Unix socket connection. It
+// carries the peer process credentials read with SO_PEERCRED when the
+// connection was accepted.
+type UnixPeerAddr struct {
+	PID int32
+	UID uint32
+	GID uint32
+
+	resolve   sync.Once
+	principal *Principal
+}
+
+// Network implements net.Addr
+func (a *UnixPeerAddr) Network() string {
+	return "unix"
+}
+
+// String implements net.Addr
+func (a *UnixPeerAddr) String() string {
+	return fmt.Sprintf("unix:pid=%d,uid=%d,gid=%d", a.PID, a.UID, a.GID)
+}
+
+// Principal returns the identity of the peer process. The name is the user
+// name of its UID and the groups are the names of its GID and of the
+// user's supplementary groups. IDs without a name are reported as
+// "uid:<n>" and "gid:<n>". The names are looked up on the first call, by
+// the first RPC of the connection, so a slow NSS backend does not hold up
+// accepting connections.
+func (a *UnixPeerAddr) Principal() *Principal {
+	a.resolve.Do(func() {
+		a.principal = lookupUnixPrincipal(a.UID, a.GID)
+	})
+	return a.principal
+}
+
+// newUnixPeerAddr records the credentials of a peer
+func newUnixPeerAddr(cred *unix.Ucred) *UnixPeerAddr {
+	return &UnixPeerAddr{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}
+}
+
+// lookupUnixPrincipal resolves the user and group names of a peer
+func lookupUnixPrincipal(peerUID, peerGID uint32) *Principal {
+	uid := strconv.FormatUint(uint64(peerUID), 10)
+	gid := strconv.FormatUint(uint64(peerGID), 10)
+
+	principal := &Principal{
+		Name:   "uid:" + uid,
+		Groups: []string{groupName(gid)},
+		Source: PrincipalSourceUnixPeer,
+	}
+	if u, err := user.LookupId(uid); err == nil {
+		principal.Name = u.Username
+		if gids, err := u.GroupIds(); err == nil {
+			for _, g := range gids {
+				if g != gid {
+					principal.Groups = append(principal.Groups, groupName(g))
+				}
+			}
+		}
+	}
+	return principal
+}
+
+// groupName returns the name of a group, or "gid:<n>" if it has none
+func groupName(gid string) string {
+	if g, err := user.LookupGroupId(gid); err == nil {
+		return g.Name
+	}
+	return "gid:" + gid
+}
+
+// Listen opens a listener for the gRPC server. Unix sockets are created
+// with the configured mode and group, replacing a stale socket left by a
+// previous run, and their connections carry the peer credentials as
+// remote address.
+func Listen(cfg config.ListenerConfig) (net.Listener, error) {
+	if cfg.Network != "unix" {
+		return net.Listen(cfg.Network, cfg.Address)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Address), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if info, err := os.Lstat(cfg.Address); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", cfg.Address)
+		}
+		if err := os.Remove(cfg.Address); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSocketOwnership(cfg); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &peerCredListener{Listener: listener}, nil
+}
+
+// setSocketOwnership applies the configured mode and group to a socket file
+func setSocketOwnership(cfg config.ListenerConfig) error {
+	mode, err := cfg.FileMode()
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(cfg.Address, mode); err != nil {
+		return fmt.Errorf("failed to set socket mode: %w", err)
+	}
+
+	if cfg.Group == "" {
+		return nil
+	}
+	gid, err := strconv.Atoi(cfg.Group)
+	if err != nil {
+		g, err := user.LookupGroup(cfg.Group)
+		if err != nil {
+			return fmt.Errorf("unknown socket group %q: %w", cfg.Group, err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	if err := os.Chown(cfg.Address, -1, gid); err != nil {
+		return fmt.Errorf("failed to set socket group: %w", err)
+	}
+
+	return nil
+}
+
+// peerCredListener reads the credentials of each accepted Unix socket peer
+type peerCredListener struct {
+	net.Listener
+}
+
+// Accept implements net.Listener. Connections whose credentials cannot be
+// read are dropped, since returning an error would stop the gRPC server.
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		unixConn, ok := conn.(*net.UnixConn)
+		if !ok {
+			return conn, nil
+		}
+		cred, err := peerCredentials(unixConn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		return &peerCredConn{UnixConn: unixConn, remoteAddr: newUnixPeerAddr(cred)}, nil
+	}
+}
+
+// peerCredentials reads SO_PEERCRED from a Unix socket connection
+func peerCredentials(conn *net.UnixConn) (*unix.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("SO_PEERCRED: %w", credErr)
+	}
+
+	return cred, nil
+}
+
+// peerCredConn is a Unix socket connection reporting the peer credentials
+// as its remote address
+type peerCredConn struct {
+	*net.UnixConn
+	remoteAddr *UnixPeerAddr
+}
+
+// RemoteAddr implements net.Conn
+func (c *peerCredConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
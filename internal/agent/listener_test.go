@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func unixListenerConfig(t *testing.T) config.ListenerConfig {
+	t.Helper()
+	return config.ListenerConfig{
+		Network: "unix",
+		Address: filepath.Join(t.TempDir(), "run", "agent.sock"),
+		Mode:    "0600",
+	}
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	cfg := unixListenerConfig(t)
+	listener, err := Listen(cfg)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	info, err := os.Stat(cfg.Address)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+		close(accepted)
+	}()
+
+	client, err := net.Dial("unix", cfg.Address)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn := <-accepted
+	require.NotNil(t, conn)
+	defer conn.Close()
+
+	addr, ok := conn.RemoteAddr().(*UnixPeerAddr)
+	require.True(t, ok)
+	assert.Equal(t, int32(os.Getpid()), addr.PID)
+	assert.Equal(t, uint32(os.Getuid()), addr.UID)
+	assert.Equal(t, uint32(os.Getgid()), addr.GID)
+
+	assert.Nil(t, addr.principal, "names are not looked up by Accept")
+
+	current, err := user.Current()
+	require.NoError(t, err)
+	principal := addr.Principal()
+	assert.Equal(t, current.Username, principal.Name)
+	assert.Equal(t, PrincipalSourceUnixPeer, principal.Source)
+	assert.NotEmpty(t, principal.Groups)
+}
+
+func TestListen_StaleSocket(t *testing.T) {
+	cfg := unixListenerConfig(t)
+
+	// A socket file left behind by a crashed agent is replaced
+	require.NoError(t, os.MkdirAll(filepath.Dir(cfg.Address), 0755))
+	stale, err := net.Listen("unix", cfg.Address)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := Listen(cfg)
+	require.NoError(t, err)
+	listener.Close()
+
+	// Other files are left alone
+	require.NoError(t, os.WriteFile(cfg.Address, []byte("data"), 0644))
+	_, err = Listen(cfg)
+	assert.Error(t, err)
+}
+
+func TestListen_UnixPeerPrincipal(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	principals := make(chan *Principal, 1)
+	recordPrincipal := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		p, _ := PrincipalFromContext(ctx)
+		principals <- p
+		return handler(ctx, req)
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(IdentityInterceptor(), recordPrincipal))
+	s.Register(grpcServer)
+
+	cfg := unixListenerConfig(t)
+	listener, err := Listen(cfg)
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("unix://"+cfg.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = pb.NewFirecrackerAgentClient(conn).HealthCheck(context.Background(), &pb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	current, err := user.Current()
+	require.NoError(t, err)
+	principal := <-principals
+	require.NotNil(t, principal)
+	assert.Equal(t, current.Username, principal.Name)
+	assert.Equal(t, PrincipalSourceUnixPeer, principal.Source)
+}
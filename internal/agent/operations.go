@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/firecracker"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// operationRetention is how long finished operations can be looked up
+	operationRetention = time.Hour
+	// maxFinishedOperations bounds the finished operations kept
+	maxFinishedOperations = 1000
+)
+
+// Operations tracks the long-running operations started by async CreateVM
+// and DeleteVM calls
+type Operations struct {
+	mu  sync.Mutex
+	ops map[string]*operation
+}
+
+// operation is a call running in the background
+type operation struct {
+	info   *pb.Operation     // guarded by Operations.mu
+	labels map[string]string // of the VM, to check the access of callers
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// operationFunc runs an operation and returns the response of the call,
+// holding an error message if it failed
+type operationFunc func(ctx context.Context) proto.Message
+
+// NewOperations creates an empty operation store
+func NewOperations() *Operations {
+	return &Operations{ops: make(map[string]*operation)}
+}
+
+// Start runs fn in the background and returns the new operation. Progress
+// reported by the VM manager through the context is recorded as the
+// operation stage.
+func (o *Operations) Start(opType pb.OperationType, vmID string, vmLabels map[string]string, fn operationFunc) *pb.Operation {
+	now := time.Now().Unix()
+	op := &operation{
+		info: &pb.Operation{
+			OperationId: newOperationID(),
+			Type:        opType,
+			VmId:        vmID,
+			Status:      pb.OperationStatus_OPERATION_STATUS_RUNNING,
+			Stage:       pb.OperationStage_OPERATION_STAGE_QUEUED,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		labels: vmLabels,
+		done:   make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	op.cancel = cancel
+	ctx = firecracker.ContextWithProgress(ctx, func(stage pb.OperationStage) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		op.info.Stage = stage
+		op.info.UpdatedAt = time.Now().Unix()
+	})
+
+	o.mu.Lock()
+	o.prune()
+	o.ops[op.info.OperationId] = op
+	snapshot := proto.Clone(op.info).(*pb.Operation)
+	o.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		o.finish(op, fn(ctx))
+	}()
+	return snapshot
+}
+
+// finish records the response of an operation
+func (o *Operations) finish(op *operation, result proto.Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info := op.info
+	info.UpdatedAt = time.Now().Unix()
+	switch r := result.(type) {
+	case *pb.CreateVMResponse:
+		info.Result = &pb.Operation_CreateVm{CreateVm: r}
+		info.ErrorMessage = r.ErrorMessage
+	case *pb.DeleteVMResponse:
+		info.Result = &pb.Operation_DeleteVm{DeleteVm: r}
+		info.ErrorMessage = r.ErrorMessage
+	}
+
+	switch {
+	case info.ErrorMessage == "":
+		info.Status = pb.OperationStatus_OPERATION_STATUS_SUCCEEDED
+	case info.CancelRequested:
+		info.Status = pb.OperationStatus_OPERATION_STATUS_CANCELLED
+	default:
+		info.Status = pb.OperationStatus_OPERATION_STATUS_FAILED
+	}
+	close(op.done)
+}
+
+// Get returns a snapshot of an operation and the labels of its VM
+func (o *Operations) Get(id string) (*pb.Operation, map[string]string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok {
+		return nil, nil, false
+	}
+	return proto.Clone(op.info).(*pb.Operation), op.labels, true
+}
+
+// Wait waits until an operation finishes, ctx is done or timeout passes,
+// whichever comes first, and returns its state. A zero timeout waits for
+// the operation or ctx only.
+func (o *Operations) Wait(ctx context.Context, id string, timeout time.Duration) (*pb.Operation, bool) {
+	o.mu.Lock()
+	op, ok := o.ops[id]
+	o.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-op.done:
+	case <-expired:
+	case <-ctx.Done():
+	}
+
+	info, _, ok := o.Get(id)
+	return info, ok
+}
+
+// Cancel asks a running operation to stop. A VM creation stops at its next
+// stage and releases what it set up; an operation past its last stage
+// completes anyway. It returns false if the operation finished already or
+// does not exist, in which case the operation is nil.
+func (o *Operations) Cancel(id string) (*pb.Operation, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	op, ok := o.ops[id]
+	if !ok {
+		return nil, false
+	}
+	if op.info.Status != pb.OperationStatus_OPERATION_STATUS_RUNNING {
+		return proto.Clone(op.info).(*pb.Operation), false
+	}
+	op.info.CancelRequested = true
+	op.info.UpdatedAt = time.Now().Unix()
+	op.cancel()
+	return proto.Clone(op.info).(*pb.Operation), true
+}
+
+// prune forgets finished operations past their retention, and the oldest
+// ones beyond the limit. The caller must hold o.mu.
+func (o *Operations) prune() {
+	cutoff := time.Now().Add(-operationRetention).Unix()
+	var finished []*pb.Operation
+	for id, op := range o.ops {
+		if op.info.Status == pb.OperationStatus_OPERATION_STATUS_RUNNING {
+			continue
+		}
+		if op.info.UpdatedAt < cutoff {
+			delete(o.ops, id)
+			continue
+		}
+		finished = append(finished, op.info)
+	}
+
+	for len(finished) > maxFinishedOperations {
+		oldest := 0
+		for i, info := range finished {
+			if info.UpdatedAt < finished[oldest].UpdatedAt {
+				oldest = i
+			}
+		}
+		delete(o.ops, finished[oldest].OperationId)
+		finished = append(finished[:oldest], finished[oldest+1:]...)
+	}
+}
+
+// newOperationID returns a random operation ID
+func newOperationID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "op-" + hex.EncodeToString(b)
+}
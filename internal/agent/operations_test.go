@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/firecracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// stageFunc reports each stage, waiting for a release in between, and
+// fails if the operation is cancelled
+func stageFunc(release chan struct{}, stages ...pb.OperationStage) operationFunc {
+	return func(ctx context.Context) proto.Message {
+		for _, stage := range stages {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return &pb.CreateVMResponse{VmId: "vm-1", State: pb.VMState_VM_STATE_ERROR, ErrorMessage: ctx.Err().Error()}
+			}
+			_ = firecracker.ReportProgress(ctx, stage)
+		}
+		return &pb.CreateVMResponse{VmId: "vm-1", State: pb.VMState_VM_STATE_RUNNING}
+	}
+}
+
+func TestOperations_Progress(t *testing.T) {
+	o := NewOperations()
+	release := make(chan struct{})
+	op := o.Start(pb.OperationType_OPERATION_TYPE_CREATE_VM, "vm-1", nil, stageFunc(release,
+		pb.OperationStage_OPERATION_STAGE_STORAGE_PREPARED,
+		pb.OperationStage_OPERATION_STAGE_NETWORK_READY,
+	))
+	assert.Equal(t, pb.OperationStatus_OPERATION_STATUS_RUNNING, op.Status)
+	assert.Equal(t, pb.OperationStage_OPERATION_STAGE_QUEUED, op.Stage)
+
+	release <- struct{}{}
+	require.Eventually(t, func() bool {
+		info, _, _ := o.Get(op.OperationId)
+		return info.Stage == pb.OperationStage_OPERATION_STAGE_STORAGE_PREPARED
+	}, 5*time.Second, time.Millisecond)
+
+	// A wait that times out returns the running operation
+	info, ok := o.Wait(context.Background(), op.OperationId, 10*time.Millisecond)
+	require.True(t, ok)
+	assert.Equal(t, pb.OperationStatus_OPERATION_STATUS_RUNNING, info.Status)
+
+	release <- struct{}{}
+	info, ok = o.Wait(context.Background(), op.OperationId, 0)
+	require.True(t, ok)
+	assert.Equal(t, pb.OperationStatus_OPERATION_STATUS_SUCCEEDED, info.Status)
+	assert.Equal(t, pb.OperationStage_OPERATION_STAGE_NETWORK_READY, info.Stage)
+	assert.Equal(t, pb.VMState_VM_STATE_RUNNING, info.GetCreateVm().State)
+
+	_, cancelled := o.Cancel(op.OperationId)
+	assert.False(t, cancelled, "finished operations cannot be cancelled")
+}
+
+func TestOperations_Cancel(t *testing.T) {
+	o := NewOperations()
+	op := o.Start(pb.OperationType_OPERATION_TYPE_CREATE_VM, "vm-1", nil, stageFunc(make(chan struct{}),
+		pb.OperationStage_OPERATION_STAGE_STORAGE_PREPARED,
+	))
+
+	info, cancelled := o.Cancel(op.OperationId)
+	require.True(t, cancelled)
+	assert.True(t, info.CancelRequested)
+
+	info, ok := o.Wait(context.Background(), op.OperationId, 0)
+	require.True(t, ok)
+	assert.Equal(t, pb.OperationStatus_OPERATION_STATUS_CANCELLED, info.Status)
+	assert.NotEmpty(t, info.ErrorMessage)
+
+	info, cancelled = o.Cancel("op-unknown")
+	assert.Nil(t, info)
+	assert.False(t, cancelled)
+}
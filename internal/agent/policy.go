@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/spluca/firecracker-agent/internal/labels"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy maps callers to the RPC methods and VMs they may access. A request
+// is allowed when any rule matches the caller and method and, for requests
+// that target a VM, the VM labels match the rule's selector. Principals and
+// groups are qualified with how the caller was authenticated, so a Unix user
+// and a JWT subject of the same name get different grants.
+//
+// Example:
+//
+//	public_methods: ["HealthCheck"]
+//	rules:
+//	  - name: ci-runners
+//	    groups: ["jwt:ci"]
+//	    methods: ["CreateVM", "DeleteVM", "GetVM", "ListVMs"]
+//	    vm_selector: "owner=${principal}"
+//	  - name: dashboards
+//	    groups: ["mtls:dashboards"]
+//	    methods: ["GetVM", "ListVMs", "WatchVMEvents", "GetHostInfo"]
+type Policy struct {
+	PublicMethods []string     `yaml:"public_methods"` // Methods allowed without authentication
+	Rules         []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule grants a set of callers access to methods on matching VMs
+type PolicyRule struct {
+	Name       string   `yaml:"name"`
+	Principals []string `yaml:"principals"`  // "<source>:<name>", "*" for any authenticated caller
+	Groups     []string `yaml:"groups"`      // "<source>:<group>", matched against Principal.Groups
+	Methods    []string `yaml:"methods"`     // Method names ("CreateVM") or full methods, "*" for all
+	VMSelector string   `yaml:"vm_selector"` // Label selector; "${principal}" expands to the caller name
+
+	selector labels.Selector
+}
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if len(rule.Principals) == 0 && len(rule.Groups) == 0 {
+			return nil, fmt.Errorf("policy rule %q matches no principals or groups", rule.Name)
+		}
+		if len(rule.Methods) == 0 {
+			return nil, fmt.Errorf("policy rule %q allows no methods", rule.Name)
+		}
+		for _, name := range append(slices.Clone(rule.Principals), rule.Groups...) {
+			if err := validateQualifiedName(name); err != nil {
+				return nil, fmt.Errorf("policy rule %q: %w", rule.Name, err)
+			}
+		}
+		rule.selector, err = labels.Parse(rule.VMSelector)
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %q: %w", rule.Name, err)
+		}
+	}
+
+	return &p, nil
+}
+
+// IsPublic reports whether the method may be called without authentication
+func (p *Policy) IsPublic(fullMethod string) bool {
+	return matchMethod(p.PublicMethods, fullMethod)
+}
+
+// Selectors returns the VM selectors of all rules granting the caller access
+// to the method, expanded for the caller. No selectors means access is denied;
+// an empty selector grants access to every VM.
+func (p *Policy) Selectors(principal *Principal, fullMethod string) []labels.Selector {
+	if principal == nil {
+		return nil
+	}
+
+	expand := func(name string) string {
+		if name == "principal" {
+			return principal.Name
+		}
+		return ""
+	}
+
+	var selectors []labels.Selector
+	for _, rule := range p.Rules {
+		if rule.matchesPrincipal(principal) && matchMethod(rule.Methods, fullMethod) {
+			selectors = append(selectors, rule.selector.Expand(expand))
+		}
+	}
+	return selectors
+}
+
+// validateQualifiedName checks that a principal or group of a rule names
+// the source it is authenticated by, e.g. "unix-peer:alice"
+func validateQualifiedName(name string) error {
+	if name == "*" {
+		return nil
+	}
+	source, rest, ok := strings.Cut(name, ":")
+	if !ok || rest == "" || !slices.Contains(principalSources, source) {
+		return fmt.Errorf("%q must be qualified with one of the sources %s, e.g. %q",
+			name, strings.Join(principalSources, ", "), PrincipalSourceJWT+":"+name)
+	}
+	return nil
+}
+
+// matchesPrincipal reports whether the rule applies to the caller
+func (r *PolicyRule) matchesPrincipal(principal *Principal) bool {
+	if slices.Contains(r.Principals, "*") || slices.Contains(r.Principals, principal.QualifiedName()) {
+		return true
+	}
+	for _, group := range principal.Groups {
+		if slices.Contains(r.Groups, principal.Source+":"+group) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMethod reports whether fullMethod ("/pkg.Service/Method") is listed
+// by its full or short name
+func matchMethod(methods []string, fullMethod string) bool {
+	short := path.Base(fullMethod)
+	for _, m := range methods {
+		if m == "*" || m == short || m == fullMethod {
+			return true
+		}
+	}
+	return false
+}
+
+// selectorsMatch reports whether any selector matches the labels
+func selectorsMatch(selectors []labels.Selector, vmLabels map[string]string) bool {
+	for _, s := range selectors {
+		if s.Matches(vmLabels) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,294 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spluca/firecracker-agent/internal/monitor"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// retryAfterHeader carries the number of seconds a rejected caller should
+// wait before retrying
+const retryAfterHeader = "retry-after"
+
+// bucketSweepInterval is how often idle token buckets are dropped
+const bucketSweepInterval = time.Minute
+
+// concurrencyRetryAfter is suggested to callers rejected because too many
+// VM operations are in progress
+const concurrencyRetryAfter = time.Second
+
+// concurrencyLimitedMethods are the RPCs counted against the global
+// concurrency cap
+var concurrencyLimitedMethods = map[string]bool{
+	"CreateVM": true,
+	"DeleteVM": true,
+}
+
+// tokenBucket is a rate limiter refilled continuously at rate tokens per
+// second up to burst tokens
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit config.RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  float64(limit.Burst),
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+// take consumes a token. When none is available it returns how long until
+// one will be.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// full reports whether the bucket has refilled, so dropping it loses nothing
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// RateLimiter enforces per caller token buckets, for all RPCs and per RPC,
+// and caps the number of concurrent CreateVM/DeleteVM calls
+type RateLimiter struct {
+	cfg       config.RateLimitConfig
+	now       func() time.Time
+	mutations chan struct{}
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a rate limiter from the configuration
+func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
+	r := &RateLimiter{
+		cfg:     cfg,
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+	if cfg.MaxConcurrentMutations > 0 {
+		r.mutations = make(chan struct{}, cfg.MaxConcurrentMutations)
+	}
+	r.lastSweep = r.now()
+	return r
+}
+
+// callerKey identifies the caller a bucket belongs to. Unauthenticated
+// callers are keyed by their address.
+func callerKey(ctx context.Context) string {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return "principal:" + p.QualifiedName()
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		return "peer:" + addr
+	}
+	return "anonymous"
+}
+
+// allow charges a call to the caller's buckets. On rejection it returns
+// which limit was hit and when to retry.
+func (r *RateLimiter) allow(ctx context.Context, fullMethod string) (bool, string, time.Duration) {
+	caller := callerKey(ctx)
+	method := path.Base(fullMethod)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.sweep(now)
+
+	if ok, wait := r.bucket(caller, r.cfg.PerPrincipal, now).take(now); !ok {
+		return false, "principal", wait
+	}
+	if limit, ok := r.cfg.PerMethod[method]; ok {
+		if ok, wait := r.bucket(caller+"|"+method, limit, now).take(now); !ok {
+			return false, "method", wait
+		}
+	}
+	return true, "", 0
+}
+
+// bucket returns the bucket for key, creating it on first use. Callers
+// must hold r.mu.
+func (r *RateLimiter) bucket(key string, limit config.RateLimit, now time.Time) *tokenBucket {
+	b, ok := r.buckets[key]
+	if !ok {
+		b = newTokenBucket(limit, now)
+		r.buckets[key] = b
+	}
+	return b
+}
+
+// sweep drops buckets that have refilled so idle callers do not accumulate.
+// Callers must hold r.mu.
+func (r *RateLimiter) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < bucketSweepInterval {
+		return
+	}
+	r.lastSweep = now
+	for key, b := range r.buckets {
+		if b.full(now) {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// acquireMutation takes a concurrency slot for CreateVM/DeleteVM. The
+// returned function releases it.
+func (r *RateLimiter) acquireMutation(fullMethod string) (func(), bool) {
+	if r.mutations == nil || !isAgentMethod(fullMethod) || !concurrencyLimitedMethods[path.Base(fullMethod)] {
+		return func() {}, true
+	}
+	select {
+	case r.mutations <- struct{}{}:
+		monitor.GRPCMutationsInFlight.Inc()
+		return func() {
+			<-r.mutations
+			monitor.GRPCMutationsInFlight.Dec()
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// mutationSlotKey is the context key of the concurrency slot of a call
+type mutationSlotKey struct{}
+
+// mutationSlot is the concurrency slot held by a CreateVM/DeleteVM call.
+// The call holds it until it returns, and work outliving the call, such as
+// an async operation, can hold it as well. It is released once its last
+// holder lets go.
+type mutationSlot struct {
+	mu      sync.Mutex
+	holders int
+	release func()
+}
+
+// hold adds a holder of the slot. The returned function drops it and may
+// be called more than once.
+func (s *mutationSlot) hold() func() {
+	s.mu.Lock()
+	s.holders++
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.holders--
+			if s.holders == 0 {
+				s.release()
+			}
+		})
+	}
+}
+
+// keepMutationSlot holds the concurrency slot of the call in ctx for work
+// continuing in the background, so the cap covers it until it finishes.
+// The returned function lets go of the slot.
+func keepMutationSlot(ctx context.Context) func() {
+	slot, ok := ctx.Value(mutationSlotKey{}).(*mutationSlot)
+	if !ok {
+		return func() {}
+	}
+	return slot.hold()
+}
+
+// reject builds the RESOURCE_EXHAUSTED error for a limited call and the
+// metadata telling the caller when to retry
+func reject(fullMethod, limit string, wait time.Duration) (metadata.MD, error) {
+	monitor.GRPCRateLimitedTotal.WithLabelValues(fullMethod, limit).Inc()
+
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	md := metadata.Pairs(retryAfterHeader, strconv.FormatInt(seconds, 10))
+
+	if limit == "concurrency" {
+		return md, status.Error(codes.ResourceExhausted, "too many VM operations in progress, retry later")
+	}
+	return md, status.Errorf(codes.ResourceExhausted, "%s rate limit exceeded, retry in %ds", limit, seconds)
+}
+
+// UnaryInterceptor rate limits unary calls
+func (r *RateLimiter) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if ok, limit, wait := r.allow(ctx, info.FullMethod); !ok {
+			md, err := reject(info.FullMethod, limit, wait)
+			grpc.SetHeader(ctx, md)
+			return nil, err
+		}
+
+		release, ok := r.acquireMutation(info.FullMethod)
+		if !ok {
+			md, err := reject(info.FullMethod, "concurrency", concurrencyRetryAfter)
+			grpc.SetHeader(ctx, md)
+			return nil, err
+		}
+		slot := &mutationSlot{release: release}
+		drop := slot.hold()
+		defer drop()
+
+		return handler(context.WithValue(ctx, mutationSlotKey{}, slot), req)
+	}
+}
+
+// StreamInterceptor rate limits the start of streaming calls
+func (r *RateLimiter) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if ok, limit, wait := r.allow(ss.Context(), info.FullMethod); !ok {
+			md, err := reject(info.FullMethod, limit, wait)
+			ss.SetHeader(md)
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
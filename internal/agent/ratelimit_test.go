@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// newTestRateLimiter returns a limiter driven by a manual clock
+func newTestRateLimiter(cfg config.RateLimitConfig) (*RateLimiter, func(time.Duration)) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRateLimiter(cfg)
+	r.now = func() time.Time { return now }
+	r.lastSweep = now
+	return r, func(d time.Duration) { now = now.Add(d) }
+}
+
+func okHandler(ctx context.Context, req interface{}) (interface{}, error) {
+	return "ok", nil
+}
+
+func principalContext(name string) context.Context {
+	return ContextWithPrincipal(context.Background(), &Principal{Name: name, Source: PrincipalSourceAPIKey})
+}
+
+func TestRateLimiter_PerPrincipal(t *testing.T) {
+	r, advance := newTestRateLimiter(config.RateLimitConfig{
+		PerPrincipal: config.RateLimit{Rate: 1, Burst: 2},
+	})
+	interceptor := r.UnaryInterceptor()
+	call := func(ctx context.Context) error {
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: agentMethod + "ListVMs"}, okHandler)
+		return err
+	}
+
+	alice := principalContext("alice")
+	require.NoError(t, call(alice))
+	require.NoError(t, call(alice))
+
+	err := call(alice)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "principal rate limit exceeded")
+
+	// Buckets are per caller
+	require.NoError(t, call(principalContext("bob")))
+
+	// Tokens refill over time
+	advance(time.Second)
+	require.NoError(t, call(alice))
+	assert.Error(t, call(alice))
+}
+
+func TestRateLimiter_AnonymousCallersByAddress(t *testing.T) {
+	r, _ := newTestRateLimiter(config.RateLimitConfig{
+		PerPrincipal: config.RateLimit{Rate: 1, Burst: 1},
+	})
+	interceptor := r.UnaryInterceptor()
+	call := func(ip string, port int) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}})
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: agentMethod + "ListVMs"}, okHandler)
+		return err
+	}
+
+	require.NoError(t, call("10.0.0.1", 1000))
+	// A new connection from the same host shares the bucket
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("10.0.0.1", 1001)))
+	require.NoError(t, call("10.0.0.2", 1000))
+}
+
+func TestRateLimiter_PerMethod(t *testing.T) {
+	r, _ := newTestRateLimiter(config.RateLimitConfig{
+		PerPrincipal: config.RateLimit{Rate: 100, Burst: 100},
+		PerMethod: map[string]config.RateLimit{
+			"CreateVM": {Rate: 1, Burst: 1},
+		},
+	})
+	interceptor := r.UnaryInterceptor()
+	call := func(method string) error {
+		_, err := interceptor(principalContext("alice"), nil, &grpc.UnaryServerInfo{FullMethod: agentMethod + method}, okHandler)
+		return err
+	}
+
+	require.NoError(t, call("CreateVM"))
+	err := call("CreateVM")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "method rate limit exceeded")
+
+	// Other methods are only subject to the per principal limit
+	require.NoError(t, call("ListVMs"))
+}
+
+func TestRateLimiter_ConcurrentMutations(t *testing.T) {
+	r, _ := newTestRateLimiter(config.RateLimitConfig{
+		PerPrincipal:           config.RateLimit{Rate: 100, Burst: 100},
+		MaxConcurrentMutations: 1,
+	})
+	interceptor := r.UnaryInterceptor()
+	call := func(method string, handler grpc.UnaryHandler) error {
+		_, err := interceptor(principalContext("alice"), nil, &grpc.UnaryServerInfo{FullMethod: agentMethod + method}, handler)
+		return err
+	}
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- call("CreateVM", func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(started)
+			<-finish
+			return "ok", nil
+		})
+	}()
+	<-started
+
+	err := call("DeleteVM", okHandler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "too many VM operations in progress")
+
+	// Other RPCs do not count against the cap
+	require.NoError(t, call("StopVM", okHandler))
+
+	close(finish)
+	require.NoError(t, <-done)
+
+	// The slot is released once the call completes
+	require.NoError(t, call("DeleteVM", okHandler))
+}
+
+func TestRateLimiter_AsyncMutationsKeepSlot(t *testing.T) {
+	r, _ := newTestRateLimiter(config.RateLimitConfig{
+		PerPrincipal:           config.RateLimit{Rate: 100, Burst: 100},
+		MaxConcurrentMutations: 1,
+	})
+	interceptor := r.UnaryInterceptor()
+	call := func(method string, handler grpc.UnaryHandler) error {
+		_, err := interceptor(principalContext("alice"), nil, &grpc.UnaryServerInfo{FullMethod: agentMethod + method}, handler)
+		return err
+	}
+
+	// An async call returns at once but its operation keeps the slot
+	var release func()
+	require.NoError(t, call("CreateVM", func(ctx context.Context, req interface{}) (interface{}, error) {
+		release = keepMutationSlot(ctx)
+		return "ok", nil
+	}))
+
+	err := call("CreateVM", okHandler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	release()
+	require.NoError(t, call("CreateVM", okHandler))
+}
+
+func TestRateLimiter_Sweep(t *testing.T) {
+	r, advance := newTestRateLimiter(config.RateLimitConfig{
+		PerPrincipal: config.RateLimit{Rate: 1, Burst: 5},
+	})
+
+	ok, _, _ := r.allow(principalContext("alice"), agentMethod+"ListVMs")
+	require.True(t, ok)
+	require.Len(t, r.buckets, 1)
+
+	advance(bucketSweepInterval)
+	ok, _, _ = r.allow(principalContext("bob"), agentMethod+"ListVMs")
+	require.True(t, ok)
+
+	// alice's bucket refilled and was dropped, bob's is still in use
+	assert.Len(t, r.buckets, 1)
+	assert.Contains(t, r.buckets, "principal:api-key:bob")
+}
+
+func TestReject_RetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		wait     time.Duration
+		expected string
+	}{
+		{"rounds up", 1500 * time.Millisecond, "2"},
+		{"at least one second", 10 * time.Millisecond, "1"},
+		{"whole seconds", 3 * time.Second, "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := reject(agentMethod+"CreateVM", "principal", tt.wait)
+			assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+			assert.Equal(t, []string{tt.expected}, md.Get(retryAfterHeader))
+		})
+	}
+}
@@ -2,15 +2,24 @@ package agent
 
 import (
 	"context"
+	"fmt"
+	"runtime"
 	"sync"
 	"time"
 
+	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/sirupsen/logrus"
 	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/audit"
+	"github.com/spluca/firecracker-agent/internal/events"
 	"github.com/spluca/firecracker-agent/internal/firecracker"
+	"github.com/spluca/firecracker-agent/internal/health"
 	"github.com/spluca/firecracker-agent/internal/monitor"
+	"github.com/spluca/firecracker-agent/internal/webhook"
 	"github.com/spluca/firecracker-agent/pkg/config"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 // Server implements the FirecrackerAgent gRPC service
@@ -22,6 +31,14 @@ type Server struct {
 	fcManager   firecracker.VMManager
 	startTime   time.Time
 	eventStream *EventStream
+	admission   *AdmissionController
+	auditLog    *audit.Log
+	webhooks    *webhook.Dispatcher // nil without webhook sinks
+	operations  *Operations
+	health      *health.Checker
+	grpcHealth  *grpchealth.Server
+	stopCh      chan struct{}
+	closeOnce   sync.Once
 	mu          sync.RWMutex
 }
 
@@ -33,21 +50,172 @@ func NewServer(cfg *config.Config, log *logrus.Logger, startTime time.Time) (*Se
 		return nil, err
 	}
 
-	return &Server{
+	vmem, err := mem.VirtualMemory()
+	if err != nil {
+		fcManager.Close()
+		return nil, fmt.Errorf("failed to read host memory: %w", err)
+	}
+	admission := NewAdmissionController(cfg.Capacity, runtime.NumCPU(), int64(vmem.Total/1024/1024))
+
+	// Account for VMs the manager already knows about
+	for _, vm := range fcManager.ListVMs() {
+		if err := admission.Admit(vm.VmId, vm.VcpuCount, vm.MemoryMb); err != nil {
+			log.WithError(err).WithField("vm_id", vm.VmId).Warn("Existing VM exceeds host capacity")
+		}
+	}
+
+	usage := admission.Usage()
+	log.WithFields(logrus.Fields{
+		"allocatable_vcpus":     usage.AllocatableVCPUs,
+		"allocatable_memory_mb": usage.AllocatableMemoryMB,
+	}).Info("Admission control initialized")
+
+	var auditLog *audit.Log
+	if cfg.Audit.Enabled {
+		auditLog, err = audit.Open(cfg.Audit)
+		if err != nil {
+			fcManager.Close()
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		if err := auditLog.Verify(); err != nil {
+			log.WithError(err).Error("Audit log hash chain verification failed")
+		}
+		log.WithField("path", cfg.Audit.Path).Info("Audit log enabled")
+	}
+
+	journal, err := events.Open(cfg.Events.JournalDir, cfg.Events.MaxEvents)
+	if err != nil {
+		fcManager.Close()
+		if auditLog != nil {
+			auditLog.Close()
+		}
+		return nil, fmt.Errorf("failed to open event journal: %w", err)
+	}
+	log.WithFields(logrus.Fields{
+		"journal_dir":   cfg.Events.JournalDir,
+		"last_sequence": journal.LastSequence(),
+	}).Info("Event journal opened")
+
+	s := &Server{
 		cfg:         cfg,
 		log:         log,
 		fcManager:   fcManager,
 		startTime:   startTime,
-		eventStream: NewEventStream(log),
-	}, nil
+		eventStream: NewEventStream(journal, cfg.Events.SubscriberBuffer, log),
+		admission:   admission,
+		auditLog:    auditLog,
+		operations:  NewOperations(),
+		grpcHealth:  grpchealth.NewServer(),
+		stopCh:      make(chan struct{}),
+	}
+	s.setServingStatus(false)
+
+	if len(cfg.Webhooks.Sinks) > 0 {
+		s.webhooks, err = webhook.New(cfg.Webhooks, s.eventStream, log)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to set up webhooks: %w", err)
+		}
+		sub := s.eventStream.Subscribe(nil, 0)
+		s.webhooks.Start()
+		go s.notifyWebhooks(sub)
+		log.WithField("sinks", len(cfg.Webhooks.Sinks)).Info("Webhook delivery enabled")
+	}
+
+	fcManager.SetEventHandler(s.eventStream.Broadcast)
+	s.health = health.NewChecker(s.healthChecks(), s.setServingStatus)
+	go s.health.Start(cfg.Health.CheckInterval, s.stopCh)
+
+	return s, nil
+}
+
+// notifyWebhooks wakes the webhook sinks whenever an event is broadcast
+func (s *Server) notifyWebhooks(sub *Subscription) {
+	defer s.eventStream.Unsubscribe(sub)
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-sub.Events:
+		case <-sub.Lagged:
+			s.eventStream.Resume(sub)
+		}
+		s.webhooks.Notify()
+	}
+}
+
+// healthChecks returns the checks of the dependencies VMs need
+func (s *Server) healthChecks() []health.Check {
+	checks := []health.Check{
+		health.BridgeCheck(s.cfg.Placement.SysfsRoot, s.cfg.Network.BridgeName),
+		health.VMsDirCheck(s.cfg.Storage.VMsDir, s.cfg.Health.MinFreeDiskMB),
+		health.BinaryCheck("firecracker", s.cfg.Firecracker.BinaryPath),
+		health.KVMCheck(s.cfg.Health.KVMDevice),
+	}
+	if s.cfg.Firecracker.UseJailer != nil && *s.cfg.Firecracker.UseJailer {
+		checks = append(checks, health.BinaryCheck("jailer", s.cfg.Firecracker.JailerPath))
+	}
+
+	// A pass may be missed while a long operation holds the manager lock
+	if r, ok := s.fcManager.(interface{ LastReconcile() time.Time }); ok {
+		checks = append(checks, health.ReconcilerCheck(r.LastReconcile, 3*s.cfg.Health.ReconcileInterval))
+	}
+
+	return checks
+}
+
+// setServingStatus publishes the agent health on the grpc.health.v1 service,
+// both for the server as a whole and for the FirecrackerAgent service
+func (s *Server) setServingStatus(healthy bool) {
+	servingStatus := healthpb.HealthCheckResponse_NOT_SERVING
+	if healthy {
+		servingStatus = healthpb.HealthCheckResponse_SERVING
+	} else if s.health != nil {
+		s.log.Warn("Agent is unhealthy, see HealthCheck for failing checks")
+	}
+
+	s.grpcHealth.SetServingStatus("", servingStatus)
+	s.grpcHealth.SetServingStatus(pb.FirecrackerAgent_ServiceDesc.ServiceName, servingStatus)
+}
+
+// Healthy reports whether all health checks passed in their latest run
+func (s *Server) Healthy() bool {
+	return s.health.Healthy()
 }
 
 // Register registers the gRPC service
 func (s *Server) Register(grpcServer *grpc.Server) {
 	pb.RegisterFirecrackerAgentServer(grpcServer, s)
+	if s.grpcHealth != nil {
+		healthpb.RegisterHealthServer(grpcServer, s.grpcHealth)
+	}
 	s.log.Info("gRPC service registered")
 }
 
+// Close releases resources held by the server
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		if s.stopCh != nil {
+			close(s.stopCh)
+		}
+		if s.grpcHealth != nil {
+			s.grpcHealth.Shutdown()
+		}
+	})
+	if s.auditLog != nil {
+		if err := s.auditLog.Close(); err != nil {
+			s.log.WithError(err).Error("Failed to close audit log")
+		}
+	}
+	if s.webhooks != nil {
+		s.webhooks.Close()
+	}
+	if err := s.eventStream.Close(); err != nil {
+		s.log.WithError(err).Error("Failed to close event journal")
+	}
+	return s.fcManager.Close()
+}
+
 // LoggingInterceptor logs all gRPC requests and records Prometheus metrics.
 func LoggingInterceptor(log *logrus.Logger) grpc.UnaryServerInterceptor {
 	return func(
@@ -67,6 +235,9 @@ func LoggingInterceptor(log *logrus.Logger) grpc.UnaryServerInterceptor {
 			"method":   info.FullMethod,
 			"duration": duration.String(),
 		}
+		if p, ok := PrincipalFromContext(ctx); ok {
+			fields["principal"] = p.Name
+		}
 
 		if err != nil {
 			statusLabel = "error"
@@ -82,57 +253,3 @@ func LoggingInterceptor(log *logrus.Logger) grpc.UnaryServerInterceptor {
 		return resp, err
 	}
 }
-
-// EventStream manages VM event subscribers
-type EventStream struct {
-	subscribers map[string]chan *pb.VMEvent
-	mu          sync.RWMutex
-	log         *logrus.Logger
-}
-
-// NewEventStream creates a new event stream
-func NewEventStream(log *logrus.Logger) *EventStream {
-	return &EventStream{
-		subscribers: make(map[string]chan *pb.VMEvent),
-		log:         log,
-	}
-}
-
-// Subscribe adds a new subscriber
-func (es *EventStream) Subscribe(id string) chan *pb.VMEvent {
-	es.mu.Lock()
-	defer es.mu.Unlock()
-
-	ch := make(chan *pb.VMEvent, 100)
-	es.subscribers[id] = ch
-	return ch
-}
-
-// Unsubscribe removes a subscriber
-func (es *EventStream) Unsubscribe(id string) {
-	es.mu.Lock()
-	defer es.mu.Unlock()
-
-	if ch, exists := es.subscribers[id]; exists {
-		close(ch)
-		delete(es.subscribers, id)
-	}
-}
-
-// Broadcast sends an event to all subscribers
-func (es *EventStream) Broadcast(event *pb.VMEvent) {
-	es.mu.RLock()
-	defer es.mu.RUnlock()
-
-	for id, ch := range es.subscribers {
-		select {
-		case ch <- event:
-		default:
-			es.log.WithFields(logrus.Fields{
-				"subscriber_id": id,
-				"vm_id":         event.VmId,
-				"event_type":    event.Type.String(),
-			}).Warn("Event dropped: subscriber channel full")
-		}
-	}
-}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"google.golang.org/grpc/credentials"
+)
+
+// CertReloader holds the server certificate and client CA pool and reloads
+// them from disk. Each TLS handshake uses the most recently loaded files, so
+// established connections are not affected by a reload.
+type CertReloader struct {
+	cfg       config.TLSConfig
+	log       *logrus.Logger
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+	mu        sync.RWMutex
+}
+
+// NewCertReloader loads the certificates referenced by cfg
+func NewCertReloader(cfg config.TLSConfig, log *logrus.Logger) (*CertReloader, error) {
+	r := &CertReloader{
+		cfg: cfg,
+		log: log,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate, key and client CA bundle from disk. On
+// failure the previously loaded certificates remain in use.
+func (r *CertReloader) Reload() error {
+	modTimes := r.currentModTimes()
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.mu.Unlock()
+
+	return nil
+}
+
+// TLSConfig returns a server TLS configuration that picks up reloaded
+// certificates on every new connection
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientCAs:    r.clientCAs,
+				ClientAuth:   r.clientAuth(),
+			}, nil
+		},
+	}
+}
+
+// Credentials returns gRPC transport credentials backed by the reloader
+func (r *CertReloader) Credentials() credentials.TransportCredentials {
+	return credentials.NewTLS(r.TLSConfig())
+}
+
+// Watch reloads the certificates whenever one of the files changes, checking
+// every interval until stopCh is closed
+func (r *CertReloader) Watch(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.log.WithError(err).Error("Failed to reload TLS certificates")
+				continue
+			}
+			r.log.Info("TLS certificates reloaded")
+		}
+	}
+}
+
+// clientAuth returns the client certificate policy
+func (r *CertReloader) clientAuth() tls.ClientAuthType {
+	switch {
+	case r.cfg.RequireClientCert:
+		return tls.RequireAndVerifyClientCert
+	case r.clientCAs != nil:
+		return tls.VerifyClientCertIfGiven
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// changed reports whether any certificate file was modified since the last load
+func (r *CertReloader) changed() bool {
+	current := r.currentModTimes()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for file, modTime := range current {
+		if !modTime.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentModTimes returns the modification time of each configured file
+func (r *CertReloader) currentModTimes() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	return modTimes
+}
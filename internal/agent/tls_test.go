@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, serial int64, subject pkix.Name, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeServerCerts writes a server certificate, key and client CA bundle to dir
+func writeServerCerts(t *testing.T, ca *testCA, dir string, serial int64) config.TLSConfig {
+	t.Helper()
+
+	certPEM, keyPEM := ca.issue(t, serial, pkix.Name{CommonName: "fc-agent"}, x509.ExtKeyUsageServerAuth)
+
+	cfg := config.TLSConfig{
+		Enabled:      true,
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	require.NoError(t, os.WriteFile(cfg.CertFile, certPEM, 0644))
+	require.NoError(t, os.WriteFile(cfg.KeyFile, keyPEM, 0600))
+	require.NoError(t, os.WriteFile(cfg.ClientCAFile, ca.pem, 0644))
+
+	return cfg
+}
+
+// handshake connects to a TLS listener and returns the server certificate serial
+func handshake(t *testing.T, addr string, clientCfg *tls.Config) (*big.Int, error) {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, clientCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Client certificate verification failures surface on the first read
+	// with TLS 1.3, after the client considers the handshake complete
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
+		}
+	}
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber, nil
+}
+
+// serveTLS accepts TLS connections and completes their handshake until the test ends
+func serveTLS(t *testing.T, r *CertReloader) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				conn.Read(make([]byte, 1))
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestCertReloader_Reload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	cfg := writeServerCerts(t, ca, dir, 10)
+
+	r, err := NewCertReloader(cfg, logrus.New())
+	require.NoError(t, err)
+	addr := serveTLS(t, r)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCfg := &tls.Config{RootCAs: roots}
+
+	serial, err := handshake(t, addr, clientCfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), serial.Int64())
+
+	// New connections use the rotated certificate after a reload
+	writeServerCerts(t, ca, dir, 11)
+	require.NoError(t, r.Reload())
+
+	serial, err = handshake(t, addr, clientCfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), serial.Int64())
+
+	// A broken certificate keeps the previous one in use
+	require.NoError(t, os.WriteFile(cfg.CertFile, []byte("not a certificate"), 0644))
+	assert.Error(t, r.Reload())
+
+	serial, err = handshake(t, addr, clientCfg)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), serial.Int64())
+}
+
+func TestCertReloader_Changed(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	cfg := writeServerCerts(t, ca, dir, 1)
+
+	r, err := NewCertReloader(cfg, logrus.New())
+	require.NoError(t, err)
+	assert.False(t, r.changed())
+
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(cfg.CertFile, future, future))
+	assert.True(t, r.changed())
+
+	require.NoError(t, r.Reload())
+	assert.False(t, r.changed())
+}
+
+func TestCertReloader_RequireClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := writeServerCerts(t, ca, t.TempDir(), 1)
+	cfg.RequireClientCert = true
+
+	r, err := NewCertReloader(cfg, logrus.New())
+	require.NoError(t, err)
+	addr := serveTLS(t, r)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	_, err = handshake(t, addr, &tls.Config{RootCAs: roots})
+	assert.Error(t, err)
+
+	certPEM, keyPEM := ca.issue(t, 2, pkix.Name{CommonName: "ci-runner"}, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	_, err = handshake(t, addr, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}})
+	assert.NoError(t, err)
+}
+
+func TestNewCertReloader_InvalidFiles(t *testing.T) {
+	_, err := NewCertReloader(config.TLSConfig{
+		CertFile: "/nonexistent/server.crt",
+		KeyFile:  "/nonexistent/server.key",
+	}, logrus.New())
+	assert.Error(t, err)
+
+	ca := newTestCA(t)
+	cfg := writeServerCerts(t, ca, t.TempDir(), 1)
+	require.NoError(t, os.WriteFile(cfg.ClientCAFile, []byte("garbage"), 0644))
+
+	_, err = NewCertReloader(cfg, logrus.New())
+	assert.Error(t, err)
+}
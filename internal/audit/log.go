@@ -0,0 +1,353 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+)
+
+// Record is a single audited operation. Records form a hash chain: each
+// record's Hash covers its content and the Hash of the previous record, so
+// modifying or removing a record breaks verification of every later one.
+type Record struct {
+	Sequence        uint64          `json:"seq"`
+	Time            time.Time       `json:"time"`
+	Principal       string          `json:"principal,omitempty"`
+	PrincipalSource string          `json:"principal_source,omitempty"`
+	Peer            string          `json:"peer,omitempty"`
+	Method          string          `json:"method"`
+	VMID            string          `json:"vm_id,omitempty"`
+	Request         json.RawMessage `json:"request,omitempty"`
+	Code            string          `json:"code"`
+	Error           string          `json:"error,omitempty"`
+	DurationMs      int64           `json:"duration_ms"`
+	Completion      bool            `json:"completion,omitempty"` // Outcome of work the call did not wait for
+	PrevHash        string          `json:"prev_hash"`
+	Hash            string          `json:"hash"`
+}
+
+// computeHash returns the chain hash of the record
+func (r Record) computeHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(r.PrevHash), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Filter selects records in Query. Zero fields match everything.
+type Filter struct {
+	Since time.Time
+	Until time.Time
+	VMID  string
+	Limit int                // Maximum number of records, keeping the most recent
+	Match func(*Record) bool // Optional, e.g. to keep the records a caller may see
+}
+
+func (f Filter) matches(r *Record) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Time.After(f.Until) {
+		return false
+	}
+	if f.VMID != "" && r.VMID != f.VMID {
+		return false
+	}
+	if f.Match != nil && !f.Match(r) {
+		return false
+	}
+	return true
+}
+
+// Log is an append-only, hash-chained JSON lines audit log. The active file
+// is rotated to "<path>.<timestamp>" once it exceeds the configured size and
+// only the newest rotated files are kept.
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file     *os.File
+	size     int64
+	seq      uint64
+	lastHash string
+	mu       sync.Mutex
+}
+
+// Open opens the audit log, continuing the hash chain of existing records
+func Open(cfg config.AuditConfig) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	l := &Log{
+		path:     cfg.Path,
+		maxSize:  int64(cfg.MaxSizeMB) * 1024 * 1024,
+		maxFiles: cfg.MaxFiles,
+	}
+
+	// Resume the chain from the newest record on disk
+	files, err := l.files()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		last, err := lastRecord(files[i])
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			l.seq = last.Sequence
+			l.lastHash = last.Hash
+			break
+		}
+	}
+
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// openFile opens the active file for appending
+func (l *Log) openFile() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// Append assigns the record its sequence number and chain hash and writes it
+func (l *Log) Append(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+
+	if l.maxSize > 0 && l.size >= l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	r.Sequence = l.seq + 1
+	r.Time = r.Time.UTC()
+	r.PrevHash = l.lastHash
+	hash, err := r.computeHash()
+	if err != nil {
+		return fmt.Errorf("failed to hash audit record: %w", err)
+	}
+	r.Hash = hash
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	data = append(data, '\n')
+
+	if _, err := l.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+
+	l.seq = r.Sequence
+	l.lastHash = r.Hash
+	l.size += int64(len(data))
+
+	return nil
+}
+
+// rotate moves the active file aside, opens a new one and prunes old files
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	l.file = nil
+
+	rotated := l.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(l.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	if err := l.openFile(); err != nil {
+		return err
+	}
+
+	rotatedFiles, err := l.rotatedFiles()
+	if err != nil {
+		return err
+	}
+	if l.maxFiles > 0 && len(rotatedFiles) > l.maxFiles {
+		for _, f := range rotatedFiles[:len(rotatedFiles)-l.maxFiles] {
+			os.Remove(f)
+		}
+	}
+
+	return nil
+}
+
+// rotatedFiles returns the rotated files, oldest first
+func (l *Log) rotatedFiles() ([]string, error) {
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// files returns all audit files in chain order, the active file last
+func (l *Log) files() ([]string, error) {
+	files, err := l.rotatedFiles()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(l.path); err == nil {
+		files = append(files, l.path)
+	}
+	return files, nil
+}
+
+// Query returns the records matching filter in chronological order
+func (l *Log) Query(filter Filter) ([]Record, error) {
+	l.mu.Lock()
+	files, err := l.files()
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, file := range files {
+		err := readRecords(file, func(r *Record) error {
+			if filter.matches(r) {
+				records = append(records, *r)
+				if filter.Limit > 0 && len(records) > filter.Limit {
+					records = records[1:]
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// Verify checks the hash chain across all retained files. The first
+// retained record may follow pruned records, so its PrevHash is trusted.
+func (l *Log) Verify() error {
+	l.mu.Lock()
+	files, err := l.files()
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var prev *Record
+	for _, file := range files {
+		err := readRecords(file, func(r *Record) error {
+			if prev != nil {
+				if r.Sequence != prev.Sequence+1 {
+					return fmt.Errorf("audit record %d follows %d", r.Sequence, prev.Sequence)
+				}
+				if r.PrevHash != prev.Hash {
+					return fmt.Errorf("audit record %d does not chain to record %d", r.Sequence, prev.Sequence)
+				}
+			}
+			hash, err := r.computeHash()
+			if err != nil {
+				return err
+			}
+			if hash != r.Hash {
+				return fmt.Errorf("audit record %d has been modified", r.Sequence)
+			}
+			rec := *r
+			prev = &rec
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the active file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// readRecords calls fn for every record in file
+func readRecords(file string, fn func(*Record) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("%s:%d: invalid audit record: %w", file, line, err)
+		}
+		if err := fn(&r); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return nil
+}
+
+// lastRecord returns the last record of file, or nil if it has none
+func lastRecord(file string) (*Record, error) {
+	var last *Record
+	err := readRecords(file, func(r *Record) error {
+		last = r
+		return nil
+	})
+	return last, err
+}
@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestLog(t *testing.T, cfg config.AuditConfig) *Log {
+	t.Helper()
+	l, err := Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestLog_AppendAndVerify(t *testing.T) {
+	cfg := config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit", "audit.jsonl")}
+	l := openTestLog(t, cfg)
+
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Append(Record{
+			Time:    base.Add(time.Duration(i) * time.Minute),
+			Method:  "/firecracker.v1.FirecrackerAgent/CreateVM",
+			VMID:    fmt.Sprintf("vm-%d", i),
+			Request: []byte(`{"vm_id": "x"}`),
+			Code:    "OK",
+		}))
+	}
+	require.NoError(t, l.Verify())
+
+	records, err := l.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, uint64(1), records[0].Sequence)
+	assert.Empty(t, records[0].PrevHash)
+	assert.Equal(t, records[0].Hash, records[1].PrevHash)
+	assert.Equal(t, records[1].Hash, records[2].PrevHash)
+
+	// The chain continues after reopening
+	require.NoError(t, l.Close())
+	l = openTestLog(t, cfg)
+	require.NoError(t, l.Append(Record{Time: base.Add(time.Hour), Method: "m", Code: "OK"}))
+	require.NoError(t, l.Verify())
+
+	records, err = l.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, uint64(4), records[3].Sequence)
+	assert.Equal(t, records[2].Hash, records[3].PrevHash)
+}
+
+func TestLog_VerifyDetectsTampering(t *testing.T) {
+	tests := map[string]func(lines []string) []string{
+		"modified record": func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "vm-1", "vm-9", 1)
+			return lines
+		},
+		"removed record": func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		},
+		"reordered records": func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		},
+	}
+
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.jsonl")}
+			l := openTestLog(t, cfg)
+			for i := 0; i < 3; i++ {
+				require.NoError(t, l.Append(Record{Time: time.Now(), Method: "m", VMID: fmt.Sprintf("vm-%d", i), Code: "OK"}))
+			}
+			require.NoError(t, l.Close())
+
+			data, err := os.ReadFile(cfg.Path)
+			require.NoError(t, err)
+			lines := tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			require.NoError(t, os.WriteFile(cfg.Path, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+
+			l = openTestLog(t, cfg)
+			assert.Error(t, l.Verify())
+		})
+	}
+}
+
+func TestLog_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	l := openTestLog(t, config.AuditConfig{
+		Path:      filepath.Join(dir, "audit.jsonl"),
+		MaxSizeMB: 1,
+		MaxFiles:  2,
+	})
+
+	// Each record is roughly 2 KiB, so the active file rotates every ~500 records
+	request := []byte(`{"padding":"` + strings.Repeat("x", 2000) + `"}`)
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, l.Append(Record{Time: time.Now(), Method: "m", Request: request, Code: "OK"}))
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "audit.jsonl.*"))
+	require.NoError(t, err)
+	assert.Len(t, rotated, 2)
+
+	// Pruning drops the oldest records but the retained chain stays valid
+	require.NoError(t, l.Verify())
+	records, err := l.Query(Filter{})
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	assert.Greater(t, records[0].Sequence, uint64(1))
+	assert.Equal(t, uint64(2000), records[len(records)-1].Sequence)
+}
+
+func TestLog_Query(t *testing.T) {
+	l := openTestLog(t, config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.jsonl")})
+
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Append(Record{
+			Time:   base.Add(time.Duration(i) * time.Minute),
+			Method: "m",
+			VMID:   fmt.Sprintf("vm-%d", i%2),
+			Code:   "OK",
+		}))
+	}
+
+	sequences := func(records []Record) []uint64 {
+		var seqs []uint64
+		for _, r := range records {
+			seqs = append(seqs, r.Sequence)
+		}
+		return seqs
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []uint64
+	}{
+		{"vm", Filter{VMID: "vm-1"}, []uint64{2, 4, 6, 8, 10}},
+		{"since", Filter{Since: base.Add(7 * time.Minute)}, []uint64{8, 9, 10}},
+		{"until", Filter{Until: base.Add(1 * time.Minute)}, []uint64{1, 2}},
+		{"range and vm", Filter{Since: base.Add(2 * time.Minute), Until: base.Add(6 * time.Minute), VMID: "vm-0"}, []uint64{3, 5, 7}},
+		{"limit keeps the most recent", Filter{VMID: "vm-0", Limit: 2}, []uint64{7, 9}},
+		{"no match", Filter{VMID: "vm-9"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := l.Query(tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, sequences(records))
+		})
+	}
+}
+
+func TestLog_AppendAfterClose(t *testing.T) {
+	l := openTestLog(t, config.AuditConfig{Path: filepath.Join(t.TempDir(), "audit.jsonl")})
+	require.NoError(t, l.Close())
+	assert.Error(t, l.Append(Record{Method: "m"}))
+}
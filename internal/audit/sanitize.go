@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxRequestSize bounds the size of a request stored in a record
+const maxRequestSize = 16 * 1024
+
+// redacted replaces the value of sensitive fields
+const redacted = "[REDACTED]"
+
+// sensitiveWords mark field and metadata keys whose values are not recorded
+var sensitiveWords = []string{"password", "secret", "token", "credential", "private", "key"}
+
+// SanitizeRequest encodes a request as JSON for an audit record. Values of
+// fields and map entries with sensitive-looking names are redacted and
+// oversized requests are replaced by a marker.
+func SanitizeRequest(msg proto.Message) json.RawMessage {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+
+	data, err = json.Marshal(redact(value))
+	if err != nil {
+		return nil
+	}
+	if len(data) > maxRequestSize {
+		return json.RawMessage(`{"truncated":true}`)
+	}
+	return data
+}
+
+// redact replaces sensitive values in a decoded JSON document
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if isSensitive(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redact(child)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redact(child)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range sensitiveWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeRequest(t *testing.T) {
+	data := SanitizeRequest(&pb.CreateVMRequest{
+		VmId:      "vm-1",
+		VcpuCount: 2,
+		MemoryMb:  512,
+		Metadata: map[string]string{
+			"owner":       "ci-1",
+			"api_token":   "abc",
+			"DB_PASSWORD": "hunter2",
+		},
+	})
+	require.NotNil(t, data)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "vm-1", decoded["vm_id"])
+	assert.Equal(t, float64(2), decoded["vcpu_count"])
+
+	metadata := decoded["metadata"].(map[string]interface{})
+	assert.Equal(t, "ci-1", metadata["owner"])
+	assert.Equal(t, redacted, metadata["api_token"])
+	assert.Equal(t, redacted, metadata["DB_PASSWORD"])
+}
+
+func TestSanitizeRequest_Truncated(t *testing.T) {
+	data := SanitizeRequest(&pb.CreateVMRequest{
+		VmId:     "vm-1",
+		Metadata: map[string]string{"notes": strings.Repeat("x", maxRequestSize)},
+	})
+	assert.JSONEq(t, `{"truncated":true}`, string(data))
+}
@@ -0,0 +1,174 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCPUPeriodUs is the cpu.max period used when none is configured
+const DefaultCPUPeriodUs = 100000
+
+// Limits describes the cgroup v2 resource limits applied to a VM.
+// Zero values leave the corresponding kernel default in place.
+type Limits struct {
+	CPUQuotaUs     int64  // cpu.max quota per period
+	CPUPeriodUs    int64  // cpu.max period
+	CPUWeight      int64  // cpu.weight (1-10000)
+	CpusetCPUs     string // cpuset.cpus
+	CpusetMems     string // cpuset.mems
+	MemoryMaxBytes int64  // memory.max
+	PidsMax        int64  // pids.max
+}
+
+// Setting is a single cgroup interface file assignment
+type Setting struct {
+	File  string
+	Value string
+}
+
+// Settings returns the interface file assignments for the limits, in the
+// order they should be written (cpuset first so later controllers see the
+// final placement).
+func (l Limits) Settings() []Setting {
+	var settings []Setting
+
+	if l.CpusetCPUs != "" {
+		settings = append(settings, Setting{"cpuset.cpus", l.CpusetCPUs})
+	}
+	if l.CpusetMems != "" {
+		settings = append(settings, Setting{"cpuset.mems", l.CpusetMems})
+	}
+	if l.CPUQuotaUs > 0 {
+		period := l.CPUPeriodUs
+		if period <= 0 {
+			period = DefaultCPUPeriodUs
+		}
+		settings = append(settings, Setting{"cpu.max", fmt.Sprintf("%d %d", l.CPUQuotaUs, period)})
+	}
+	if l.CPUWeight > 0 {
+		settings = append(settings, Setting{"cpu.weight", strconv.FormatInt(l.CPUWeight, 10)})
+	}
+	if l.MemoryMaxBytes > 0 {
+		settings = append(settings, Setting{"memory.max", strconv.FormatInt(l.MemoryMaxBytes, 10)})
+	}
+	if l.PidsMax > 0 {
+		settings = append(settings, Setting{"pids.max", strconv.FormatInt(l.PidsMax, 10)})
+	}
+
+	return settings
+}
+
+// IsZero reports whether no limit is set
+func (l Limits) IsZero() bool {
+	return len(l.Settings()) == 0
+}
+
+// JailerArgs translates the limits into jailer "--cgroup <file>=<value>" arguments
+func (l Limits) JailerArgs() []string {
+	var args []string
+	for _, s := range l.Settings() {
+		args = append(args, "--cgroup", s.File+"="+s.Value)
+	}
+	return args
+}
+
+// controllers returns the cgroup controllers required by the limits
+func (l Limits) controllers() []string {
+	seen := make(map[string]bool)
+	var controllers []string
+	for _, s := range l.Settings() {
+		controller, _, _ := strings.Cut(s.File, ".")
+		if !seen[controller] {
+			seen[controller] = true
+			controllers = append(controllers, controller)
+		}
+	}
+	return controllers
+}
+
+// Create creates the cgroup <root>/<parent>/<vmID>, enables the controllers
+// needed by limits on its ancestors and applies the limits. It is used in
+// direct mode; in jailer mode the jailer manages the cgroup itself.
+func Create(root, parent, vmID string, limits Limits) (string, error) {
+	parentDir := filepath.Join(root, parent)
+	dir := Path(root, parent, vmID)
+
+	if !Exists(root) {
+		return "", fmt.Errorf("cgroup v2 hierarchy not mounted at %s", root)
+	}
+	if err := os.MkdirAll(parentDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create parent cgroup: %w", err)
+	}
+
+	controllers := limits.controllers()
+	for _, d := range []string{root, parentDir} {
+		if err := enableControllers(d, controllers); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	for _, s := range limits.Settings() {
+		if err := os.WriteFile(filepath.Join(dir, s.File), []byte(s.Value), 0644); err != nil {
+			Remove(dir)
+			return "", fmt.Errorf("failed to set %s=%s: %w", s.File, s.Value, err)
+		}
+	}
+
+	return dir, nil
+}
+
+// AddProcess moves a process into the cgroup
+func AddProcess(dir string, pid int) error {
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("failed to add process %d to cgroup: %w", pid, err)
+	}
+	return nil
+}
+
+// Remove deletes the cgroup directory. The kernel refuses to remove a cgroup
+// that still has processes, so removal is retried briefly while the killed
+// Firecracker process is being reaped.
+func Remove(dir string) error {
+	var err error
+	for i := 0; i < 10; i++ {
+		err = os.Remove(dir)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to remove cgroup %s: %w", dir, err)
+}
+
+// enableControllers enables controllers for the children of dir
+func enableControllers(dir string, controllers []string) error {
+	if len(controllers) == 0 {
+		return nil
+	}
+
+	enabled, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("failed to read subtree controllers of %s: %w", dir, err)
+	}
+	active := strings.Fields(string(enabled))
+
+	for _, c := range controllers {
+		if slices.Contains(active, c) {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+c), 0644); err != nil {
+			return fmt.Errorf("failed to enable %s controller in %s: %w", c, dir, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits_Settings(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		expected []Setting
+	}{
+		{
+			name:     "no limits",
+			limits:   Limits{},
+			expected: nil,
+		},
+		{
+			name: "all limits",
+			limits: Limits{
+				CPUQuotaUs:     200000,
+				CPUPeriodUs:    100000,
+				CPUWeight:      50,
+				CpusetCPUs:     "2-3",
+				CpusetMems:     "0",
+				MemoryMaxBytes: 1 << 30,
+				PidsMax:        64,
+			},
+			expected: []Setting{
+				{"cpuset.cpus", "2-3"},
+				{"cpuset.mems", "0"},
+				{"cpu.max", "200000 100000"},
+				{"cpu.weight", "50"},
+				{"memory.max", "1073741824"},
+				{"pids.max", "64"},
+			},
+		},
+		{
+			name:     "quota without period uses default period",
+			limits:   Limits{CPUQuotaUs: 50000},
+			expected: []Setting{{"cpu.max", "50000 100000"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.limits.Settings())
+			assert.Equal(t, len(tt.expected) == 0, tt.limits.IsZero())
+		})
+	}
+}
+
+func TestLimits_JailerArgs(t *testing.T) {
+	limits := Limits{CPUQuotaUs: 100000, CPUPeriodUs: 100000, MemoryMaxBytes: 1024}
+
+	assert.Equal(t, []string{
+		"--cgroup", "cpu.max=100000 100000",
+		"--cgroup", "memory.max=1024",
+	}, limits.JailerArgs())
+	assert.Empty(t, Limits{}.JailerArgs())
+}
+
+func TestCreate(t *testing.T) {
+	t.Run("creates cgroup and applies limits", func(t *testing.T) {
+		root := createFakeCgroup(t, map[string]string{"cgroup.subtree_control": "cpu\n"})
+		parent := filepath.Join(root, "firecracker")
+		require.NoError(t, os.Mkdir(parent, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), nil, 0644))
+
+		limits := Limits{CPUQuotaUs: 100000, CPUPeriodUs: 100000, MemoryMaxBytes: 4096}
+		dir, err := Create(root, "firecracker", "vm-1", limits)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(parent, "vm-1"), dir)
+
+		cpuMax, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+		require.NoError(t, err)
+		assert.Equal(t, "100000 100000", string(cpuMax))
+
+		memMax, err := os.ReadFile(filepath.Join(dir, "memory.max"))
+		require.NoError(t, err)
+		assert.Equal(t, "4096", string(memMax))
+
+		// Only controllers not already enabled are written
+		rootControl, err := os.ReadFile(filepath.Join(root, "cgroup.subtree_control"))
+		require.NoError(t, err)
+		assert.Equal(t, "+memory", strings.TrimSpace(string(rootControl)))
+
+		require.NoError(t, AddProcess(dir, 1234))
+		procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+		require.NoError(t, err)
+		assert.Equal(t, "1234", string(procs))
+	})
+
+	t.Run("fails without cgroup v2 hierarchy", func(t *testing.T) {
+		_, err := Create(t.TempDir(), "firecracker", "vm-1", Limits{PidsMax: 10})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not mounted")
+	})
+}
+
+func TestRemove(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "vm-1")
+	require.NoError(t, os.Mkdir(dir, 0755))
+
+	require.NoError(t, Remove(dir))
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+
+	// Removing a missing cgroup is not an error
+	require.NoError(t, Remove(dir))
+}
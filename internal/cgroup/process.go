@@ -0,0 +1,46 @@
+package cgroup
+
+import (
+	"fmt"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ReadProcessStats reads usage counters for a single process from /proc.
+// It is used when a VM has no dedicated cgroup. CPU throttling and IO
+// operation counts are not available per process and are left at zero.
+func ReadProcessStats(pid int) (*Stats, error) {
+	proc, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return nil, fmt.Errorf("process %d not found: %w", pid, err)
+	}
+
+	stats := &Stats{}
+
+	times, err := proc.Times()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CPU times: %w", err)
+	}
+	stats.CPUUserUsec = int64(times.User * 1e6)
+	stats.CPUSystemUsec = int64(times.System * 1e6)
+
+	memInfo, err := proc.MemoryInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory info: %w", err)
+	}
+	stats.MemoryRSSBytes = int64(memInfo.RSS)
+	stats.MemoryUsageBytes = int64(memInfo.RSS)
+
+	if faults, err := proc.PageFaults(); err == nil {
+		stats.PageFaults = int64(faults.MinorFaults + faults.MajorFaults)
+		stats.MajorPageFaults = int64(faults.MajorFaults)
+	}
+
+	// /proc/<pid>/io requires the same UID or CAP_SYS_PTRACE
+	if io, err := proc.IOCounters(); err == nil {
+		stats.IOReadBytes = int64(io.ReadBytes)
+		stats.IOWriteBytes = int64(io.WriteBytes)
+	}
+
+	return stats, nil
+}
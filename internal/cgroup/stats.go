@@ -0,0 +1,157 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Stats holds resource usage counters for a cgroup or process
+type Stats struct {
+	CPUUserUsec         int64
+	CPUSystemUsec       int64
+	CPUThrottledPeriods int64
+	CPUThrottledUsec    int64
+	MemoryRSSBytes      int64
+	MemoryUsageBytes    int64
+	PageFaults          int64
+	MajorPageFaults     int64
+	IOReadBytes         int64
+	IOWriteBytes        int64
+	IOReadOps           int64
+	IOWriteOps          int64
+}
+
+// Path returns the cgroup v2 directory for a VM under root/parent
+func Path(root, parent, vmID string) string {
+	return filepath.Join(root, parent, vmID)
+}
+
+// Exists reports whether dir is a cgroup v2 directory
+func Exists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "cgroup.controllers"))
+	return err == nil
+}
+
+// ReadStats reads usage counters from a cgroup v2 directory. Files for
+// controllers that are not enabled in the cgroup are skipped.
+func ReadStats(dir string) (*Stats, error) {
+	if !Exists(dir) {
+		return nil, fmt.Errorf("cgroup %s not found", dir)
+	}
+
+	stats := &Stats{}
+
+	cpu, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	stats.CPUUserUsec = cpu["user_usec"]
+	stats.CPUSystemUsec = cpu["system_usec"]
+	stats.CPUThrottledPeriods = cpu["nr_throttled"]
+	stats.CPUThrottledUsec = cpu["throttled_usec"]
+
+	mem, err := readKeyValues(filepath.Join(dir, "memory.stat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	stats.MemoryRSSBytes = mem["anon"]
+	stats.PageFaults = mem["pgfault"]
+	stats.MajorPageFaults = mem["pgmajfault"]
+
+	if current, err := readInt(filepath.Join(dir, "memory.current")); err == nil {
+		stats.MemoryUsageBytes = current
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := readIOStat(filepath.Join(dir, "io.stat"), stats); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// readKeyValues parses a flat-keyed cgroup file ("key value" per line)
+func readKeyValues(path string) (map[string]int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// readIOStat sums the nested-keyed io.stat entries across all devices
+// (e.g. "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0")
+func readIOStat(path string, stats *Stats) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for _, field := range fields[min(1, len(fields)):] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				stats.IOReadBytes += v
+			case "wbytes":
+				stats.IOWriteBytes += v
+			case "rios":
+				stats.IOReadOps += v
+			case "wios":
+				stats.IOWriteOps += v
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// readInt reads a single integer value from a cgroup file
+func readInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,82 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createFakeCgroup writes cgroup v2 interface files into a temp directory
+func createFakeCgroup(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files["cgroup.controllers"] = "cpu memory io pids\n"
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestPath(t *testing.T) {
+	assert.Equal(t, "/sys/fs/cgroup/firecracker/vm-1", Path("/sys/fs/cgroup", "firecracker", "vm-1"))
+}
+
+func TestReadStats(t *testing.T) {
+	t.Run("reads all controllers", func(t *testing.T) {
+		dir := createFakeCgroup(t, map[string]string{
+			"cpu.stat": "usage_usec 3000\nuser_usec 2000\nsystem_usec 1000\n" +
+				"nr_periods 50\nnr_throttled 5\nthrottled_usec 700\n",
+			"memory.stat":    "anon 1048576\nfile 4096\npgfault 300\npgmajfault 7\n",
+			"memory.current": "2097152\n",
+			"io.stat": "8:0 rbytes=100 wbytes=200 rios=1 wios=2 dbytes=0 dios=0\n" +
+				"8:16 rbytes=10 wbytes=20 rios=3 wios=4 dbytes=0 dios=0\n",
+		})
+
+		stats, err := ReadStats(dir)
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(2000), stats.CPUUserUsec)
+		assert.Equal(t, int64(1000), stats.CPUSystemUsec)
+		assert.Equal(t, int64(5), stats.CPUThrottledPeriods)
+		assert.Equal(t, int64(700), stats.CPUThrottledUsec)
+		assert.Equal(t, int64(1048576), stats.MemoryRSSBytes)
+		assert.Equal(t, int64(2097152), stats.MemoryUsageBytes)
+		assert.Equal(t, int64(300), stats.PageFaults)
+		assert.Equal(t, int64(7), stats.MajorPageFaults)
+		assert.Equal(t, int64(110), stats.IOReadBytes)
+		assert.Equal(t, int64(220), stats.IOWriteBytes)
+		assert.Equal(t, int64(4), stats.IOReadOps)
+		assert.Equal(t, int64(6), stats.IOWriteOps)
+	})
+
+	t.Run("skips disabled controllers", func(t *testing.T) {
+		dir := createFakeCgroup(t, map[string]string{
+			"cpu.stat": "user_usec 10\nsystem_usec 20\n",
+		})
+
+		stats, err := ReadStats(dir)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), stats.CPUUserUsec)
+		assert.Zero(t, stats.MemoryUsageBytes)
+		assert.Zero(t, stats.IOReadBytes)
+	})
+
+	t.Run("fails for missing cgroup", func(t *testing.T) {
+		_, err := ReadStats(filepath.Join(t.TempDir(), "missing"))
+		require.Error(t, err)
+	})
+}
+
+func TestReadProcessStats(t *testing.T) {
+	stats, err := ReadProcessStats(os.Getpid())
+	require.NoError(t, err)
+	assert.Greater(t, stats.MemoryRSSBytes, int64(0))
+	assert.Equal(t, stats.MemoryRSSBytes, stats.MemoryUsageBytes)
+
+	_, err = ReadProcessStats(-1)
+	require.Error(t, err)
+}
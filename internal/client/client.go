@@ -0,0 +1,126 @@
+// Package client connects the command line client to an agent and prints
+// API responses.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DefaultAddress is used when neither a context nor an address is given
+const DefaultAddress = "localhost:50051"
+
+// Client is a connection to an agent
+type Client struct {
+	pb.FirecrackerAgentClient
+	conn *grpc.ClientConn
+}
+
+// Dial connects to the agent of a context. The connection is established
+// lazily on the first call.
+func Dial(c *config.ClientContext) (*Client, error) {
+	creds := insecure.NewCredentials()
+	if c.TLS.Enabled {
+		tlsCfg, err := clientTLSConfig(c.TLS)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsCfg)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+
+	switch {
+	case c.APIKeyFile != "":
+		key, err := readSecret(c.APIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{"x-api-key": key}))
+	case c.TokenFile != "":
+		token, err := readSecret(c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{"authorization": "Bearer " + token}))
+	}
+
+	conn, err := grpc.NewClient(c.Address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", c.Address, err)
+	}
+
+	return &Client{
+		FirecrackerAgentClient: pb.NewFirecrackerAgentClient(conn),
+		conn:                   conn,
+	}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// clientTLSConfig builds the TLS configuration of a context
+func clientTLSConfig(cfg config.ClientTLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// readSecret reads a credential from a file, ignoring surrounding whitespace
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials: %w", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("credentials file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// tokenCredentials sends fixed metadata with every call. Transport security
+// is not required so tokens can be used over a local Unix socket.
+type tokenCredentials map[string]string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return t, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeAgent records the metadata of the last call
+type fakeAgent struct {
+	pb.UnimplementedFirecrackerAgentServer
+	md metadata.MD
+}
+
+func (f *fakeAgent) GetVM(ctx context.Context, req *pb.GetVMRequest) (*pb.GetVMResponse, error) {
+	f.md, _ = metadata.FromIncomingContext(ctx)
+	return &pb.GetVMResponse{Vm: &pb.VMInfo{VmId: req.VmId, State: pb.VMState_VM_STATE_RUNNING}}, nil
+}
+
+// startFakeAgent serves a fake agent on a Unix socket
+func startFakeAgent(t *testing.T) (*fakeAgent, string) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	lis, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	agent := &fakeAgent{}
+	srv := grpc.NewServer()
+	pb.RegisterFirecrackerAgentServer(srv, agent)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	return agent, "unix://" + socket
+}
+
+func writeSecret(t *testing.T, secret string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte(secret), 0600))
+	return path
+}
+
+func TestDial_Credentials(t *testing.T) {
+	agent, address := startFakeAgent(t)
+
+	tests := []struct {
+		name   string
+		ctx    config.ClientContext
+		header string
+		want   string
+	}{
+		{"no credentials", config.ClientContext{Address: address}, "x-api-key", ""},
+		{"api key", config.ClientContext{Address: address, APIKeyFile: writeSecret(t, "secret-key\n")}, "x-api-key", "secret-key"},
+		{"token", config.ClientContext{Address: address, TokenFile: writeSecret(t, "a.b.c")}, "authorization", "Bearer a.b.c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Dial(&tt.ctx)
+			require.NoError(t, err)
+			defer c.Close()
+
+			resp, err := c.GetVM(context.Background(), &pb.GetVMRequest{VmId: "vm-1"})
+			require.NoError(t, err)
+			assert.Equal(t, "vm-1", resp.Vm.VmId)
+
+			got := agent.md.Get(tt.header)
+			if tt.want == "" {
+				assert.Empty(t, got)
+			} else {
+				assert.Equal(t, []string{tt.want}, got)
+			}
+		})
+	}
+}
+
+func TestDial_InvalidCredentials(t *testing.T) {
+	_, err := Dial(&config.ClientContext{Address: "localhost:50051", APIKeyFile: writeSecret(t, "  \n")})
+	assert.Error(t, err)
+
+	_, err = Dial(&config.ClientContext{Address: "localhost:50051", TokenFile: "/nonexistent/token"})
+	assert.Error(t, err)
+
+	_, err = Dial(&config.ClientContext{Address: "localhost:50051", TLS: config.ClientTLSConfig{Enabled: true, CAFile: writeSecret(t, "not a certificate")}})
+	assert.Error(t, err)
+}
+
+func TestPrinter_Formats(t *testing.T) {
+	vm := &pb.VMInfo{
+		VmId:      "vm-1",
+		State:     pb.VMState_VM_STATE_RUNNING,
+		VcpuCount: 2,
+		MemoryMb:  512,
+		CreatedAt: 1700000000,
+		Metadata:  map[string]string{"team": "web", "env": "prod"},
+	}
+	resp := &pb.GetVMResponse{Vm: vm}
+	table := func() Table { return VMTable(vm) }
+
+	var buf bytes.Buffer
+	require.NoError(t, NewPrinter(FormatTable, &buf).Print(resp, table))
+	assert.Contains(t, buf.String(), "ID")
+	assert.Contains(t, buf.String(), "RUNNING")
+	assert.Contains(t, buf.String(), "512MiB")
+	assert.Contains(t, buf.String(), "2023-11-14T22:13:20Z")
+	assert.Contains(t, buf.String(), "env=prod,team=web")
+
+	buf.Reset()
+	require.NoError(t, NewPrinter(FormatJSON, &buf).Print(resp, table))
+	var asJSON map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &asJSON))
+	assert.Equal(t, "vm-1", asJSON["vm"]["vm_id"])
+	assert.Equal(t, "VM_STATE_RUNNING", asJSON["vm"]["state"])
+
+	buf.Reset()
+	require.NoError(t, NewPrinter(FormatYAML, &buf).Print(resp, table))
+	var asYAML map[string]map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(buf.Bytes(), &asYAML))
+	assert.Equal(t, "vm-1", asYAML["vm"]["vm_id"])
+
+	_, err := ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestPrinter_Stream(t *testing.T) {
+	events := []*pb.VMEvent{
+		{VmId: "vm-1", Type: pb.EventType_EVENT_TYPE_CREATED, State: pb.VMState_VM_STATE_RUNNING, Timestamp: 1700000000},
+		{VmId: "vm-1", Type: pb.EventType_EVENT_TYPE_STOPPED, State: pb.VMState_VM_STATE_STOPPED, Timestamp: 1700000060},
+	}
+
+	var buf bytes.Buffer
+	p := NewPrinter(FormatTable, &buf)
+	for _, e := range events {
+		require.NoError(t, p.PrintStream(e, func() Table { return EventTable(e) }))
+	}
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("MESSAGE")), "header is written once")
+	assert.Contains(t, buf.String(), "CREATED")
+	assert.Contains(t, buf.String(), "STOPPED")
+
+	buf.Reset()
+	p = NewPrinter(FormatJSON, &buf)
+	for _, e := range events {
+		require.NoError(t, p.PrintStream(e, func() Table { return EventTable(e) }))
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2, "one JSON object per line")
+	for _, line := range lines {
+		assert.True(t, json.Valid(line))
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512B", formatBytes(512))
+	assert.Equal(t, "1.5KiB", formatBytes(1536))
+	assert.Equal(t, "2.0GiB", formatBytes(2<<30))
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	policy, err := ParseRestartPolicy("on-failure:5")
+	require.NoError(t, err)
+	assert.Equal(t, pb.RestartMode_RESTART_MODE_ON_FAILURE, policy.Mode)
+	assert.Equal(t, int32(5), policy.MaxRetries)
+
+	policy, err = ParseRestartPolicy("always")
+	require.NoError(t, err)
+	assert.Equal(t, pb.RestartMode_RESTART_MODE_ALWAYS, policy.Mode)
+
+	for _, s := range []string{"sometimes", "always:3", "on-failure:x", "on-failure:-1"} {
+		_, err := ParseRestartPolicy(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseEventTypesAndStates(t *testing.T) {
+	types, err := ParseEventTypes([]string{"crashed", "EVENT_TYPE_DELETED"})
+	require.NoError(t, err)
+	assert.Equal(t, []pb.EventType{pb.EventType_EVENT_TYPE_CRASHED, pb.EventType_EVENT_TYPE_DELETED}, types)
+
+	states, err := ParseVMStates([]string{"Running"})
+	require.NoError(t, err)
+	assert.Equal(t, []pb.VMState{pb.VMState_VM_STATE_RUNNING}, states)
+
+	_, err = ParseEventTypes([]string{"unspecified"})
+	assert.Error(t, err)
+	_, err = ParseVMStates([]string{"sleeping"})
+	assert.Error(t, err)
+}
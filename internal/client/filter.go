@@ -0,0 +1,54 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+)
+
+// ParseEventTypes parses event type flags such as "crashed" or
+// "EVENT_TYPE_CRASHED"
+func ParseEventTypes(names []string) ([]pb.EventType, error) {
+	values, err := parseEnums(names, "EVENT_TYPE_", pb.EventType_value)
+	if err != nil {
+		return nil, fmt.Errorf("event type: %w", err)
+	}
+	types := make([]pb.EventType, len(values))
+	for i, v := range values {
+		types[i] = pb.EventType(v)
+	}
+	return types, nil
+}
+
+// ParseVMStates parses VM state flags such as "running" or
+// "VM_STATE_RUNNING"
+func ParseVMStates(names []string) ([]pb.VMState, error) {
+	values, err := parseEnums(names, "VM_STATE_", pb.VMState_value)
+	if err != nil {
+		return nil, fmt.Errorf("VM state: %w", err)
+	}
+	states := make([]pb.VMState, len(values))
+	for i, v := range values {
+		states[i] = pb.VMState(v)
+	}
+	return states, nil
+}
+
+// parseEnums looks up enum names case insensitively, with or without their
+// prefix. The unspecified value is rejected.
+func parseEnums(names []string, prefix string, values map[string]int32) ([]int32, error) {
+	var parsed []int32
+	for _, name := range names {
+		key := strings.ToUpper(name)
+		if !strings.HasPrefix(key, prefix) {
+			key = prefix + key
+		}
+		v, ok := values[key]
+		if !ok || v == 0 {
+			return nil, fmt.Errorf("unknown value %q", name)
+		}
+		parsed = append(parsed, v)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,288 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is an output format of the client
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+	FormatYAML  Format = "yaml"
+)
+
+// ParseFormat validates an output format name
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatTable, FormatJSON, FormatYAML:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (table, json or yaml)", s)
+}
+
+// Table is the tabular form of a response
+type Table struct {
+	Headers []string
+	Rows    [][]string
+}
+
+// Printer writes API responses in the selected format
+type Printer struct {
+	format Format
+	out    io.Writer
+
+	headerDone bool // table header of a stream already written
+}
+
+// NewPrinter creates a printer writing to out
+func NewPrinter(format Format, out io.Writer) *Printer {
+	return &Printer{format: format, out: out}
+}
+
+var jsonOptions = protojson.MarshalOptions{UseProtoNames: true}
+
+// Print writes one response. JSON and YAML print the message itself, table
+// output prints the rows built by table.
+func (p *Printer) Print(msg proto.Message, table func() Table) error {
+	switch p.format {
+	case FormatJSON:
+		data, err := protojson.MarshalOptions{UseProtoNames: true, Multiline: true, Indent: "  "}.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.out, "%s\n", data)
+		return err
+	case FormatYAML:
+		data, err := marshalYAML(msg)
+		if err != nil {
+			return err
+		}
+		_, err = p.out.Write(data)
+		return err
+	default:
+		return p.writeTable(table(), true)
+	}
+}
+
+// PrintStream writes one message of a stream: one JSON object per line,
+// YAML documents separated by "---", or table rows under a single header
+func (p *Printer) PrintStream(msg proto.Message, table func() Table) error {
+	switch p.format {
+	case FormatJSON:
+		data, err := jsonOptions.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.out, "%s\n", data)
+		return err
+	case FormatYAML:
+		data, err := marshalYAML(msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.out, "---\n%s", data)
+		return err
+	default:
+		err := p.writeTable(table(), !p.headerDone)
+		p.headerDone = true
+		return err
+	}
+}
+
+func (p *Printer) writeTable(t Table, header bool) error {
+	w := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+	if header {
+		fmt.Fprintln(w, strings.Join(t.Headers, "\t"))
+	}
+	for _, row := range t.Rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// marshalYAML converts a message to YAML through its JSON form, so field
+// names and enum values match the JSON output
+func marshalYAML(msg proto.Message) ([]byte, error) {
+	data, err := jsonOptions.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// VMTable lists VMs
+func VMTable(vms ...*pb.VMInfo) Table {
+	t := Table{Headers: []string{"ID", "STATE", "VCPUS", "MEMORY", "IP", "CREATED", "RESTARTS", "LABELS"}}
+	for _, vm := range vms {
+		t.Rows = append(t.Rows, []string{
+			vm.VmId,
+			stateName(vm.State),
+			strconv.Itoa(int(vm.VcpuCount)),
+			fmt.Sprintf("%dMiB", vm.MemoryMb),
+			vm.IpAddress,
+			formatUnix(vm.CreatedAt),
+			strconv.Itoa(int(vm.RestartCount)),
+			formatLabels(vm.Metadata),
+		})
+	}
+	return t
+}
+
+// TransitionTable lists the recorded state transitions of a VM
+func TransitionTable(vm *pb.VMInfo) Table {
+	t := Table{Headers: []string{"TIME", "FROM", "TO", "REASON"}}
+	for _, tr := range vm.Transitions {
+		t.Rows = append(t.Rows, []string{formatUnix(tr.Timestamp), stateName(tr.From), stateName(tr.To), tr.Reason})
+	}
+	return t
+}
+
+// VMStateTable shows the state of a VM after a lifecycle call
+func VMStateTable(vmID string, state pb.VMState) Table {
+	return Table{
+		Headers: []string{"ID", "STATE"},
+		Rows:    [][]string{{vmID, stateName(state)}},
+	}
+}
+
+// StatsTable shows resource usage of a VM
+func StatsTable(s *pb.VMStats) Table {
+	return Table{
+		Headers: []string{"ID", "SOURCE", "CPU USER", "CPU SYSTEM", "THROTTLED", "RSS", "MEMORY", "IO READ", "IO WRITE"},
+		Rows: [][]string{{
+			s.VmId,
+			s.Source,
+			(time.Duration(s.CpuUserUsec) * time.Microsecond).String(),
+			(time.Duration(s.CpuSystemUsec) * time.Microsecond).String(),
+			(time.Duration(s.CpuThrottledUsec) * time.Microsecond).String(),
+			formatBytes(s.MemoryRssBytes),
+			formatBytes(s.MemoryUsageBytes),
+			formatBytes(s.IoReadBytes),
+			formatBytes(s.IoWriteBytes),
+		}},
+	}
+}
+
+// EventTable shows one VM event
+func EventTable(e *pb.VMEvent) Table {
+	return Table{
+		Headers: []string{"SEQ", "TIME", "ID", "TYPE", "STATE", "MESSAGE"},
+		Rows: [][]string{{
+			strconv.FormatUint(e.Sequence, 10),
+			formatUnix(e.Timestamp),
+			e.VmId,
+			strings.TrimPrefix(e.Type.String(), "EVENT_TYPE_"),
+			stateName(e.State),
+			e.Message,
+		}},
+	}
+}
+
+// HostTable shows host capacity
+func HostTable(h *pb.GetHostInfoResponse) Table {
+	return Table{
+		Headers: []string{"HOSTNAME", "VERSION", "VMS", "CPUS", "VCPUS (ALLOC/CAP)", "MEMORY (ALLOC/CAP)", "CPU USAGE"},
+		Rows: [][]string{{
+			h.Hostname,
+			h.Version,
+			strconv.Itoa(int(h.RunningVms)),
+			strconv.Itoa(int(h.TotalCpus)),
+			fmt.Sprintf("%d/%d", h.AllocatedVcpus, h.AllocatableVcpus),
+			fmt.Sprintf("%d/%dMiB", h.AllocatedMemoryMb, h.AllocatableMemoryMb),
+			fmt.Sprintf("%.1f%%", h.CpuUsage),
+		}},
+	}
+}
+
+// HealthTable shows the agent health checks
+func HealthTable(h *pb.HealthCheckResponse) Table {
+	t := Table{Headers: []string{"CHECK", "HEALTHY", "MESSAGE", "CHECKED"}}
+	for _, c := range h.Checks {
+		t.Rows = append(t.Rows, []string{c.Name, strconv.FormatBool(c.Healthy), c.Message, formatUnix(c.CheckedAt)})
+	}
+	return t
+}
+
+// OperationTable shows a long-running operation
+func OperationTable(op *pb.Operation) Table {
+	return Table{
+		Headers: []string{"ID", "TYPE", "VM", "STATUS", "STAGE", "UPDATED", "ERROR"},
+		Rows: [][]string{{
+			op.OperationId,
+			strings.TrimPrefix(op.Type.String(), "OPERATION_TYPE_"),
+			op.VmId,
+			strings.TrimPrefix(op.Status.String(), "OPERATION_STATUS_"),
+			strings.TrimPrefix(op.Stage.String(), "OPERATION_STAGE_"),
+			formatUnix(op.UpdatedAt),
+			op.ErrorMessage,
+		}},
+	}
+}
+
+// AuditTable lists audit records
+func AuditTable(records ...*pb.AuditRecord) Table {
+	t := Table{Headers: []string{"SEQ", "TIME", "PRINCIPAL", "METHOD", "VM", "CODE"}}
+	for _, r := range records {
+		t.Rows = append(t.Rows, []string{
+			strconv.FormatUint(r.Sequence, 10),
+			time.Unix(0, r.Timestamp).UTC().Format(time.RFC3339),
+			r.Principal,
+			r.Method[strings.LastIndex(r.Method, "/")+1:],
+			r.VmId,
+			r.Code,
+		})
+	}
+	return t
+}
+
+func stateName(s pb.VMState) string {
+	return strings.TrimPrefix(s.String(), "VM_STATE_")
+}
+
+func formatUnix(sec int64) string {
+	if sec == 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
+// formatLabels prints labels as sorted key=value pairs
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
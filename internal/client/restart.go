@@ -0,0 +1,39 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+)
+
+// ParseRestartPolicy parses a restart policy flag: "never", "always",
+// "on-failure" or "on-failure:N" to give up after N consecutive restarts
+func ParseRestartPolicy(s string) (*pb.RestartPolicy, error) {
+	mode, retries, hasRetries := strings.Cut(s, ":")
+
+	policy := &pb.RestartPolicy{}
+	switch mode {
+	case "never":
+		policy.Mode = pb.RestartMode_RESTART_MODE_NEVER
+	case "always":
+		policy.Mode = pb.RestartMode_RESTART_MODE_ALWAYS
+	case "on-failure":
+		policy.Mode = pb.RestartMode_RESTART_MODE_ON_FAILURE
+	default:
+		return nil, fmt.Errorf("unknown restart policy %q (never, on-failure[:N] or always)", s)
+	}
+
+	if hasRetries {
+		if policy.Mode != pb.RestartMode_RESTART_MODE_ON_FAILURE {
+			return nil, fmt.Errorf("restart policy %q: max retries only apply to on-failure", s)
+		}
+		n, err := strconv.ParseInt(retries, 10, 32)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("restart policy %q: invalid max retries", s)
+		}
+		policy.MaxRetries = int32(n)
+	}
+	return policy, nil
+}
@@ -0,0 +1,130 @@
+package doctor
+
+import (
+	"fmt"
+	"os/user"
+	"regexp"
+	"strconv"
+)
+
+// minFirecrackerVersion is the oldest release supporting the jailer
+// arguments used by the agent (--parent-cgroup with cgroup v2)
+var minFirecrackerVersion = version{1, 1, 0}
+
+// versionPattern matches the version printed by "firecracker --version"
+// and "jailer --version", e.g. "Firecracker v1.7.0"
+var versionPattern = regexp.MustCompile(`v(\d+)\.(\d+)\.(\d+)`)
+
+type version [3]int
+
+func (v version) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v version) less(o version) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+func parseVersion(output string) (version, bool) {
+	m := versionPattern.FindStringSubmatch(output)
+	if m == nil {
+		return version{}, false
+	}
+	var v version
+	for i := range v {
+		v[i], _ = strconv.Atoi(m[i+1])
+	}
+	return v, true
+}
+
+// binaryVersion runs "<path> --version"
+func (d *Doctor) binaryVersion(check, path string) (version, *Result) {
+	output, err := d.runCommand(path, "--version")
+	if err != nil {
+		r := fail(check, "install it from https://github.com/firecracker-microvm/firecracker/releases", "cannot run %s: %v", path, err)
+		return version{}, &r
+	}
+	v, ok := parseVersion(string(output))
+	if !ok {
+		r := warn(check, "", "cannot parse version of %s from %q", path, output)
+		return version{}, &r
+	}
+	return v, nil
+}
+
+// checkBinaries verifies the Firecracker and jailer versions and the host
+// tools the agent runs
+func (d *Doctor) checkBinaries() []Result {
+	fc := d.cfg.Firecracker
+	var results []Result
+
+	fcVersion, result := d.binaryVersion("binary:firecracker", fc.BinaryPath)
+	switch {
+	case result != nil:
+		results = append(results, *result)
+	case fcVersion.less(minFirecrackerVersion):
+		results = append(results, fail("binary:firecracker", fmt.Sprintf("upgrade to %s or later", minFirecrackerVersion),
+			"firecracker %s is older than the minimum supported %s", fcVersion, minFirecrackerVersion))
+	default:
+		results = append(results, pass("binary:firecracker", "firecracker %s", fcVersion))
+	}
+
+	if fc.UseJailer != nil && *fc.UseJailer {
+		jailerVersion, result := d.binaryVersion("binary:jailer", fc.JailerPath)
+		switch {
+		case result != nil:
+			results = append(results, *result)
+		case fcVersion != (version{}) && jailerVersion != fcVersion:
+			results = append(results, fail("binary:jailer", "install the jailer from the same release as firecracker",
+				"jailer %s does not match firecracker %s", jailerVersion, fcVersion))
+		default:
+			results = append(results, pass("binary:jailer", "jailer %s", jailerVersion))
+		}
+	}
+
+	tools := []string{"ip", "iptables", "cp"}
+	if d.cfg.Storage.UseOverlay {
+		tools = append(tools, "qemu-img") // qcow2 overlays of the base rootfs
+	}
+	for _, tool := range tools {
+		check := "tool:" + tool
+		if path, err := d.lookPath(tool); err != nil {
+			results = append(results, fail(check, "install the package providing "+tool, "%s not found in PATH", tool))
+		} else {
+			results = append(results, pass(check, "%s", path))
+		}
+	}
+
+	return results
+}
+
+// checkJailIDs verifies the UID and GID the jailer drops privileges to
+func (d *Doctor) checkJailIDs() []Result {
+	fc := d.cfg.Firecracker
+	if fc.UseJailer == nil || !*fc.UseJailer {
+		return []Result{warn("jail:ids", "set firecracker.use_jailer: true in production", "jailer disabled, VMs run unconfined")}
+	}
+
+	uid := strconv.Itoa(fc.JailUID)
+	gid := strconv.Itoa(fc.JailGID)
+	hint := fmt.Sprintf("groupadd --system --gid %s firecracker && useradd --system --no-create-home --uid %s --gid %s firecracker", gid, uid, gid)
+
+	var results []Result
+	if u, err := user.LookupId(uid); err != nil {
+		results = append(results, warn("jail:uid", hint, "UID %s has no user, files owned by the jail are hard to audit", uid))
+	} else {
+		results = append(results, pass("jail:uid", "UID %s is %s", uid, u.Username))
+	}
+	if g, err := user.LookupGroupId(gid); err != nil {
+		results = append(results, warn("jail:gid", hint, "GID %s has no group", gid))
+	} else {
+		results = append(results, pass("jail:gid", "GID %s is %s", gid, g.Name))
+	}
+
+	return results
+}
@@ -0,0 +1,121 @@
+// Package doctor validates that a host meets the requirements of the agent
+// before it is started.
+package doctor
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn" // The agent works, possibly degraded
+	StatusFail Status = "fail" // The agent or VM creation will fail
+)
+
+// Result is the outcome of one check, with a hint on how to fix it
+type Result struct {
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// Report is the outcome of all checks
+type Report struct {
+	Results []Result `json:"results"`
+	Passed  int      `json:"passed"`
+	Warned  int      `json:"warned"`
+	Failed  int      `json:"failed"`
+}
+
+// OK reports whether no check failed
+func (r *Report) OK() bool {
+	return r.Failed == 0
+}
+
+func (r *Report) add(results ...Result) {
+	for _, result := range results {
+		switch result.Status {
+		case StatusPass:
+			r.Passed++
+		case StatusWarn:
+			r.Warned++
+		case StatusFail:
+			r.Failed++
+		}
+		r.Results = append(r.Results, result)
+	}
+}
+
+// WriteText prints the report as one line per check followed by its hint
+func (r *Report) WriteText(w io.Writer) {
+	for _, result := range r.Results {
+		fmt.Fprintf(w, "[%s] %-24s %s\n", strings.ToUpper(string(result.Status)), result.Check, result.Message)
+		if result.Hint != "" && result.Status != StatusPass {
+			fmt.Fprintf(w, "       %-24s hint: %s\n", "", result.Hint)
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed\n", r.Passed, r.Warned, r.Failed)
+}
+
+func pass(check, format string, args ...interface{}) Result {
+	return Result{Check: check, Status: StatusPass, Message: fmt.Sprintf(format, args...)}
+}
+
+func warn(check, hint, format string, args ...interface{}) Result {
+	return Result{Check: check, Status: StatusWarn, Message: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+func fail(check, hint, format string, args ...interface{}) Result {
+	return Result{Check: check, Status: StatusFail, Message: fmt.Sprintf(format, args...), Hint: hint}
+}
+
+// Doctor runs host checks against an agent configuration
+type Doctor struct {
+	cfg         *config.Config
+	procRoot    string
+	devRoot     string
+	modulesRoot string
+
+	// Host access, replaced in tests
+	runCommand func(name string, args ...string) ([]byte, error)
+	lookPath   func(file string) (string, error)
+	statfs     func(path string, stat *unix.Statfs_t) error
+}
+
+// New creates a doctor for the configuration
+func New(cfg *config.Config) *Doctor {
+	return &Doctor{
+		cfg:         cfg,
+		procRoot:    "/proc",
+		devRoot:     "/dev",
+		modulesRoot: "/lib/modules",
+		runCommand: func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).CombinedOutput()
+		},
+		lookPath: exec.LookPath,
+		statfs:   unix.Statfs,
+	}
+}
+
+// Run runs all checks
+func (d *Doctor) Run() *Report {
+	report := &Report{}
+	report.add(d.checkKernelModules()...)
+	report.add(d.checkKVM())
+	report.add(d.checkCgroups()...)
+	report.add(d.checkBinaries()...)
+	report.add(d.checkJailIDs()...)
+	report.add(d.checkStorage()...)
+	report.add(d.checkNetwork()...)
+	return report
+}
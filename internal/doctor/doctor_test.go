@@ -0,0 +1,270 @@
+package doctor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// testHost is a fake host: sysfs, procfs, devices, cgroup hierarchy,
+// binaries and tools live in temporary directories
+type testHost struct {
+	root     string
+	versions map[string]string
+	tools    map[string]bool
+	cgroupFS int64
+}
+
+func newTestHost(t *testing.T) (*testHost, *config.Config) {
+	t.Helper()
+	h := &testHost{
+		root:     t.TempDir(),
+		versions: map[string]string{"firecracker": "Firecracker v1.7.0\n", "jailer": "Jailer v1.7.0\n"},
+		tools:    map[string]bool{"ip": true, "iptables": true, "cp": true},
+		cgroupFS: unix.CGROUP2_SUPER_MAGIC,
+	}
+
+	for _, module := range []string{"kvm", "bridge"} {
+		h.mkdir(t, "sys/module/"+module)
+	}
+	h.write(t, "dev/net/tun", "")
+	h.write(t, "proc/sys/net/ipv4/ip_forward", "1\n")
+	h.write(t, "cgroup/cgroup.controllers", "cpuset cpu io memory pids\n")
+	h.write(t, "images/vmlinux", "kernel")
+	h.write(t, "images/rootfs.ext4", "rootfs")
+	h.mkdir(t, "vms")
+
+	useJailer := true
+	cfg := &config.Config{
+		Firecracker: config.FirecrackerConfig{
+			BinaryPath: "firecracker",
+			JailerPath: "jailer",
+			KernelPath: filepath.Join(h.root, "images/vmlinux"),
+			RootfsPath: filepath.Join(h.root, "images/rootfs.ext4"),
+			UseJailer:  &useJailer,
+			JailUID:    424242, // unlikely to exist on the test host
+			JailGID:    424242,
+			CgroupRoot: filepath.Join(h.root, "cgroup"),
+		},
+		Network:   config.NetworkConfig{BridgeName: "fcdoctor0", BridgeIP: "198.18.250.1/24"},
+		Storage:   config.StorageConfig{VMsDir: filepath.Join(h.root, "vms")},
+		Placement: config.PlacementConfig{SysfsRoot: filepath.Join(h.root, "sys")},
+		Health:    config.HealthConfig{KVMDevice: "/dev/null", MinFreeDiskMB: 1},
+	}
+	return h, cfg
+}
+
+func (h *testHost) mkdir(t *testing.T, path string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(h.root, path), 0755))
+}
+
+func (h *testHost) write(t *testing.T, path, data string) {
+	h.mkdir(t, filepath.Dir(path))
+	require.NoError(t, os.WriteFile(filepath.Join(h.root, path), []byte(data), 0644))
+}
+
+func (h *testHost) doctor(cfg *config.Config) *Doctor {
+	d := New(cfg)
+	d.procRoot = filepath.Join(h.root, "proc")
+	d.devRoot = filepath.Join(h.root, "dev")
+	d.modulesRoot = filepath.Join(h.root, "lib/modules")
+	d.runCommand = func(name string, args ...string) ([]byte, error) {
+		if v, ok := h.versions[name]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("exec: %q: executable file not found", name)
+	}
+	d.lookPath = func(file string) (string, error) {
+		if h.tools[file] {
+			return "/usr/bin/" + file, nil
+		}
+		return "", fmt.Errorf("exec: %q: executable file not found in $PATH", file)
+	}
+	d.statfs = func(path string, stat *unix.Statfs_t) error {
+		if err := unix.Statfs(path, stat); err != nil {
+			return err
+		}
+		if path == cfg.Firecracker.CgroupRoot {
+			stat.Type = h.cgroupFS
+		}
+		return nil
+	}
+	return d
+}
+
+// statuses indexes results by check name
+func statuses(report *Report) map[string]Status {
+	m := make(map[string]Status)
+	for _, r := range report.Results {
+		m[r.Check] = r.Status
+	}
+	return m
+}
+
+func TestDoctor_HealthyHost(t *testing.T) {
+	h, cfg := newTestHost(t)
+	report := h.doctor(cfg).Run()
+
+	got := statuses(report)
+	for _, check := range []string{
+		"module:kvm", "module:tun", "module:bridge", "kvm", "cgroup:v2", "cgroup:controllers", "cgroup:parent",
+		"binary:firecracker", "binary:jailer", "tool:ip", "tool:iptables", "tool:cp",
+		"image:kernel", "image:rootfs", "storage:writable", "storage:free_space",
+		"network:bridge", "network:subnet", "network:ip_forward",
+	} {
+		assert.Equal(t, StatusPass, got[check], check)
+	}
+	assert.True(t, report.OK())
+	assert.Equal(t, len(report.Results), report.Passed+report.Warned+report.Failed)
+
+	// The doctor cleans up its probe files
+	entries, err := os.ReadDir(cfg.Storage.VMsDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDoctor_Failures(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, h *testHost, cfg *config.Config)
+		check  string
+		status Status
+	}{
+		{"kvm module missing", func(t *testing.T, h *testHost, cfg *config.Config) {
+			require.NoError(t, os.Remove(filepath.Join(h.root, "sys/module/kvm")))
+		}, "module:kvm", StatusFail},
+		{"kvm module built in", func(t *testing.T, h *testHost, cfg *config.Config) {
+			require.NoError(t, os.Remove(filepath.Join(h.root, "sys/module/kvm")))
+			var uts unix.Utsname
+			require.NoError(t, unix.Uname(&uts))
+			h.write(t, filepath.Join("lib/modules", unix.ByteSliceToString(uts.Release[:]), "modules.builtin"),
+				"kernel/arch/x86/kvm/kvm.ko\nkernel/arch/x86/kvm/kvm-intel.ko\n")
+		}, "module:kvm", StatusPass},
+		{"tun missing", func(t *testing.T, h *testHost, cfg *config.Config) {
+			require.NoError(t, os.Remove(filepath.Join(h.root, "dev/net/tun")))
+		}, "module:tun", StatusFail},
+		{"bridge module not loaded", func(t *testing.T, h *testHost, cfg *config.Config) {
+			require.NoError(t, os.Remove(filepath.Join(h.root, "sys/module/bridge")))
+		}, "module:bridge", StatusWarn},
+		{"kvm device missing", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Health.KVMDevice = filepath.Join(h.root, "dev/kvm")
+		}, "kvm", StatusFail},
+		{"kvm device not a character device", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Health.KVMDevice = filepath.Join(h.root, "images/vmlinux")
+		}, "kvm", StatusFail},
+		{"cgroup v1", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.cgroupFS = unix.TMPFS_MAGIC
+		}, "cgroup:v2", StatusFail},
+		{"memory controller missing", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.write(t, "cgroup/cgroup.controllers", "cpuset cpu io pids\n")
+		}, "cgroup:controllers", StatusFail},
+		{"memory controller missing in direct mode", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.write(t, "cgroup/cgroup.controllers", "cpuset cpu io pids\n")
+			*cfg.Firecracker.UseJailer = false
+		}, "cgroup:controllers", StatusWarn},
+		{"memory controller missing in direct mode with limits", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.write(t, "cgroup/cgroup.controllers", "cpuset cpu io pids\n")
+			*cfg.Firecracker.UseJailer = false
+			cfg.Firecracker.ResourceLimits.MemoryOverheadMB = 64
+		}, "cgroup:controllers", StatusFail},
+		{"cpuset missing without pinning", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.write(t, "cgroup/cgroup.controllers", "cpu io memory pids\n")
+		}, "cgroup:controllers", StatusWarn},
+		{"cpuset missing with pinning", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.write(t, "cgroup/cgroup.controllers", "cpu io memory pids\n")
+			cfg.Placement.Enabled = true
+		}, "cgroup:controllers", StatusFail},
+		{"firecracker missing", func(t *testing.T, h *testHost, cfg *config.Config) {
+			delete(h.versions, "firecracker")
+		}, "binary:firecracker", StatusFail},
+		{"firecracker too old", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.versions["firecracker"] = "Firecracker v1.0.0"
+		}, "binary:firecracker", StatusFail},
+		{"firecracker version unknown", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.versions["firecracker"] = "dev build"
+		}, "binary:firecracker", StatusWarn},
+		{"jailer version mismatch", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.versions["jailer"] = "Jailer v1.6.0"
+		}, "binary:jailer", StatusFail},
+		{"iptables missing", func(t *testing.T, h *testHost, cfg *config.Config) {
+			delete(h.tools, "iptables")
+		}, "tool:iptables", StatusFail},
+		{"qemu-img missing with overlays", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Storage.UseOverlay = true
+		}, "tool:qemu-img", StatusFail},
+		{"jail user missing", func(t *testing.T, h *testHost, cfg *config.Config) {}, "jail:uid", StatusWarn},
+		{"jailer disabled", func(t *testing.T, h *testHost, cfg *config.Config) {
+			useJailer := false
+			cfg.Firecracker.UseJailer = &useJailer
+		}, "jail:ids", StatusWarn},
+		{"rootfs missing", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Firecracker.RootfsPath = filepath.Join(h.root, "images/missing.ext4")
+		}, "image:rootfs", StatusFail},
+		{"vms dir not created yet", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Storage.VMsDir = filepath.Join(h.root, "new/vms")
+		}, "storage:vms_dir", StatusWarn},
+		{"not enough free space", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Health.MinFreeDiskMB = 1 << 40
+		}, "storage:free_space", StatusFail},
+		{"invalid bridge ip", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Network.BridgeIP = "172.16.0.1"
+		}, "network:subnet", StatusFail},
+		{"bridge subnet overlaps loopback", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Network.BridgeIP = "127.0.0.2/8"
+		}, "network:subnet", StatusFail},
+		{"bridge name taken by another interface", func(t *testing.T, h *testHost, cfg *config.Config) {
+			cfg.Network.BridgeName = "lo"
+		}, "network:bridge", StatusFail},
+		{"ip forwarding disabled", func(t *testing.T, h *testHost, cfg *config.Config) {
+			h.write(t, "proc/sys/net/ipv4/ip_forward", "0\n")
+		}, "network:ip_forward", StatusWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, cfg := newTestHost(t)
+			tt.setup(t, h, cfg)
+
+			report := h.doctor(cfg).Run()
+			assert.Equal(t, tt.status, statuses(report)[tt.check])
+			if tt.status == StatusFail {
+				assert.False(t, report.OK())
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	v, ok := parseVersion("Firecracker v1.10.1\n\nSupported snapshot data format versions: v1.0.0")
+	require.True(t, ok)
+	assert.Equal(t, version{1, 10, 1}, v)
+	assert.False(t, v.less(minFirecrackerVersion))
+	assert.True(t, version{0, 25, 2}.less(minFirecrackerVersion))
+
+	_, ok = parseVersion("unknown")
+	assert.False(t, ok)
+}
+
+func TestReport_WriteText(t *testing.T) {
+	report := &Report{}
+	report.add(
+		pass("kvm", "/dev/kvm is accessible"),
+		fail("module:tun", "modprobe tun", "tun not loaded"),
+	)
+
+	var buf bytes.Buffer
+	report.WriteText(&buf)
+	out := buf.String()
+
+	assert.Contains(t, out, "[PASS] kvm")
+	assert.Contains(t, out, "[FAIL] module:tun")
+	assert.Contains(t, out, "hint: modprobe tun")
+	assert.Contains(t, out, "1 passed, 0 warnings, 1 failed")
+}
@@ -0,0 +1,172 @@
+package doctor
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// moduleLoaded reports whether a kernel module is loaded, which lists it
+// under /sys/module, or built into the kernel
+func (d *Doctor) moduleLoaded(name string) bool {
+	if _, err := os.Stat(filepath.Join(d.cfg.Placement.SysfsRoot, "module", name)); err == nil {
+		return true
+	}
+
+	// Built-in modules without parameters only appear in modules.builtin
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	builtin, err := os.ReadFile(filepath.Join(d.modulesRoot, unix.ByteSliceToString(uts.Release[:]), "modules.builtin"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(builtin), "\n") {
+		if strings.TrimSuffix(filepath.Base(line), ".ko") == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKernelModules verifies the modules for KVM, TAP devices and bridging
+func (d *Doctor) checkKernelModules() []Result {
+	var results []Result
+
+	if d.moduleLoaded("kvm") {
+		results = append(results, pass("module:kvm", "kvm loaded"))
+	} else {
+		results = append(results, fail("module:kvm", "modprobe kvm_intel (or kvm_amd) and enable virtualization (VT-x/AMD-V) in the firmware",
+			"kvm not loaded"))
+	}
+
+	// TAP devices are created through /dev/net/tun
+	tunDevice := filepath.Join(d.devRoot, "net", "tun")
+	if _, err := os.Stat(tunDevice); err == nil || d.moduleLoaded("tun") {
+		results = append(results, pass("module:tun", "tun available"))
+	} else {
+		results = append(results, fail("module:tun", "modprobe tun", "%s not found and tun not loaded", tunDevice))
+	}
+
+	if d.moduleLoaded("bridge") {
+		results = append(results, pass("module:bridge", "bridge loaded"))
+	} else {
+		results = append(results, warn("module:bridge", "modprobe bridge", "bridge not loaded, it is loaded on demand when the bridge is created"))
+	}
+
+	return results
+}
+
+// checkKVM verifies the agent can open the KVM device
+func (d *Doctor) checkKVM() Result {
+	const check = "kvm"
+	device := d.cfg.Health.KVMDevice
+
+	info, err := os.Stat(device)
+	if err != nil {
+		return fail(check, "load the kvm modules and check that the host exposes hardware virtualization (nested virtualization on cloud VMs)",
+			"%s not found", device)
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return fail(check, "recreate it with: mknod "+device+" c 10 232", "%s is not a character device", device)
+	}
+
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return fail(check, "run the agent as root or add its user to the group owning "+device, "cannot open %s: %v", device, err)
+	}
+	f.Close()
+
+	return pass(check, "%s is accessible", device)
+}
+
+// checkCgroups verifies the cgroup v2 hierarchy, the controllers used for
+// VM resource limits and access to the parent cgroup. Without the jailer,
+// limits or CPU placement VMs only get a cgroup when they request limits,
+// so failures are reported as warnings
+func (d *Doctor) checkCgroups() []Result {
+	results := d.cgroupResults()
+	if d.cgroupsRequired() {
+		return results
+	}
+	for i, r := range results {
+		if r.Status == StatusFail {
+			results[i].Status = StatusWarn
+			results[i].Message += " (only needed by VMs that request resource limits)"
+		}
+	}
+	return results
+}
+
+// cgroupsRequired reports whether every VM is placed in a cgroup: always
+// with the jailer, and in direct mode when limits or placement are configured
+func (d *Doctor) cgroupsRequired() bool {
+	fc := d.cfg.Firecracker
+	return (fc.UseJailer != nil && *fc.UseJailer) ||
+		fc.ResourceLimits != (config.ResourceLimitsConfig{}) ||
+		d.cfg.Placement.Enabled
+}
+
+func (d *Doctor) cgroupResults() []Result {
+	root := d.cfg.Firecracker.CgroupRoot
+
+	var stat unix.Statfs_t
+	if err := d.statfs(root, &stat); err != nil {
+		return []Result{fail("cgroup:v2", "mount cgroup2 at "+root, "cannot access %s: %v", root, err)}
+	}
+	if stat.Type != unix.CGROUP2_SUPER_MAGIC {
+		return []Result{fail("cgroup:v2", "boot with systemd.unified_cgroup_hierarchy=1 or set firecracker.cgroup_root to a cgroup2 mount",
+			"%s is not a cgroup v2 mount", root)}
+	}
+	results := []Result{pass("cgroup:v2", "%s is a cgroup v2 mount", root)}
+
+	data, err := os.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return append(results, fail("cgroup:controllers", "", "cannot read available controllers: %v", err))
+	}
+	available := strings.Fields(string(data))
+
+	// cpuset is only needed for CPU pinning and explicit cpuset limits
+	cpusetRequired := d.cfg.Placement.Enabled ||
+		d.cfg.Firecracker.ResourceLimits.CpusetCPUs != "" ||
+		d.cfg.Firecracker.ResourceLimits.CpusetMems != ""
+
+	var missing, optional []string
+	for _, c := range []string{"cpu", "memory", "pids", "cpuset"} {
+		if slices.Contains(available, c) {
+			continue
+		}
+		if c == "cpuset" && !cpusetRequired {
+			optional = append(optional, c)
+		} else {
+			missing = append(missing, c)
+		}
+	}
+
+	hint := "enable the controllers in the parent cgroup (e.g. Delegate=yes in the systemd unit)"
+	switch {
+	case len(missing) > 0:
+		results = append(results, fail("cgroup:controllers", hint, "missing controllers: %s", strings.Join(missing, ", ")))
+	case len(optional) > 0:
+		results = append(results, warn("cgroup:controllers", hint, "missing controllers: %s (needed for CPU pinning)", strings.Join(optional, ", ")))
+	default:
+		results = append(results, pass("cgroup:controllers", "cpu, memory, pids and cpuset available"))
+	}
+
+	// VM cgroups are created under the parent, which is created on first use
+	parent := filepath.Join(root, d.cfg.Firecracker.CgroupParent)
+	dir := parent
+	if _, err := os.Stat(parent); os.IsNotExist(err) {
+		dir = root
+	}
+	if err := unix.Access(dir, unix.W_OK); err != nil {
+		return append(results, fail("cgroup:parent", "run the agent as root or delegate "+parent+" to its user",
+			"cannot create VM cgroups in %s: %v", dir, err))
+	}
+	return append(results, pass("cgroup:parent", "VM cgroups can be created in %s", parent))
+}
@@ -0,0 +1,74 @@
+package doctor
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkNetwork verifies the bridge does not conflict with existing
+// interfaces and that VMs can be routed
+func (d *Doctor) checkNetwork() []Result {
+	name := d.cfg.Network.BridgeName
+	var results []Result
+
+	if _, err := net.InterfaceByName(name); err != nil {
+		results = append(results, pass("network:bridge", "%s does not exist, the agent will create it", name))
+	} else if _, err := os.Stat(filepath.Join(d.cfg.Placement.SysfsRoot, "class", "net", name, "bridge")); err != nil {
+		results = append(results, fail("network:bridge", "set network.bridge_name to an unused name or an existing bridge",
+			"%s exists and is not a bridge", name))
+	} else {
+		results = append(results, pass("network:bridge", "%s is a bridge", name))
+	}
+
+	results = append(results, d.checkBridgeSubnet())
+
+	forward, err := os.ReadFile(filepath.Join(d.procRoot, "sys", "net", "ipv4", "ip_forward"))
+	if err != nil || strings.TrimSpace(string(forward)) != "1" {
+		results = append(results, warn("network:ip_forward", "sysctl -w net.ipv4.ip_forward=1 and persist it in /etc/sysctl.d",
+			"IPv4 forwarding is disabled, VMs cannot reach other networks"))
+	} else {
+		results = append(results, pass("network:ip_forward", "IPv4 forwarding is enabled"))
+	}
+
+	return results
+}
+
+// checkBridgeSubnet verifies the bridge subnet does not overlap the
+// addresses of other interfaces
+func (d *Doctor) checkBridgeSubnet() Result {
+	const check = "network:subnet"
+	bridgeIP := d.cfg.Network.BridgeIP
+
+	_, subnet, err := net.ParseCIDR(bridgeIP)
+	if err != nil {
+		return fail(check, "set network.bridge_ip to an address with prefix length, e.g. 172.16.0.1/24", "invalid bridge_ip %q", bridgeIP)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return warn(check, "", "cannot list interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Name == d.cfg.Network.BridgeName {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if subnet.Contains(ipNet.IP) || ipNet.Contains(subnet.IP) {
+				return fail(check, "choose a network.bridge_ip subnet not used on the host",
+					"%s overlaps %s on %s", subnet, ipNet, iface.Name)
+			}
+		}
+	}
+
+	return pass(check, "%s does not overlap host networks", subnet)
+}
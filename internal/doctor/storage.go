@@ -0,0 +1,98 @@
+package doctor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// filesystemNames maps statfs magic numbers to names for the report
+var filesystemNames = map[int64]string{
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.EXT4_SUPER_MAGIC:      "ext4",
+	unix.XFS_SUPER_MAGIC:       "xfs",
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlay",
+	unix.NFS_SUPER_MAGIC:       "nfs",
+}
+
+// checkStorage verifies the VM images and the VMs directory: writable,
+// with enough free space, and whether rootfs copies can be reflinks
+func (d *Doctor) checkStorage() []Result {
+	var results []Result
+
+	for _, image := range []struct{ check, path, key string }{
+		{"image:kernel", d.cfg.Firecracker.KernelPath, "firecracker.kernel_path"},
+		{"image:rootfs", d.cfg.Firecracker.RootfsPath, "firecracker.rootfs_path"},
+	} {
+		if info, err := os.Stat(image.path); err != nil || !info.Mode().IsRegular() {
+			results = append(results, fail(image.check, "set "+image.key+" to an existing image", "%s not found", image.path))
+		} else {
+			results = append(results, pass(image.check, "%s", image.path))
+		}
+	}
+
+	dir := d.cfg.Storage.VMsDir
+	if _, err := os.Stat(dir); err != nil {
+		// The agent creates the directory, so check where it will be created
+		results = append(results, warn("storage:vms_dir", "", "%s does not exist, the agent will create it", dir))
+		for dir != "/" {
+			if _, err := os.Stat(dir); err == nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	probe, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return append(results, fail("storage:writable", "run the agent as root or fix the ownership of "+dir, "%s is not writable: %v", dir, err))
+	}
+	defer os.Remove(probe.Name())
+	defer probe.Close()
+	results = append(results, pass("storage:writable", "%s is writable", dir))
+
+	var stat unix.Statfs_t
+	if err := d.statfs(dir, &stat); err != nil {
+		return append(results, fail("storage:free_space", "", "cannot stat filesystem of %s: %v", dir, err))
+	}
+	freeMB := int64(stat.Bavail) * int64(stat.Bsize) / 1024 / 1024
+	minFreeMB := d.cfg.Health.MinFreeDiskMB
+	switch {
+	case freeMB < minFreeMB:
+		results = append(results, fail("storage:free_space", "free up space or lower health.min_free_disk_mb",
+			"%d MB free, below the %d MB minimum", freeMB, minFreeMB))
+	case freeMB < 4*minFreeMB:
+		results = append(results, warn("storage:free_space", "each VM gets a full copy of the rootfs unless copies are reflinks",
+			"only %d MB free", freeMB))
+	default:
+		results = append(results, pass("storage:free_space", "%d MB free", freeMB))
+	}
+
+	results = append(results, d.checkReflink(dir, probe, stat.Type))
+	return results
+}
+
+// checkReflink clones the probe file to see whether the filesystem shares
+// extents between copies, which makes rootfs copies instant
+func (d *Doctor) checkReflink(dir string, src *os.File, fsType int64) Result {
+	fsName, ok := filesystemNames[fsType]
+	if !ok {
+		fsName = fmt.Sprintf("0x%x", fsType)
+	}
+
+	dst, err := os.CreateTemp(dir, ".doctor-clone-*")
+	if err != nil {
+		return warn("storage:reflink", "", "cannot create clone probe: %v", err)
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	if err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())); err != nil {
+		return warn("storage:reflink", "use XFS (mkfs.xfs -m reflink=1) or btrfs for the VMs directory",
+			"%s does not support reflinks, each VM rootfs is a full copy", fsName)
+	}
+	return pass("storage:reflink", "%s supports reflinks", fsName)
+}
@@ -0,0 +1,231 @@
+// Package events keeps the numbered history of VM events that lets
+// WatchVMEvents clients resume where they left off.
+package events
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const segmentPrefix = "events-"
+
+// Journal numbers VM events and retains the most recent ones. With a
+// directory, events are also appended to JSON lines segment files named
+// after their first sequence, and the history survives restarts.
+// Segments are removed once their events fall out of the retained window.
+type Journal struct {
+	dir           string
+	maxEvents     int
+	segmentEvents int
+
+	mu         sync.Mutex
+	events     []*pb.VMEvent // retained events, oldest first
+	seq        uint64        // sequence of the last event
+	segments   []string      // segment files, oldest first
+	file       *os.File
+	w          *bufio.Writer
+	fileEvents int
+}
+
+// Open loads the journal in dir, or creates an in-memory journal if dir is
+// empty. maxEvents bounds the events retained for replay.
+func Open(dir string, maxEvents int) (*Journal, error) {
+	if maxEvents < 1 {
+		maxEvents = 1
+	}
+	j := &Journal{
+		dir:           dir,
+		maxEvents:     maxEvents,
+		segmentEvents: max(maxEvents/4, 1),
+	}
+	if dir == "" {
+		return j, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create event journal directory: %w", err)
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list event journal: %w", err)
+	}
+	sort.Strings(segments) // names are zero padded sequences
+	j.segments = segments
+
+	for _, segment := range segments {
+		if err := j.load(segment); err != nil {
+			return nil, err
+		}
+	}
+	j.prune()
+
+	return j, nil
+}
+
+// load reads the events of a segment. Lines that fail to parse, such as a
+// record cut short by a crash, are skipped.
+func (j *Journal) load(segment string) error {
+	f, err := os.Open(segment)
+	if err != nil {
+		return fmt.Errorf("failed to open event journal: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event pb.VMEvent
+		if err := protojson.Unmarshal(scanner.Bytes(), &event); err != nil || event.Sequence <= j.seq {
+			continue
+		}
+		j.retain(&event)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read event journal %s: %w", segment, err)
+	}
+	return nil
+}
+
+// retain adds an event to the in-memory window
+func (j *Journal) retain(event *pb.VMEvent) {
+	j.events = append(j.events, event)
+	if len(j.events) > j.maxEvents {
+		j.events = j.events[len(j.events)-j.maxEvents:]
+	}
+	j.seq = event.Sequence
+}
+
+// Append assigns the event the next sequence number and records it. The
+// event is retained even if writing it to disk fails.
+func (j *Journal) Append(event *pb.VMEvent) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	event.Sequence = j.seq + 1
+	j.retain(event)
+
+	if j.dir == "" {
+		return nil
+	}
+	return j.write(event)
+}
+
+// write appends an event to the active segment, starting a new one when it
+// is full
+func (j *Journal) write(event *pb.VMEvent) error {
+	if j.file == nil || j.fileEvents >= j.segmentEvents {
+		if err := j.rotate(event.Sequence); err != nil {
+			return err
+		}
+	}
+
+	data, err := protojson.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	j.w.Write(data)
+	j.w.WriteByte('\n')
+	if err := j.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write event journal: %w", err)
+	}
+	j.fileEvents++
+	return nil
+}
+
+// rotate closes the active segment and starts one at sequence first
+func (j *Journal) rotate(first uint64) error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	name := filepath.Join(j.dir, fmt.Sprintf("%s%020d.jsonl", segmentPrefix, first))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open event journal: %w", err)
+	}
+	j.file = f
+	j.w = bufio.NewWriter(f)
+	j.fileEvents = 0
+	j.segments = append(j.segments, name)
+	j.prune()
+	return nil
+}
+
+// prune removes segments whose events all fall before the retained window
+func (j *Journal) prune() {
+	if len(j.events) == 0 {
+		return
+	}
+	oldest := j.events[0].Sequence
+	for len(j.segments) > 1 {
+		// A segment ends where the next one starts
+		next, ok := segmentStart(j.segments[1])
+		if !ok || next > oldest {
+			return
+		}
+		os.Remove(j.segments[0])
+		j.segments = j.segments[1:]
+	}
+}
+
+// segmentStart returns the first sequence of a segment from its name
+func segmentStart(segment string) (uint64, bool) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(segment), segmentPrefix), ".jsonl")
+	seq, err := strconv.ParseUint(name, 10, 64)
+	return seq, err == nil
+}
+
+// Since returns the retained events with a sequence greater than after. It
+// reports false when events after that sequence are no longer retained, or
+// when after lies beyond the last event, e.g. from before the journal was
+// reset.
+func (j *Journal) Since(after uint64) ([]*pb.VMEvent, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if after > j.seq {
+		return nil, false
+	}
+	if after == j.seq {
+		return nil, true
+	}
+
+	oldest := j.seq + 1
+	if len(j.events) > 0 {
+		oldest = j.events[0].Sequence
+	}
+	complete := after+1 >= oldest
+
+	i := sort.Search(len(j.events), func(i int) bool { return j.events[i].Sequence > after })
+	return append([]*pb.VMEvent(nil), j.events[i:]...), complete
+}
+
+// LastSequence returns the sequence of the last event, 0 if there is none
+func (j *Journal) LastSequence() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// Close closes the active segment
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
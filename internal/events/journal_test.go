@@ -0,0 +1,94 @@
+package events
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendEvents(t *testing.T, j *Journal, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		require.NoError(t, j.Append(&pb.VMEvent{VmId: "vm-1", Type: pb.EventType_EVENT_TYPE_CREATED}))
+	}
+}
+
+func sequences(events []*pb.VMEvent) []uint64 {
+	seqs := make([]uint64, len(events))
+	for i, e := range events {
+		seqs[i] = e.Sequence
+	}
+	return seqs
+}
+
+func TestJournal_Since(t *testing.T) {
+	j, err := Open("", 5)
+	require.NoError(t, err)
+
+	events, complete := j.Since(0)
+	assert.True(t, complete)
+	assert.Empty(t, events)
+
+	appendEvents(t, j, 3)
+	assert.Equal(t, uint64(3), j.LastSequence())
+
+	events, complete = j.Since(1)
+	assert.True(t, complete)
+	assert.Equal(t, []uint64{2, 3}, sequences(events))
+
+	events, complete = j.Since(3)
+	assert.True(t, complete)
+	assert.Empty(t, events)
+
+	// Only the last five events are retained
+	appendEvents(t, j, 5)
+	events, complete = j.Since(3)
+	assert.True(t, complete)
+	assert.Equal(t, []uint64{4, 5, 6, 7, 8}, sequences(events))
+
+	events, complete = j.Since(2)
+	assert.False(t, complete, "event 3 is gone")
+	assert.Equal(t, []uint64{4, 5, 6, 7, 8}, sequences(events))
+
+	_, complete = j.Since(9)
+	assert.False(t, complete, "sequence from the future")
+}
+
+func TestJournal_Persistence(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := Open(dir, 8)
+	require.NoError(t, err)
+	appendEvents(t, j, 30)
+	require.NoError(t, j.Close())
+
+	// Segments hold two events each, only those overlapping the window remain
+	segments, err := filepath.Glob(filepath.Join(dir, "events-*.jsonl"))
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(segments), 5)
+
+	// A record cut short by a crash is skipped
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"vmId":"vm-1","seq`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j, err = Open(dir, 8)
+	require.NoError(t, err)
+	defer j.Close()
+	assert.Equal(t, uint64(30), j.LastSequence())
+
+	events, complete := j.Since(22)
+	assert.True(t, complete)
+	assert.Equal(t, []uint64{23, 24, 25, 26, 27, 28, 29, 30}, sequences(events))
+	assert.Equal(t, "vm-1", events[0].VmId)
+
+	// Numbering continues after a restart
+	appendEvents(t, j, 1)
+	assert.Equal(t, uint64(31), j.LastSequence())
+}
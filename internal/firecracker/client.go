@@ -63,6 +63,11 @@ type NetworkInterface struct {
 	GuestMAC    string `json:"guest_mac,omitempty"`
 }
 
+// Metrics represents the metrics system configuration
+type Metrics struct {
+	MetricsPath string `json:"metrics_path"`
+}
+
 // InstanceActionInfo represents an action to perform on the VM
 type InstanceActionInfo struct {
 	ActionType string `json:"action_type"` // "FlushMetrics", "InstanceStart", "SendCtrlAltDel"
@@ -94,6 +99,17 @@ func (c *Client) StartInstance(ctx context.Context) error {
 	return c.put(ctx, "/actions", action)
 }
 
+// SetMetrics configures the metrics sink (must be called before boot)
+func (c *Client) SetMetrics(ctx context.Context, metrics Metrics) error {
+	return c.put(ctx, "/metrics", metrics)
+}
+
+// FlushMetrics asks Firecracker to write its current metrics to the sink
+func (c *Client) FlushMetrics(ctx context.Context) error {
+	action := InstanceActionInfo{ActionType: "FlushMetrics"}
+	return c.put(ctx, "/actions", action)
+}
+
 // SendCtrlAltDel sends Ctrl+Alt+Del to the VM
 func (c *Client) SendCtrlAltDel(ctx context.Context) error {
 	action := InstanceActionInfo{ActionType: "SendCtrlAltDel"}
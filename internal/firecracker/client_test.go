@@ -491,6 +491,50 @@ func TestClient_SendCtrlAltDel(t *testing.T) {
 	}
 }
 
+func TestClient_SetMetrics(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT", r.Method)
+		assert.Equal(t, "/metrics", r.URL.Path)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var metrics Metrics
+		require.NoError(t, json.Unmarshal(body, &metrics))
+		assert.Equal(t, "/metrics.fifo", metrics.MetricsPath)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	socketPath, cleanup := mockUnixServer(t, handler)
+	defer cleanup()
+
+	client := NewClient(socketPath)
+	require.NoError(t, client.SetMetrics(context.Background(), Metrics{MetricsPath: "/metrics.fifo"}))
+}
+
+func TestClient_FlushMetrics(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT", r.Method)
+		assert.Equal(t, "/actions", r.URL.Path)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var action InstanceActionInfo
+		require.NoError(t, json.Unmarshal(body, &action))
+		assert.Equal(t, "FlushMetrics", action.ActionType)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	socketPath, cleanup := mockUnixServer(t, handler)
+	defer cleanup()
+
+	client := NewClient(socketPath)
+	require.NoError(t, client.FlushMetrics(context.Background()))
+}
+
 func TestClient_GetInstanceInfo(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -0,0 +1,173 @@
+package firecracker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/monitor"
+)
+
+// EventHandler receives the VM events the manager raises on its own, for
+// process exits and restarts. It is called without the manager lock held.
+type EventHandler func(event *pb.VMEvent)
+
+// SetEventHandler registers the handler notified of process exits and restarts
+func (m *Manager) SetEventHandler(handler EventHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.eventHandler = handler
+}
+
+// vmExit is an unexpected process exit recorded by markExited
+type vmExit struct {
+	vmID    string
+	vm      *VM
+	events  []*pb.VMEvent
+	restart bool          // whether the restart policy relaunches the VM
+	delay   time.Duration // backoff before the relaunch
+}
+
+// watchExit waits for a process of the VM to exit and records the exit
+func (m *Manager) watchExit(vmID string, vm *VM, process *VMProcess) {
+	select {
+	case <-m.stopCh:
+		return
+	case <-process.Done():
+	}
+
+	m.mu.Lock()
+	exited := m.markExited(vmID, vm, process, process.Exit())
+	m.mu.Unlock()
+
+	m.afterExit(exited)
+}
+
+// markExited moves a running or paused VM whose process is gone to STOPPED
+// or ERROR and applies its restart policy. It returns nil when the exit was expected
+// because the VM is being stopped or deleted, or process is no longer the
+// VM's. exit is nil if the exit status is unknown. The caller must hold
+// m.mu.
+func (m *Manager) markExited(vmID string, vm *VM, process *VMProcess, exit *ProcessExit) *vmExit {
+	if m.vms[vmID] != vm || vm.Process != process || vm.stopRequested || !vmUp(vm.Info.State) {
+		return nil
+	}
+
+	lastExit := &pb.VMExit{ExitCode: -1, ExitedAt: time.Now().Unix()}
+	state := pb.VMState_VM_STATE_STOPPED
+	if exit != nil {
+		lastExit.ExitCode = int32(exit.Code)
+		lastExit.Signal = exit.Signal
+		lastExit.ExitedAt = exit.Time.Unix()
+		if exit.Failed() {
+			state = pb.VMState_VM_STATE_ERROR
+		}
+	}
+	lastExit.LogTail = process.LogTail()
+
+	vm.Info.LastExit = lastExit
+	event, err := m.transition(vm, state, exitMessage(state, lastExit))
+	if err != nil {
+		m.log.WithError(err).Error("Failed to record VM exit")
+		return nil
+	}
+	event.Exit = lastExit
+
+	entry := m.log.WithFields(logrus.Fields{
+		"vm_id":     vmID,
+		"exit_code": lastExit.ExitCode,
+		"signal":    lastExit.Signal,
+	})
+	if state == pb.VMState_VM_STATE_ERROR {
+		entry.Error("VM process crashed")
+	} else {
+		entry.Warn("VM process exited, marking VM as stopped")
+	}
+
+	exited := &vmExit{vmID: vmID, vm: vm, events: []*pb.VMEvent{event}}
+
+	failed := state == pb.VMState_VM_STATE_ERROR
+	if delay, ok := m.restartDelay(vm, failed, time.Since(vm.StartedAt)); ok {
+		exited.restart, exited.delay = true, delay
+		exited.events = append(exited.events, restartingEvent(vm, delay))
+	}
+
+	return exited
+}
+
+// afterExit stops the metrics of an exited VM, emits its events and
+// schedules its restart. It must be called without m.mu held.
+func (m *Manager) afterExit(exited *vmExit) {
+	if exited == nil {
+		return
+	}
+	if exited.vm.Metrics != nil {
+		exited.vm.Metrics.Stop()
+	}
+	m.emit(exited.events...)
+	if exited.restart {
+		go m.restartAfter(exited.vmID, exited.vm, exited.delay)
+	}
+}
+
+// exitMessage describes a process exit in an event
+func exitMessage(state pb.VMState, exit *pb.VMExit) string {
+	message := "VM process exited"
+	if state == pb.VMState_VM_STATE_ERROR {
+		message = "VM process crashed"
+	}
+	if exit.Signal != "" {
+		return fmt.Sprintf("%s: killed by %s", message, exit.Signal)
+	}
+	if exit.ExitCode >= 0 {
+		return fmt.Sprintf("%s with code %d", message, exit.ExitCode)
+	}
+	return message
+}
+
+// newEvent builds an event about a VM in its current state
+func newEvent(info *pb.VMInfo, eventType pb.EventType, message string) *pb.VMEvent {
+	return &pb.VMEvent{
+		VmId:      info.VmId,
+		State:     info.State,
+		Message:   message,
+		Timestamp: time.Now().Unix(),
+		Type:      eventType,
+		Labels:    info.Metadata,
+	}
+}
+
+// emit passes events to the event handler, skipping nil ones. It must be
+// called without m.mu held.
+func (m *Manager) emit(events ...*pb.VMEvent) {
+	m.mu.RLock()
+	handler := m.eventHandler
+	m.mu.RUnlock()
+
+	if handler == nil {
+		return
+	}
+	for _, event := range events {
+		if event != nil {
+			handler(event)
+		}
+	}
+}
+
+// vmUp reports whether a VM in state has a guest that is meant to be running
+func vmUp(state pb.VMState) bool {
+	return state == pb.VMState_VM_STATE_RUNNING || state == pb.VMState_VM_STATE_PAUSED
+}
+
+// updateRunningGauge sets the running VMs gauge from the registry. The
+// caller must hold m.mu.
+func (m *Manager) updateRunningGauge() {
+	running := 0
+	for _, vm := range m.vms {
+		if vm.Info.State == pb.VMState_VM_STATE_RUNNING {
+			running++
+		}
+	}
+	monitor.VMsRunning.Set(float64(running))
+}
@@ -0,0 +1,126 @@
+package firecracker
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startExitingVM registers a VM whose fake Firecracker process runs command
+func startExitingVM(t *testing.T, m *Manager, vmID, command string) *VM {
+	t.Helper()
+
+	tempDir := t.TempDir()
+	binary := writeFakeFirecracker(t, tempDir, command)
+	process, err := StartFirecrackerProcess(context.Background(), binary,
+		filepath.Join(tempDir, "fc.sock"), filepath.Join(tempDir, "fc.log"), m.log)
+	require.NoError(t, err)
+
+	vm := &VM{
+		Info:    &pb.VMInfo{VmId: vmID, State: pb.VMState_VM_STATE_RUNNING, Metadata: map[string]string{"team": "web"}},
+		Process: process,
+	}
+	m.mu.Lock()
+	m.vms[vmID] = vm
+	m.mu.Unlock()
+	return vm
+}
+
+func TestManager_WatchExit(t *testing.T) {
+	newManager := func() (*Manager, chan *pb.VMEvent) {
+		events := make(chan *pb.VMEvent, 10)
+		m := &Manager{
+			log:    createTestLogger(),
+			vms:    make(map[string]*VM),
+			stopCh: make(chan struct{}),
+		}
+		m.SetEventHandler(func(event *pb.VMEvent) { events <- event })
+		return m, events
+	}
+
+	t.Run("crash marks the VM failed", func(t *testing.T) {
+		m, events := newManager()
+		vm := startExitingVM(t, m, "vm-1", "echo \"panic: boom\" >> \"$4\"; sleep 0.2; exit 3")
+
+		m.watchExit("vm-1", vm, vm.Process)
+
+		select {
+		case event := <-events:
+			assert.Equal(t, "vm-1", event.VmId)
+			assert.Equal(t, pb.EventType_EVENT_TYPE_CRASHED, event.Type)
+			assert.Equal(t, pb.VMState_VM_STATE_ERROR, event.State)
+			assert.Equal(t, "VM process crashed with code 3", event.Message)
+			assert.Equal(t, map[string]string{"team": "web"}, event.Labels)
+			require.NotNil(t, event.Exit)
+			assert.Equal(t, int32(3), event.Exit.ExitCode)
+			assert.Contains(t, event.Exit.LogTail, "panic: boom")
+		default:
+			t.Fatal("event handler not called")
+		}
+		assert.Empty(t, events, "no restart without a restart policy")
+
+		got, err := m.GetVM("vm-1")
+		require.NoError(t, err)
+		assert.Equal(t, pb.VMState_VM_STATE_ERROR, got.State)
+		assert.Equal(t, int32(3), got.LastExit.GetExitCode())
+	})
+
+	t.Run("clean exit marks the VM stopped", func(t *testing.T) {
+		m, events := newManager()
+		vm := startExitingVM(t, m, "vm-1", "sleep 0.2")
+
+		m.watchExit("vm-1", vm, vm.Process)
+
+		event := <-events
+		assert.Equal(t, pb.EventType_EVENT_TYPE_STOPPED, event.Type)
+		assert.Equal(t, pb.VMState_VM_STATE_STOPPED, event.State)
+		assert.Equal(t, "VM process exited with code 0", event.Message)
+		assert.Equal(t, int32(0), vm.Info.LastExit.ExitCode)
+	})
+
+	t.Run("requested stop is not reported", func(t *testing.T) {
+		m, events := newManager()
+		vm := startExitingVM(t, m, "vm-1", "sleep 0.2; exit 1")
+		vm.stopRequested = true
+
+		m.watchExit("vm-1", vm, vm.Process)
+
+		assert.Empty(t, events)
+		assert.Equal(t, pb.VMState_VM_STATE_RUNNING, vm.Info.State)
+		assert.Nil(t, vm.Info.LastExit)
+	})
+
+	t.Run("stops waiting when the manager closes", func(t *testing.T) {
+		m, events := newManager()
+		vm := startExitingVM(t, m, "vm-1", "exec sleep 60")
+		defer vm.Process.Cmd.Process.Kill()
+
+		done := make(chan struct{})
+		go func() {
+			m.watchExit("vm-1", vm, vm.Process)
+			close(done)
+		}()
+		require.NoError(t, m.Close())
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("watchExit did not return after Close")
+		}
+		assert.Empty(t, events)
+	})
+}
+
+func TestExitMessage(t *testing.T) {
+	assert.Equal(t, "VM process crashed: killed by SIGSEGV",
+		exitMessage(pb.VMState_VM_STATE_ERROR, &pb.VMExit{ExitCode: -1, Signal: "SIGSEGV"}))
+	assert.Equal(t, "VM process exited with code 0",
+		exitMessage(pb.VMState_VM_STATE_STOPPED, &pb.VMExit{}))
+	assert.Equal(t, "VM process exited",
+		exitMessage(pb.VMState_VM_STATE_STOPPED, &pb.VMExit{ExitCode: -1}))
+}
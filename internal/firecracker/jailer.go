@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/spluca/firecracker-agent/internal/cgroup"
 	"github.com/spluca/firecracker-agent/internal/storage"
 	"github.com/spluca/firecracker-agent/pkg/fileutil"
 )
@@ -27,6 +28,8 @@ func StartJailedProcess(
 	vmID string,
 	jailPaths *storage.JailPaths,
 	uid, gid int,
+	parentCgroup string,
+	limits cgroup.Limits,
 	log *logrus.Logger,
 ) (*VMProcess, error) {
 	log.WithFields(logrus.Fields{
@@ -40,11 +43,13 @@ func StartJailedProcess(
 	if err := verifyFileExists(jailPaths.FirecrackerBinary, "firecracker binary"); err != nil {
 		return nil, err
 	}
-	if err := verifyFileExists(jailPaths.KernelPath, "kernel"); err != nil {
-		return nil, err
-	}
-	if err := verifyFileExists(jailPaths.RootfsPath, "rootfs"); err != nil {
-		return nil, err
+	if !jailPaths.Reuse {
+		if err := verifyFileExists(jailPaths.KernelPath, "kernel"); err != nil {
+			return nil, err
+		}
+		if err := verifyFileExists(jailPaths.RootfsPath, "rootfs"); err != nil {
+			return nil, err
+		}
 	}
 
 	// Structure: <chroot-base-dir>/firecracker/<vm_id>/root/
@@ -80,11 +85,21 @@ func StartJailedProcess(
 	if err := fileutil.CopyFile(jailPaths.FirecrackerBinary, jailedFirecrackerPath); err != nil {
 		return nil, fmt.Errorf("failed to copy firecracker binary: %w", err)
 	}
-	if err := fileutil.CopyFile(jailPaths.KernelPath, jailedKernelPath); err != nil {
-		return nil, fmt.Errorf("failed to copy kernel: %w", err)
-	}
-	if err := fileutil.CopyFile(jailPaths.RootfsPath, jailedRootfsPath); err != nil {
-		return nil, fmt.Errorf("failed to copy rootfs: %w", err)
+	if jailPaths.Reuse {
+		// A relaunched VM keeps its disk
+		if err := verifyFileExists(jailedKernelPath, "jailed kernel"); err != nil {
+			return nil, err
+		}
+		if err := verifyFileExists(jailedRootfsPath, "jailed rootfs"); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := fileutil.CopyFile(jailPaths.KernelPath, jailedKernelPath); err != nil {
+			return nil, fmt.Errorf("failed to copy kernel: %w", err)
+		}
+		if err := fileutil.CopyFile(jailPaths.RootfsPath, jailedRootfsPath); err != nil {
+			return nil, fmt.Errorf("failed to copy rootfs: %w", err)
+		}
 	}
 
 	// STEP 3: Set ownership so jailer can access files after dropping privileges
@@ -117,10 +132,14 @@ func StartJailedProcess(
 		"--chroot-base-dir", chrootBaseDir,
 		"--exec-file", jailedFirecrackerPath, // Host path to firecracker inside jail
 		"--cgroup-version", "2",
+		"--parent-cgroup", parentCgroup,
+	}
+	args = append(args, limits.JailerArgs()...)
+	args = append(args,
 		"--",
 		"--api-sock", "/run/firecracker.socket",
 		"--boot-timer",
-	}
+	)
 
 	log.WithFields(logrus.Fields{
 		"jailer": jailerPath,
@@ -164,15 +183,18 @@ func StartJailedProcess(
 		return nil, fmt.Errorf("jailer exited immediately. Log: %s", string(logContent))
 	}
 
-	// Start goroutine to monitor jailer
-	go func() {
-		err := cmd.Wait()
-		if err != nil {
-			log.WithError(err).WithField("pid", cmd.Process.Pid).Warn("Jailer exited with error")
-		} else {
-			log.WithField("pid", cmd.Process.Pid).Info("Jailer exited cleanly")
-		}
-	}()
+	// The jailer execs Firecracker, so its exit is the VM process exit
+	process := &VMProcess{
+		PID:      cmd.Process.Pid,
+		Cmd:      cmd,
+		LogFile:  logFile,
+		LogPath:  jailPaths.LogPath,
+		log:      log,
+		Mode:     ModeJailer,
+		JailPath: jailIdDir,
+		done:     make(chan struct{}),
+	}
+	go process.wait()
 
 	// Wait for socket
 	if err := waitForSocket(chrootSocketPath, 20*time.Second); err != nil {
@@ -191,17 +213,12 @@ func StartJailedProcess(
 	jailPaths.RootfsPath = "/rootfs.ext4"
 	jailPaths.SocketPath = chrootSocketPath
 	jailPaths.JailDir = jailIdDir
+	jailPaths.MetricsPath = filepath.Join(jailRootDir, jailedMetricsPath) // Host path; Firecracker sees jailedMetricsPath
+
+	process.SocketPath = chrootSocketPath
+	process.Client = client
 
-	return &VMProcess{
-		PID:        cmd.Process.Pid,
-		Cmd:        cmd,
-		SocketPath: chrootSocketPath,
-		LogFile:    logFile,
-		Client:     client,
-		log:        log,
-		Mode:       ModeJailer,
-		JailPath:   jailIdDir,
-	}, nil
+	return process, nil
 }
 
 // verifyFileExists checks if a file exists
@@ -0,0 +1,72 @@
+package firecracker
+
+import (
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/cgroup"
+	"github.com/spluca/firecracker-agent/pkg/config"
+)
+
+// resourceLimits merges the per-VM limits from the request with the configured
+// defaults. Request fields left at zero inherit the default; negative
+// defaults disable a limit.
+func resourceLimits(defaults config.ResourceLimitsConfig, req *pb.CreateVMRequest) cgroup.Limits {
+	r := req.GetResourceLimits()
+
+	quotaPercent := int64(defaults.CPUQuotaPercent)
+	if r.GetCpuQuotaPercent() > 0 {
+		quotaPercent = int64(r.GetCpuQuotaPercent())
+	}
+
+	period := defaults.CPUPeriodUs
+	if r.GetCpuPeriodUs() > 0 {
+		period = r.GetCpuPeriodUs()
+	}
+	if period == 0 {
+		period = cgroup.DefaultCPUPeriodUs
+	}
+
+	weight := int64(defaults.CPUWeight)
+	if r.GetCpuWeight() > 0 {
+		weight = int64(r.GetCpuWeight())
+	}
+
+	cpus := defaults.CpusetCPUs
+	if r.GetCpusetCpus() != "" {
+		cpus = r.GetCpusetCpus()
+	}
+
+	mems := defaults.CpusetMems
+	if r.GetCpusetMems() != "" {
+		mems = r.GetCpusetMems()
+	}
+
+	overheadMB := defaults.MemoryOverheadMB
+	if r.GetMemoryOverheadMb() > 0 {
+		overheadMB = r.GetMemoryOverheadMb()
+	}
+
+	pids := defaults.PidsMax
+	if r.GetPidsMax() > 0 {
+		pids = r.GetPidsMax()
+	}
+
+	limits := cgroup.Limits{
+		CPUPeriodUs: period,
+		CpusetCPUs:  cpus,
+		CpusetMems:  mems,
+	}
+	if quotaPercent > 0 && period > 0 {
+		limits.CPUQuotaUs = period * quotaPercent * int64(req.VcpuCount) / 100
+	}
+	if weight > 0 {
+		limits.CPUWeight = weight
+	}
+	if overheadMB > 0 {
+		limits.MemoryMaxBytes = (int64(req.MemoryMb) + overheadMB) * 1024 * 1024
+	}
+	if pids > 0 {
+		limits.PidsMax = pids
+	}
+
+	return limits
+}
@@ -0,0 +1,96 @@
+package firecracker
+
+import (
+	"testing"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/cgroup"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceLimits(t *testing.T) {
+	defaults := config.ResourceLimitsConfig{
+		CPUQuotaPercent:  100,
+		CPUPeriodUs:      100000,
+		MemoryOverheadMB: 64,
+	}
+
+	tests := []struct {
+		name     string
+		defaults config.ResourceLimitsConfig
+		req      *pb.CreateVMRequest
+		expected cgroup.Limits
+	}{
+		{
+			name:     "defaults scale with VM size",
+			defaults: defaults,
+			req:      &pb.CreateVMRequest{VcpuCount: 2, MemoryMb: 512},
+			expected: cgroup.Limits{
+				CPUQuotaUs:     200000,
+				CPUPeriodUs:    100000,
+				MemoryMaxBytes: 576 * 1024 * 1024,
+			},
+		},
+		{
+			name:     "request overrides defaults",
+			defaults: defaults,
+			req: &pb.CreateVMRequest{
+				VcpuCount: 4,
+				MemoryMb:  1024,
+				ResourceLimits: &pb.ResourceLimits{
+					CpuQuotaPercent:  50,
+					CpuPeriodUs:      50000,
+					CpuWeight:        200,
+					CpusetCpus:       "4-7",
+					CpusetMems:       "1",
+					MemoryOverheadMb: 128,
+					PidsMax:          32,
+				},
+			},
+			expected: cgroup.Limits{
+				CPUQuotaUs:     100000,
+				CPUPeriodUs:    50000,
+				CPUWeight:      200,
+				CpusetCPUs:     "4-7",
+				CpusetMems:     "1",
+				MemoryMaxBytes: 1152 * 1024 * 1024,
+				PidsMax:        32,
+			},
+		},
+		{
+			name: "negative defaults disable limits",
+			defaults: config.ResourceLimitsConfig{
+				CPUQuotaPercent:  -1,
+				CPUPeriodUs:      100000,
+				MemoryOverheadMB: -1,
+			},
+			req:      &pb.CreateVMRequest{VcpuCount: 1, MemoryMb: 128},
+			expected: cgroup.Limits{CPUPeriodUs: 100000},
+		},
+		{
+			name:     "no defaults limit nothing",
+			req:      &pb.CreateVMRequest{VcpuCount: 1, MemoryMb: 128},
+			expected: cgroup.Limits{CPUPeriodUs: 100000},
+		},
+		{
+			name: "requested limits apply without defaults",
+			req: &pb.CreateVMRequest{
+				VcpuCount:      2,
+				MemoryMb:       256,
+				ResourceLimits: &pb.ResourceLimits{CpuQuotaPercent: 50, MemoryOverheadMb: 32},
+			},
+			expected: cgroup.Limits{
+				CPUQuotaUs:     100000,
+				CPUPeriodUs:    100000,
+				MemoryMaxBytes: 288 * 1024 * 1024,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, resourceLimits(tt.defaults, tt.req))
+		})
+	}
+}
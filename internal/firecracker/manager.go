@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/cgroup"
+	"github.com/spluca/firecracker-agent/internal/monitor"
 	"github.com/spluca/firecracker-agent/internal/network"
 	"github.com/spluca/firecracker-agent/internal/storage"
+	"github.com/spluca/firecracker-agent/internal/topology"
 	"github.com/spluca/firecracker-agent/pkg/config"
+	"google.golang.org/protobuf/proto"
 )
 
 // VMManager defines the interface for managing Firecracker VMs.
@@ -22,19 +28,29 @@ type VMManager interface {
 	DeleteVM(ctx context.Context, vmID string) error
 	GetVM(vmID string) (*pb.VMInfo, error)
 	ListVMs() []*pb.VMInfo
+	GetVMStats(vmID string) (*pb.VMStats, error)
+	Close() error
 }
 
 // Compile-time check that Manager implements VMManager.
 var _ VMManager = (*Manager)(nil)
 
-// Manager manages Firecracker VMs
+// Manager manages Firecracker VMs. mu guards the registry and the fields
+// of its VMs and is only held briefly; provisioning, stopping and tearing
+// down a VM run under the VM's own lock, so operations on different VMs
+// proceed in parallel.
 type Manager struct {
 	cfg            *config.Config
 	log            *logrus.Logger
 	networkManager *network.Manager
 	storageManager *storage.Manager
+	placer         *Placer // nil when placement is disabled
 	vms            map[string]*VM
 	mu             sync.RWMutex
+	stopCh         chan struct{}
+	closeOnce      sync.Once
+	lastReconcile  atomic.Int64 // unix nanoseconds of the last reconciler pass
+	eventHandler   EventHandler
 }
 
 // VM represents a Firecracker microVM
@@ -44,6 +60,18 @@ type VM struct {
 	SocketPath string
 	TAPDevice  string
 	CreatedAt  time.Time
+	Metrics    *MetricsCollector
+	CgroupPath string // cgroup v2 directory, empty if the VM has no cgroup
+	Placement  *Placement
+	Request    *pb.CreateVMRequest // configuration the VM is relaunched from
+	StartedAt  time.Time           // when the current Firecracker process was launched
+
+	restarts      int  // consecutive restarts, reset once the VM stays up
+	stopRequested bool // set by StopVM and DeleteVM, so the process exit is expected
+
+	// opMu serializes the lifecycle operations of the VM. It is taken
+	// before Manager.mu, and fields are written with both held.
+	opMu sync.Mutex
 }
 
 // NewManager creates a new Firecracker manager
@@ -66,27 +94,165 @@ func NewManager(cfg *config.Config, log *logrus.Logger) (*Manager, error) {
 		return nil, fmt.Errorf("failed to ensure VMs directory: %w", err)
 	}
 
-	return &Manager{
+	m := &Manager{
 		cfg:            cfg,
 		log:            log,
 		networkManager: networkMgr,
 		storageManager: storageMgr,
 		vms:            make(map[string]*VM),
-	}, nil
+		stopCh:         make(chan struct{}),
+	}
+
+	// Discover host topology for NUMA-aware placement
+	if cfg.Placement.Enabled {
+		topo, err := topology.Discover(cfg.Placement.SysfsRoot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover host topology: %w", err)
+		}
+		reserved, err := topology.ParseCPUList(cfg.Placement.ReservedCPUs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid placement.reserved_cpus: %w", err)
+		}
+		m.placer = NewPlacer(topo, reserved)
+
+		log.WithFields(logrus.Fields{
+			"numa_nodes":    len(topo.Nodes),
+			"cpus":          topology.FormatCPUList(topo.CPUs()),
+			"reserved_cpus": topology.FormatCPUList(reserved),
+		}).Info("CPU placement enabled")
+	}
+
+	go m.reconcileLoop(cfg.Health.ReconcileInterval)
+
+	// Sample per-VM resource usage into Prometheus
+	if cfg.Monitoring.Enabled {
+		go m.statsLoop(cfg.Monitoring.VMStatsInterval)
+	}
+
+	return m, nil
+}
+
+// Close stops the manager's background goroutines. Running VMs are left untouched.
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.stopCh)
+	})
+	return nil
 }
 
-// CreateVM creates and starts a new VM
+// CreateVM creates and starts a new VM. The ID is reserved up front, so
+// the VM is listed as CREATING, then BOOTING, while it is provisioned.
 func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VMInfo, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	vm := &VM{
+		Info: &pb.VMInfo{
+			VmId:          req.VmId,
+			VcpuCount:     req.VcpuCount,
+			MemoryMb:      req.MemoryMb,
+			IpAddress:     req.IpAddress,
+			CreatedAt:     time.Now().Unix(),
+			Metadata:      req.Metadata,
+			RestartPolicy: req.RestartPolicy,
+		},
+		Request:   proto.Clone(req).(*pb.CreateVMRequest),
+		CreatedAt: time.Now(),
+	}
+	vm.opMu.Lock()
+	defer vm.opMu.Unlock()
 
-	// Check if VM already exists
+	m.mu.Lock()
 	if _, exists := m.vms[req.VmId]; exists {
+		m.mu.Unlock()
 		return nil, fmt.Errorf("VM %s already exists", req.VmId)
 	}
+	m.vms[req.VmId] = vm
+	event, _ := m.transition(vm, pb.VMState_VM_STATE_CREATING, "Creation requested")
+	m.mu.Unlock()
+	m.emit(event)
 
 	m.log.WithField("vm_id", req.VmId).Info("Creating VM")
 
+	launched, err := m.launch(ctx, req, false, func() {
+		m.mu.Lock()
+		event, _ := m.transition(vm, pb.VMState_VM_STATE_BOOTING, "Firecracker process started")
+		m.mu.Unlock()
+		m.emit(event)
+	})
+
+	m.mu.Lock()
+	if err != nil {
+		event, _ := m.transition(vm, pb.VMState_VM_STATE_ERROR, err.Error())
+		delete(m.vms, req.VmId)
+		m.updateRunningGauge()
+		m.mu.Unlock()
+		m.emit(event)
+		return nil, err
+	}
+	vm.attach(launched)
+	event, _ = m.transition(vm, pb.VMState_VM_STATE_RUNNING, "VM booted")
+	info := m.copyVMInfo(vm)
+	m.mu.Unlock()
+	m.emit(event)
+
+	go m.watchExit(req.VmId, vm, launched.Process)
+
+	m.log.WithFields(logrus.Fields{
+		"vm_id":      req.VmId,
+		"cgroup":     launched.CgroupPath,
+		"vcpus":      req.VcpuCount,
+		"memory":     req.MemoryMb,
+		"ip":         req.IpAddress,
+		"tap_device": launched.TAPDevice,
+	}).Info("VM created successfully")
+
+	return info, nil
+}
+
+// attach takes over the process and resources of a launched VM. The caller
+// must hold vm.opMu and m.mu.
+func (vm *VM) attach(launched *VM) {
+	vm.Process = launched.Process
+	vm.SocketPath = launched.SocketPath
+	vm.TAPDevice = launched.TAPDevice
+	vm.StartedAt = launched.StartedAt
+	vm.Metrics = launched.Metrics
+	vm.CgroupPath = launched.CgroupPath
+	vm.Placement = launched.Placement
+	vm.Info.SocketPath = launched.SocketPath
+}
+
+// detach forgets the process and resources of a torn down VM. The caller
+// must hold vm.opMu and m.mu.
+func (vm *VM) detach() {
+	vm.attach(&VM{})
+}
+
+// lockVM looks up a VM and takes its operation lock
+func (m *Manager) lockVM(vmID string) (*VM, error) {
+	m.mu.RLock()
+	vm, exists := m.vms[vmID]
+	m.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("VM %s not found", vmID)
+	}
+
+	vm.opMu.Lock()
+	m.mu.RLock()
+	current := m.vms[vmID] == vm
+	m.mu.RUnlock()
+	if !current {
+		// Deleted while we waited, or its creation failed
+		vm.opMu.Unlock()
+		return nil, fmt.Errorf("VM %s not found", vmID)
+	}
+	return vm, nil
+}
+
+// launch provisions storage, network and cgroup for a VM and boots its
+// Firecracker process, calling started, if set, once the process runs.
+// Everything it set up is released when it fails. A relaunch boots the VM
+// from the storage it already has, which it keeps on failure. The caller
+// attaches the result to the registered VM.
+func (m *Manager) launch(ctx context.Context, req *pb.CreateVMRequest, relaunch bool, started func()) (*VM, error) {
 	// Determine kernel and rootfs paths
 	kernelPath := req.KernelPath
 	if kernelPath == "" {
@@ -113,8 +279,23 @@ func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VM
 	var process *VMProcess
 	var tapDevice string
 	var macAddr string
+	var cgroupPath string
 
 	useJailer := m.cfg.Firecracker.UseJailer != nil && *m.cfg.Firecracker.UseJailer
+	limits := resourceLimits(m.cfg.Firecracker.ResourceLimits, req)
+
+	// Assign CPUs and a NUMA node unless the caller pinned an explicit cpuset
+	placement, err := m.place(req)
+	if err != nil {
+		return nil, err
+	}
+	if m.placer != nil {
+		cleanups = append(cleanups, func() { m.placer.Release(req.VmId) })
+	}
+	if placement != nil {
+		limits.CpusetCPUs = topology.FormatCPUList(placement.CPUs)
+		limits.CpusetMems = strconv.Itoa(placement.Node)
+	}
 
 	if useJailer {
 		m.log.WithField("vm_id", req.VmId).Info("Using Firecracker jailer for security isolation")
@@ -124,10 +305,20 @@ func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VM
 		if err != nil {
 			return nil, fmt.Errorf("failed to setup jail directory: %w", err)
 		}
-		cleanups = append(cleanups, func() {
-			m.storageManager.CleanupJail(req.VmId)
-			m.storageManager.CleanupVMStorage(req.VmId)
-		})
+		if relaunch {
+			if err := m.storageManager.ResetJail(req.VmId); err != nil {
+				return nil, err
+			}
+			jailPaths.Reuse = true
+		} else {
+			cleanups = append(cleanups, func() {
+				m.storageManager.CleanupJail(req.VmId)
+				m.storageManager.CleanupVMStorage(req.VmId)
+			})
+		}
+		if err := ReportProgress(ctx, pb.OperationStage_OPERATION_STAGE_STORAGE_PREPARED); err != nil {
+			return nil, err
+		}
 
 		jailPaths.FirecrackerBinary = m.cfg.Firecracker.BinaryPath
 
@@ -137,6 +328,9 @@ func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VM
 			return nil, fmt.Errorf("failed to create TAP device: %w", err)
 		}
 		cleanups = append(cleanups, func() { m.networkManager.DeleteTAPDevice(tapDevice) })
+		if err := ReportProgress(ctx, pb.OperationStage_OPERATION_STAGE_NETWORK_READY); err != nil {
+			return nil, err
+		}
 
 		macAddr = m.networkManager.GenerateMAC(req.VmId)
 
@@ -148,6 +342,8 @@ func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VM
 			jailPaths,
 			m.cfg.Firecracker.JailUID,
 			m.cfg.Firecracker.JailGID,
+			m.cfg.Firecracker.CgroupParent,
+			limits,
 			m.log,
 		)
 		if err != nil {
@@ -156,22 +352,36 @@ func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VM
 		cleanups = append(cleanups, func() { process.Kill() })
 
 		vmStorage = &storage.VMStorage{
-			VMDir:      jailPaths.JailDir,
-			RootfsPath: jailPaths.RootfsPath,
-			KernelPath: jailPaths.KernelPath,
-			SocketPath: jailPaths.SocketPath,
-			LogPath:    jailPaths.LogPath,
+			VMDir:       jailPaths.JailDir,
+			RootfsPath:  jailPaths.RootfsPath,
+			KernelPath:  jailPaths.KernelPath,
+			SocketPath:  jailPaths.SocketPath,
+			LogPath:     jailPaths.LogPath,
+			MetricsPath: jailPaths.MetricsPath,
 		}
+
+		// The jailer places Firecracker in <cgroup_root>/<cgroup_parent>/<vm_id>
+		cgroupPath = cgroup.Path(m.cfg.Firecracker.CgroupRoot, m.cfg.Firecracker.CgroupParent, req.VmId)
 	} else {
 		m.log.WithField("vm_id", req.VmId).Warn("Running Firecracker without jailer (security risk)")
 
 		// Prepare storage (traditional mode without jailer)
 		var err error
-		vmStorage, err = m.storageManager.PrepareVMStorage(req.VmId, kernelPath, rootfsPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to prepare storage: %w", err)
+		if relaunch {
+			vmStorage, err = m.storageManager.ReuseVMStorage(req.VmId, kernelPath)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			vmStorage, err = m.storageManager.PrepareVMStorage(req.VmId, kernelPath, rootfsPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to prepare storage: %w", err)
+			}
+			cleanups = append(cleanups, func() { m.storageManager.CleanupVMStorage(req.VmId) })
+		}
+		if err := ReportProgress(ctx, pb.OperationStage_OPERATION_STAGE_STORAGE_PREPARED); err != nil {
+			return nil, err
 		}
-		cleanups = append(cleanups, func() { m.storageManager.CleanupVMStorage(req.VmId) })
 
 		// Create TAP device
 		tapDevice, err = m.networkManager.CreateTAPDevice(req.VmId)
@@ -179,9 +389,21 @@ func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VM
 			return nil, fmt.Errorf("failed to create TAP device: %w", err)
 		}
 		cleanups = append(cleanups, func() { m.networkManager.DeleteTAPDevice(tapDevice) })
+		if err := ReportProgress(ctx, pb.OperationStage_OPERATION_STAGE_NETWORK_READY); err != nil {
+			return nil, err
+		}
 
 		macAddr = m.networkManager.GenerateMAC(req.VmId)
 
+		// Create a cgroup to enforce resource limits without the jailer
+		if !limits.IsZero() {
+			cgroupPath, err = cgroup.Create(m.cfg.Firecracker.CgroupRoot, m.cfg.Firecracker.CgroupParent, req.VmId, limits)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create cgroup: %w", err)
+			}
+			cleanups = append(cleanups, func() { cgroup.Remove(cgroupPath) })
+		}
+
 		// Start Firecracker process directly
 		process, err = StartFirecrackerProcess(
 			ctx,
@@ -194,6 +416,19 @@ func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VM
 			return nil, fmt.Errorf("failed to start Firecracker process: %w", err)
 		}
 		cleanups = append(cleanups, func() { process.Kill() })
+
+		if cgroupPath != "" {
+			if err := cgroup.AddProcess(cgroupPath, process.PID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := ReportProgress(ctx, pb.OperationStage_OPERATION_STAGE_PROCESS_STARTED); err != nil {
+		return nil, err
+	}
+	if started != nil {
+		started()
 	}
 
 	// Configure Firecracker via API
@@ -241,41 +476,101 @@ func (m *Manager) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.VM
 		return nil, fmt.Errorf("failed to add network interface: %w", err)
 	}
 
+	// Configure the per-VM metrics FIFO before boot
+	var metrics *MetricsCollector
+	if m.cfg.Monitoring.Enabled {
+		metricsAPIPath := vmStorage.MetricsPath
+		uid, gid := -1, -1
+		if useJailer {
+			metricsAPIPath = jailedMetricsPath
+			uid, gid = m.cfg.Firecracker.JailUID, m.cfg.Firecracker.JailGID
+		}
+
+		metrics = NewMetricsCollector(req.VmId, vmStorage.MetricsPath, client, m.cfg.Monitoring.VMMetricsInterval, m.log)
+		if err := metrics.Open(uid, gid); err != nil {
+			return nil, err
+		}
+		cleanups = append(cleanups, metrics.Stop)
+
+		if err := client.SetMetrics(ctx, Metrics{MetricsPath: metricsAPIPath}); err != nil {
+			return nil, fmt.Errorf("failed to configure metrics: %w", err)
+		}
+	}
+
 	if err := client.StartInstance(ctx); err != nil {
 		return nil, fmt.Errorf("failed to start instance: %w", err)
 	}
+	// The guest is up, a cancellation arriving now no longer undoes it
+	_ = ReportProgress(ctx, pb.OperationStage_OPERATION_STAGE_BOOTED)
 
-	// Create VM info
-	vmInfo := &pb.VMInfo{
-		VmId:       req.VmId,
-		State:      pb.VMState_VM_STATE_RUNNING,
-		VcpuCount:  req.VcpuCount,
-		MemoryMb:   req.MemoryMb,
-		IpAddress:  req.IpAddress,
-		SocketPath: vmStorage.SocketPath,
-		CreatedAt:  time.Now().Unix(),
-		Metadata:   req.Metadata,
+	if metrics != nil {
+		metrics.Start()
 	}
 
-	m.vms[req.VmId] = &VM{
-		Info:       vmInfo,
+	// vCPU threads exist once the instance has started
+	if placement != nil {
+		if err := pinVCPUThreads(process.PID, placement.VCPUCPUs); err != nil {
+			m.log.WithError(err).WithField("vm_id", req.VmId).Warn("Failed to pin vCPU threads")
+		}
+	}
+
+	committed = true
+
+	return &VM{
 		Process:    process,
 		SocketPath: vmStorage.SocketPath,
 		TAPDevice:  tapDevice,
-		CreatedAt:  time.Now(),
+		StartedAt:  time.Now(),
+		Metrics:    metrics,
+		CgroupPath: cgroupPath,
+		Placement:  placement,
+	}, nil
+}
+
+// place assigns host CPUs and a NUMA node to a new VM. It returns nil when
+// placement is disabled or the request carries an explicit cpuset, whose
+// CPUs are reserved so exclusive VMs do not get them.
+func (m *Manager) place(req *pb.CreateVMRequest) (*Placement, error) {
+	requested := req.GetPlacement().GetExclusive() || req.GetPlacement() != nil && req.Placement.NumaNode != nil
+	if m.placer == nil {
+		if requested {
+			return nil, fmt.Errorf("CPU placement is disabled on this host")
+		}
+		return nil, nil
+	}
+	if cpuset := req.GetResourceLimits().GetCpusetCpus(); cpuset != "" {
+		if requested {
+			return nil, fmt.Errorf("placement cannot be combined with resource_limits.cpuset_cpus")
+		}
+		cpus, err := topology.ParseCPUList(cpuset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource_limits.cpuset_cpus: %w", err)
+		}
+		if err := m.placer.Reserve(req.VmId, cpus); err != nil {
+			return nil, fmt.Errorf("failed to place VM: %w", err)
+		}
+		return nil, nil
 	}
 
-	committed = true
+	var node *int
+	if req.GetPlacement() != nil && req.Placement.NumaNode != nil {
+		n := int(req.GetPlacement().GetNumaNode())
+		node = &n
+	}
+
+	placement, err := m.placer.Allocate(req.VmId, int(req.VcpuCount), req.GetPlacement().GetExclusive(), node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to place VM: %w", err)
+	}
 
 	m.log.WithFields(logrus.Fields{
-		"vm_id":      req.VmId,
-		"vcpus":      req.VcpuCount,
-		"memory":     req.MemoryMb,
-		"ip":         req.IpAddress,
-		"tap_device": tapDevice,
-	}).Info("VM created successfully")
+		"vm_id":     req.VmId,
+		"numa_node": placement.Node,
+		"cpus":      topology.FormatCPUList(placement.CPUs),
+		"exclusive": placement.Exclusive,
+	}).Info("VM placed")
 
-	return vmInfo, nil
+	return placement, nil
 }
 
 // extractGatewayIP extracts the IP address from a CIDR string (e.g. "172.16.0.1/24" -> "172.16.0.1").
@@ -288,92 +583,136 @@ func (m *Manager) extractGatewayIP(cidr string) string {
 	return ip.String()
 }
 
-// StartVM starts an existing VM (not applicable for Firecracker - VMs start on creation)
+// StartVM starts an existing VM. VMs boot on creation, so this succeeds
+// for running VMs only: a stopped Firecracker VM cannot be booted again and
+// has to be recreated.
 func (m *Manager) StartVM(ctx context.Context, vmID string) error {
 	m.mu.RLock()
 	vm, exists := m.vms[vmID]
+	var state pb.VMState
+	if exists {
+		state = vm.Info.State
+	}
 	m.mu.RUnlock()
 
 	if !exists {
 		return fmt.Errorf("VM %s not found", vmID)
 	}
-
-	// Check if already running
-	if vm.Process != nil && vm.Process.IsRunning() {
-		m.log.WithField("vm_id", vmID).Info("VM is already running")
-		return nil
+	if state != pb.VMState_VM_STATE_RUNNING {
+		return fmt.Errorf("%w: VM %s is %s and cannot be started, Firecracker VMs must be recreated",
+			ErrInvalidTransition, vmID, stateName(state))
 	}
 
-	// For Firecracker, starting a stopped VM requires recreating it
-	// This is a limitation of Firecracker architecture
-	return fmt.Errorf("VM %s cannot be restarted - Firecracker VMs must be recreated", vmID)
+	m.log.WithField("vm_id", vmID).Info("VM is already running")
+	return nil
 }
 
-// StopVM stops a running VM
+// StopVM stops a running VM. The VM is STOPPING until its process is gone,
+// and ends up in ERROR if the process outlives the stop.
 func (m *Manager) StopVM(ctx context.Context, vmID string, force bool) error {
-	m.mu.RLock()
-	vm, exists := m.vms[vmID]
-	m.mu.RUnlock()
+	vm, err := m.lockVM(vmID)
+	if err != nil {
+		return err
+	}
+	defer vm.opMu.Unlock()
 
-	if !exists {
-		return fmt.Errorf("VM %s not found", vmID)
+	reason := "Stop requested"
+	if force {
+		reason = "Forced stop requested"
+	}
+	m.mu.Lock()
+	event, err := m.transition(vm, pb.VMState_VM_STATE_STOPPING, reason)
+	if err != nil {
+		m.mu.Unlock()
+		return err
 	}
+	// The exit is expected and a pending restart is cancelled
+	vm.stopRequested = true
+	process := vm.Process
+	m.mu.Unlock()
+	m.emit(event)
 
 	m.log.WithFields(logrus.Fields{
 		"vm_id": vmID,
 		"force": force,
 	}).Info("Stopping VM")
 
-	if vm.Process != nil {
+	// A process that already exited, e.g. one waiting to be restarted, is
+	// not signalled again
+	if process != nil && !hasExited(process) {
 		if force {
-			if err := vm.Process.Kill(); err != nil {
+			if err := process.Kill(); err != nil {
 				m.log.WithError(err).Warn("Failed to kill VM process")
 			}
 		} else {
 			// Try graceful shutdown via Ctrl+Alt+Del
-			if err := vm.Process.Client.SendCtrlAltDel(ctx); err != nil {
+			if err := process.Client.SendCtrlAltDel(ctx); err != nil {
 				m.log.WithError(err).Warn("Failed to send Ctrl+Alt+Del, forcing kill")
-				vm.Process.Kill()
+				process.Kill()
 			} else {
 				// Wait a bit then stop
 				time.Sleep(2 * time.Second)
-				vm.Process.Stop()
+				process.Stop()
 			}
 		}
 	}
 
+	stopped := process == nil || waitExited(process, processExitTimeout)
+
 	m.mu.Lock()
-	vm.Info.State = pb.VMState_VM_STATE_STOPPED
+	if stopped {
+		event, _ = m.transition(vm, pb.VMState_VM_STATE_STOPPED, "Stopped by request")
+	} else {
+		err = fmt.Errorf("VM %s process is still running after the stop", vmID)
+		event, _ = m.transition(vm, pb.VMState_VM_STATE_ERROR, err.Error())
+	}
 	m.mu.Unlock()
+	m.emit(event)
 
-	return nil
+	return err
 }
 
-// DeleteVM deletes a VM and cleans up resources
+// DeleteVM deletes a VM and cleans up resources. The VM stays registered
+// as DELETING until its resources are released, so its ID cannot be reused
+// before.
 func (m *Manager) DeleteVM(ctx context.Context, vmID string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	vm, err := m.lockVM(vmID)
+	if err != nil {
+		return err
+	}
+	defer vm.opMu.Unlock()
 
-	vm, exists := m.vms[vmID]
-	if !exists {
-		return fmt.Errorf("VM %s not found", vmID)
+	m.mu.Lock()
+	event, err := m.transition(vm, pb.VMState_VM_STATE_DELETING, "Deletion requested")
+	if err != nil {
+		m.mu.Unlock()
+		return err
 	}
+	vm.stopRequested = true
+	m.mu.Unlock()
+	m.emit(event)
 
 	m.log.WithField("vm_id", vmID).Info("Deleting VM")
 
-	// Stop process if running
-	if vm.Process != nil {
-		if err := vm.Process.Kill(); err != nil {
-			m.log.WithError(err).Warn("Failed to kill VM process")
-		}
-	}
+	m.teardown(vmID, vm)
 
-	// Delete TAP device
-	if vm.TAPDevice != "" {
-		if err := m.networkManager.DeleteTAPDevice(vm.TAPDevice); err != nil {
-			m.log.WithError(err).Warn("Failed to delete TAP device")
-		}
-	}
+	m.mu.Lock()
+	delete(m.vms, vmID)
+	monitor.DeleteVMMetrics(vmID)
+	m.updateRunningGauge()
+	deleted := newEvent(vm.Info, pb.EventType_EVENT_TYPE_DELETED, "VM deleted")
+	m.mu.Unlock()
+	m.emit(deleted)
+
+	m.log.WithField("vm_id", vmID).Info("VM deleted successfully")
+
+	return nil
+}
+
+// teardown kills the Firecracker process of a VM and releases everything
+// launch set up for it. The caller must hold vm.opMu but not m.mu.
+func (m *Manager) teardown(vmID string, vm *VM) {
+	m.releaseProcess(vmID, vm)
 
 	// Cleanup jail directory if using jailer
 	if m.cfg.Firecracker.UseJailer != nil && *m.cfg.Firecracker.UseJailer {
@@ -386,35 +725,67 @@ func (m *Manager) DeleteVM(ctx context.Context, vmID string) error {
 	if err := m.storageManager.CleanupVMStorage(vmID); err != nil {
 		m.log.WithError(err).Warn("Failed to cleanup VM storage")
 	}
+}
 
-	// Remove from map
-	delete(m.vms, vmID)
+// releaseProcess releases what a VM's process holds: the process itself,
+// its metrics, cgroup, CPUs and TAP device. The VM's storage is kept so it
+// can be relaunched against it. The caller must hold vm.opMu but not m.mu.
+func (m *Manager) releaseProcess(vmID string, vm *VM) {
+	// Stop metrics collection
+	if vm.Metrics != nil {
+		vm.Metrics.Stop()
+	}
 
-	m.log.WithField("vm_id", vmID).Info("VM deleted successfully")
+	// Stop process if running
+	if vm.Process != nil {
+		if err := vm.Process.Kill(); err != nil {
+			m.log.WithError(err).Warn("Failed to kill VM process")
+		}
+	}
 
-	return nil
-}
+	// Remove the VM's cgroup once its process is gone
+	if vm.CgroupPath != "" {
+		if err := cgroup.Remove(vm.CgroupPath); err != nil {
+			m.log.WithError(err).Warn("Failed to remove VM cgroup")
+		}
+	}
 
-// resolveVMState returns the current state of a VM, checking process status.
-// This does not mutate the VM, making it safe to call under RLock.
-func (m *Manager) resolveVMState(vm *VM) pb.VMState {
-	if vm.Process != nil && !vm.Process.IsRunning() {
-		return pb.VMState_VM_STATE_STOPPED
+	// Return the VM's CPUs to the pool
+	if m.placer != nil {
+		m.placer.Release(vmID)
+	}
+
+	// Delete TAP device
+	if vm.TAPDevice != "" {
+		if err := m.networkManager.DeleteTAPDevice(vm.TAPDevice); err != nil {
+			m.log.WithError(err).Warn("Failed to delete TAP device")
+		}
 	}
-	return vm.Info.State
 }
 
-// copyVMInfo returns a copy of VMInfo with the current resolved state.
+// copyVMInfo returns a copy of VMInfo. The caller must hold m.mu.
 func (m *Manager) copyVMInfo(vm *VM) *pb.VMInfo {
+	var placement *pb.VMPlacement
+	if vm.Placement != nil {
+		placement = vm.Placement.ToProto()
+	}
+
 	return &pb.VMInfo{
-		VmId:       vm.Info.VmId,
-		State:      m.resolveVMState(vm),
-		VcpuCount:  vm.Info.VcpuCount,
-		MemoryMb:   vm.Info.MemoryMb,
-		IpAddress:  vm.Info.IpAddress,
-		SocketPath: vm.Info.SocketPath,
-		CreatedAt:  vm.Info.CreatedAt,
-		Metadata:   vm.Info.Metadata,
+		VmId:           vm.Info.VmId,
+		State:          vm.Info.State,
+		VcpuCount:      vm.Info.VcpuCount,
+		MemoryMb:       vm.Info.MemoryMb,
+		IpAddress:      vm.Info.IpAddress,
+		SocketPath:     vm.Info.SocketPath,
+		CreatedAt:      vm.Info.CreatedAt,
+		Metadata:       vm.Info.Metadata,
+		Placement:      placement,
+		LastExit:       vm.Info.LastExit,
+		RestartCount:   vm.Info.RestartCount,
+		RestartPolicy:  vm.Info.RestartPolicy,
+		StateReason:    vm.Info.StateReason,
+		StateChangedAt: vm.Info.StateChangedAt,
+		Transitions:    append([]*pb.VMStateTransition(nil), vm.Info.Transitions...),
 	}
 }
 
@@ -0,0 +1,89 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/storage"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_CreateVMConcurrently(t *testing.T) {
+	const creates = 4
+	tempDir := t.TempDir()
+	log := createTestLogger()
+	kernel := filepath.Join(tempDir, "vmlinux")
+	rootfs := filepath.Join(tempDir, "rootfs.ext4")
+	require.NoError(t, os.WriteFile(kernel, []byte("kernel"), 0644))
+	require.NoError(t, os.WriteFile(rootfs, []byte("rootfs"), 0644))
+
+	m := &Manager{
+		cfg:            &config.Config{},
+		log:            log,
+		storageManager: storage.NewManager(filepath.Join(tempDir, "vms"), false, log),
+		vms:            make(map[string]*VM),
+		stopCh:         make(chan struct{}),
+	}
+	defer m.Close()
+
+	// Each creation blocks once its storage is prepared, until it is
+	// cancelled. With a global lock held during provisioning only one
+	// would get there.
+	ctx, cancel := context.WithCancel(context.Background())
+	reached := make(chan string, creates)
+	var wg sync.WaitGroup
+	errs := make([]error, creates)
+	for n := 0; n < creates; n++ {
+		vmID := fmt.Sprintf("vm-%d", n)
+		progress := ContextWithProgress(ctx, func(stage pb.OperationStage) {
+			if stage == pb.OperationStage_OPERATION_STAGE_STORAGE_PREPARED {
+				reached <- vmID
+				<-ctx.Done()
+			}
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[n] = m.CreateVM(progress, &pb.CreateVMRequest{VmId: vmID, KernelPath: kernel, RootfsPath: rootfs})
+		}()
+	}
+
+	for n := 0; n < creates; n++ {
+		select {
+		case <-reached:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d creations provision in parallel", n, creates)
+		}
+	}
+
+	// Reads and other operations are not held up by the creations
+	vms := m.ListVMs()
+	require.Len(t, vms, creates)
+	for _, vm := range vms {
+		assert.Equal(t, pb.VMState_VM_STATE_CREATING, vm.State)
+	}
+	_, err := m.CreateVM(context.Background(), &pb.CreateVMRequest{VmId: "vm-0"})
+	assert.ErrorContains(t, err, "already exists")
+	assert.ErrorContains(t, m.DeleteVM(context.Background(), "vm-missing"), "not found")
+
+	// A deletion waits for the creation of its VM
+	deleted := make(chan error, 1)
+	go func() { deleted <- m.DeleteVM(context.Background(), "vm-0") }()
+
+	cancel()
+	wg.Wait()
+	for _, err := range errs {
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	assert.ErrorContains(t, <-deleted, "not found", "the failed creation released the ID")
+	assert.Empty(t, m.ListVMs())
+	assert.NoDirExists(t, filepath.Join(tempDir, "vms", "vm-0"))
+}
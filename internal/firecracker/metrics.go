@@ -0,0 +1,289 @@
+package firecracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spluca/firecracker-agent/internal/monitor"
+)
+
+// jailedMetricsPath is the metrics FIFO path as seen from inside the jail
+const jailedMetricsPath = "/metrics.fifo"
+
+// VcpuMetrics holds vCPU exit counters
+type VcpuMetrics struct {
+	ExitIoIn      uint64 `json:"exit_io_in"`
+	ExitIoOut     uint64 `json:"exit_io_out"`
+	ExitMmioRead  uint64 `json:"exit_mmio_read"`
+	ExitMmioWrite uint64 `json:"exit_mmio_write"`
+	Failures      uint64 `json:"failures"`
+}
+
+// BlockDeviceMetrics holds counters for a single block device
+type BlockDeviceMetrics struct {
+	ReadBytes                  uint64 `json:"read_bytes"`
+	WriteBytes                 uint64 `json:"write_bytes"`
+	ReadCount                  uint64 `json:"read_count"`
+	WriteCount                 uint64 `json:"write_count"`
+	RateLimiterThrottledEvents uint64 `json:"rate_limiter_throttled_events"`
+}
+
+// NetDeviceMetrics holds counters for a single network interface
+type NetDeviceMetrics struct {
+	RxBytesCount           uint64 `json:"rx_bytes_count"`
+	TxBytesCount           uint64 `json:"tx_bytes_count"`
+	RxPacketsCount         uint64 `json:"rx_packets_count"`
+	TxPacketsCount         uint64 `json:"tx_packets_count"`
+	RxRateLimiterThrottled uint64 `json:"rx_rate_limiter_throttled"`
+	TxRateLimiterThrottled uint64 `json:"tx_rate_limiter_throttled"`
+}
+
+// MMDSMetrics holds MMDS request counters
+type MMDSMetrics struct {
+	RxAccepted    uint64 `json:"rx_accepted"`
+	RxAcceptedErr uint64 `json:"rx_accepted_err"`
+}
+
+// SignalMetrics holds counters of signals received by Firecracker
+type SignalMetrics struct {
+	Sigbus  uint64 `json:"sigbus"`
+	Sigsegv uint64 `json:"sigsegv"`
+	Sigxfsz uint64 `json:"sigxfsz"`
+	Sigxcpu uint64 `json:"sigxcpu"`
+	Sigpipe uint64 `json:"sigpipe"`
+	Sighup  uint64 `json:"sighup"`
+	Sigill  uint64 `json:"sigill"`
+}
+
+// FirecrackerMetrics is a single metrics sample written by Firecracker.
+// Only the subset of fields exported to Prometheus is decoded.
+type FirecrackerMetrics struct {
+	UTCTimestampMs int64
+	Vcpu           VcpuMetrics
+	MMDS           MMDSMetrics
+	Signals        SignalMetrics
+	Block          map[string]BlockDeviceMetrics // keyed by drive ID
+	Net            map[string]NetDeviceMetrics   // keyed by interface ID
+}
+
+// ParseMetrics decodes a Firecracker metrics JSON object. Per-device sections
+// ("block_<drive_id>", "net_<iface_id>") are collected by device ID; the
+// aggregate "block" and "net" sections are ignored to avoid double counting.
+func ParseMetrics(data []byte) (*FirecrackerMetrics, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	m := &FirecrackerMetrics{
+		Block: make(map[string]BlockDeviceMetrics),
+		Net:   make(map[string]NetDeviceMetrics),
+	}
+
+	for key, value := range raw {
+		var err error
+		switch {
+		case key == "utc_timestamp_ms":
+			err = json.Unmarshal(value, &m.UTCTimestampMs)
+		case key == "vcpu":
+			err = json.Unmarshal(value, &m.Vcpu)
+		case key == "mmds":
+			err = json.Unmarshal(value, &m.MMDS)
+		case key == "signals":
+			err = json.Unmarshal(value, &m.Signals)
+		case strings.HasPrefix(key, "block_"):
+			var dev BlockDeviceMetrics
+			err = json.Unmarshal(value, &dev)
+			m.Block[strings.TrimPrefix(key, "block_")] = dev
+		case strings.HasPrefix(key, "net_"):
+			var dev NetDeviceMetrics
+			err = json.Unmarshal(value, &dev)
+			m.Net[strings.TrimPrefix(key, "net_")] = dev
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse metrics section %q: %w", key, err)
+		}
+	}
+
+	return m, nil
+}
+
+// MetricsCollector reads metrics samples from a VM's metrics FIFO and
+// exports them as labelled Prometheus series.
+type MetricsCollector struct {
+	vmID     string
+	fifoPath string
+	interval time.Duration
+	client   *Client
+	fifo     *os.File
+	log      *logrus.Logger
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewMetricsCollector creates a collector for the FIFO at fifoPath (host path).
+// If interval is positive, the collector periodically asks Firecracker to
+// flush its metrics through client.
+func NewMetricsCollector(vmID, fifoPath string, client *Client, interval time.Duration, log *logrus.Logger) *MetricsCollector {
+	return &MetricsCollector{
+		vmID:     vmID,
+		fifoPath: fifoPath,
+		interval: interval,
+		client:   client,
+		log:      log,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Open creates the FIFO and opens its read end. It must be called before
+// configuring Firecracker, which opens the FIFO non-blocking and fails if
+// there is no reader. uid and gid own the FIFO so a jailed process can write
+// to it; pass -1 to leave ownership unchanged.
+func (c *MetricsCollector) Open(uid, gid int) error {
+	os.Remove(c.fifoPath)
+
+	if err := syscall.Mkfifo(c.fifoPath, 0600); err != nil {
+		return fmt.Errorf("failed to create metrics FIFO: %w", err)
+	}
+	if err := os.Chown(c.fifoPath, uid, gid); err != nil {
+		c.log.WithError(err).Warn("Failed to chown metrics FIFO")
+	}
+
+	// O_RDWR keeps the open from blocking until a writer appears and avoids
+	// EOF between Firecracker flushes.
+	fifo, err := os.OpenFile(c.fifoPath, os.O_RDWR, 0)
+	if err != nil {
+		os.Remove(c.fifoPath)
+		return fmt.Errorf("failed to open metrics FIFO: %w", err)
+	}
+	c.fifo = fifo
+
+	return nil
+}
+
+// Start launches the reader and flush goroutines
+func (c *MetricsCollector) Start() {
+	c.wg.Add(1)
+	go c.readLoop()
+
+	if c.interval > 0 && c.client != nil {
+		c.wg.Add(1)
+		go c.flushLoop()
+	}
+}
+
+// Stop stops the collector and closes and removes the FIFO
+func (c *MetricsCollector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		if c.fifo != nil {
+			c.fifo.Close()
+		}
+		c.wg.Wait()
+		os.Remove(c.fifoPath)
+	})
+}
+
+// readLoop decodes JSON samples from the FIFO until it is closed
+func (c *MetricsCollector) readLoop() {
+	defer c.wg.Done()
+
+	decoder := json.NewDecoder(c.fifo)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			select {
+			case <-c.stopCh:
+				return
+			default:
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) {
+				return
+			}
+			// A malformed sample leaves the decoder in an unusable state
+			c.log.WithError(err).WithField("vm_id", c.vmID).Warn("Failed to decode metrics sample, stopping collector")
+			return
+		}
+
+		metrics, err := ParseMetrics(raw)
+		if err != nil {
+			c.log.WithError(err).WithField("vm_id", c.vmID).Warn("Failed to parse metrics sample")
+			continue
+		}
+		c.record(metrics)
+	}
+}
+
+// flushLoop periodically triggers a FlushMetrics action
+func (c *MetricsCollector) flushLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := c.client.FlushMetrics(ctx); err != nil {
+				c.log.WithError(err).WithField("vm_id", c.vmID).Debug("Failed to flush metrics")
+			}
+			cancel()
+		}
+	}
+}
+
+// record exports a metrics sample to Prometheus
+func (c *MetricsCollector) record(m *FirecrackerMetrics) {
+	id := c.vmID
+
+	monitor.VMVcpuExitsTotal.WithLabelValues(id, "io_in").Add(float64(m.Vcpu.ExitIoIn))
+	monitor.VMVcpuExitsTotal.WithLabelValues(id, "io_out").Add(float64(m.Vcpu.ExitIoOut))
+	monitor.VMVcpuExitsTotal.WithLabelValues(id, "mmio_read").Add(float64(m.Vcpu.ExitMmioRead))
+	monitor.VMVcpuExitsTotal.WithLabelValues(id, "mmio_write").Add(float64(m.Vcpu.ExitMmioWrite))
+	monitor.VMVcpuExitsTotal.WithLabelValues(id, "failures").Add(float64(m.Vcpu.Failures))
+
+	for drive, b := range m.Block {
+		monitor.VMBlockBytesTotal.WithLabelValues(id, drive, "read").Add(float64(b.ReadBytes))
+		monitor.VMBlockBytesTotal.WithLabelValues(id, drive, "write").Add(float64(b.WriteBytes))
+		monitor.VMBlockOpsTotal.WithLabelValues(id, drive, "read").Add(float64(b.ReadCount))
+		monitor.VMBlockOpsTotal.WithLabelValues(id, drive, "write").Add(float64(b.WriteCount))
+		monitor.VMRateLimiterThrottledTotal.WithLabelValues(id, "block_"+drive, "io").Add(float64(b.RateLimiterThrottledEvents))
+	}
+
+	for iface, n := range m.Net {
+		monitor.VMNetBytesTotal.WithLabelValues(id, iface, "rx").Add(float64(n.RxBytesCount))
+		monitor.VMNetBytesTotal.WithLabelValues(id, iface, "tx").Add(float64(n.TxBytesCount))
+		monitor.VMNetPacketsTotal.WithLabelValues(id, iface, "rx").Add(float64(n.RxPacketsCount))
+		monitor.VMNetPacketsTotal.WithLabelValues(id, iface, "tx").Add(float64(n.TxPacketsCount))
+		monitor.VMRateLimiterThrottledTotal.WithLabelValues(id, "net_"+iface, "rx").Add(float64(n.RxRateLimiterThrottled))
+		monitor.VMRateLimiterThrottledTotal.WithLabelValues(id, "net_"+iface, "tx").Add(float64(n.TxRateLimiterThrottled))
+	}
+
+	monitor.VMMMDSRequestsTotal.WithLabelValues(id, "accepted").Add(float64(m.MMDS.RxAccepted))
+	monitor.VMMMDSRequestsTotal.WithLabelValues(id, "error").Add(float64(m.MMDS.RxAcceptedErr))
+
+	monitor.VMSignalsTotal.WithLabelValues(id, "sigbus").Add(float64(m.Signals.Sigbus))
+	monitor.VMSignalsTotal.WithLabelValues(id, "sigsegv").Add(float64(m.Signals.Sigsegv))
+	monitor.VMSignalsTotal.WithLabelValues(id, "sigxfsz").Add(float64(m.Signals.Sigxfsz))
+	monitor.VMSignalsTotal.WithLabelValues(id, "sigxcpu").Add(float64(m.Signals.Sigxcpu))
+	monitor.VMSignalsTotal.WithLabelValues(id, "sigpipe").Add(float64(m.Signals.Sigpipe))
+	monitor.VMSignalsTotal.WithLabelValues(id, "sighup").Add(float64(m.Signals.Sighup))
+	monitor.VMSignalsTotal.WithLabelValues(id, "sigill").Add(float64(m.Signals.Sigill))
+
+	if m.UTCTimestampMs > 0 {
+		monitor.VMMetricsLastFlush.WithLabelValues(id).Set(float64(m.UTCTimestampMs) / 1000)
+	}
+}
@@ -0,0 +1,96 @@
+package firecracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spluca/firecracker-agent/internal/monitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleMetrics = `{
+  "utc_timestamp_ms": 1700000000000,
+  "vcpu": {"exit_io_in": 3, "exit_io_out": 4, "exit_mmio_read": 5, "exit_mmio_write": 6, "failures": 0},
+  "block": {"read_bytes": 9999},
+  "block_rootfs": {"read_bytes": 4096, "write_bytes": 1024, "read_count": 2, "write_count": 1, "rate_limiter_throttled_events": 7},
+  "net": {"rx_bytes_count": 9999},
+  "net_eth0": {"rx_bytes_count": 100, "tx_bytes_count": 200, "rx_packets_count": 1, "tx_packets_count": 2, "rx_rate_limiter_throttled": 3, "tx_rate_limiter_throttled": 4},
+  "mmds": {"rx_accepted": 8, "rx_accepted_err": 1},
+  "signals": {"sigbus": 0, "sigsegv": 1},
+  "api_server": {"process_startup_time_us": 10}
+}`
+
+func TestParseMetrics(t *testing.T) {
+	t.Run("parses per-device sections", func(t *testing.T) {
+		m, err := ParseMetrics([]byte(sampleMetrics))
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(1700000000000), m.UTCTimestampMs)
+		assert.Equal(t, uint64(3), m.Vcpu.ExitIoIn)
+		assert.Equal(t, uint64(6), m.Vcpu.ExitMmioWrite)
+
+		require.Contains(t, m.Block, "rootfs")
+		assert.Len(t, m.Block, 1, "aggregate block section should be ignored")
+		assert.Equal(t, uint64(4096), m.Block["rootfs"].ReadBytes)
+		assert.Equal(t, uint64(7), m.Block["rootfs"].RateLimiterThrottledEvents)
+
+		require.Contains(t, m.Net, "eth0")
+		assert.Len(t, m.Net, 1, "aggregate net section should be ignored")
+		assert.Equal(t, uint64(200), m.Net["eth0"].TxBytesCount)
+
+		assert.Equal(t, uint64(8), m.MMDS.RxAccepted)
+		assert.Equal(t, uint64(1), m.Signals.Sigsegv)
+	})
+
+	t.Run("rejects invalid JSON", func(t *testing.T) {
+		_, err := ParseMetrics([]byte("not json"))
+		require.Error(t, err)
+	})
+
+	t.Run("rejects malformed section", func(t *testing.T) {
+		_, err := ParseMetrics([]byte(`{"vcpu": "oops"}`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "vcpu")
+	})
+}
+
+func TestMetricsCollector_ReadsFIFO(t *testing.T) {
+	fifoPath := filepath.Join(t.TempDir(), "metrics.fifo")
+	vmID := "metrics-test-vm"
+
+	collector := NewMetricsCollector(vmID, fifoPath, nil, 0, createTestLogger())
+	require.NoError(t, collector.Open(-1, -1))
+	collector.Start()
+
+	// Write two samples the way Firecracker does (one JSON object per flush)
+	writer, err := os.OpenFile(fifoPath, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = writer.WriteString(sampleMetrics + "\n" + sampleMetrics + "\n")
+	require.NoError(t, err)
+	writer.Close()
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(monitor.VMBlockBytesTotal.WithLabelValues(vmID, "rootfs", "read")) == 8192
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, float64(6), testutil.ToFloat64(monitor.VMVcpuExitsTotal.WithLabelValues(vmID, "io_in")))
+	assert.Equal(t, float64(400), testutil.ToFloat64(monitor.VMNetBytesTotal.WithLabelValues(vmID, "eth0", "tx")))
+	assert.Equal(t, float64(16), testutil.ToFloat64(monitor.VMMMDSRequestsTotal.WithLabelValues(vmID, "accepted")))
+	assert.Equal(t, float64(1700000000), testutil.ToFloat64(monitor.VMMetricsLastFlush.WithLabelValues(vmID)))
+
+	collector.Stop()
+
+	// The FIFO is gone after Stop
+	_, err = os.Stat(fifoPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// Stop is idempotent
+	collector.Stop()
+
+	monitor.DeleteVMMetrics(vmID)
+	assert.Equal(t, 0, testutil.CollectAndCount(monitor.VMBlockBytesTotal))
+}
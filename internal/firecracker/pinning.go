@@ -0,0 +1,71 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// vcpuThreadPrefix is the name Firecracker gives its vCPU threads ("fc_vcpu 0")
+const vcpuThreadPrefix = "fc_vcpu "
+
+// findVCPUThreads maps vCPU index to thread ID for a Firecracker process by
+// reading thread names under <procRoot>/<pid>/task
+func findVCPUThreads(procRoot string, pid int) (map[int]int, error) {
+	taskDir := filepath.Join(procRoot, strconv.Itoa(pid), "task")
+	entries, err := os.ReadDir(taskDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list threads of process %d: %w", pid, err)
+	}
+
+	threads := make(map[int]int)
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		comm, err := os.ReadFile(filepath.Join(taskDir, entry.Name(), "comm"))
+		if err != nil {
+			continue // thread exited
+		}
+
+		name := strings.TrimSpace(string(comm))
+		if !strings.HasPrefix(name, vcpuThreadPrefix) {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(name, vcpuThreadPrefix))
+		if err != nil {
+			continue
+		}
+		threads[index] = tid
+	}
+
+	return threads, nil
+}
+
+// pinVCPUThreads sets the CPU affinity of each vCPU thread of a running
+// Firecracker process to the host CPU assigned to that vCPU
+func pinVCPUThreads(pid int, vcpuCPUs []int) error {
+	threads, err := findVCPUThreads("/proc", pid)
+	if err != nil {
+		return err
+	}
+	if len(threads) != len(vcpuCPUs) {
+		return fmt.Errorf("found %d vCPU threads, expected %d", len(threads), len(vcpuCPUs))
+	}
+
+	for index, cpu := range vcpuCPUs {
+		var set unix.CPUSet
+		set.Set(cpu)
+		if err := unix.SchedSetaffinity(threads[index], &set); err != nil {
+			return fmt.Errorf("failed to pin vCPU %d to CPU %d: %w", index, cpu, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package firecracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindVCPUThreads(t *testing.T) {
+	procRoot := t.TempDir()
+	threads := map[string]string{
+		"100": "firecracker",
+		"101": "fc_api",
+		"102": "fc_vcpu 0",
+		"103": "fc_vcpu 1",
+	}
+	for tid, comm := range threads {
+		dir := filepath.Join(procRoot, "100", "task", tid)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0644))
+	}
+
+	found, err := findVCPUThreads(procRoot, 100)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]int{0: 102, 1: 103}, found)
+
+	_, err = findVCPUThreads(procRoot, 999)
+	require.Error(t, err)
+}
+
+func TestPinVCPUThreads_NoVCPUThreads(t *testing.T) {
+	// The test process has no Firecracker vCPU threads
+	err := pinVCPUThreads(os.Getpid(), []int{0})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "found 0 vCPU threads")
+}
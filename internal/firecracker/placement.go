@@ -0,0 +1,240 @@
+package firecracker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/topology"
+)
+
+// Placement is the host CPU and NUMA node assignment of a VM
+type Placement struct {
+	Node      int
+	CPUs      []int // cpuset of the VM
+	VCPUCPUs  []int // host CPU pinned for each vCPU, by vCPU index
+	Exclusive bool
+}
+
+// ToProto converts the placement to its API representation
+func (p *Placement) ToProto() *pb.VMPlacement {
+	vcpuCPUs := make([]int32, len(p.VCPUCPUs))
+	for i, cpu := range p.VCPUCPUs {
+		vcpuCPUs[i] = int32(cpu)
+	}
+	return &pb.VMPlacement{
+		CpusetCpus: topology.FormatCPUList(p.CPUs),
+		NumaNode:   int32(p.Node),
+		Exclusive:  p.Exclusive,
+		VcpuCpus:   vcpuCPUs,
+	}
+}
+
+// Placer assigns host CPUs and NUMA nodes to VMs. All vCPUs of a VM are
+// kept on one node. Exclusive VMs get CPUs no other VM uses; shared VMs
+// are spread over the least loaded CPUs not held exclusively. VMs with an
+// explicit cpuset count as shared VMs on each of its CPUs.
+type Placer struct {
+	topo      *topology.Topology
+	reserved  map[int]bool
+	exclusive map[int]string // CPU -> VM holding it exclusively
+	load      map[int]int    // CPU -> vCPUs of shared VMs pinned to it
+	vms       map[string]*Placement
+	explicit  map[string][]int // VM -> explicit cpuset
+	mu        sync.Mutex
+}
+
+// NewPlacer creates a placer for the topology. Reserved CPUs are never
+// assigned to VMs.
+func NewPlacer(topo *topology.Topology, reserved []int) *Placer {
+	p := &Placer{
+		topo:      topo,
+		reserved:  make(map[int]bool),
+		exclusive: make(map[int]string),
+		load:      make(map[int]int),
+		vms:       make(map[string]*Placement),
+		explicit:  make(map[string][]int),
+	}
+	for _, cpu := range reserved {
+		p.reserved[cpu] = true
+	}
+	return p
+}
+
+// Allocate places a VM with the given number of vCPUs. If node is non-nil
+// the VM is placed on that NUMA node only.
+func (p *Placer) Allocate(vmID string, vcpus int, exclusive bool, node *int) (*Placement, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.placed(vmID) {
+		return nil, fmt.Errorf("VM %s is already placed", vmID)
+	}
+	if vcpus < 1 {
+		return nil, fmt.Errorf("invalid vCPU count %d", vcpus)
+	}
+
+	candidates := p.topo.Nodes
+	if node != nil {
+		n, ok := p.topo.Node(*node)
+		if !ok {
+			return nil, fmt.Errorf("NUMA node %d does not exist or has no CPUs", *node)
+		}
+		candidates = []topology.Node{n}
+	}
+
+	var best *Placement
+	bestFree := -1
+	bestLoad := 0
+	for _, n := range candidates {
+		available := p.availableCPUs(n, exclusive)
+		if len(available) == 0 || (exclusive && len(available) < vcpus) {
+			continue
+		}
+
+		load := 0
+		for _, cpu := range available {
+			load += p.load[cpu]
+		}
+
+		// Prefer the node with the most free CPUs, then the least loaded
+		if len(available) > bestFree || (len(available) == bestFree && load < bestLoad) {
+			best = p.pick(n.ID, available, vcpus, exclusive)
+			bestFree = len(available)
+			bestLoad = load
+		}
+	}
+
+	if best == nil {
+		if exclusive {
+			return nil, fmt.Errorf("not enough free CPUs for %d exclusive vCPUs", vcpus)
+		}
+		return nil, fmt.Errorf("no CPUs available for VM placement")
+	}
+
+	if exclusive {
+		for _, cpu := range best.CPUs {
+			p.exclusive[cpu] = vmID
+		}
+	} else {
+		for _, cpu := range best.VCPUCPUs {
+			p.load[cpu]++
+		}
+	}
+	p.vms[vmID] = best
+
+	return best, nil
+}
+
+// Reserve records the explicit cpuset of a VM placed by its caller, so
+// exclusive VMs are not given its CPUs. It fails if a CPU is held by an
+// exclusive VM.
+func (p *Placer) Reserve(vmID string, cpus []int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.placed(vmID) {
+		return fmt.Errorf("VM %s is already placed", vmID)
+	}
+	for _, cpu := range cpus {
+		if owner, taken := p.exclusive[cpu]; taken {
+			return fmt.Errorf("CPU %d is dedicated to VM %s", cpu, owner)
+		}
+	}
+
+	for _, cpu := range cpus {
+		p.load[cpu]++
+	}
+	p.explicit[vmID] = cpus
+	return nil
+}
+
+// Release frees the CPUs assigned to a VM
+func (p *Placer) Release(vmID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cpus, exists := p.explicit[vmID]; exists {
+		p.unload(cpus)
+		delete(p.explicit, vmID)
+		return
+	}
+
+	placement, exists := p.vms[vmID]
+	if !exists {
+		return
+	}
+
+	if placement.Exclusive {
+		for _, cpu := range placement.CPUs {
+			delete(p.exclusive, cpu)
+		}
+	} else {
+		p.unload(placement.VCPUCPUs)
+	}
+	delete(p.vms, vmID)
+}
+
+// placed reports whether a VM holds CPUs. The caller must hold p.mu.
+func (p *Placer) placed(vmID string) bool {
+	_, placed := p.vms[vmID]
+	_, reserved := p.explicit[vmID]
+	return placed || reserved
+}
+
+// unload removes one vCPU of load from each CPU. The caller must hold p.mu.
+func (p *Placer) unload(cpus []int) {
+	for _, cpu := range cpus {
+		if p.load[cpu]--; p.load[cpu] <= 0 {
+			delete(p.load, cpu)
+		}
+	}
+}
+
+// availableCPUs returns the node's CPUs that can be assigned, ordered by
+// ascending load. Exclusive placements also skip CPUs used by shared VMs.
+func (p *Placer) availableCPUs(n topology.Node, exclusive bool) []int {
+	var cpus []int
+	for _, cpu := range n.CPUs {
+		if p.reserved[cpu] {
+			continue
+		}
+		if _, taken := p.exclusive[cpu]; taken {
+			continue
+		}
+		if exclusive && p.load[cpu] > 0 {
+			continue
+		}
+		cpus = append(cpus, cpu)
+	}
+
+	sort.SliceStable(cpus, func(i, j int) bool { return p.load[cpus[i]] < p.load[cpus[j]] })
+	return cpus
+}
+
+// pick assigns one CPU per vCPU from the available CPUs. Shared VMs with
+// more vCPUs than available CPUs wrap around.
+func (p *Placer) pick(node int, available []int, vcpus int, exclusive bool) *Placement {
+	vcpuCPUs := make([]int, vcpus)
+	for i := range vcpuCPUs {
+		vcpuCPUs[i] = available[i%len(available)]
+	}
+
+	seen := make(map[int]bool)
+	var cpus []int
+	for _, cpu := range vcpuCPUs {
+		if !seen[cpu] {
+			seen[cpu] = true
+			cpus = append(cpus, cpu)
+		}
+	}
+	sort.Ints(cpus)
+
+	return &Placement{
+		Node:      node,
+		CPUs:      cpus,
+		VCPUCPUs:  vcpuCPUs,
+		Exclusive: exclusive,
+	}
+}
@@ -0,0 +1,132 @@
+package firecracker
+
+import (
+	"testing"
+
+	"github.com/spluca/firecracker-agent/internal/topology"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestTopology() *topology.Topology {
+	return &topology.Topology{Nodes: []topology.Node{
+		{ID: 0, CPUs: []int{0, 1, 2, 3}},
+		{ID: 1, CPUs: []int{4, 5, 6, 7}},
+	}}
+}
+
+func TestPlacer_Exclusive(t *testing.T) {
+	placer := NewPlacer(createTestTopology(), []int{0})
+
+	// Node 1 has more free CPUs because CPU 0 is reserved
+	first, err := placer.Allocate("vm-1", 2, true, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, first.Node)
+	assert.Equal(t, []int{4, 5}, first.CPUs)
+	assert.Equal(t, []int{4, 5}, first.VCPUCPUs)
+
+	second, err := placer.Allocate("vm-2", 3, true, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, second.Node)
+	assert.Equal(t, []int{1, 2, 3}, second.CPUs)
+
+	// No node has 3 free CPUs left
+	_, err = placer.Allocate("vm-3", 3, true, nil)
+	require.Error(t, err)
+
+	// Released CPUs can be reused
+	placer.Release("vm-2")
+	third, err := placer.Allocate("vm-3", 3, true, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, third.CPUs)
+}
+
+func TestPlacer_Shared(t *testing.T) {
+	placer := NewPlacer(createTestTopology(), nil)
+
+	exclusive, err := placer.Allocate("vm-excl", 3, true, intPtr(0))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, exclusive.CPUs)
+
+	// Shared VMs never land on exclusive CPUs and wrap around when oversubscribed
+	shared, err := placer.Allocate("vm-shared", 3, false, intPtr(0))
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, shared.CPUs)
+	assert.Equal(t, []int{3, 3, 3}, shared.VCPUCPUs)
+
+	// Exclusive VMs avoid CPUs used by shared VMs
+	_, err = placer.Allocate("vm-excl-2", 1, true, intPtr(0))
+	require.Error(t, err)
+
+	// Shared VMs spread over the least loaded CPUs
+	a, err := placer.Allocate("vm-a", 2, false, intPtr(1))
+	require.NoError(t, err)
+	b, err := placer.Allocate("vm-b", 2, false, intPtr(1))
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, a.VCPUCPUs)
+	assert.Equal(t, []int{6, 7}, b.VCPUCPUs)
+
+	placer.Release("vm-shared")
+	excl, err := placer.Allocate("vm-excl-2", 1, true, intPtr(0))
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, excl.CPUs)
+}
+
+func TestPlacer_Reserve(t *testing.T) {
+	placer := NewPlacer(createTestTopology(), nil)
+
+	// Exclusive VMs are not placed on CPUs of an explicit cpuset
+	require.NoError(t, placer.Reserve("vm-pinned", []int{0, 1}))
+	excl, err := placer.Allocate("vm-excl", 2, true, intPtr(0))
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, excl.CPUs)
+
+	_, err = placer.Allocate("vm-excl-2", 1, true, intPtr(0))
+	require.Error(t, err)
+
+	// Explicit cpusets may not include dedicated CPUs
+	err = placer.Reserve("vm-pinned-2", []int{3, 4})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dedicated to VM vm-excl")
+
+	err = placer.Reserve("vm-pinned", []int{4})
+	require.Error(t, err)
+
+	placer.Release("vm-pinned")
+	excl, err = placer.Allocate("vm-excl-2", 2, true, intPtr(0))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, excl.CPUs)
+}
+
+func TestPlacer_Errors(t *testing.T) {
+	placer := NewPlacer(createTestTopology(), nil)
+
+	_, err := placer.Allocate("vm-1", 1, false, intPtr(5))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "NUMA node 5")
+
+	_, err = placer.Allocate("vm-1", 0, false, nil)
+	require.Error(t, err)
+
+	_, err = placer.Allocate("vm-1", 1, false, nil)
+	require.NoError(t, err)
+	_, err = placer.Allocate("vm-1", 1, false, nil)
+	require.Error(t, err)
+
+	// Releasing an unknown VM is a no-op
+	placer.Release("unknown")
+}
+
+func TestPlacement_ToProto(t *testing.T) {
+	p := &Placement{Node: 1, CPUs: []int{4, 5, 6}, VCPUCPUs: []int{4, 5, 6}, Exclusive: true}
+
+	pbPlacement := p.ToProto()
+	assert.Equal(t, "4-6", pbPlacement.CpusetCpus)
+	assert.Equal(t, int32(1), pbPlacement.NumaNode)
+	assert.True(t, pbPlacement.Exclusive)
+	assert.Equal(t, []int32{4, 5, 6}, pbPlacement.VcpuCpus)
+}
+
+func intPtr(v int) *int {
+	return &v
+}
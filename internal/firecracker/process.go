@@ -3,14 +3,20 @@ package firecracker
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
+// logTailBytes bounds how much of the Firecracker log is kept with an exit
+const logTailBytes = 4096
+
 // ProcessMode indicates how the Firecracker process is being run
 type ProcessMode int
 
@@ -29,6 +35,30 @@ type VMProcess struct {
 	log        *logrus.Logger
 	Mode       ProcessMode // NEW: Track if running with jailer
 	JailPath   string      // NEW: Path to jail directory (if using jailer)
+	LogPath    string      // Host path of the Firecracker log
+
+	done chan struct{} // closed once the process has been reaped
+	exit *ProcessExit  // set before done is closed
+}
+
+// ProcessExit describes how a Firecracker process ended
+type ProcessExit struct {
+	Code   int    // exit status, -1 when killed by a signal
+	Signal string // terminating signal such as "SIGKILL", empty on a normal exit
+	Time   time.Time
+}
+
+// String describes the exit for logs and events
+func (e *ProcessExit) String() string {
+	if e.Signal != "" {
+		return "killed by " + e.Signal
+	}
+	return fmt.Sprintf("exited with code %d", e.Code)
+}
+
+// Failed reports whether the process exited with an error or was killed
+func (e *ProcessExit) Failed() bool {
+	return e.Code != 0 || e.Signal != ""
 }
 
 // StartFirecrackerProcess starts a new Firecracker process
@@ -75,17 +105,20 @@ func StartFirecrackerProcess(ctx context.Context, binaryPath, socketPath, logPat
 		"socket": socketPath,
 	}).Info("Firecracker process started")
 
+	process := &VMProcess{
+		PID:        cmd.Process.Pid,
+		Cmd:        cmd,
+		SocketPath: socketPath,
+		LogFile:    logFile,
+		LogPath:    logPath,
+		log:        log,
+		done:       make(chan struct{}),
+	}
+
 	// Start background goroutine to wait for process exit
 	// This ensures the process is reaped even if it exits unexpectedly
 	// (crash, panic, or normal termination)
-	go func() {
-		err := cmd.Wait()
-		if err != nil {
-			log.WithError(err).WithField("pid", cmd.Process.Pid).Warn("Firecracker process exited with error")
-		} else {
-			log.WithField("pid", cmd.Process.Pid).Info("Firecracker process exited cleanly")
-		}
-	}()
+	go process.wait()
 
 	// Wait for socket to be ready
 	if err := waitForSocket(socketPath, 5*time.Second); err != nil {
@@ -96,16 +129,103 @@ func StartFirecrackerProcess(ctx context.Context, binaryPath, socketPath, logPat
 	}
 
 	// Create API client
-	client := NewClient(socketPath)
+	process.Client = NewClient(socketPath)
 
-	return &VMProcess{
-		PID:        cmd.Process.Pid,
-		Cmd:        cmd,
-		SocketPath: socketPath,
-		LogFile:    logFile,
-		Client:     client,
-		log:        log,
-	}, nil
+	return process, nil
+}
+
+// wait reaps the process and records how it exited
+func (p *VMProcess) wait() {
+	err := p.Cmd.Wait()
+	p.exit = processExit(p.Cmd.ProcessState)
+	close(p.done)
+
+	entry := p.log.WithFields(logrus.Fields{
+		"pid":  p.PID,
+		"exit": p.exit.String(),
+	})
+	if err != nil {
+		entry.WithError(err).Warn("Firecracker process exited with error")
+	} else {
+		entry.Info("Firecracker process exited cleanly")
+	}
+}
+
+// processExit converts the state of a reaped process
+func processExit(state *os.ProcessState) *ProcessExit {
+	exit := &ProcessExit{Code: state.ExitCode(), Time: time.Now()}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		exit.Signal = unix.SignalName(ws.Signal())
+		if exit.Signal == "" {
+			exit.Signal = ws.Signal().String()
+		}
+	}
+	return exit
+}
+
+// Done returns a channel closed once the process has exited. It is nil, and
+// so never ready, for processes not started by this package.
+func (p *VMProcess) Done() <-chan struct{} {
+	return p.done
+}
+
+// Exit returns how the process exited, or nil while it is running
+func (p *VMProcess) Exit() *ProcessExit {
+	exit, _ := p.exited()
+	return exit
+}
+
+// exited reports whether the process is gone and, if it was reaped by this
+// package, how it exited
+func (p *VMProcess) exited() (*ProcessExit, bool) {
+	if p.done == nil {
+		return nil, !p.IsRunning()
+	}
+	select {
+	case <-p.done:
+		return p.exit, true
+	default:
+		return nil, false
+	}
+}
+
+// LogTail returns the last lines of the Firecracker log, up to a few KiB
+func (p *VMProcess) LogTail() string {
+	if p.LogPath == "" {
+		return ""
+	}
+	return readTail(p.LogPath, logTailBytes)
+}
+
+// readTail returns the last whole lines within the final max bytes of a file
+func readTail(path string, max int64) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	offset := info.Size() - max
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return ""
+	}
+
+	tail := string(data)
+	if offset > 0 {
+		// Drop the partial first line
+		if i := strings.IndexByte(tail, '\n'); i >= 0 {
+			tail = tail[i+1:]
+		}
+	}
+	return strings.TrimRight(tail, "\n")
 }
 
 // Stop gracefully stops the Firecracker process
@@ -168,6 +288,38 @@ func (p *VMProcess) Kill() error {
 	return nil
 }
 
+// processExitTimeout is how long a stop waits for the process to be gone
+const processExitTimeout = 5 * time.Second
+
+// waitExited waits up to timeout for a process to exit and reports whether
+// it did
+func waitExited(p *VMProcess, timeout time.Duration) bool {
+	if p.done == nil {
+		return !p.IsRunning()
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// hasExited reports whether a process already exited, without waiting
+func hasExited(p *VMProcess) bool {
+	if p.done == nil {
+		return !p.IsRunning()
+	}
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
 // IsRunning checks if the process is still running
 func (p *VMProcess) IsRunning() bool {
 	if p.Cmd == nil || p.Cmd.Process == nil {
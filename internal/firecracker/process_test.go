@@ -391,3 +391,73 @@ func TestVMProcess_StructFields(t *testing.T) {
 		assert.Equal(t, "/test/socket.sock", process.SocketPath)
 	})
 }
+
+// writeFakeFirecracker writes a script standing in for Firecracker: it
+// creates the API socket path, logs a line and runs the given command
+func writeFakeFirecracker(t *testing.T, dir, command string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "fake-firecracker")
+	script := "#!/bin/sh\n" +
+		"touch \"$2\"\n" +
+		"echo \"fake firecracker starting\" >> \"$4\"\n" +
+		command + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(script), 0755))
+	return path
+}
+
+func TestStartFirecrackerProcess_Exit(t *testing.T) {
+	log := createTestLogger()
+
+	t.Run("records exit code and log tail", func(t *testing.T) {
+		tempDir := t.TempDir()
+		binary := writeFakeFirecracker(t, tempDir, "echo \"panic: boom\" >> \"$4\"; sleep 0.2; exit 3")
+
+		process, err := StartFirecrackerProcess(context.Background(), binary,
+			filepath.Join(tempDir, "fc.sock"), filepath.Join(tempDir, "fc.log"), log)
+		require.NoError(t, err)
+		assert.Nil(t, process.Exit())
+
+		select {
+		case <-process.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("process exit not observed")
+		}
+
+		exit := process.Exit()
+		require.NotNil(t, exit)
+		assert.Equal(t, 3, exit.Code)
+		assert.Empty(t, exit.Signal)
+		assert.True(t, exit.Failed())
+		assert.Equal(t, "exited with code 3", exit.String())
+		assert.False(t, process.IsRunning())
+		assert.Equal(t, "fake firecracker starting\npanic: boom", process.LogTail())
+	})
+
+	t.Run("records terminating signal", func(t *testing.T) {
+		tempDir := t.TempDir()
+		binary := writeFakeFirecracker(t, tempDir, "exec sleep 60")
+
+		process, err := StartFirecrackerProcess(context.Background(), binary,
+			filepath.Join(tempDir, "fc.sock"), filepath.Join(tempDir, "fc.log"), log)
+		require.NoError(t, err)
+
+		require.NoError(t, process.Cmd.Process.Kill())
+		<-process.Done()
+
+		exit := process.Exit()
+		require.NotNil(t, exit)
+		assert.Equal(t, -1, exit.Code)
+		assert.Equal(t, "SIGKILL", exit.Signal)
+		assert.Equal(t, "killed by SIGKILL", exit.String())
+	})
+}
+
+func TestReadTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fc.log")
+	require.NoError(t, os.WriteFile(path, []byte("first line\nsecond line\nthird line\n"), 0644))
+
+	assert.Equal(t, "first line\nsecond line\nthird line", readTail(path, 1024))
+	assert.Equal(t, "third line", readTail(path, 15), "partial lines are dropped")
+	assert.Empty(t, readTail(filepath.Join(t.TempDir(), "missing.log"), 1024))
+}
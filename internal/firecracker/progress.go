@@ -0,0 +1,27 @@
+package firecracker
+
+import (
+	"context"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+)
+
+// ProgressFunc is told about each stage a VM creation reaches
+type ProgressFunc func(stage pb.OperationStage)
+
+type progressKey struct{}
+
+// ContextWithProgress returns a context reporting the stages of a CreateVM
+// call made with it to fn
+func ContextWithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ReportProgress reports a stage to the context's ProgressFunc. It returns
+// the context error, so a cancelled creation stops at the next stage.
+func ReportProgress(ctx context.Context, stage pb.OperationStage) error {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(stage)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,60 @@
+package firecracker
+
+import (
+	"time"
+)
+
+// reconcileLoop brings the recorded VM state in line with the host until
+// the manager is closed
+func (m *Manager) reconcileLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	m.reconcile()
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.reconcile()
+		}
+	}
+}
+
+// reconcile marks running or paused VMs whose Firecracker process has
+// exited as stopped, or failed when it exited with an error. Exits are normally
+// recorded as they happen, this catches processes nobody waits on. Each
+// completed pass is recorded, so a reconciler stuck behind the manager lock
+// shows up in the health checks.
+func (m *Manager) reconcile() {
+	var exits []*vmExit
+
+	m.mu.Lock()
+	for vmID, vm := range m.vms {
+		if !vmUp(vm.Info.State) || vm.Process == nil {
+			continue
+		}
+		if exit, gone := vm.Process.exited(); gone {
+			if exited := m.markExited(vmID, vm, vm.Process, exit); exited != nil {
+				exits = append(exits, exited)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, exited := range exits {
+		m.afterExit(exited)
+	}
+
+	m.lastReconcile.Store(time.Now().UnixNano())
+}
+
+// LastReconcile returns when the reconciler last completed a pass, or the
+// zero time if it has not yet
+func (m *Manager) LastReconcile() time.Time {
+	last := m.lastReconcile.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
@@ -0,0 +1,31 @@
+package firecracker
+
+import (
+	"os/exec"
+	"testing"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_Reconcile(t *testing.T) {
+	m := &Manager{
+		log: createTestLogger(),
+		vms: map[string]*VM{
+			"exited": {
+				Info:    &pb.VMInfo{VmId: "exited", State: pb.VMState_VM_STATE_RUNNING},
+				Process: &VMProcess{Cmd: &exec.Cmd{}, log: createTestLogger()},
+			},
+			"stopped": {
+				Info: &pb.VMInfo{VmId: "stopped", State: pb.VMState_VM_STATE_STOPPED},
+			},
+		},
+	}
+	assert.True(t, m.LastReconcile().IsZero())
+
+	m.reconcile()
+
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPED, m.vms["exited"].Info.State)
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPED, m.vms["stopped"].Info.State)
+	assert.False(t, m.LastReconcile().IsZero())
+}
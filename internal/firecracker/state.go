@@ -0,0 +1,118 @@
+package firecracker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+)
+
+// maxTransitions bounds the state history kept per VM
+const maxTransitions = 20
+
+// ErrInvalidTransition is returned for operations the current state of a VM
+// does not allow
+var ErrInvalidTransition = errors.New("invalid VM state transition")
+
+// transitions lists the states each state can move to. A VM is registered
+// in CREATING and removed from DELETING. PAUSED is reserved for pausing
+// guests, which the agent does not do yet.
+var transitions = map[pb.VMState][]pb.VMState{
+	pb.VMState_VM_STATE_UNSPECIFIED: {pb.VMState_VM_STATE_CREATING},
+	pb.VMState_VM_STATE_CREATING:    {pb.VMState_VM_STATE_BOOTING, pb.VMState_VM_STATE_ERROR},
+	pb.VMState_VM_STATE_BOOTING:     {pb.VMState_VM_STATE_RUNNING, pb.VMState_VM_STATE_ERROR},
+	pb.VMState_VM_STATE_RUNNING: {
+		pb.VMState_VM_STATE_PAUSED,
+		pb.VMState_VM_STATE_STOPPING,
+		pb.VMState_VM_STATE_STOPPED, // the process exited on its own
+		pb.VMState_VM_STATE_ERROR,
+		pb.VMState_VM_STATE_DELETING,
+	},
+	pb.VMState_VM_STATE_PAUSED: {
+		pb.VMState_VM_STATE_RUNNING,
+		pb.VMState_VM_STATE_STOPPING,
+		pb.VMState_VM_STATE_ERROR,
+		pb.VMState_VM_STATE_DELETING,
+	},
+	pb.VMState_VM_STATE_STOPPING: {pb.VMState_VM_STATE_STOPPED, pb.VMState_VM_STATE_ERROR},
+	// Stopping an exited or failed VM cancels its pending restart
+	pb.VMState_VM_STATE_STOPPED: {pb.VMState_VM_STATE_BOOTING, pb.VMState_VM_STATE_STOPPING, pb.VMState_VM_STATE_DELETING},
+	pb.VMState_VM_STATE_ERROR:   {pb.VMState_VM_STATE_BOOTING, pb.VMState_VM_STATE_STOPPING, pb.VMState_VM_STATE_DELETING},
+}
+
+// canTransition reports whether a VM may move from one state to another
+func canTransition(from, to pb.VMState) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition moves a VM to a new state, records why in its history and
+// returns the event announcing the change. The caller must hold m.mu and
+// emit the event once it released it.
+func (m *Manager) transition(vm *VM, to pb.VMState, reason string) (*pb.VMEvent, error) {
+	from := vm.Info.State
+	if !canTransition(from, to) {
+		return nil, fmt.Errorf("%w: VM %s is %s and cannot become %s",
+			ErrInvalidTransition, vm.Info.VmId, stateName(from), stateName(to))
+	}
+
+	now := time.Now().Unix()
+	vm.Info.State = to
+	vm.Info.StateReason = reason
+	vm.Info.StateChangedAt = now
+	vm.Info.Transitions = append(vm.Info.Transitions, &pb.VMStateTransition{
+		From:      from,
+		To:        to,
+		Reason:    reason,
+		Timestamp: now,
+	})
+	if n := len(vm.Info.Transitions); n > maxTransitions {
+		vm.Info.Transitions = append([]*pb.VMStateTransition(nil), vm.Info.Transitions[n-maxTransitions:]...)
+	}
+	m.updateRunningGauge()
+
+	m.log.WithFields(logrus.Fields{
+		"vm_id":  vm.Info.VmId,
+		"from":   stateName(from),
+		"to":     stateName(to),
+		"reason": reason,
+	}).Debug("VM state changed")
+
+	event := newEvent(vm.Info, transitionEventType(from, to, vm.Info.RestartCount), reason)
+	event.PreviousState = from
+	return event, nil
+}
+
+// transitionEventType returns the type of the event announcing a transition
+func transitionEventType(from, to pb.VMState, restartCount int32) pb.EventType {
+	switch to {
+	case pb.VMState_VM_STATE_RUNNING:
+		if from == pb.VMState_VM_STATE_PAUSED {
+			return pb.EventType_EVENT_TYPE_STARTED
+		}
+		if restartCount > 0 {
+			return pb.EventType_EVENT_TYPE_RESTARTED
+		}
+		return pb.EventType_EVENT_TYPE_CREATED
+	case pb.VMState_VM_STATE_STOPPED:
+		return pb.EventType_EVENT_TYPE_STOPPED
+	case pb.VMState_VM_STATE_ERROR:
+		if from == pb.VMState_VM_STATE_RUNNING || from == pb.VMState_VM_STATE_PAUSED {
+			return pb.EventType_EVENT_TYPE_CRASHED
+		}
+		return pb.EventType_EVENT_TYPE_ERROR
+	}
+	return pb.EventType_EVENT_TYPE_STATE_CHANGED
+}
+
+// stateName returns the short name of a state, e.g. RUNNING
+func stateName(s pb.VMState) string {
+	return strings.TrimPrefix(s.String(), "VM_STATE_")
+}
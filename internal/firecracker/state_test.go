@@ -0,0 +1,152 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanTransition(t *testing.T) {
+	for _, tt := range []struct {
+		from, to pb.VMState
+		ok       bool
+	}{
+		{pb.VMState_VM_STATE_UNSPECIFIED, pb.VMState_VM_STATE_CREATING, true},
+		{pb.VMState_VM_STATE_CREATING, pb.VMState_VM_STATE_BOOTING, true},
+		{pb.VMState_VM_STATE_BOOTING, pb.VMState_VM_STATE_RUNNING, true},
+		{pb.VMState_VM_STATE_RUNNING, pb.VMState_VM_STATE_STOPPING, true},
+		{pb.VMState_VM_STATE_STOPPING, pb.VMState_VM_STATE_STOPPED, true},
+		{pb.VMState_VM_STATE_ERROR, pb.VMState_VM_STATE_BOOTING, true},
+		{pb.VMState_VM_STATE_STOPPED, pb.VMState_VM_STATE_DELETING, true},
+		{pb.VMState_VM_STATE_CREATING, pb.VMState_VM_STATE_RUNNING, false},
+		{pb.VMState_VM_STATE_STOPPED, pb.VMState_VM_STATE_STOPPING, true},
+		{pb.VMState_VM_STATE_STOPPED, pb.VMState_VM_STATE_RUNNING, false},
+		{pb.VMState_VM_STATE_STOPPING, pb.VMState_VM_STATE_DELETING, false},
+		{pb.VMState_VM_STATE_DELETING, pb.VMState_VM_STATE_RUNNING, false},
+		{pb.VMState_VM_STATE_UNSPECIFIED, pb.VMState_VM_STATE_RUNNING, false},
+	} {
+		assert.Equal(t, tt.ok, canTransition(tt.from, tt.to), "%s -> %s", tt.from, tt.to)
+	}
+}
+
+func TestTransitionEventType(t *testing.T) {
+	assert.Equal(t, pb.EventType_EVENT_TYPE_CREATED,
+		transitionEventType(pb.VMState_VM_STATE_BOOTING, pb.VMState_VM_STATE_RUNNING, 0))
+	assert.Equal(t, pb.EventType_EVENT_TYPE_RESTARTED,
+		transitionEventType(pb.VMState_VM_STATE_BOOTING, pb.VMState_VM_STATE_RUNNING, 1))
+	assert.Equal(t, pb.EventType_EVENT_TYPE_STARTED,
+		transitionEventType(pb.VMState_VM_STATE_PAUSED, pb.VMState_VM_STATE_RUNNING, 0))
+	assert.Equal(t, pb.EventType_EVENT_TYPE_CRASHED,
+		transitionEventType(pb.VMState_VM_STATE_RUNNING, pb.VMState_VM_STATE_ERROR, 0))
+	assert.Equal(t, pb.EventType_EVENT_TYPE_ERROR,
+		transitionEventType(pb.VMState_VM_STATE_BOOTING, pb.VMState_VM_STATE_ERROR, 0))
+	assert.Equal(t, pb.EventType_EVENT_TYPE_STOPPED,
+		transitionEventType(pb.VMState_VM_STATE_STOPPING, pb.VMState_VM_STATE_STOPPED, 0))
+	assert.Equal(t, pb.EventType_EVENT_TYPE_STATE_CHANGED,
+		transitionEventType(pb.VMState_VM_STATE_RUNNING, pb.VMState_VM_STATE_DELETING, 0))
+}
+
+func TestManager_Transition(t *testing.T) {
+	m := &Manager{log: createTestLogger(), vms: make(map[string]*VM)}
+	vm := &VM{Info: &pb.VMInfo{VmId: "vm-1", Metadata: map[string]string{"team": "web"}}}
+
+	event, err := m.transition(vm, pb.VMState_VM_STATE_CREATING, "Creation requested")
+	require.NoError(t, err)
+	assert.Equal(t, pb.EventType_EVENT_TYPE_STATE_CHANGED, event.Type)
+	assert.Equal(t, pb.VMState_VM_STATE_CREATING, event.State)
+	assert.Equal(t, pb.VMState_VM_STATE_UNSPECIFIED, event.PreviousState)
+	assert.Equal(t, "Creation requested", event.Message)
+	assert.Equal(t, map[string]string{"team": "web"}, event.Labels)
+	assert.Equal(t, "Creation requested", vm.Info.StateReason)
+	assert.NotZero(t, vm.Info.StateChangedAt)
+
+	_, err = m.transition(vm, pb.VMState_VM_STATE_RUNNING, "skipping boot")
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+	assert.EqualError(t, err, "invalid VM state transition: VM vm-1 is CREATING and cannot become RUNNING")
+	assert.Equal(t, pb.VMState_VM_STATE_CREATING, vm.Info.State, "a rejected transition changes nothing")
+
+	// The history keeps the most recent transitions
+	for n := 0; n < maxTransitions; n++ {
+		_, err = m.transition(vm, pb.VMState_VM_STATE_BOOTING, fmt.Sprint("boot ", n))
+		require.NoError(t, err)
+		_, err = m.transition(vm, pb.VMState_VM_STATE_ERROR, fmt.Sprint("failure ", n))
+		require.NoError(t, err)
+	}
+	require.Len(t, vm.Info.Transitions, maxTransitions)
+	last := vm.Info.Transitions[maxTransitions-1]
+	assert.Equal(t, pb.VMState_VM_STATE_BOOTING, last.From)
+	assert.Equal(t, pb.VMState_VM_STATE_ERROR, last.To)
+	assert.Equal(t, fmt.Sprint("failure ", maxTransitions-1), last.Reason)
+}
+
+func TestManager_StopVMTransitions(t *testing.T) {
+	events := make(chan *pb.VMEvent, 10)
+	m := &Manager{
+		log:    createTestLogger(),
+		vms:    make(map[string]*VM),
+		stopCh: make(chan struct{}),
+	}
+	defer m.Close()
+	m.SetEventHandler(func(event *pb.VMEvent) { events <- event })
+
+	vm := startExitingVM(t, m, "vm-1", "exec sleep 60")
+	go m.watchExit("vm-1", vm, vm.Process)
+
+	require.NoError(t, m.StopVM(context.Background(), "vm-1", true))
+
+	stopping := <-events
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPING, stopping.State)
+	assert.Equal(t, "Forced stop requested", stopping.Message)
+	stopped := <-events
+	assert.Equal(t, pb.EventType_EVENT_TYPE_STOPPED, stopped.Type)
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPING, stopped.PreviousState)
+	assert.Empty(t, events, "the requested exit is not reported as a crash")
+
+	got, err := m.GetVM("vm-1")
+	require.NoError(t, err)
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPED, got.State)
+	assert.Equal(t, "Stopped by request", got.StateReason)
+
+	// Stopped VMs cannot be started again
+	assert.ErrorIs(t, m.StartVM(context.Background(), "vm-1"), ErrInvalidTransition)
+	assert.Empty(t, events)
+}
+
+func TestManager_StopVMCancelsRestartAfterExit(t *testing.T) {
+	events := make(chan *pb.VMEvent, 10)
+	m := &Manager{
+		log:    createTestLogger(),
+		vms:    make(map[string]*VM),
+		stopCh: make(chan struct{}),
+	}
+	defer m.Close()
+	m.SetEventHandler(func(event *pb.VMEvent) { events <- event })
+
+	vm := startExitingVM(t, m, "vm-1", "exit 0")
+	vm.Request = &pb.CreateVMRequest{RestartPolicy: &pb.RestartPolicy{
+		Mode:             pb.RestartMode_RESTART_MODE_ALWAYS,
+		InitialBackoffMs: 60000,
+	}}
+	go m.watchExit("vm-1", vm, vm.Process)
+
+	assert.Equal(t, pb.EventType_EVENT_TYPE_STOPPED, (<-events).Type)
+	assert.Equal(t, pb.EventType_EVENT_TYPE_RESTARTING, (<-events).Type)
+
+	// The clean exit left the VM STOPPED with a restart pending
+	require.NoError(t, m.StopVM(context.Background(), "vm-1", false))
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPING, (<-events).State)
+	stopped := <-events
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPED, stopped.State)
+	assert.Equal(t, "Stopped by request", stopped.Message)
+
+	// teardown and launch would panic without their dependencies
+	m.restart("vm-1", vm)
+	got, err := m.GetVM("vm-1")
+	require.NoError(t, err)
+	assert.Equal(t, pb.VMState_VM_STATE_STOPPED, got.State)
+	assert.Empty(t, events)
+}
@@ -0,0 +1,143 @@
+package firecracker
+
+import (
+	"fmt"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/cgroup"
+	"github.com/spluca/firecracker-agent/internal/monitor"
+)
+
+const (
+	statsSourceCgroup  = "cgroup"
+	statsSourceProcess = "process"
+)
+
+// statsTarget is where the usage of a VM is read from, copied under m.mu
+// so the reads run without it
+type statsTarget struct {
+	vmID       string
+	vm         *VM
+	cgroupPath string
+	process    *VMProcess
+}
+
+// statsTargetOf returns the stats target of a VM. The caller must hold m.mu.
+func statsTargetOf(vmID string, vm *VM) statsTarget {
+	return statsTarget{vmID: vmID, vm: vm, cgroupPath: vm.CgroupPath, process: vm.Process}
+}
+
+// GetVMStats returns the current host resource usage of a VM
+func (m *Manager) GetVMStats(vmID string) (*pb.VMStats, error) {
+	m.mu.RLock()
+	vm, exists := m.vms[vmID]
+	if !exists {
+		m.mu.RUnlock()
+		return nil, fmt.Errorf("VM %s not found", vmID)
+	}
+	target := statsTargetOf(vmID, vm)
+	m.mu.RUnlock()
+
+	return readVMStats(target)
+}
+
+// readVMStats reads usage from the VM's cgroup when it has one, falling
+// back to per-process accounting of the Firecracker process.
+func readVMStats(t statsTarget) (*pb.VMStats, error) {
+	var stats *cgroup.Stats
+	var source string
+	var err error
+
+	if t.cgroupPath != "" && cgroup.Exists(t.cgroupPath) {
+		source = statsSourceCgroup
+		stats, err = cgroup.ReadStats(t.cgroupPath)
+	} else if t.process != nil && t.process.IsRunning() {
+		source = statsSourceProcess
+		stats, err = cgroup.ReadProcessStats(t.process.PID)
+	} else {
+		return nil, fmt.Errorf("VM %s is not running", t.vmID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats for VM %s: %w", t.vmID, err)
+	}
+
+	return &pb.VMStats{
+		VmId:                t.vmID,
+		Source:              source,
+		CpuUserUsec:         stats.CPUUserUsec,
+		CpuSystemUsec:       stats.CPUSystemUsec,
+		CpuThrottledPeriods: stats.CPUThrottledPeriods,
+		CpuThrottledUsec:    stats.CPUThrottledUsec,
+		MemoryRssBytes:      stats.MemoryRSSBytes,
+		MemoryUsageBytes:    stats.MemoryUsageBytes,
+		PageFaults:          stats.PageFaults,
+		MajorPageFaults:     stats.MajorPageFaults,
+		IoReadBytes:         stats.IOReadBytes,
+		IoWriteBytes:        stats.IOWriteBytes,
+		IoReadOps:           stats.IOReadOps,
+		IoWriteOps:          stats.IOWriteOps,
+		SampledAt:           time.Now().Unix(),
+	}, nil
+}
+
+// statsLoop samples resource usage of all VMs into Prometheus until the
+// manager is closed
+func (m *Manager) statsLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.sampleStats()
+		}
+	}
+}
+
+// sampleStats records one sample per VM. The files are read without m.mu,
+// so slow reads do not hold up the registry. A sample is only recorded if
+// its VM is still registered, so a concurrent DeleteVM cannot have its
+// series re-created.
+func (m *Manager) sampleStats() {
+	m.mu.RLock()
+	targets := make([]statsTarget, 0, len(m.vms))
+	for vmID, vm := range m.vms {
+		targets = append(targets, statsTargetOf(vmID, vm))
+	}
+	m.mu.RUnlock()
+
+	for _, target := range targets {
+		stats, err := readVMStats(target)
+		if err != nil {
+			m.log.WithError(err).WithField("vm_id", target.vmID).Debug("Failed to sample VM stats")
+			continue
+		}
+
+		m.mu.RLock()
+		if m.vms[target.vmID] == target.vm {
+			recordVMStats(stats)
+		}
+		m.mu.RUnlock()
+	}
+}
+
+// recordVMStats exports a stats sample to Prometheus
+func recordVMStats(s *pb.VMStats) {
+	id := s.VmId
+
+	monitor.VMCPUSeconds.WithLabelValues(id, "user").Set(float64(s.CpuUserUsec) / 1e6)
+	monitor.VMCPUSeconds.WithLabelValues(id, "system").Set(float64(s.CpuSystemUsec) / 1e6)
+	monitor.VMCPUThrottledSeconds.WithLabelValues(id).Set(float64(s.CpuThrottledUsec) / 1e6)
+	monitor.VMCPUThrottledPeriods.WithLabelValues(id).Set(float64(s.CpuThrottledPeriods))
+	monitor.VMMemoryRSSBytes.WithLabelValues(id).Set(float64(s.MemoryRssBytes))
+	monitor.VMMemoryUsageBytes.WithLabelValues(id).Set(float64(s.MemoryUsageBytes))
+	monitor.VMPageFaults.WithLabelValues(id, "all").Set(float64(s.PageFaults))
+	monitor.VMPageFaults.WithLabelValues(id, "major").Set(float64(s.MajorPageFaults))
+	monitor.VMIOBytes.WithLabelValues(id, "read").Set(float64(s.IoReadBytes))
+	monitor.VMIOBytes.WithLabelValues(id, "write").Set(float64(s.IoWriteBytes))
+	monitor.VMIOOps.WithLabelValues(id, "read").Set(float64(s.IoReadOps))
+	monitor.VMIOOps.WithLabelValues(id, "write").Set(float64(s.IoWriteOps))
+}
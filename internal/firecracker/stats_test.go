@@ -0,0 +1,30 @@
+package firecracker
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spluca/firecracker-agent/internal/monitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_SampleStats(t *testing.T) {
+	m := &Manager{
+		log:    createTestLogger(),
+		vms:    make(map[string]*VM),
+		stopCh: make(chan struct{}),
+	}
+	defer m.Close()
+
+	vm := startExitingVM(t, m, "vm-stats", "exec sleep 60")
+	defer vm.Process.Kill()
+
+	stats, err := m.GetVMStats("vm-stats")
+	require.NoError(t, err)
+	assert.Equal(t, statsSourceProcess, stats.Source)
+	assert.NotZero(t, stats.MemoryRssBytes)
+
+	m.sampleStats()
+	assert.NotZero(t, testutil.ToFloat64(monitor.VMMemoryRSSBytes.WithLabelValues("vm-stats")))
+}
@@ -0,0 +1,163 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+)
+
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+
+	// restartResetAfter is how long a VM has to stay up before its
+	// consecutive restarts, and so its backoff, start over
+	restartResetAfter = 10 * time.Minute
+)
+
+// restartDelay applies the restart policy of a VM whose process exited
+// after running for uptime. It returns the delay before the VM is
+// relaunched, or false when the policy does not restart it. The caller
+// must hold m.mu.
+func (m *Manager) restartDelay(vm *VM, failed bool, uptime time.Duration) (time.Duration, bool) {
+	policy := vm.Request.GetRestartPolicy()
+	if uptime >= restartResetAfter {
+		vm.restarts = 0
+	}
+
+	switch policy.GetMode() {
+	case pb.RestartMode_RESTART_MODE_ALWAYS:
+	case pb.RestartMode_RESTART_MODE_ON_FAILURE:
+		if !failed {
+			return 0, false
+		}
+		if policy.MaxRetries > 0 && vm.restarts >= int(policy.MaxRetries) {
+			m.log.WithFields(logrus.Fields{
+				"vm_id":       vm.Info.VmId,
+				"max_retries": policy.MaxRetries,
+			}).Warn("VM reached its restart limit")
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	delay := backoff(policy, vm.restarts)
+	vm.restarts++
+	return delay, true
+}
+
+// backoff returns the delay before consecutive restart n, counted from 0.
+// It doubles from the initial delay up to the maximum.
+func backoff(policy *pb.RestartPolicy, n int) time.Duration {
+	initial := time.Duration(policy.GetInitialBackoffMs()) * time.Millisecond
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	max := time.Duration(policy.GetMaxBackoffMs()) * time.Millisecond
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	if max < initial {
+		max = initial
+	}
+
+	delay := initial
+	for i := 0; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// restartingEvent announces a scheduled restart
+func restartingEvent(vm *VM, delay time.Duration) *pb.VMEvent {
+	return newEvent(vm.Info, pb.EventType_EVENT_TYPE_RESTARTING,
+		fmt.Sprintf("Restarting VM in %s (attempt %d)", delay, vm.restarts))
+}
+
+// restartAfter relaunches a VM once delay has passed
+func (m *Manager) restartAfter(vmID string, vm *VM, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-m.stopCh:
+		return
+	case <-timer.C:
+	}
+	m.restart(vmID, vm)
+}
+
+// restart relaunches a VM from its stored request, unless it was stopped,
+// deleted or replaced since its process exited. A failed relaunch leaves
+// the VM in ERROR and is retried as the restart policy allows. Only the
+// VM's lock is held while it is relaunched.
+func (m *Manager) restart(vmID string, vm *VM) {
+	vm.opMu.Lock()
+	defer vm.opMu.Unlock()
+
+	m.mu.Lock()
+	if m.vms[vmID] != vm || vm.stopRequested {
+		m.mu.Unlock()
+		return
+	}
+	event, err := m.transition(vm, pb.VMState_VM_STATE_BOOTING, fmt.Sprintf("Restarting VM (attempt %d)", vm.restarts))
+	m.mu.Unlock()
+	if err != nil {
+		m.log.WithError(err).WithField("vm_id", vmID).Warn("Not restarting VM")
+		return
+	}
+	m.emit(event)
+
+	m.log.WithFields(logrus.Fields{
+		"vm_id":   vmID,
+		"attempt": vm.restarts,
+	}).Info("Restarting VM")
+
+	// Release what the exited process held; the relaunch sets it up again
+	// and boots the guest from the disk it had
+	m.releaseProcess(vmID, vm)
+	m.mu.Lock()
+	vm.detach()
+	m.mu.Unlock()
+
+	launched, err := m.launch(context.Background(), vm.Request, true, nil)
+
+	m.mu.Lock()
+	if err != nil {
+		m.log.WithError(err).WithField("vm_id", vmID).Error("Failed to restart VM")
+
+		event, _ := m.transition(vm, pb.VMState_VM_STATE_ERROR, "Failed to restart VM: "+err.Error())
+		events := []*pb.VMEvent{event}
+		delay, retry := m.restartDelay(vm, true, 0)
+		if retry {
+			events = append(events, restartingEvent(vm, delay))
+		}
+		m.mu.Unlock()
+
+		m.emit(events...)
+		if retry {
+			go m.restartAfter(vmID, vm, delay)
+		}
+		return
+	}
+
+	vm.attach(launched)
+	vm.Info.RestartCount++
+	event, _ = m.transition(vm, pb.VMState_VM_STATE_RUNNING, fmt.Sprintf("VM restarted (restart %d)", vm.Info.RestartCount))
+	m.mu.Unlock()
+
+	go m.watchExit(vmID, vm, launched.Process)
+	m.emit(event)
+
+	m.log.WithFields(logrus.Fields{
+		"vm_id":         vmID,
+		"restart_count": vm.Info.RestartCount,
+	}).Info("VM restarted")
+}
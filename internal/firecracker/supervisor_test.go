@@ -0,0 +1,188 @@
+package firecracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/storage"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := &pb.RestartPolicy{InitialBackoffMs: 100, MaxBackoffMs: 1000}
+	assert.Equal(t, 100*time.Millisecond, backoff(policy, 0))
+	assert.Equal(t, 200*time.Millisecond, backoff(policy, 1))
+	assert.Equal(t, 800*time.Millisecond, backoff(policy, 3))
+	assert.Equal(t, time.Second, backoff(policy, 4))
+	assert.Equal(t, time.Second, backoff(policy, 100))
+
+	assert.Equal(t, defaultInitialBackoff, backoff(nil, 0))
+	assert.Equal(t, defaultMaxBackoff, backoff(nil, 100))
+	assert.Equal(t, 2*time.Second, backoff(&pb.RestartPolicy{InitialBackoffMs: 2000, MaxBackoffMs: 1000}, 3),
+		"maximum below the initial delay")
+}
+
+func TestManager_RestartDelay(t *testing.T) {
+	m := &Manager{log: createTestLogger()}
+	newVM := func(policy *pb.RestartPolicy) *VM {
+		return &VM{
+			Info:    &pb.VMInfo{VmId: "vm-1"},
+			Request: &pb.CreateVMRequest{VmId: "vm-1", RestartPolicy: policy},
+		}
+	}
+
+	t.Run("never", func(t *testing.T) {
+		for _, vm := range []*VM{newVM(nil), newVM(&pb.RestartPolicy{Mode: pb.RestartMode_RESTART_MODE_NEVER})} {
+			_, ok := m.restartDelay(vm, true, time.Second)
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("on failure with max retries", func(t *testing.T) {
+		vm := newVM(&pb.RestartPolicy{Mode: pb.RestartMode_RESTART_MODE_ON_FAILURE, MaxRetries: 2, InitialBackoffMs: 100})
+
+		_, ok := m.restartDelay(vm, false, time.Second)
+		assert.False(t, ok, "clean exits are not restarted")
+
+		delay, ok := m.restartDelay(vm, true, time.Second)
+		assert.True(t, ok)
+		assert.Equal(t, 100*time.Millisecond, delay)
+		delay, ok = m.restartDelay(vm, true, time.Second)
+		assert.True(t, ok)
+		assert.Equal(t, 200*time.Millisecond, delay)
+		_, ok = m.restartDelay(vm, true, time.Second)
+		assert.False(t, ok, "limit reached")
+
+		// A VM that stayed up starts over
+		delay, ok = m.restartDelay(vm, true, restartResetAfter)
+		assert.True(t, ok)
+		assert.Equal(t, 100*time.Millisecond, delay)
+	})
+
+	t.Run("always", func(t *testing.T) {
+		vm := newVM(&pb.RestartPolicy{Mode: pb.RestartMode_RESTART_MODE_ALWAYS, MaxRetries: 1})
+		for i := 0; i < 3; i++ {
+			_, ok := m.restartDelay(vm, i%2 == 0, time.Second)
+			assert.True(t, ok)
+		}
+		assert.Equal(t, 3, vm.restarts)
+	})
+}
+
+func TestManager_RestartAfterCrash(t *testing.T) {
+	tempDir := t.TempDir()
+	log := createTestLogger()
+	events := make(chan *pb.VMEvent, 10)
+
+	// The relaunch fails: the kernel image does not exist
+	m := &Manager{
+		cfg:            &config.Config{},
+		log:            log,
+		storageManager: storage.NewManager(filepath.Join(tempDir, "vms"), false, log),
+		vms:            make(map[string]*VM),
+		stopCh:         make(chan struct{}),
+	}
+	defer m.Close()
+	m.SetEventHandler(func(event *pb.VMEvent) { events <- event })
+
+	vm := startExitingVM(t, m, "vm-1", "sleep 0.2; exit 1")
+	vm.Request = &pb.CreateVMRequest{
+		VmId:       "vm-1",
+		KernelPath: filepath.Join(tempDir, "missing-vmlinux"),
+		RootfsPath: filepath.Join(tempDir, "missing-rootfs"),
+		RestartPolicy: &pb.RestartPolicy{
+			Mode:             pb.RestartMode_RESTART_MODE_ON_FAILURE,
+			MaxRetries:       2,
+			InitialBackoffMs: 10,
+		},
+	}
+	vm.StartedAt = time.Now()
+
+	next := func() *pb.VMEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return nil
+		}
+	}
+
+	go m.watchExit("vm-1", vm, vm.Process)
+
+	assert.Equal(t, pb.EventType_EVENT_TYPE_CRASHED, next().Type)
+	restarting := next()
+	assert.Equal(t, pb.EventType_EVENT_TYPE_RESTARTING, restarting.Type)
+	assert.Equal(t, "Restarting VM in 10ms (attempt 1)", restarting.Message)
+
+	booting := next()
+	assert.Equal(t, pb.EventType_EVENT_TYPE_STATE_CHANGED, booting.Type)
+	assert.Equal(t, pb.VMState_VM_STATE_BOOTING, booting.State)
+	assert.Equal(t, pb.VMState_VM_STATE_ERROR, booting.PreviousState)
+	failed := next()
+	assert.Equal(t, pb.EventType_EVENT_TYPE_ERROR, failed.Type)
+	assert.Contains(t, failed.Message, "Failed to restart VM")
+	assert.Equal(t, pb.EventType_EVENT_TYPE_RESTARTING, next().Type)
+
+	// The second failed relaunch exhausts max_retries
+	assert.Equal(t, pb.VMState_VM_STATE_BOOTING, next().State)
+	assert.Equal(t, pb.EventType_EVENT_TYPE_ERROR, next().Type)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event after the restart limit: %v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	got, err := m.GetVM("vm-1")
+	require.NoError(t, err)
+	assert.Equal(t, pb.VMState_VM_STATE_ERROR, got.State)
+	assert.Equal(t, int32(0), got.RestartCount)
+	assert.Equal(t, int32(1), got.LastExit.GetExitCode())
+}
+
+func TestManager_RestartCancelledByStop(t *testing.T) {
+	m := &Manager{
+		log:    createTestLogger(),
+		vms:    make(map[string]*VM),
+		stopCh: make(chan struct{}),
+	}
+	vm := &VM{Info: &pb.VMInfo{VmId: "vm-1", State: pb.VMState_VM_STATE_ERROR}, stopRequested: true}
+	m.vms["vm-1"] = vm
+
+	// teardown and launch would panic without their dependencies
+	m.restart("vm-1", vm)
+	m.restart("vm-1", &VM{Info: &pb.VMInfo{VmId: "vm-1"}})
+
+	assert.Same(t, vm, m.vms["vm-1"])
+}
+
+func TestManager_ReleaseProcessKeepsStorage(t *testing.T) {
+	tempDir := t.TempDir()
+	log := createTestLogger()
+	m := &Manager{
+		cfg:            &config.Config{},
+		log:            log,
+		storageManager: storage.NewManager(tempDir, false, log),
+		vms:            make(map[string]*VM),
+		stopCh:         make(chan struct{}),
+	}
+	rootfs := filepath.Join(tempDir, "vm-1", "rootfs.ext4")
+	require.NoError(t, os.MkdirAll(filepath.Dir(rootfs), 0755))
+	require.NoError(t, os.WriteFile(rootfs, []byte("guest data"), 0644))
+
+	vm := startExitingVM(t, m, "vm-1", "exec sleep 60")
+
+	// A restart releases the process but keeps the guest's disk
+	m.releaseProcess("vm-1", vm)
+	assert.True(t, waitExited(vm.Process, processExitTimeout))
+	assert.FileExists(t, rootfs)
+
+	m.teardown("vm-1", vm)
+	assert.NoFileExists(t, rootfs)
+}
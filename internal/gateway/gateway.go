@@ -0,0 +1,338 @@
+// Package gateway serves the agent API as REST/JSON over HTTP. Requests go
+// through the same interceptors as gRPC calls, so authentication, rate
+// limits, auditing and authorization are shared.
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// route maps an HTTP method and path to an RPC
+type route struct {
+	method string // HTTP method
+	path   string // URL path, {name} segments set the request field of that name
+	rpc    string // method of the FirecrackerAgent service
+	body   bool   // request fields are read from the JSON body
+}
+
+// routes maps every RPC of the service. Fields that are neither in the path
+// nor in the body are read from query parameters.
+var routes = []route{
+	{http.MethodPost, "/v1/vms", "CreateVM", true},
+	{http.MethodGet, "/v1/vms", "ListVMs", false},
+	{http.MethodGet, "/v1/vms/{vm_id}", "GetVM", false},
+	{http.MethodDelete, "/v1/vms/{vm_id}", "DeleteVM", false},
+	{http.MethodPost, "/v1/vms/{vm_id}/start", "StartVM", true},
+	{http.MethodPost, "/v1/vms/{vm_id}/stop", "StopVM", true},
+	{http.MethodGet, "/v1/vms/{vm_id}/stats", "GetVMStats", false},
+	{http.MethodGet, "/v1/operations/{operation_id}", "GetOperation", false},
+	{http.MethodGet, "/v1/operations/{operation_id}/wait", "WaitOperation", false},
+	{http.MethodPost, "/v1/operations/{operation_id}/cancel", "CancelOperation", true},
+	{http.MethodGet, "/v1/events", "WatchVMEvents", false},
+	{http.MethodGet, "/v1/host", "GetHostInfo", false},
+	{http.MethodGet, "/v1/health", "HealthCheck", false},
+	{http.MethodGet, "/v1/audit", "QueryAuditLog", false},
+}
+
+// OpenAPIPath is where the OpenAPI document of the gateway is served
+const OpenAPIPath = "/v1/openapi.json"
+
+var jsonOptions = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// Gateway is an http.Handler translating REST/JSON requests into calls of
+// the agent service
+type Gateway struct {
+	srv    pb.FirecrackerAgentServer
+	unary  grpc.UnaryServerInterceptor
+	stream grpc.StreamServerInterceptor
+	log    *logrus.Logger
+	mux    *http.ServeMux
+}
+
+// New creates a gateway calling srv through the given interceptors, in the
+// order they are installed on the gRPC server
+func New(srv pb.FirecrackerAgentServer, unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor, log *logrus.Logger) *Gateway {
+	g := &Gateway{
+		srv:    srv,
+		unary:  chainUnary(unary),
+		stream: chainStream(stream),
+		log:    log,
+		mux:    http.NewServeMux(),
+	}
+
+	methods := make(map[string]grpc.MethodDesc)
+	for _, md := range pb.FirecrackerAgent_ServiceDesc.Methods {
+		methods[md.MethodName] = md
+	}
+	streams := make(map[string]grpc.StreamDesc)
+	for _, sd := range pb.FirecrackerAgent_ServiceDesc.Streams {
+		streams[sd.StreamName] = sd
+	}
+
+	for _, rt := range routes {
+		pattern := rt.method + " " + rt.path
+		if md, ok := methods[rt.rpc]; ok {
+			g.mux.HandleFunc(pattern, g.unaryHandler(rt, md))
+		} else if sd, ok := streams[rt.rpc]; ok {
+			g.mux.HandleFunc(pattern, g.streamHandler(rt, sd))
+		} else {
+			panic(fmt.Sprintf("gateway route for unknown RPC %s", rt.rpc))
+		}
+	}
+	g.mux.HandleFunc("GET "+OpenAPIPath, g.serveOpenAPI)
+
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+func fullMethodName(rpc string) string {
+	return "/" + pb.FirecrackerAgent_ServiceDesc.ServiceName + "/" + rpc
+}
+
+// unaryHandler serves a unary RPC
+func (g *Gateway) unaryHandler(rt route, md grpc.MethodDesc) http.HandlerFunc {
+	fullMethod := fullMethodName(rt.rpc)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ts := &transportStream{method: fullMethod}
+		ctx := rpcContext(r, ts)
+
+		dec := func(req interface{}) error {
+			return decodeRequest(r, rt, req.(proto.Message))
+		}
+		resp, err := md.Handler(g.srv, ctx, dec, g.unary)
+
+		ts.writeHeader(w)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeMessage(w, http.StatusOK, resp.(proto.Message))
+	}
+}
+
+// streamHandler serves a server streaming RPC as server-sent events, or as
+// newline delimited JSON when the client accepts application/x-ndjson
+func (g *Gateway) streamHandler(rt route, sd grpc.StreamDesc) http.HandlerFunc {
+	info := &grpc.StreamServerInfo{
+		FullMethod:     fullMethodName(rt.rpc),
+		IsClientStream: sd.ClientStreams,
+		IsServerStream: sd.ServerStreams,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, status.Error(codes.Internal, "streaming is not supported by the connection"))
+			return
+		}
+
+		ts := &transportStream{method: info.FullMethod}
+		ss := &httpStream{
+			ctx:     rpcContext(r, ts),
+			w:       w,
+			flusher: flusher,
+			r:       r,
+			route:   rt,
+			ts:      ts,
+			ndjson:  strings.Contains(r.Header.Get("Accept"), "application/x-ndjson"),
+		}
+
+		var err error
+		if g.stream != nil {
+			err = g.stream(g.srv, ss, info, sd.Handler)
+		} else {
+			err = sd.Handler(g.srv, ss)
+		}
+		if err == nil || r.Context().Err() != nil {
+			return
+		}
+
+		if !ss.started {
+			ts.writeHeader(w)
+			writeError(w, err)
+			return
+		}
+		ss.sendError(err)
+	}
+}
+
+// rpcContext builds the context of a call: request headers become incoming
+// metadata and the HTTP client becomes the peer, with its verified TLS
+// state, so the interceptors identify callers as they do for gRPC
+func rpcContext(r *http.Request, ts *transportStream) context.Context {
+	md := metadata.MD{}
+	for name, values := range r.Header {
+		md.Append(strings.ToLower(name), values...)
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+
+	p := &peer.Peer{Addr: remoteAddr(r.RemoteAddr)}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{
+			State:          *r.TLS,
+			CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		}
+	}
+	ctx = peer.NewContext(ctx, p)
+
+	return grpc.NewContextWithServerTransportStream(ctx, ts)
+}
+
+// stringAddr is a peer address that is not a TCP address
+type stringAddr string
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
+func remoteAddr(addr string) net.Addr {
+	if tcpAddr, err := net.ResolveTCPAddr("tcp", addr); err == nil {
+		return tcpAddr
+	}
+	return stringAddr(addr)
+}
+
+// transportStream collects the headers interceptors and handlers set with
+// grpc.SetHeader, such as retry-after on rate limited calls
+type transportStream struct {
+	method string
+
+	mu      sync.Mutex
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *transportStream) Method() string {
+	return s.method
+}
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+// writeHeader copies the collected headers to the HTTP response
+func (s *transportStream) writeHeader(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, values := range s.header {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+}
+
+func writeMessage(w http.ResponseWriter, code int, msg proto.Message) {
+	data, err := jsonOptions.Marshal(msg)
+	if err != nil {
+		code = http.StatusInternalServerError
+		data = []byte(`{"code":13,"message":"failed to encode response"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+// writeError writes a gRPC status as a google.rpc.Status JSON body with the
+// matching HTTP status code
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	writeMessage(w, httpStatus(st.Code()), st.Proto())
+}
+
+// httpStatus maps gRPC codes to HTTP status codes
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// chainUnary combines interceptors, the first one being the outermost
+func chainUnary(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i > 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return interceptors[0](ctx, req, info, next)
+	}
+}
+
+// chainStream combines stream interceptors, the first one being the outermost
+func chainStream(interceptors []grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		next := handler
+		for i := len(interceptors) - 1; i > 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, inner)
+			}
+		}
+		return interceptors[0](srv, ss, info, next)
+	}
+}
@@ -0,0 +1,310 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/agent"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const testAPIKey = "gateway-test-key"
+
+// fakeAgent records requests and the principal of each call
+type fakeAgent struct {
+	pb.UnimplementedFirecrackerAgentServer
+	lastCreate *pb.CreateVMRequest
+	lastList   *pb.ListVMsRequest
+	lastStop   *pb.StopVMRequest
+	principal  string
+	events     chan *pb.VMEvent
+	watches    chan *pb.WatchVMEventsRequest
+}
+
+func (f *fakeAgent) record(ctx context.Context) {
+	if p, ok := agent.PrincipalFromContext(ctx); ok {
+		f.principal = p.Name
+	}
+}
+
+func (f *fakeAgent) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (*pb.CreateVMResponse, error) {
+	f.record(ctx)
+	f.lastCreate = req
+	return &pb.CreateVMResponse{VmId: req.VmId, State: pb.VMState_VM_STATE_RUNNING, CreatedAt: 1700000000}, nil
+}
+
+func (f *fakeAgent) ListVMs(ctx context.Context, req *pb.ListVMsRequest) (*pb.ListVMsResponse, error) {
+	f.lastList = req
+	return &pb.ListVMsResponse{Vms: []*pb.VMInfo{{VmId: "vm-1"}}, TotalCount: 1}, nil
+}
+
+func (f *fakeAgent) GetVM(ctx context.Context, req *pb.GetVMRequest) (*pb.GetVMResponse, error) {
+	return nil, status.Errorf(codes.NotFound, "VM %s not found", req.VmId)
+}
+
+func (f *fakeAgent) StopVM(ctx context.Context, req *pb.StopVMRequest) (*pb.StopVMResponse, error) {
+	f.lastStop = req
+	return &pb.StopVMResponse{VmId: req.VmId, State: pb.VMState_VM_STATE_STOPPED}, nil
+}
+
+func (f *fakeAgent) WatchVMEvents(req *pb.WatchVMEventsRequest, stream pb.FirecrackerAgent_WatchVMEventsServer) error {
+	f.watches <- req
+	for {
+		select {
+		case event := <-f.events:
+			if req.VmId != "" && event.VmId != req.VmId {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// requireMutationPrincipal rejects anonymous CreateVM calls, standing in
+// for the authorization policy
+func requireMutationPrincipal(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if strings.HasSuffix(info.FullMethod, "/CreateVM") {
+		if _, ok := agent.PrincipalFromContext(ctx); !ok {
+			grpc.SetHeader(ctx, metadata.Pairs("x-denied-by", "test-policy"))
+			return nil, status.Error(codes.PermissionDenied, "anonymous callers cannot create VMs")
+		}
+	}
+	return handler(ctx, req)
+}
+
+func newTestGateway(t *testing.T) (*fakeAgent, *httptest.Server) {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	sum := sha256.Sum256([]byte(testAPIKey))
+	tokenAuth, err := agent.NewTokenAuthenticator(config.AuthenticationConfig{
+		APIKeys: []config.APIKeyConfig{{Name: "ci", SHA256: hex.EncodeToString(sum[:])}},
+	}, log)
+	require.NoError(t, err)
+
+	fake := &fakeAgent{events: make(chan *pb.VMEvent, 10), watches: make(chan *pb.WatchVMEventsRequest, 10)}
+	gw := New(fake,
+		[]grpc.UnaryServerInterceptor{agent.IdentityInterceptor(), tokenAuth.UnaryInterceptor(), requireMutationPrincipal},
+		[]grpc.StreamServerInterceptor{agent.IdentityStreamInterceptor(), tokenAuth.StreamInterceptor()},
+		log)
+
+	srv := httptest.NewServer(gw)
+	t.Cleanup(srv.Close)
+	return fake, srv
+}
+
+func doRequest(t *testing.T, method, url, body string, header http.Header) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	return resp, decoded
+}
+
+func TestGateway_CreateVM(t *testing.T) {
+	fake, srv := newTestGateway(t)
+	body := `{"vm_id": "vm-1", "vcpuCount": 2, "memory_mb": 512, "metadata": {"team": "web"}}`
+
+	// Authentication and the interceptors apply as for gRPC
+	resp, decoded := doRequest(t, http.MethodPost, srv.URL+"/v1/vms", body, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, float64(codes.PermissionDenied), decoded["code"])
+	assert.Equal(t, "test-policy", resp.Header.Get("X-Denied-By"))
+
+	resp, _ = doRequest(t, http.MethodPost, srv.URL+"/v1/vms", body, http.Header{"X-Api-Key": {"wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, decoded = doRequest(t, http.MethodPost, srv.URL+"/v1/vms", body, http.Header{"X-Api-Key": {testAPIKey}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "vm-1", decoded["vm_id"])
+	assert.Equal(t, "VM_STATE_RUNNING", decoded["state"])
+	assert.Equal(t, "1700000000", decoded["created_at"])
+	assert.Equal(t, "ci", fake.principal)
+	assert.Equal(t, int32(2), fake.lastCreate.VcpuCount)
+	assert.Equal(t, map[string]string{"team": "web"}, fake.lastCreate.Metadata)
+
+	resp, decoded = doRequest(t, http.MethodPost, srv.URL+"/v1/vms", `{"vm_id": `, http.Header{"X-Api-Key": {testAPIKey}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, decoded["message"], "invalid request body")
+}
+
+func TestGateway_PathAndQueryParameters(t *testing.T) {
+	fake, srv := newTestGateway(t)
+
+	resp, decoded := doRequest(t, http.MethodGet, srv.URL+"/v1/vms?page_size=10&pageToken=abc", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(10), fake.lastList.PageSize)
+	assert.Equal(t, "abc", fake.lastList.PageToken)
+	assert.Equal(t, float64(1), decoded["total_count"])
+
+	resp, _ = doRequest(t, http.MethodGet, srv.URL+"/v1/vms?page_size=ten", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = doRequest(t, http.MethodGet, srv.URL+"/v1/vms?color=blue", "", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Path parameters win over the body, an empty body is allowed
+	resp, _ = doRequest(t, http.MethodPost, srv.URL+"/v1/vms/vm-2/stop", `{"vm_id": "other", "force": true}`, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "vm-2", fake.lastStop.VmId)
+	assert.True(t, fake.lastStop.Force)
+
+	resp, _ = doRequest(t, http.MethodPost, srv.URL+"/v1/vms/vm-3/stop", "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "vm-3", fake.lastStop.VmId)
+
+	resp, decoded = doRequest(t, http.MethodGet, srv.URL+"/v1/vms/missing", "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "VM missing not found", decoded["message"])
+
+	resp, _ = doRequest(t, http.MethodGet, srv.URL+"/v1/host", "", nil)
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestGateway_WatchVMEvents(t *testing.T) {
+	fake, srv := newTestGateway(t)
+
+	for _, tt := range []struct {
+		name        string
+		accept      string
+		contentType string
+		prefix      string
+	}{
+		{"server-sent events", "text/event-stream", "text/event-stream", "data: "},
+		{"ndjson", "application/x-ndjson", "application/x-ndjson", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/events?vm_id=vm-1", nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept", tt.accept)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			// Headers arrive once the subscription is established
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+
+			fake.events <- &pb.VMEvent{VmId: "vm-2", Type: pb.EventType_EVENT_TYPE_CREATED}
+			fake.events <- &pb.VMEvent{VmId: "vm-1", Type: pb.EventType_EVENT_TYPE_STOPPED, Message: "VM stopped"}
+
+			reader := bufio.NewReader(resp.Body)
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(line, tt.prefix), line)
+
+			var event pb.VMEvent
+			require.NoError(t, protojson.Unmarshal([]byte(strings.TrimPrefix(line, tt.prefix)), &event))
+			assert.Equal(t, "vm-1", event.VmId)
+			assert.Equal(t, pb.EventType_EVENT_TYPE_STOPPED, event.Type)
+		})
+	}
+
+	// Invalid credentials are rejected before the stream starts
+	resp, decoded := doRequest(t, http.MethodGet, srv.URL+"/v1/events", "", http.Header{"X-Api-Key": {"wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, float64(codes.Unauthenticated), decoded["code"])
+}
+
+func TestGateway_WatchVMEventsResume(t *testing.T) {
+	fake, srv := newTestGateway(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "41")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	watchReq := <-fake.watches
+	require.NotNil(t, watchReq.SinceSequence)
+	assert.Equal(t, uint64(41), *watchReq.SinceSequence)
+
+	// Events carry their sequence as the SSE id
+	fake.events <- &pb.VMEvent{VmId: "vm-1", Sequence: 42}
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "id: 42\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "data: "), line)
+}
+
+func TestRoutes_CoverEveryRPC(t *testing.T) {
+	mapped := make(map[string]bool)
+	for _, rt := range routes {
+		mapped[rt.rpc] = true
+	}
+	for _, md := range pb.FirecrackerAgent_ServiceDesc.Methods {
+		assert.True(t, mapped[md.MethodName], md.MethodName)
+	}
+	for _, sd := range pb.FirecrackerAgent_ServiceDesc.Streams {
+		assert.True(t, mapped[sd.StreamName], sd.StreamName)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	_, srv := newTestGateway(t)
+
+	resp, doc := doRequest(t, http.MethodGet, srv.URL+OpenAPIPath, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "3.0.3", doc["openapi"])
+
+	paths := doc["paths"].(map[string]interface{})
+	for _, rt := range routes {
+		op, ok := paths[rt.path].(map[string]interface{})[strings.ToLower(rt.method)].(map[string]interface{})
+		require.True(t, ok, rt.path)
+		assert.Equal(t, rt.rpc, op["operationId"])
+	}
+
+	list := paths["/v1/vms"].(map[string]interface{})["get"].(map[string]interface{})
+	var params []string
+	for _, p := range list["parameters"].([]interface{}) {
+		params = append(params, p.(map[string]interface{})["name"].(string))
+	}
+	assert.Equal(t, []string{"page_size", "page_token", "states", "label_selector", "created_after", "created_before", "name_prefix"}, params)
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	vmInfo := schemas["VMInfo"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "int64"}, vmInfo["created_at"])
+	assert.Equal(t, map[string]interface{}{"$ref": "#/components/schemas/VMPlacement"}, vmInfo["placement"])
+	assert.Contains(t, schemas, "VMPlacement")
+	assert.Contains(t, schemas, "Status")
+}
@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/version"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// statusSchema is the error body, a google.rpc.Status in JSON
+var statusSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"code":    map[string]interface{}{"type": "integer", "format": "int32", "description": "gRPC status code"},
+		"message": map[string]interface{}{"type": "string"},
+		"details": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+	},
+}
+
+// OpenAPI generates the OpenAPI 3 document of the gateway from the routes
+// and the message descriptors of the proto file
+func OpenAPI() ([]byte, error) {
+	g := &openAPIGenerator{schemas: map[string]interface{}{"Status": statusSchema}}
+	svc := pb.File_api_proto_firecracker_v1_firecracker_proto.Services().ByName("FirecrackerAgent")
+
+	paths := make(map[string]map[string]interface{})
+	for _, rt := range routes {
+		if paths[rt.path] == nil {
+			paths[rt.path] = make(map[string]interface{})
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = g.operation(rt, svc.Methods().ByName(protoreflect.Name(rt.rpc)))
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Firecracker Agent API",
+			"version": version.Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "x-api-key"},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		// Client certificates authenticate at the TLS layer
+		"security": []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{},
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func (g *Gateway) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := OpenAPI()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
+
+type openAPIGenerator struct {
+	schemas map[string]interface{}
+}
+
+// operation describes the route of an RPC
+func (g *openAPIGenerator) operation(rt route, md protoreflect.MethodDescriptor) map[string]interface{} {
+	in := md.Input()
+	inPath := make(map[string]bool)
+
+	var params []interface{}
+	for _, name := range pathParams(rt.path) {
+		inPath[name] = true
+		params = append(params, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   g.fieldSchema(in.Fields().ByName(protoreflect.Name(name))),
+		})
+	}
+
+	op := map[string]interface{}{
+		"operationId": rt.rpc,
+		"tags":        []string{string(md.Parent().Name())},
+		"responses": map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     jsonContent(ref("Status")),
+			},
+		},
+	}
+
+	if rt.body {
+		op["requestBody"] = map[string]interface{}{
+			"content": jsonContent(g.messageSchema(in)),
+		}
+	} else {
+		fields := in.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			if inPath[string(fd.Name())] || fd.IsMap() || fd.Kind() == protoreflect.MessageKind {
+				continue
+			}
+			params = append(params, map[string]interface{}{
+				"name":   string(fd.Name()),
+				"in":     "query",
+				"schema": g.fieldSchema(fd),
+			})
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	out := g.messageSchema(md.Output())
+	if md.IsStreamingServer() {
+		op["description"] = "Streams one message per event as server-sent events, or as newline delimited JSON with Accept: application/x-ndjson"
+		op["responses"].(map[string]interface{})["200"] = map[string]interface{}{
+			"description": "Stream of " + string(md.Output().Name()),
+			"content": map[string]interface{}{
+				"text/event-stream":    map[string]interface{}{"schema": out},
+				"application/x-ndjson": map[string]interface{}{"schema": out},
+			},
+		}
+	} else {
+		op["responses"].(map[string]interface{})["200"] = map[string]interface{}{
+			"description": string(md.Output().Name()),
+			"content":     jsonContent(out),
+		}
+	}
+	return op
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// messageSchema registers the schema of a message and returns a reference
+func (g *openAPIGenerator) messageSchema(md protoreflect.MessageDescriptor) map[string]interface{} {
+	name := string(md.Name())
+	if _, ok := g.schemas[name]; ok {
+		return ref(name)
+	}
+
+	properties := make(map[string]interface{})
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	g.schemas[name] = schema // registered first so recursive messages terminate
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		properties[string(fd.Name())] = g.fieldSchema(fd)
+	}
+	return ref(name)
+}
+
+// fieldSchema describes a field as encoded by protojson
+func (g *openAPIGenerator) fieldSchema(fd protoreflect.FieldDescriptor) map[string]interface{} {
+	if fd.IsMap() {
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.valueSchema(fd.MapValue()),
+		}
+	}
+	if fd.IsList() {
+		return map[string]interface{}{"type": "array", "items": g.valueSchema(fd)}
+	}
+	return g.valueSchema(fd)
+}
+
+func (g *openAPIGenerator) valueSchema(fd protoreflect.FieldDescriptor) map[string]interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return map[string]interface{}{"type": "boolean"}
+	case protoreflect.StringKind:
+		return map[string]interface{}{"type": "string"}
+	case protoreflect.BytesKind:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		// 64-bit integers are JSON strings in protojson
+		return map[string]interface{}{"type": "string", "format": "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return map[string]interface{}{"type": "string", "format": "uint64"}
+	case protoreflect.FloatKind:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case protoreflect.DoubleKind:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		names := make([]string, values.Len())
+		for i := range names {
+			names[i] = string(values.Get(i).Name())
+		}
+		return map[string]interface{}{"type": "string", "enum": names}
+	default:
+		return g.messageSchema(fd.Message())
+	}
+}
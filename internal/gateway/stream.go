@@ -0,0 +1,146 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// httpStream is the server stream of a streaming RPC served over HTTP.
+// Messages are written as server-sent events ("data: <json>") or as newline
+// delimited JSON.
+type httpStream struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	flusher http.Flusher
+	r       *http.Request
+	route   route
+	ts      *transportStream
+	ndjson  bool
+
+	received bool
+	started  bool
+}
+
+func (s *httpStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *httpStream) SetHeader(md metadata.MD) error {
+	return s.ts.SetHeader(md)
+}
+
+func (s *httpStream) SendHeader(md metadata.MD) error {
+	if err := s.ts.SetHeader(md); err != nil {
+		return err
+	}
+	s.start()
+	return nil
+}
+
+func (s *httpStream) SetTrailer(md metadata.MD) {
+	s.ts.SetTrailer(md)
+}
+
+// RecvMsg decodes the request. The handler receives it once every
+// interceptor accepted the call, so the response is started here and
+// clients see the subscription before the first message.
+func (s *httpStream) RecvMsg(m interface{}) error {
+	if s.received {
+		return io.EOF
+	}
+	s.received = true
+
+	msg := m.(proto.Message)
+	if err := decodeRequest(s.r, s.route, msg); err != nil {
+		return err
+	}
+
+	// Reconnecting EventSource clients resume after the last event id
+	if lastID := s.r.Header.Get("Last-Event-ID"); lastID != "" {
+		req := msg.ProtoReflect()
+		if fd := req.Descriptor().Fields().ByName(resumeField); fd != nil && !req.Has(fd) {
+			if err := setField(req, resumeField, []string{lastID}); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.start()
+	return nil
+}
+
+func (s *httpStream) SendMsg(m interface{}) error {
+	s.start()
+
+	data, err := jsonOptions.Marshal(m.(proto.Message))
+	if err != nil {
+		return err
+	}
+	if s.ndjson {
+		_, err = fmt.Fprintf(s.w, "%s\n", data)
+	} else {
+		_, err = fmt.Fprintf(s.w, "%sdata: %s\n\n", eventID(m.(proto.Message)), data)
+	}
+	if err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Streamed messages with a sequence field are sent with it as the event id,
+// and requests with a since_sequence field are resumed from Last-Event-ID
+const (
+	sequenceField = "sequence"
+	resumeField   = "since_sequence"
+)
+
+// eventID returns the "id:" line of a message with a non-zero sequence
+func eventID(msg proto.Message) string {
+	m := msg.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName(sequenceField)
+	if fd == nil || fd.Kind() != protoreflect.Uint64Kind || !m.Has(fd) {
+		return ""
+	}
+	return fmt.Sprintf("id: %d\n", m.Get(fd).Uint())
+}
+
+// start writes the response headers
+func (s *httpStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	s.ts.writeHeader(s.w)
+	if s.ndjson {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		s.w.Header().Set("Content-Type", "text/event-stream")
+	}
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+}
+
+// sendError reports an error after the response started: an "error" event,
+// or a final line holding the status for newline delimited JSON
+func (s *httpStream) sendError(err error) {
+	data, merr := jsonOptions.Marshal(status.Convert(err).Proto())
+	if merr != nil {
+		return
+	}
+	if s.ndjson {
+		fmt.Fprintf(s.w, "{\"error\":%s}\n", data)
+	} else {
+		fmt.Fprintf(s.w, "event: error\ndata: %s\n\n", data)
+	}
+	s.flusher.Flush()
+}
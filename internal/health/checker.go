@@ -0,0 +1,140 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds the duration of a single check
+const checkTimeout = 5 * time.Second
+
+// Check is a named probe of a dependency the agent needs to work
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name      string
+	Healthy   bool
+	Message   string
+	CheckedAt time.Time
+	Duration  time.Duration
+}
+
+// Checker runs a set of checks and keeps their latest results
+type Checker struct {
+	checks   []Check
+	onChange func(healthy bool)
+
+	mu      sync.RWMutex
+	results []Result
+	healthy bool
+	ran     bool
+}
+
+// NewChecker creates a checker. onChange, if not nil, is called after a run
+// that changed the overall health, and after the first run.
+func NewChecker(checks []Check, onChange func(healthy bool)) *Checker {
+	return &Checker{
+		checks:   checks,
+		onChange: onChange,
+	}
+}
+
+// Run runs every check and stores the results. The agent is healthy when
+// all checks pass.
+func (c *Checker) Run(ctx context.Context) []Result {
+	results := make([]Result, len(c.checks))
+	healthy := true
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		healthy = healthy && r.Healthy
+	}
+
+	c.mu.Lock()
+	changed := !c.ran || c.healthy != healthy
+	c.results = results
+	c.healthy = healthy
+	c.ran = true
+	c.mu.Unlock()
+
+	if changed && c.onChange != nil {
+		c.onChange(healthy)
+	}
+
+	return results
+}
+
+func runCheck(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+
+	result := Result{
+		Name:      check.Name,
+		Healthy:   err == nil,
+		Message:   "ok",
+		CheckedAt: start,
+		Duration:  time.Since(start),
+	}
+	if err != nil {
+		result.Message = err.Error()
+	}
+	return result
+}
+
+// Results returns the latest results, running the checks if they have not
+// run yet
+func (c *Checker) Results(ctx context.Context) ([]Result, bool) {
+	c.mu.RLock()
+	ran := c.ran
+	results, healthy := c.results, c.healthy
+	c.mu.RUnlock()
+
+	if !ran {
+		results = c.Run(ctx)
+		c.mu.RLock()
+		healthy = c.healthy
+		c.mu.RUnlock()
+	}
+	return results, healthy
+}
+
+// Healthy reports whether all checks passed in the latest run
+func (c *Checker) Healthy() bool {
+	_, healthy := c.Results(context.Background())
+	return healthy
+}
+
+// Start runs the checks immediately and then every interval until stopCh
+// is closed
+func (c *Checker) Start(interval time.Duration, stopCh <-chan struct{}) {
+	c.Run(context.Background())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			c.Run(context.Background())
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticCheck(name string, err *error) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error { return *err }}
+}
+
+func TestChecker_Run(t *testing.T) {
+	var bridgeErr, kvmErr error
+	var changes []bool
+	c := NewChecker([]Check{
+		staticCheck("bridge", &bridgeErr),
+		staticCheck("kvm", &kvmErr),
+	}, func(healthy bool) { changes = append(changes, healthy) })
+
+	// Results run the checks on first use
+	results, healthy := c.Results(context.Background())
+	assert.True(t, healthy)
+	require.Len(t, results, 2)
+	assert.Equal(t, "bridge", results[0].Name)
+	assert.Equal(t, "ok", results[0].Message)
+
+	kvmErr = errors.New("KVM is not accessible")
+	results = c.Run(context.Background())
+	assert.True(t, results[0].Healthy)
+	assert.False(t, results[1].Healthy)
+	assert.Equal(t, "KVM is not accessible", results[1].Message)
+	assert.False(t, c.Healthy())
+
+	// Unchanged health does not notify
+	c.Run(context.Background())
+	kvmErr = nil
+	c.Run(context.Background())
+	assert.True(t, c.Healthy())
+
+	assert.Equal(t, []bool{true, false, true}, changes)
+}
+
+func TestChecker_NoChecks(t *testing.T) {
+	c := NewChecker(nil, nil)
+	results, healthy := c.Results(context.Background())
+	assert.True(t, healthy)
+	assert.Empty(t, results)
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// BridgeCheck verifies the VM bridge exists, is a bridge and is up
+func BridgeCheck(sysfsRoot, name string) Check {
+	return Check{
+		Name: "bridge",
+		Run: func(ctx context.Context) error {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return fmt.Errorf("bridge %s not found: %w", name, err)
+			}
+			if _, err := os.Stat(filepath.Join(sysfsRoot, "class", "net", name, "bridge")); err != nil {
+				return fmt.Errorf("%s is not a bridge", name)
+			}
+			if iface.Flags&net.FlagUp == 0 {
+				return fmt.Errorf("bridge %s is down", name)
+			}
+			return nil
+		},
+	}
+}
+
+// VMsDirCheck verifies the VMs directory is writable and has at least
+// minFreeMB of free space
+func VMsDirCheck(dir string, minFreeMB int64) Check {
+	return Check{
+		Name: "vms_dir",
+		Run: func(ctx context.Context) error {
+			f, err := os.CreateTemp(dir, ".health-*")
+			if err != nil {
+				return fmt.Errorf("%s is not writable: %w", dir, err)
+			}
+			f.Close()
+			os.Remove(f.Name())
+
+			var stat unix.Statfs_t
+			if err := unix.Statfs(dir, &stat); err != nil {
+				return fmt.Errorf("failed to stat filesystem of %s: %w", dir, err)
+			}
+			freeMB := int64(stat.Bavail) * int64(stat.Bsize) / 1024 / 1024
+			if freeMB < minFreeMB {
+				return fmt.Errorf("%s has %d MB free, below the %d MB minimum", dir, freeMB, minFreeMB)
+			}
+			return nil
+		},
+	}
+}
+
+// BinaryCheck verifies an executable exists and can be run by the agent
+func BinaryCheck(name, path string) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("%s is not a regular file", path)
+			}
+			if err := unix.Access(path, unix.X_OK); err != nil {
+				return fmt.Errorf("%s is not executable: %w", path, err)
+			}
+			return nil
+		},
+	}
+}
+
+// KVMCheck verifies the KVM device can be opened for reading and writing
+func KVMCheck(path string) Check {
+	return Check{
+		Name: "kvm",
+		Run: func(ctx context.Context) error {
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				return fmt.Errorf("KVM is not accessible: %w", err)
+			}
+			return f.Close()
+		},
+	}
+}
+
+// ReconcilerCheck verifies the VM reconciler completed a pass within maxAge
+func ReconcilerCheck(lastSuccess func() time.Time, maxAge time.Duration) Check {
+	return Check{
+		Name: "reconciler",
+		Run: func(ctx context.Context) error {
+			last := lastSuccess()
+			if last.IsZero() {
+				return fmt.Errorf("reconciler has not completed a pass yet")
+			}
+			if age := time.Since(last); age > maxAge {
+				return fmt.Errorf("last reconciliation %s ago, expected within %s", age.Round(time.Second), maxAge)
+			}
+			return nil
+		},
+	}
+}
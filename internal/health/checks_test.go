@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runCheckFunc(check Check) error {
+	return check.Run(context.Background())
+}
+
+func TestBridgeCheck(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil || lo.Flags&net.FlagUp == 0 {
+		t.Skip("loopback interface not available")
+	}
+	sysfs := t.TempDir()
+
+	assert.ErrorContains(t, runCheckFunc(BridgeCheck(sysfs, "lo")), "not a bridge")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(sysfs, "class", "net", "lo", "bridge"), 0755))
+	assert.NoError(t, runCheckFunc(BridgeCheck(sysfs, "lo")))
+
+	assert.ErrorContains(t, runCheckFunc(BridgeCheck(sysfs, "nonexistent0")), "not found")
+}
+
+func TestVMsDirCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	assert.NoError(t, runCheckFunc(VMsDirCheck(dir, 0)))
+	assert.ErrorContains(t, runCheckFunc(VMsDirCheck(dir, 1<<40)), "below the")
+	assert.ErrorContains(t, runCheckFunc(VMsDirCheck(filepath.Join(dir, "missing"), 0)), "not writable")
+
+	// The probe file is removed
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestBinaryCheck(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "firecracker")
+	require.NoError(t, os.WriteFile(executable, []byte("#!/bin/sh\n"), 0755))
+	plain := filepath.Join(dir, "plain")
+	require.NoError(t, os.WriteFile(plain, []byte("data"), 0644))
+
+	assert.NoError(t, runCheckFunc(BinaryCheck("firecracker", executable)))
+	assert.Error(t, runCheckFunc(BinaryCheck("firecracker", filepath.Join(dir, "missing"))))
+	assert.ErrorContains(t, runCheckFunc(BinaryCheck("firecracker", dir)), "not a regular file")
+	assert.ErrorContains(t, runCheckFunc(BinaryCheck("firecracker", plain)), "not executable")
+}
+
+func TestKVMCheck(t *testing.T) {
+	device := filepath.Join(t.TempDir(), "kvm")
+	require.NoError(t, os.WriteFile(device, nil, 0600))
+
+	assert.NoError(t, runCheckFunc(KVMCheck(device)))
+	assert.ErrorContains(t, runCheckFunc(KVMCheck(device+"-missing")), "not accessible")
+}
+
+func TestReconcilerCheck(t *testing.T) {
+	var last time.Time
+	check := ReconcilerCheck(func() time.Time { return last }, time.Minute)
+
+	assert.ErrorContains(t, runCheckFunc(check), "has not completed a pass")
+
+	last = time.Now().Add(-2 * time.Minute)
+	assert.ErrorContains(t, runCheckFunc(check), "expected within 1m0s")
+
+	last = time.Now()
+	assert.NoError(t, runCheckFunc(check))
+}
@@ -0,0 +1,217 @@
+package labels
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Operator is the comparison applied by a requirement
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is a single condition on a label
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Matches reports whether the labels satisfy the requirement
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, exists := labels[r.Key]
+
+	switch r.Operator {
+	case Equals:
+		return exists && value == r.Values[0]
+	case NotEquals:
+		return !exists || value != r.Values[0]
+	case In:
+		return exists && slices.Contains(r.Values, value)
+	case NotIn:
+		return !exists || !slices.Contains(r.Values, value)
+	case Exists:
+		return exists
+	case DoesNotExist:
+		return !exists
+	default:
+		return false
+	}
+}
+
+// Selector is a conjunction of label requirements. The zero value matches
+// every set of labels.
+type Selector struct {
+	Requirements []Requirement
+}
+
+// Empty reports whether the selector has no requirements
+func (s Selector) Empty() bool {
+	return len(s.Requirements) == 0
+}
+
+// Matches reports whether the labels satisfy every requirement
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s.Requirements {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Expand returns a copy of the selector with "${var}" references in values
+// replaced using mapping
+func (s Selector) Expand(mapping func(string) string) Selector {
+	expanded := Selector{Requirements: make([]Requirement, len(s.Requirements))}
+	for i, r := range s.Requirements {
+		values := make([]string, len(r.Values))
+		for j, v := range r.Values {
+			values[j] = os.Expand(v, mapping)
+		}
+		expanded.Requirements[i] = Requirement{Key: r.Key, Operator: r.Operator, Values: values}
+	}
+	return expanded
+}
+
+// Parse parses a selector such as "env=prod,tier in (web,api),!canary".
+// Supported terms are key=value, key==value, key!=value, key in (...),
+// key notin (...), key (exists) and !key (does not exist).
+func Parse(selector string) (Selector, error) {
+	var s Selector
+
+	terms, err := splitTerms(selector)
+	if err != nil {
+		return Selector{}, err
+	}
+
+	for _, term := range terms {
+		r, err := parseRequirement(term)
+		if err != nil {
+			return Selector{}, err
+		}
+		s.Requirements = append(s.Requirements, r)
+	}
+
+	return s, nil
+}
+
+// splitTerms splits a selector on commas outside of parentheses
+func splitTerms(selector string) ([]string, error) {
+	var terms []string
+	depth := 0
+	start := 0
+
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in selector %q", selector)
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in selector %q", selector)
+	}
+	terms = append(terms, selector[start:])
+
+	// An empty selector has no terms
+	if len(terms) == 1 && strings.TrimSpace(terms[0]) == "" {
+		return nil, nil
+	}
+	return terms, nil
+}
+
+// parseRequirement parses a single selector term
+func parseRequirement(term string) (Requirement, error) {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return Requirement{}, fmt.Errorf("empty selector term")
+	}
+
+	if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		key := strings.TrimSpace(term[1:])
+		if err := validateKey(key); err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: key, Operator: DoesNotExist}, nil
+	}
+
+	for _, op := range []struct {
+		token    string
+		operator Operator
+	}{{"!=", NotEquals}, {"==", Equals}, {"=", Equals}} {
+		if key, value, found := strings.Cut(term, op.token); found {
+			key = strings.TrimSpace(key)
+			if err := validateKey(key); err != nil {
+				return Requirement{}, err
+			}
+			return Requirement{Key: key, Operator: op.operator, Values: []string{strings.TrimSpace(value)}}, nil
+		}
+	}
+
+	if open := strings.Index(term, "("); open >= 0 {
+		if !strings.HasSuffix(term, ")") {
+			return Requirement{}, fmt.Errorf("invalid selector term %q", term)
+		}
+		fields := strings.Fields(term[:open])
+		if len(fields) != 2 {
+			return Requirement{}, fmt.Errorf("invalid selector term %q", term)
+		}
+		key := fields[0]
+		if err := validateKey(key); err != nil {
+			return Requirement{}, err
+		}
+
+		var operator Operator
+		switch fields[1] {
+		case "in":
+			operator = In
+		case "notin":
+			operator = NotIn
+		default:
+			return Requirement{}, fmt.Errorf("unknown operator %q in selector term %q", fields[1], term)
+		}
+
+		var values []string
+		for _, v := range strings.Split(term[open+1:len(term)-1], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return Requirement{}, fmt.Errorf("selector term %q has no values", term)
+		}
+		return Requirement{Key: key, Operator: operator, Values: values}, nil
+	}
+
+	if err := validateKey(term); err != nil {
+		return Requirement{}, err
+	}
+	return Requirement{Key: term, Operator: Exists}, nil
+}
+
+// validateKey rejects empty keys and keys containing selector syntax
+func validateKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " \t!=(),") {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    []Requirement
+		expectError bool
+	}{
+		{input: "", expected: nil},
+		{input: "env=prod", expected: []Requirement{{Key: "env", Operator: Equals, Values: []string{"prod"}}}},
+		{input: "env == prod", expected: []Requirement{{Key: "env", Operator: Equals, Values: []string{"prod"}}}},
+		{input: "env!=prod", expected: []Requirement{{Key: "env", Operator: NotEquals, Values: []string{"prod"}}}},
+		{input: "owner=", expected: []Requirement{{Key: "owner", Operator: Equals, Values: []string{""}}}},
+		{
+			input: "tier in (web, api),env notin (dev)",
+			expected: []Requirement{
+				{Key: "tier", Operator: In, Values: []string{"web", "api"}},
+				{Key: "env", Operator: NotIn, Values: []string{"dev"}},
+			},
+		},
+		{
+			input: "team,!canary",
+			expected: []Requirement{
+				{Key: "team", Operator: Exists},
+				{Key: "canary", Operator: DoesNotExist},
+			},
+		},
+		{input: "owner=${principal}", expected: []Requirement{{Key: "owner", Operator: Equals, Values: []string{"${principal}"}}}},
+		{input: "env=prod,", expectError: true},
+		{input: "tier in (web", expectError: true},
+		{input: "tier in ()", expectError: true},
+		{input: "tier within (web)", expectError: true},
+		{input: "=prod", expectError: true},
+		{input: "a b", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			s, err := Parse(tt.input)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, s.Requirements)
+		})
+	}
+}
+
+func TestSelector_Matches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "tier": "web", "owner": "ci-1"}
+
+	tests := []struct {
+		selector string
+		expected bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"missing!=x", true},
+		{"tier in (web,api)", true},
+		{"tier in (db)", false},
+		{"missing in (x)", false},
+		{"tier notin (db)", true},
+		{"missing notin (x)", true},
+		{"owner", true},
+		{"missing", false},
+		{"!missing", true},
+		{"!owner", false},
+		{"env=prod,tier in (web),!canary", true},
+		{"env=prod,tier=db", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			s, err := Parse(tt.selector)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, s.Matches(labels))
+		})
+	}
+
+	assert.True(t, Selector{}.Matches(nil))
+	assert.True(t, Selector{}.Empty())
+}
+
+func TestSelector_Expand(t *testing.T) {
+	s, err := Parse("owner=${principal},env in (dev,${principal})")
+	require.NoError(t, err)
+
+	expanded := s.Expand(func(name string) string {
+		if name == "principal" {
+			return "ci-1"
+		}
+		return ""
+	})
+
+	assert.True(t, expanded.Matches(map[string]string{"owner": "ci-1", "env": "ci-1"}))
+	assert.False(t, expanded.Matches(map[string]string{"owner": "ci-2", "env": "dev"}))
+
+	// The original selector is unchanged
+	assert.Equal(t, "${principal}", s.Requirements[0].Values[0])
+}
@@ -22,6 +22,12 @@ var (
 		Help: "Number of VMs currently running",
 	})
 
+	// VMsAdmissionRejected tracks VMs rejected for lack of host capacity
+	VMsAdmissionRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "firecracker_vms_admission_rejected_total",
+		Help: "Total number of VMs rejected for lack of host capacity",
+	})
+
 	// VMOperationDuration tracks operation durations
 	VMOperationDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -40,27 +46,117 @@ var (
 		},
 		[]string{"method", "status"},
 	)
+
+	// GRPCRateLimitedTotal tracks gRPC requests rejected by rate limits
+	GRPCRateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_grpc_rate_limited_total",
+			Help: "Total number of gRPC requests rejected by rate or concurrency limits",
+		},
+		[]string{"method", "limit"},
+	)
+
+	// GRPCMutationsInFlight tracks CreateVM/DeleteVM calls in progress
+	GRPCMutationsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "firecracker_grpc_mutations_in_flight",
+		Help: "Number of CreateVM and DeleteVM calls in progress",
+	})
+
+	// IdempotentReplays counts retries answered with the result of an
+	// earlier call with the same request_id
+	IdempotentReplays = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_grpc_idempotent_replays_total",
+			Help: "Total number of retried calls answered with the result of the original call",
+		},
+		[]string{"method"},
+	)
+
+	// EventSubscriberOverflows counts events a watcher could not queue and
+	// has to catch up on from the journal
+	EventSubscriberOverflows = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "firecracker_event_subscriber_overflows_total",
+		Help: "Total number of events that did not fit in a watcher's buffer",
+	})
+
+	// EventsLostTotal counts EVENTS_LOST signals sent to watchers
+	EventsLostTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "firecracker_events_lost_total",
+		Help: "Total number of times watchers were told to resync because events were no longer retained",
+	})
+
+	// WebhookDeliveries counts webhook delivery attempts by outcome:
+	// delivered, failed (to be retried) or dropped (out of attempts)
+	WebhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts by result",
+		},
+		[]string{"sink", "result"},
+	)
+
+	// WebhookDeliveryDuration tracks the duration of webhook requests
+	WebhookDeliveryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "firecracker_webhook_delivery_duration_seconds",
+			Help:    "Duration of webhook delivery requests",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"sink"},
+	)
+
+	// WebhookBacklog tracks the journaled events a sink has yet to process
+	WebhookBacklog = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_webhook_backlog_events",
+			Help: "Number of journaled events a webhook sink has not processed yet",
+		},
+		[]string{"sink"},
+	)
+
+	// WebhookEventsLost counts events pruned from the journal before a sink
+	// delivered them
+	WebhookEventsLost = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_webhook_events_lost_total",
+			Help: "Total number of events pruned from the journal before a webhook sink delivered them",
+		},
+		[]string{"sink"},
+	)
 )
 
 func init() {
 	// Register metrics
 	prometheus.MustRegister(VMsCreated)
 	prometheus.MustRegister(VMsRunning)
+	prometheus.MustRegister(VMsAdmissionRejected)
 	prometheus.MustRegister(VMOperationDuration)
 	prometheus.MustRegister(GRPCRequestsTotal)
+	prometheus.MustRegister(GRPCRateLimitedTotal)
+	prometheus.MustRegister(GRPCMutationsInFlight)
+	prometheus.MustRegister(IdempotentReplays)
+	prometheus.MustRegister(EventSubscriberOverflows)
+	prometheus.MustRegister(EventsLostTotal)
+	prometheus.MustRegister(WebhookDeliveries)
+	prometheus.MustRegister(WebhookDeliveryDuration)
+	prometheus.MustRegister(WebhookBacklog)
+	prometheus.MustRegister(WebhookEventsLost)
 }
 
 // MetricsServer serves Prometheus metrics
 type MetricsServer struct {
-	port int
-	log  *logrus.Logger
+	port    int
+	healthy func() bool
+	log     *logrus.Logger
 }
 
-// NewMetricsServer creates a new metrics server
-func NewMetricsServer(port int, log *logrus.Logger) *MetricsServer {
+// NewMetricsServer creates a new metrics server. The /health endpoint
+// reports the result of healthy.
+func NewMetricsServer(port int, healthy func() bool, log *logrus.Logger) *MetricsServer {
 	return &MetricsServer{
-		port: port,
-		log:  log,
+		port:    port,
+		healthy: healthy,
+		log:     log,
 	}
 }
 
@@ -71,6 +167,11 @@ func (s *MetricsServer) Start() error {
 
 	// Health endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if !s.healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("UNHEALTHY"))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
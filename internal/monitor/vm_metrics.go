@@ -0,0 +1,214 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Per-VM metrics ingested from the Firecracker metrics FIFO. Firecracker
+// reports counters as deltas since the previous flush, so they are added
+// to the Prometheus counters as they arrive. Host resource usage sampled
+// from cgroups is already cumulative and is exported as gauges.
+var (
+	// VMVcpuExitsTotal tracks vCPU exits by exit type
+	VMVcpuExitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_vm_vcpu_exits_total",
+			Help: "Total number of vCPU exits reported by Firecracker",
+		},
+		[]string{"vm_id", "type"},
+	)
+
+	// VMBlockBytesTotal tracks bytes read from and written to block devices
+	VMBlockBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_vm_block_bytes_total",
+			Help: "Total bytes transferred by VM block devices",
+		},
+		[]string{"vm_id", "drive", "direction"},
+	)
+
+	// VMBlockOpsTotal tracks block device read and write operations
+	VMBlockOpsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_vm_block_ops_total",
+			Help: "Total operations performed by VM block devices",
+		},
+		[]string{"vm_id", "drive", "direction"},
+	)
+
+	// VMNetBytesTotal tracks bytes received and transmitted by network interfaces
+	VMNetBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_vm_net_bytes_total",
+			Help: "Total bytes transferred by VM network interfaces",
+		},
+		[]string{"vm_id", "iface", "direction"},
+	)
+
+	// VMNetPacketsTotal tracks packets received and transmitted by network interfaces
+	VMNetPacketsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_vm_net_packets_total",
+			Help: "Total packets transferred by VM network interfaces",
+		},
+		[]string{"vm_id", "iface", "direction"},
+	)
+
+	// VMRateLimiterThrottledTotal tracks rate limiter throttle events per device
+	VMRateLimiterThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_vm_rate_limiter_throttled_total",
+			Help: "Total number of rate limiter throttle events per VM device",
+		},
+		[]string{"vm_id", "device", "direction"},
+	)
+
+	// VMMMDSRequestsTotal tracks MMDS requests by result
+	VMMMDSRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_vm_mmds_requests_total",
+			Help: "Total number of MMDS requests handled by Firecracker",
+		},
+		[]string{"vm_id", "result"},
+	)
+
+	// VMSignalsTotal tracks signals received by the Firecracker process
+	VMSignalsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "firecracker_vm_signals_total",
+			Help: "Total number of signals received by the Firecracker process",
+		},
+		[]string{"vm_id", "signal"},
+	)
+
+	// VMCPUSeconds tracks CPU time consumed on the host by a VM
+	VMCPUSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_cpu_seconds",
+			Help: "Cumulative host CPU time consumed by the VM",
+		},
+		[]string{"vm_id", "mode"},
+	)
+
+	// VMCPUThrottledSeconds tracks time a VM was throttled by its CPU quota
+	VMCPUThrottledSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_cpu_throttled_seconds",
+			Help: "Cumulative time the VM was throttled by its cgroup CPU quota",
+		},
+		[]string{"vm_id"},
+	)
+
+	// VMCPUThrottledPeriods tracks the number of throttled CPU periods
+	VMCPUThrottledPeriods = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_cpu_throttled_periods",
+			Help: "Cumulative number of cgroup CPU periods in which the VM was throttled",
+		},
+		[]string{"vm_id"},
+	)
+
+	// VMMemoryRSSBytes tracks resident memory of a VM on the host
+	VMMemoryRSSBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_memory_rss_bytes",
+			Help: "Resident anonymous memory of the VM on the host",
+		},
+		[]string{"vm_id"},
+	)
+
+	// VMMemoryUsageBytes tracks total memory charged to a VM
+	VMMemoryUsageBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_memory_usage_bytes",
+			Help: "Total host memory charged to the VM",
+		},
+		[]string{"vm_id"},
+	)
+
+	// VMPageFaults tracks page faults taken by a VM on the host
+	VMPageFaults = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_page_faults",
+			Help: "Cumulative page faults taken by the VM on the host",
+		},
+		[]string{"vm_id", "type"},
+	)
+
+	// VMIOBytes tracks host IO performed on behalf of a VM
+	VMIOBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_io_bytes",
+			Help: "Cumulative host IO bytes performed by the VM",
+		},
+		[]string{"vm_id", "direction"},
+	)
+
+	// VMIOOps tracks host IO operations performed on behalf of a VM
+	VMIOOps = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_io_ops",
+			Help: "Cumulative host IO operations performed by the VM",
+		},
+		[]string{"vm_id", "direction"},
+	)
+
+	// VMMetricsLastFlush tracks the timestamp of the last metrics sample
+	VMMetricsLastFlush = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "firecracker_vm_metrics_last_flush_timestamp_seconds",
+			Help: "Unix timestamp of the last metrics sample received from Firecracker",
+		},
+		[]string{"vm_id"},
+	)
+)
+
+// vmCollectors lists every per-VM metric vector so series can be removed
+// when a VM is deleted.
+var vmCollectors = []*prometheus.MetricVec{
+	VMVcpuExitsTotal.MetricVec,
+	VMBlockBytesTotal.MetricVec,
+	VMBlockOpsTotal.MetricVec,
+	VMNetBytesTotal.MetricVec,
+	VMNetPacketsTotal.MetricVec,
+	VMRateLimiterThrottledTotal.MetricVec,
+	VMMMDSRequestsTotal.MetricVec,
+	VMSignalsTotal.MetricVec,
+	VMMetricsLastFlush.MetricVec,
+	VMCPUSeconds.MetricVec,
+	VMCPUThrottledSeconds.MetricVec,
+	VMCPUThrottledPeriods.MetricVec,
+	VMMemoryRSSBytes.MetricVec,
+	VMMemoryUsageBytes.MetricVec,
+	VMPageFaults.MetricVec,
+	VMIOBytes.MetricVec,
+	VMIOOps.MetricVec,
+}
+
+func init() {
+	prometheus.MustRegister(VMVcpuExitsTotal)
+	prometheus.MustRegister(VMBlockBytesTotal)
+	prometheus.MustRegister(VMBlockOpsTotal)
+	prometheus.MustRegister(VMNetBytesTotal)
+	prometheus.MustRegister(VMNetPacketsTotal)
+	prometheus.MustRegister(VMRateLimiterThrottledTotal)
+	prometheus.MustRegister(VMMMDSRequestsTotal)
+	prometheus.MustRegister(VMSignalsTotal)
+	prometheus.MustRegister(VMMetricsLastFlush)
+	prometheus.MustRegister(VMCPUSeconds)
+	prometheus.MustRegister(VMCPUThrottledSeconds)
+	prometheus.MustRegister(VMCPUThrottledPeriods)
+	prometheus.MustRegister(VMMemoryRSSBytes)
+	prometheus.MustRegister(VMMemoryUsageBytes)
+	prometheus.MustRegister(VMPageFaults)
+	prometheus.MustRegister(VMIOBytes)
+	prometheus.MustRegister(VMIOOps)
+}
+
+// DeleteVMMetrics removes all per-VM series for the given VM
+func DeleteVMMetrics(vmID string) {
+	labels := prometheus.Labels{"vm_id": vmID}
+	for _, vec := range vmCollectors {
+		vec.DeletePartialMatch(labels)
+	}
+}
@@ -13,9 +13,11 @@ import (
 // StorageManager defines the interface for VM storage management.
 type StorageManager interface {
 	PrepareVMStorage(vmID, kernelPath, rootfsPath string) (*VMStorage, error)
+	ReuseVMStorage(vmID, kernelPath string) (*VMStorage, error)
 	CleanupVMStorage(vmID string) error
 	SetupJailDirectory(vmID, kernelPath, rootfsPath string) (*JailPaths, error)
 	CleanupJail(vmID string) error
+	ResetJail(vmID string) error
 	EnsureVMsDir() error
 }
 
@@ -40,11 +42,12 @@ func NewManager(vmsDir string, useOverlay bool, log *logrus.Logger) *Manager {
 
 // VMStorage represents VM storage paths
 type VMStorage struct {
-	VMDir      string
-	RootfsPath string
-	KernelPath string
-	SocketPath string
-	LogPath    string
+	VMDir       string
+	RootfsPath  string
+	KernelPath  string
+	SocketPath  string
+	LogPath     string
+	MetricsPath string
 }
 
 // JailPaths represents paths for a jailed VM
@@ -55,6 +58,8 @@ type JailPaths struct {
 	RootfsPath        string // Path to rootfs
 	SocketPath        string // Path to socket
 	LogPath           string // Path to logs
+	MetricsPath       string // Host path to metrics FIFO
+	Reuse             bool   // Keep the kernel and rootfs already in the jail
 }
 
 // PrepareVMStorage prepares storage directories and files for a VM
@@ -71,19 +76,10 @@ func (m *Manager) PrepareVMStorage(vmID, kernelPath, rootfsPath string) (*VMStor
 		return nil, fmt.Errorf("failed to create VM directory: %w", err)
 	}
 
-	storage := &VMStorage{
-		VMDir:      vmDir,
-		SocketPath: filepath.Join(vmDir, "firecracker.socket"),
-		LogPath:    filepath.Join(vmDir, "firecracker.log"),
-	}
+	storage := m.storagePaths(vmID, kernelPath)
 
-	// Handle kernel
-	if m.useOverlay {
-		// Create symlink to shared kernel
-		storage.KernelPath = kernelPath
-	} else {
-		// Copy kernel to VM directory
-		storage.KernelPath = filepath.Join(vmDir, "vmlinux.bin")
+	// Handle kernel: overlays share the source kernel, copies get their own
+	if !m.useOverlay {
 		if err := fileutil.CopyFile(kernelPath, storage.KernelPath); err != nil {
 			return nil, fmt.Errorf("failed to copy kernel: %w", err)
 		}
@@ -92,14 +88,11 @@ func (m *Manager) PrepareVMStorage(vmID, kernelPath, rootfsPath string) (*VMStor
 	// Handle rootfs
 	if m.useOverlay {
 		// Create overlay filesystem for rootfs
-		overlayPath := filepath.Join(vmDir, "rootfs.ext4")
-		if err := m.createOverlay(rootfsPath, overlayPath, vmDir); err != nil {
+		if err := m.createOverlay(rootfsPath, storage.RootfsPath, vmDir); err != nil {
 			return nil, fmt.Errorf("failed to create overlay: %w", err)
 		}
-		storage.RootfsPath = overlayPath
 	} else {
 		// Copy rootfs to VM directory
-		storage.RootfsPath = filepath.Join(vmDir, "rootfs.ext4")
 		if err := fileutil.CopyFile(rootfsPath, storage.RootfsPath); err != nil {
 			return nil, fmt.Errorf("failed to copy rootfs: %w", err)
 		}
@@ -114,6 +107,46 @@ func (m *Manager) PrepareVMStorage(vmID, kernelPath, rootfsPath string) (*VMStor
 	return storage, nil
 }
 
+// ReuseVMStorage returns the storage PrepareVMStorage set up for a VM,
+// keeping the guest's rootfs, e.g. to relaunch the VM after its process
+// exited
+func (m *Manager) ReuseVMStorage(vmID, kernelPath string) (*VMStorage, error) {
+	storage := m.storagePaths(vmID, kernelPath)
+	if err := verifyExists(storage.RootfsPath, "rootfs"); err != nil {
+		return nil, err
+	}
+	if err := verifyExists(storage.KernelPath, "kernel"); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// storagePaths returns the storage paths of a VM
+func (m *Manager) storagePaths(vmID, kernelPath string) *VMStorage {
+	vmDir := filepath.Join(m.vmsDir, vmID)
+	storage := &VMStorage{
+		VMDir:       vmDir,
+		RootfsPath:  filepath.Join(vmDir, "rootfs.ext4"),
+		KernelPath:  filepath.Join(vmDir, "vmlinux.bin"),
+		SocketPath:  filepath.Join(vmDir, "firecracker.socket"),
+		LogPath:     filepath.Join(vmDir, "firecracker.log"),
+		MetricsPath: filepath.Join(vmDir, "metrics.fifo"),
+	}
+	if m.useOverlay {
+		// Overlays use the shared kernel
+		storage.KernelPath = kernelPath
+	}
+	return storage
+}
+
+// verifyExists checks that a file of the VM's storage exists
+func verifyExists(path, what string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("VM %s not found at %s: %w", what, path, err)
+	}
+	return nil
+}
+
 // CleanupVMStorage removes VM storage
 func (m *Manager) CleanupVMStorage(vmID string) error {
 	vmDir := filepath.Join(m.vmsDir, vmID)
@@ -234,3 +267,22 @@ func (m *Manager) CleanupJail(vmID string) error {
 
 	return nil
 }
+
+// ResetJail removes what the jailer created in a jail, its device nodes and
+// run directory, so the VM can be jailed again. The kernel and rootfs
+// copied into the jail are kept.
+func (m *Manager) ResetJail(vmID string) error {
+	jailRootDir := filepath.Join(m.vmsDir, "firecracker", vmID, "root")
+
+	m.log.WithFields(logrus.Fields{
+		"vm_id":    vmID,
+		"jail_dir": jailRootDir,
+	}).Info("Resetting jail directory")
+
+	for _, dir := range []string{"dev", "run"} {
+		if err := os.RemoveAll(filepath.Join(jailRootDir, dir)); err != nil {
+			return fmt.Errorf("failed to reset jail directory: %w", err)
+		}
+	}
+	return nil
+}
@@ -266,6 +266,49 @@ func TestManager_CleanupVMStorage(t *testing.T) {
 	})
 }
 
+func TestManager_ReuseVMStorage(t *testing.T) {
+	tempDir := t.TempDir()
+	kernelPath := createTestFile(t, tempDir, "vmlinux", "kernel")
+	rootfsPath := createTestFile(t, tempDir, "rootfs.ext4", "rootfs")
+	manager := NewManager(filepath.Join(tempDir, "vms"), false, createTestLogger())
+
+	_, err := manager.ReuseVMStorage("test-vm", kernelPath)
+	assert.ErrorContains(t, err, "VM rootfs not found")
+
+	prepared, err := manager.PrepareVMStorage("test-vm", kernelPath, rootfsPath)
+	require.NoError(t, err)
+	// The guest writes to its disk
+	require.NoError(t, os.WriteFile(prepared.RootfsPath, []byte("guest data"), 0644))
+
+	reused, err := manager.ReuseVMStorage("test-vm", kernelPath)
+	require.NoError(t, err)
+	assert.Equal(t, prepared, reused)
+	content, err := os.ReadFile(reused.RootfsPath)
+	require.NoError(t, err)
+	assert.Equal(t, "guest data", string(content))
+}
+
+func TestManager_ResetJail(t *testing.T) {
+	vmsDir := t.TempDir()
+	manager := NewManager(vmsDir, false, createTestLogger())
+
+	jailRootDir := filepath.Join(vmsDir, "firecracker", "test-vm", "root")
+	for _, dir := range []string{"dev/net", "run"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(jailRootDir, dir), 0755))
+	}
+	createTestFile(t, jailRootDir, "rootfs.ext4", "guest data")
+	createTestFile(t, jailRootDir, "vmlinux", "kernel")
+
+	require.NoError(t, manager.ResetJail("test-vm"))
+	assert.NoDirExists(t, filepath.Join(jailRootDir, "dev"))
+	assert.NoDirExists(t, filepath.Join(jailRootDir, "run"))
+	assert.FileExists(t, filepath.Join(jailRootDir, "rootfs.ext4"))
+	assert.FileExists(t, filepath.Join(jailRootDir, "vmlinux"))
+
+	// Jails that do not exist need no reset
+	require.NoError(t, manager.ResetJail("test-vm-missing"))
+}
+
 func TestCopyFile(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -0,0 +1,167 @@
+package topology
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Node is a NUMA node and the online CPUs that belong to it
+type Node struct {
+	ID   int
+	CPUs []int
+}
+
+// Topology describes the host CPU and NUMA layout
+type Topology struct {
+	Nodes []Node
+}
+
+// CPUs returns all online CPUs across nodes, sorted
+func (t *Topology) CPUs() []int {
+	var cpus []int
+	for _, n := range t.Nodes {
+		cpus = append(cpus, n.CPUs...)
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
+// Node returns the node with the given ID
+func (t *Topology) Node(id int) (Node, bool) {
+	for _, n := range t.Nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+// Discover reads the host topology from sysfs (normally "/sys"). Hosts
+// without NUMA support are reported as a single node 0 holding every
+// online CPU.
+func Discover(sysfsRoot string) (*Topology, error) {
+	onlineData, err := os.ReadFile(filepath.Join(sysfsRoot, "devices/system/cpu/online"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read online CPUs: %w", err)
+	}
+	online, err := ParseCPUList(string(onlineData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse online CPUs: %w", err)
+	}
+	onlineSet := make(map[int]bool, len(online))
+	for _, cpu := range online {
+		onlineSet[cpu] = true
+	}
+
+	nodeDirs, _ := filepath.Glob(filepath.Join(sysfsRoot, "devices/system/node/node[0-9]*"))
+	if len(nodeDirs) == 0 {
+		return &Topology{Nodes: []Node{{ID: 0, CPUs: online}}}, nil
+	}
+
+	topo := &Topology{}
+	for _, dir := range nodeDirs {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "cpulist"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CPUs of NUMA node %d: %w", id, err)
+		}
+		nodeCPUs, err := ParseCPUList(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CPUs of NUMA node %d: %w", id, err)
+		}
+
+		// Offline CPUs are listed in cpulist but cannot be used
+		var cpus []int
+		for _, cpu := range nodeCPUs {
+			if onlineSet[cpu] {
+				cpus = append(cpus, cpu)
+			}
+		}
+
+		// Memory-only nodes have no CPUs to place VMs on
+		if len(cpus) > 0 {
+			topo.Nodes = append(topo.Nodes, Node{ID: id, CPUs: cpus})
+		}
+	}
+
+	sort.Slice(topo.Nodes, func(i, j int) bool { return topo.Nodes[i].ID < topo.Nodes[j].ID })
+
+	return topo, nil
+}
+
+// ParseCPUList parses a kernel CPU list such as "0-3,8,10-11"
+func ParseCPUList(list string) ([]int, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+
+	seen := make(map[int]bool)
+	var cpus []int
+	for _, part := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid CPU list %q", list)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid CPU list %q", list)
+			}
+		}
+
+		for cpu := start; cpu <= end; cpu++ {
+			if !seen[cpu] {
+				seen[cpu] = true
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// FormatCPUList formats CPUs as a compact kernel CPU list ("0-3,8")
+func FormatCPUList(cpus []int) string {
+	if len(cpus) == 0 {
+		return ""
+	}
+
+	sorted := append([]int(nil), cpus...)
+	sort.Ints(sorted)
+
+	var parts []string
+	start, prev := sorted[0], sorted[0]
+	flush := func() {
+		if start == prev {
+			parts = append(parts, strconv.Itoa(start))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", start, prev))
+		}
+	}
+	for _, cpu := range sorted[1:] {
+		if cpu == prev {
+			continue
+		}
+		if cpu != prev+1 {
+			flush()
+			start = cpu
+		}
+		prev = cpu
+	}
+	flush()
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,102 @@
+package topology
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createFakeSysfs builds a minimal /sys tree with the given online list and node CPU lists
+func createFakeSysfs(t *testing.T, online string, nodes map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	cpuDir := filepath.Join(root, "devices/system/cpu")
+	require.NoError(t, os.MkdirAll(cpuDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(cpuDir, "online"), []byte(online+"\n"), 0644))
+
+	for name, cpulist := range nodes {
+		nodeDir := filepath.Join(root, "devices/system/node", name)
+		require.NoError(t, os.MkdirAll(nodeDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(nodeDir, "cpulist"), []byte(cpulist+"\n"), 0644))
+	}
+
+	return root
+}
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    []int
+		expectError bool
+	}{
+		{input: "", expected: nil},
+		{input: "0", expected: []int{0}},
+		{input: "0-3", expected: []int{0, 1, 2, 3}},
+		{input: "0-1,4,6-7\n", expected: []int{0, 1, 4, 6, 7}},
+		{input: "3,1,2-3", expected: []int{1, 2, 3}},
+		{input: "a-b", expectError: true},
+		{input: "3-1", expectError: true},
+		{input: "-1", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			cpus, err := ParseCPUList(tt.input)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, cpus)
+		})
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	assert.Equal(t, "", FormatCPUList(nil))
+	assert.Equal(t, "5", FormatCPUList([]int{5}))
+	assert.Equal(t, "0-3", FormatCPUList([]int{3, 2, 1, 0}))
+	assert.Equal(t, "0-1,4,6-7", FormatCPUList([]int{0, 1, 4, 6, 7}))
+	assert.Equal(t, "1-2", FormatCPUList([]int{1, 1, 2}))
+}
+
+func TestDiscover(t *testing.T) {
+	t.Run("multiple NUMA nodes", func(t *testing.T) {
+		root := createFakeSysfs(t, "0-6", map[string]string{
+			"node0": "0-3",
+			"node1": "4-7", // CPU 7 is offline
+			"node2": "",    // memory-only node
+		})
+
+		topo, err := Discover(root)
+		require.NoError(t, err)
+		require.Len(t, topo.Nodes, 2)
+		assert.Equal(t, Node{ID: 0, CPUs: []int{0, 1, 2, 3}}, topo.Nodes[0])
+		assert.Equal(t, Node{ID: 1, CPUs: []int{4, 5, 6}}, topo.Nodes[1])
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6}, topo.CPUs())
+
+		node, ok := topo.Node(1)
+		assert.True(t, ok)
+		assert.Equal(t, 1, node.ID)
+		_, ok = topo.Node(2)
+		assert.False(t, ok)
+	})
+
+	t.Run("no NUMA support", func(t *testing.T) {
+		root := createFakeSysfs(t, "0-1", nil)
+
+		topo, err := Discover(root)
+		require.NoError(t, err)
+		require.Len(t, topo.Nodes, 1)
+		assert.Equal(t, Node{ID: 0, CPUs: []int{0, 1}}, topo.Nodes[0])
+	})
+
+	t.Run("missing sysfs", func(t *testing.T) {
+		_, err := Discover(t.TempDir())
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,327 @@
+// Package webhook pushes VM events to HTTP endpoints. Each sink delivers
+// the journaled events in order and records the sequence of the last event
+// it processed, so deliveries resume after a sink outage or agent restart.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/monitor"
+	"github.com/spluca/firecracker-agent/internal/version"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Delivery headers. The signature is "sha256=" followed by the hex encoded
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the sink secret.
+const (
+	HeaderEvent     = "X-Fc-Agent-Event"
+	HeaderSequence  = "X-Fc-Agent-Sequence"
+	HeaderTimestamp = "X-Fc-Agent-Timestamp"
+	HeaderSignature = "X-Fc-Agent-Signature"
+)
+
+var jsonOptions = protojson.MarshalOptions{UseProtoNames: true}
+
+// Source is the numbered event history sinks deliver from
+type Source interface {
+	// Since returns the events after a sequence, and whether none of them
+	// were dropped from the history
+	Since(after uint64) ([]*pb.VMEvent, bool)
+	// LastSequence returns the sequence of the last event
+	LastSequence() uint64
+}
+
+// Dispatcher runs one delivery worker per sink
+type Dispatcher struct {
+	sinks  []*sink
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// sink delivers events to one endpoint
+type sink struct {
+	cfg        config.WebhookSinkConfig
+	secret     []byte
+	types      map[pb.EventType]bool // nil accepts every type
+	source     Source
+	client     *http.Client
+	cursorPath string
+	cursor     uint64 // sequence of the last processed event
+	wake       chan struct{}
+	log        *logrus.Entry
+}
+
+// New creates a dispatcher for the configured sinks. Sinks without a
+// stored cursor start after the last event in source.
+func New(cfg config.WebhooksConfig, source Source, log *logrus.Logger) (*Dispatcher, error) {
+	if err := os.MkdirAll(cfg.StateDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create webhook state directory: %w", err)
+	}
+
+	d := &Dispatcher{}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, sc := range cfg.Sinks {
+		s, err := newSink(sc, cfg.StateDir, source, log)
+		if err != nil {
+			return nil, fmt.Errorf("webhook sink %q: %w", sc.Name, err)
+		}
+		d.sinks = append(d.sinks, s)
+	}
+	return d, nil
+}
+
+func newSink(cfg config.WebhookSinkConfig, stateDir string, source Source, log *logrus.Logger) (*sink, error) {
+	secret, err := os.ReadFile(cfg.SecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret file %s is empty", cfg.SecretFile)
+	}
+
+	types, err := parseEventTypes(cfg.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sink{
+		cfg:        cfg,
+		secret:     secret,
+		types:      types,
+		source:     source,
+		client:     &http.Client{},
+		cursorPath: filepath.Join(stateDir, cfg.Name+".cursor"),
+		wake:       make(chan struct{}, 1),
+		log:        log.WithField("sink", cfg.Name),
+	}
+
+	cursor, found, err := readCursor(s.cursorPath)
+	if err != nil {
+		return nil, err
+	}
+	if !found || cursor > source.LastSequence() {
+		// New sinks only get new events, and a cursor beyond the journal
+		// means the journal was reset
+		cursor = source.LastSequence()
+		if err := s.saveCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	s.cursor = cursor
+	return s, nil
+}
+
+// parseEventTypes accepts names with or without the EVENT_TYPE_ prefix
+func parseEventTypes(names []string) (map[pb.EventType]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	types := make(map[pb.EventType]bool)
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if !strings.HasPrefix(name, "EVENT_TYPE_") {
+			name = "EVENT_TYPE_" + name
+		}
+		value, ok := pb.EventType_value[name]
+		if !ok || value == 0 {
+			return nil, fmt.Errorf("unknown event type %q", name)
+		}
+		types[pb.EventType(value)] = true
+	}
+	return types, nil
+}
+
+// Start launches the delivery workers
+func (d *Dispatcher) Start() {
+	for _, s := range d.sinks {
+		d.wg.Add(1)
+		go func(s *sink) {
+			defer d.wg.Done()
+			s.run(d.ctx)
+		}(s)
+	}
+}
+
+// Notify tells the sinks new events were journaled. It never blocks.
+func (d *Dispatcher) Notify() {
+	for _, s := range d.sinks {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close stops the workers. Undelivered events stay in the journal and are
+// delivered after the next start.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// run delivers events as they are journaled until ctx is cancelled
+func (s *sink) run(ctx context.Context) {
+	for {
+		events, complete := s.source.Since(s.cursor)
+		if !complete && len(events) > 0 {
+			lost := events[0].Sequence - s.cursor - 1
+			monitor.WebhookEventsLost.WithLabelValues(s.cfg.Name).Add(float64(lost))
+			s.log.WithFields(logrus.Fields{
+				"after": s.cursor,
+				"lost":  lost,
+			}).Error("Events were pruned from the journal before delivery")
+		}
+
+		for _, event := range events {
+			if s.types == nil || s.types[event.Type] {
+				if !s.deliver(ctx, event) {
+					return
+				}
+			}
+			s.advance(event.Sequence)
+		}
+		monitor.WebhookBacklog.WithLabelValues(s.cfg.Name).Set(float64(s.source.LastSequence() - s.cursor))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+	}
+}
+
+// deliver posts an event until it is accepted, the attempts run out or ctx
+// is cancelled. It returns false only when cancelled.
+func (s *sink) deliver(ctx context.Context, event *pb.VMEvent) bool {
+	body, err := jsonOptions.Marshal(event)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to encode event")
+		return true
+	}
+
+	backoff := s.cfg.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := s.post(ctx, event, body)
+		monitor.WebhookDeliveryDuration.WithLabelValues(s.cfg.Name).Observe(time.Since(start).Seconds())
+		if err == nil {
+			monitor.WebhookDeliveries.WithLabelValues(s.cfg.Name, "delivered").Inc()
+			return true
+		}
+
+		if ctx.Err() != nil {
+			return false
+		}
+		monitor.WebhookDeliveries.WithLabelValues(s.cfg.Name, "failed").Inc()
+		entry := s.log.WithError(err).WithFields(logrus.Fields{
+			"sequence": event.Sequence,
+			"attempt":  attempt,
+		})
+		if s.cfg.MaxAttempts > 0 && attempt >= s.cfg.MaxAttempts {
+			monitor.WebhookDeliveries.WithLabelValues(s.cfg.Name, "dropped").Inc()
+			entry.Error("Dropping webhook event after the last attempt")
+			return true
+		}
+		entry.WithField("retry_in", backoff).Warn("Webhook delivery failed")
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, s.cfg.MaxBackoff)
+	}
+}
+
+// post sends one signed delivery request
+func (s *sink) post(ctx context.Context, event *pb.VMEvent, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fc-agent/"+version.Version)
+	req.Header.Set(HeaderEvent, event.Type.String())
+	req.Header.Set(HeaderSequence, strconv.FormatUint(event.Sequence, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(s.secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value of a delivery
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// advance records that the events up to seq were processed
+func (s *sink) advance(seq uint64) {
+	if seq <= s.cursor {
+		return
+	}
+	s.cursor = seq
+	if err := s.saveCursor(seq); err != nil {
+		s.log.WithError(err).Error("Failed to save webhook cursor")
+	}
+}
+
+// saveCursor atomically replaces the cursor file
+func (s *sink) saveCursor(seq uint64) error {
+	tmp := s.cursorPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(seq, 10)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write webhook cursor: %w", err)
+	}
+	if err := os.Rename(tmp, s.cursorPath); err != nil {
+		return fmt.Errorf("failed to write webhook cursor: %w", err)
+	}
+	return nil
+}
+
+// readCursor reads a cursor file, reporting whether it exists
+func readCursor(path string) (uint64, bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read webhook cursor: %w", err)
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid webhook cursor %s: %w", path, err)
+	}
+	return seq, true, nil
+}
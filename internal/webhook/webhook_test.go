@@ -0,0 +1,228 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	pb "github.com/spluca/firecracker-agent/api/proto/firecracker/v1"
+	"github.com/spluca/firecracker-agent/internal/events"
+	"github.com/spluca/firecracker-agent/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const testSecret = "s3cr3t"
+
+// receiver is a webhook endpoint recording verified deliveries. Requests
+// are answered with the queued status codes, then with 204.
+type receiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	events   chan *pb.VMEvent
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, statuses: statuses, events: make(chan *pb.VMEvent, 100)}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, srv
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	require.NoError(r.t, err)
+	assert.Equal(r.t, Sign([]byte(testSecret), req.Header.Get(HeaderTimestamp), body), req.Header.Get(HeaderSignature))
+	assert.Equal(r.t, "application/json", req.Header.Get("Content-Type"))
+
+	r.mu.Lock()
+	r.attempts++
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	r.mu.Unlock()
+
+	if status/100 == 2 {
+		var event pb.VMEvent
+		require.NoError(r.t, protojson.Unmarshal(body, &event))
+		assert.Equal(r.t, event.Type.String(), req.Header.Get(HeaderEvent))
+		r.events <- &event
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) next() *pb.VMEvent {
+	r.t.Helper()
+	select {
+	case event := <-r.events:
+		return event
+	case <-time.After(5 * time.Second):
+		r.t.Fatal("timed out waiting for delivery")
+		return nil
+	}
+}
+
+func sinkConfig(t *testing.T, url string) config.WebhookSinkConfig {
+	t.Helper()
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte(testSecret+"\n"), 0600))
+	return config.WebhookSinkConfig{
+		Name:           "orchestrator",
+		URL:            url,
+		SecretFile:     secretFile,
+		Timeout:        time.Second,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	return log
+}
+
+// startDispatcher starts a dispatcher delivering from journal
+func startDispatcher(t *testing.T, stateDir string, journal *events.Journal, sinks ...config.WebhookSinkConfig) *Dispatcher {
+	t.Helper()
+	d, err := New(config.WebhooksConfig{StateDir: stateDir, Sinks: sinks}, journal, testLogger())
+	require.NoError(t, err)
+	d.Start()
+	t.Cleanup(d.Close)
+	return d
+}
+
+func appendEvent(t *testing.T, journal *events.Journal, d *Dispatcher, vmID string, eventType pb.EventType) {
+	t.Helper()
+	require.NoError(t, journal.Append(&pb.VMEvent{VmId: vmID, Type: eventType}))
+	d.Notify()
+}
+
+func TestDispatcher_Delivers(t *testing.T) {
+	journal, err := events.Open("", 100)
+	require.NoError(t, err)
+	require.NoError(t, journal.Append(&pb.VMEvent{VmId: "old"}))
+
+	r, srv := newReceiver(t)
+	d := startDispatcher(t, t.TempDir(), journal, sinkConfig(t, srv.URL))
+
+	// Events from before the sink existed are not delivered
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_CREATED)
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_CRASHED)
+
+	first := r.next()
+	assert.Equal(t, "vm-1", first.VmId)
+	assert.Equal(t, uint64(2), first.Sequence)
+	assert.Equal(t, pb.EventType_EVENT_TYPE_CRASHED, r.next().Type)
+}
+
+func TestDispatcher_EventTypeFilter(t *testing.T) {
+	journal, err := events.Open("", 100)
+	require.NoError(t, err)
+
+	r, srv := newReceiver(t)
+	cfg := sinkConfig(t, srv.URL)
+	cfg.EventTypes = []string{"crashed", "EVENT_TYPE_DELETED"}
+	d := startDispatcher(t, t.TempDir(), journal, cfg)
+
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_CREATED)
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_CRASHED)
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_STARTED)
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_DELETED)
+
+	assert.Equal(t, pb.EventType_EVENT_TYPE_CRASHED, r.next().Type)
+	assert.Equal(t, pb.EventType_EVENT_TYPE_DELETED, r.next().Type)
+
+	cfg.EventTypes = []string{"EXPLODED"}
+	_, err = New(config.WebhooksConfig{StateDir: t.TempDir(), Sinks: []config.WebhookSinkConfig{cfg}}, journal, testLogger())
+	assert.ErrorContains(t, err, "unknown event type")
+}
+
+func TestDispatcher_RetriesInOrder(t *testing.T) {
+	journal, err := events.Open("", 100)
+	require.NoError(t, err)
+
+	r, srv := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	d := startDispatcher(t, t.TempDir(), journal, sinkConfig(t, srv.URL))
+
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_CREATED)
+	appendEvent(t, journal, d, "vm-2", pb.EventType_EVENT_TYPE_CREATED)
+
+	assert.Equal(t, "vm-1", r.next().VmId)
+	assert.Equal(t, "vm-2", r.next().VmId)
+	r.mu.Lock()
+	assert.Equal(t, 4, r.attempts)
+	r.mu.Unlock()
+}
+
+func TestDispatcher_DropsAfterMaxAttempts(t *testing.T) {
+	journal, err := events.Open("", 100)
+	require.NoError(t, err)
+
+	r, srv := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError)
+	cfg := sinkConfig(t, srv.URL)
+	cfg.MaxAttempts = 2
+	d := startDispatcher(t, t.TempDir(), journal, cfg)
+
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_CREATED)
+	appendEvent(t, journal, d, "vm-2", pb.EventType_EVENT_TYPE_CREATED)
+
+	assert.Equal(t, "vm-2", r.next().VmId)
+}
+
+func TestDispatcher_ResumesAfterRestart(t *testing.T) {
+	stateDir := t.TempDir()
+	journalDir := t.TempDir()
+	journal, err := events.Open(journalDir, 100)
+	require.NoError(t, err)
+
+	r, srv := newReceiver(t)
+	cfg := sinkConfig(t, srv.URL)
+	d := startDispatcher(t, stateDir, journal, cfg)
+
+	appendEvent(t, journal, d, "vm-1", pb.EventType_EVENT_TYPE_CREATED)
+	assert.Equal(t, uint64(1), r.next().Sequence)
+	waitCursor(t, stateDir, 1)
+	d.Close()
+
+	// Events raised while the agent is down are delivered after the restart
+	require.NoError(t, journal.Append(&pb.VMEvent{VmId: "vm-1", Type: pb.EventType_EVENT_TYPE_STOPPED}))
+	require.NoError(t, journal.Append(&pb.VMEvent{VmId: "vm-1", Type: pb.EventType_EVENT_TYPE_DELETED}))
+	require.NoError(t, journal.Close())
+
+	journal, err = events.Open(journalDir, 100)
+	require.NoError(t, err)
+	defer journal.Close()
+	startDispatcher(t, stateDir, journal, cfg)
+
+	assert.Equal(t, uint64(2), r.next().Sequence)
+	assert.Equal(t, uint64(3), r.next().Sequence)
+	waitCursor(t, stateDir, 3)
+}
+
+// waitCursor waits until the test sink has stored cursor seq. Deliveries are
+// acknowledged before the cursor is saved.
+func waitCursor(t *testing.T, stateDir string, seq uint64) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		cursor, found, err := readCursor(filepath.Join(stateDir, "orchestrator.cursor"))
+		return err == nil && found && cursor == seq
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{}' | openssl dgst -sha256 -hmac s3cr3t
+	assert.Equal(t,
+		"sha256=dd8508e44d9a9f82f2690fb7dff1da8a6ae99700d98a23a4e7e1c307af3cb6cb",
+		Sign([]byte(testSecret), "1700000000", []byte("{}")))
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ClientConfig holds the agents the command line client can talk to. Each
+// context names an agent endpoint and the credentials used to reach it.
+type ClientConfig struct {
+	CurrentContext string          `yaml:"current_context"`
+	Contexts       []ClientContext `yaml:"contexts"`
+}
+
+// ClientContext is an agent endpoint and the credentials to reach it
+type ClientContext struct {
+	Name       string          `yaml:"name"`
+	Address    string          `yaml:"address"`                // host:port or unix:///path/to/socket
+	TLS        ClientTLSConfig `yaml:"tls"`                    // Server verification and client certificate
+	APIKeyFile string          `yaml:"api_key_file,omitempty"` // File holding an API key sent as x-api-key
+	TokenFile  string          `yaml:"token_file,omitempty"`   // File holding a JWT sent as bearer token
+}
+
+// ClientTLSConfig configures TLS towards an agent
+type ClientTLSConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	CAFile     string `yaml:"ca_file,omitempty"`     // CA bundle verifying the agent, system roots if empty
+	CertFile   string `yaml:"cert_file,omitempty"`   // Client certificate for mTLS
+	KeyFile    string `yaml:"key_file,omitempty"`    // Client certificate key
+	ServerName string `yaml:"server_name,omitempty"` // Overrides the name verified in the agent certificate
+}
+
+// DefaultClientConfigPath returns the client config location,
+// $XDG_CONFIG_HOME/fc-agent/client.yaml or ~/.config/fc-agent/client.yaml
+func DefaultClientConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "client.yaml"
+	}
+	return filepath.Join(dir, "fc-agent", "client.yaml")
+}
+
+// LoadClientConfig reads the client config. A missing file yields an empty
+// config, so the client works with flags alone.
+func LoadClientConfig(path string) (*ClientConfig, error) {
+	var cfg ClientConfig
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse client config: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range cfg.Contexts {
+		if c.Name == "" {
+			return nil, fmt.Errorf("client config contexts require a name")
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("duplicate client context %q", c.Name)
+		}
+		seen[c.Name] = true
+
+		if c.Address == "" {
+			return nil, fmt.Errorf("client context %q requires an address", c.Name)
+		}
+		if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+			return nil, fmt.Errorf("client context %q: tls cert_file and key_file must be set together", c.Name)
+		}
+		if c.APIKeyFile != "" && c.TokenFile != "" {
+			return nil, fmt.Errorf("client context %q: api_key_file and token_file are mutually exclusive", c.Name)
+		}
+	}
+	if cfg.CurrentContext != "" && !seen[cfg.CurrentContext] {
+		return nil, fmt.Errorf("current_context %q is not defined", cfg.CurrentContext)
+	}
+
+	return &cfg, nil
+}
+
+// Save writes the client config, creating its directory if needed
+func (c *ClientConfig) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode client config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create client config directory: %w", err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Context returns the named context, or the current one if name is empty.
+// It returns nil when no context is selected.
+func (c *ClientConfig) Context(name string) (*ClientContext, error) {
+	if name == "" {
+		name = c.CurrentContext
+	}
+	if name == "" {
+		return nil, nil
+	}
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == name {
+			return &c.Contexts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("client context %q is not defined", name)
+}
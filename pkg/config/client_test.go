@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadClientConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "client.yaml")
+
+	// A missing file is an empty config
+	cfg, err := LoadClientConfig(configPath)
+	require.NoError(t, err)
+	c, err := cfg.Context("")
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	configContent := `
+current_context: local
+contexts:
+  - name: local
+    address: "unix:///run/fc-agent/agent.sock"
+  - name: prod
+    address: "agent1.example.com:50051"
+    tls:
+      enabled: true
+      ca_file: "/etc/fc-agent/ca.crt"
+      cert_file: "/etc/fc-agent/client.crt"
+      key_file: "/etc/fc-agent/client.key"
+    token_file: "/etc/fc-agent/token"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0600))
+	cfg, err = LoadClientConfig(configPath)
+	require.NoError(t, err)
+
+	c, err = cfg.Context("")
+	require.NoError(t, err)
+	assert.Equal(t, "unix:///run/fc-agent/agent.sock", c.Address)
+
+	c, err = cfg.Context("prod")
+	require.NoError(t, err)
+	assert.True(t, c.TLS.Enabled)
+	assert.Equal(t, "/etc/fc-agent/token", c.TokenFile)
+
+	_, err = cfg.Context("staging")
+	assert.Error(t, err)
+
+	// Saving keeps the contexts
+	cfg.CurrentContext = "prod"
+	savedPath := filepath.Join(t.TempDir(), "fc-agent", "client.yaml")
+	require.NoError(t, cfg.Save(savedPath))
+	saved, err := LoadClientConfig(savedPath)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, saved)
+
+	for _, invalid := range []string{
+		"contexts:\n  - address: \"localhost:50051\"\n",
+		"contexts:\n  - name: a\n",
+		"contexts:\n  - name: a\n    address: \":1\"\n  - name: a\n    address: \":2\"\n",
+		"current_context: b\ncontexts:\n  - name: a\n    address: \":1\"\n",
+		"contexts:\n  - name: a\n    address: \":1\"\n    tls:\n      cert_file: \"/c.crt\"\n",
+		"contexts:\n  - name: a\n    address: \":1\"\n    api_key_file: \"/k\"\n    token_file: \"/t\"\n",
+	} {
+		require.NoError(t, os.WriteFile(configPath, []byte(invalid), 0600))
+		_, err := LoadClientConfig(configPath)
+		assert.Error(t, err, invalid)
+	}
+}
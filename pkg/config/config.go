@@ -2,24 +2,82 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the agent configuration
 type Config struct {
-	Server      ServerConfig      `yaml:"server"`
-	Firecracker FirecrackerConfig `yaml:"firecracker"`
-	Network     NetworkConfig     `yaml:"network"`
-	Storage     StorageConfig     `yaml:"storage"`
-	Monitoring  MonitoringConfig  `yaml:"monitoring"`
-	Log         LogConfig         `yaml:"log"`
+	Server         ServerConfig         `yaml:"server"`
+	Firecracker    FirecrackerConfig    `yaml:"firecracker"`
+	Network        NetworkConfig        `yaml:"network"`
+	Storage        StorageConfig        `yaml:"storage"`
+	Monitoring     MonitoringConfig     `yaml:"monitoring"`
+	Log            LogConfig            `yaml:"log"`
+	Placement      PlacementConfig      `yaml:"placement"`
+	Capacity       CapacityConfig       `yaml:"capacity"`
+	Authorization  AuthorizationConfig  `yaml:"authorization"`
+	Authentication AuthenticationConfig `yaml:"authentication"`
+	Audit          AuditConfig          `yaml:"audit"`
+	RateLimit      RateLimitConfig      `yaml:"rate_limit"`
+	Idempotency    IdempotencyConfig    `yaml:"idempotency"`
+	Health         HealthConfig         `yaml:"health"`
+	Events         EventsConfig         `yaml:"events"`
+	Webhooks       WebhooksConfig       `yaml:"webhooks"`
 }
 
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	Host string    `yaml:"host"`
+	Port int       `yaml:"port"`
+	TLS  TLSConfig `yaml:"tls"`
+	// Listeners replace host/port when set, serving the API on several
+	// TCP addresses and/or Unix sockets
+	Listeners []ListenerConfig `yaml:"listeners"`
+	Gateway   GatewayConfig    `yaml:"gateway"`
+}
+
+// GatewayConfig configures the REST/JSON gateway. It serves the same API as
+// gRPC over HTTP, using the server TLS settings and the same
+// authentication, rate limits, audit log and authorization.
+type GatewayConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"` // host:port to serve HTTP on
+}
+
+// ListenerConfig is an address the gRPC server is served on
+type ListenerConfig struct {
+	Network string `yaml:"network"` // "tcp" or "unix"
+	Address string `yaml:"address"` // host:port, or the socket path for unix
+	Mode    string `yaml:"mode"`    // Octal permissions of the socket file (unix only)
+	Group   string `yaml:"group"`   // Group name or GID owning the socket file (unix only)
+}
+
+// FileMode returns the permissions of a Unix socket listener
+func (l ListenerConfig) FileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(l.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q", l.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// TLSConfig configures TLS and client certificate authentication for the
+// gRPC server. Certificates are reloaded from disk when they change or on
+// SIGHUP.
+type TLSConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	CertFile          string        `yaml:"cert_file"`
+	KeyFile           string        `yaml:"key_file"`
+	ClientCAFile      string        `yaml:"client_ca_file"`      // CA bundle used to verify client certificates
+	RequireClientCert bool          `yaml:"require_client_cert"` // Reject clients without a valid certificate
+	ReloadInterval    time.Duration `yaml:"reload_interval"`     // How often files are checked for changes
 }
 
 type FirecrackerConfig struct {
@@ -31,6 +89,25 @@ type FirecrackerConfig struct {
 	UseJailer *bool `yaml:"use_jailer"`
 	JailUID   int   `yaml:"jail_uid"`
 	JailGID   int   `yaml:"jail_gid"`
+	// cgroup v2 hierarchy: VM cgroups live at <cgroup_root>/<cgroup_parent>/<vm_id>
+	CgroupRoot   string `yaml:"cgroup_root"`
+	CgroupParent string `yaml:"cgroup_parent"`
+	// Default cgroup limits, overridable per VM in CreateVMRequest
+	ResourceLimits ResourceLimitsConfig `yaml:"resource_limits"`
+}
+
+// ResourceLimitsConfig holds the default cgroup limits for VMs.
+// A negative value disables the corresponding limit. Without the jailer the
+// defaults only apply when the section is configured, so hosts where the
+// agent cannot manage cgroups keep working.
+type ResourceLimitsConfig struct {
+	CPUQuotaPercent  int    `yaml:"cpu_quota_percent"` // per vCPU
+	CPUPeriodUs      int64  `yaml:"cpu_period_us"`
+	CPUWeight        int    `yaml:"cpu_weight"`
+	CpusetCPUs       string `yaml:"cpuset_cpus"`
+	CpusetMems       string `yaml:"cpuset_mems"`
+	MemoryOverheadMB int64  `yaml:"memory_overhead_mb"`
+	PidsMax          int64  `yaml:"pids_max"`
 }
 
 type NetworkConfig struct {
@@ -47,6 +124,127 @@ type StorageConfig struct {
 type MonitoringConfig struct {
 	Enabled     bool `yaml:"enabled"`
 	MetricsPort int  `yaml:"metrics_port"`
+	// Interval at which Firecracker is asked to flush per-VM metrics
+	VMMetricsInterval time.Duration `yaml:"vm_metrics_interval"`
+	// Interval at which per-VM host resource usage is sampled
+	VMStatsInterval time.Duration `yaml:"vm_stats_interval"`
+}
+
+// PlacementConfig controls NUMA-aware CPU placement and vCPU pinning
+type PlacementConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	ReservedCPUs string `yaml:"reserved_cpus"` // host CPUs never assigned to VMs (e.g. "0-1")
+	SysfsRoot    string `yaml:"sysfs_root"`
+}
+
+// CapacityConfig controls admission of VMs against host capacity
+type CapacityConfig struct {
+	CPUOvercommitRatio    float64 `yaml:"cpu_overcommit_ratio"`
+	MemoryOvercommitRatio float64 `yaml:"memory_overcommit_ratio"`
+	ReservedHostCPUs      int     `yaml:"reserved_host_cpus"` // CPUs kept for the host
+	ReservedMemoryMB      int64   `yaml:"reserved_memory_mb"` // memory kept for the host
+}
+
+// AuthorizationConfig enables the RPC authorization policy. The policy file
+// is reloaded on SIGHUP.
+type AuthorizationConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	PolicyFile string `yaml:"policy_file"`
+}
+
+// AuthenticationConfig configures token based authentication, accepted in
+// addition to client certificates. It is re-read on SIGHUP, so keys can be
+// revoked without a restart.
+type AuthenticationConfig struct {
+	APIKeys []APIKeyConfig `yaml:"api_keys"`
+	JWT     JWTConfig      `yaml:"jwt"`
+}
+
+// APIKeyConfig is a static API key sent in the "x-api-key" metadata header
+type APIKeyConfig struct {
+	Name   string   `yaml:"name"`   // Principal name of the key holder
+	Groups []string `yaml:"groups"` // Groups used by the authorization policy
+	SHA256 string   `yaml:"sha256"` // Hex encoded SHA-256 of the key
+}
+
+// JWTConfig configures validation of bearer JWTs against a local JWKS file
+type JWTConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	JWKSFile     string        `yaml:"jwks_file"`
+	Issuer       string        `yaml:"issuer"`        // Required "iss" claim, if set
+	Audience     string        `yaml:"audience"`      // Required "aud" claim, if set
+	SubjectClaim string        `yaml:"subject_claim"` // Claim used as principal name
+	GroupsClaim  string        `yaml:"groups_claim"`  // Claim holding the principal groups
+	Leeway       time.Duration `yaml:"leeway"`        // Allowed clock skew for exp/nbf
+}
+
+// AuditConfig configures the audit log of mutating RPCs
+type AuditConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Path      string `yaml:"path"`        // Active JSON lines file
+	MaxSizeMB int    `yaml:"max_size_mb"` // Rotate once the active file reaches this size
+	MaxFiles  int    `yaml:"max_files"`   // Rotated files to keep
+}
+
+// RateLimitConfig limits the request rate of each caller and the number of
+// VM create/delete operations running at once
+type RateLimitConfig struct {
+	Enabled                bool                 `yaml:"enabled"`
+	PerPrincipal           RateLimit            `yaml:"per_principal"`            // Applies to all RPCs of a caller
+	PerMethod              map[string]RateLimit `yaml:"per_method"`               // Per caller limits keyed by RPC name (e.g. "CreateVM")
+	MaxConcurrentMutations int                  `yaml:"max_concurrent_mutations"` // In-flight CreateVM/DeleteVM across all callers
+}
+
+// RateLimit is a token bucket refilled at Rate requests per second that
+// allows bursts of up to Burst requests
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// IdempotencyConfig controls how long the results of mutating calls with a
+// request_id are remembered for retries
+type IdempotencyConfig struct {
+	TTL     time.Duration `yaml:"ttl"`      // How long a result is replayed
+	MaxKeys int           `yaml:"max_keys"` // Results kept, the oldest are forgotten first
+}
+
+// HealthConfig controls the dependency checks reported by HealthCheck, the
+// grpc.health.v1 service and the metrics /health endpoint
+type HealthConfig struct {
+	CheckInterval     time.Duration `yaml:"check_interval"`     // How often the checks run
+	MinFreeDiskMB     int64         `yaml:"min_free_disk_mb"`   // Free space required in storage.vms_dir
+	KVMDevice         string        `yaml:"kvm_device"`         // Device checked for KVM access
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"` // How often VM state is reconciled
+}
+
+// EventsConfig controls the VM event journal. Events are numbered and the
+// most recent ones are kept so WatchVMEvents clients can resume after a
+// disconnect.
+type EventsConfig struct {
+	JournalDir       string `yaml:"journal_dir"`       // Directory of the on-disk journal segments
+	MaxEvents        int    `yaml:"max_events"`        // Events retained for replay
+	SubscriberBuffer int    `yaml:"subscriber_buffer"` // Events queued per watcher before it catches up from the journal
+}
+
+// WebhooksConfig configures the sinks VM events are pushed to. Sinks
+// deliver from the event journal and persist how far they got, so events
+// raised while a sink or the agent is down are delivered afterwards.
+type WebhooksConfig struct {
+	StateDir string              `yaml:"state_dir"` // Delivery cursor of each sink
+	Sinks    []WebhookSinkConfig `yaml:"sinks"`
+}
+
+// WebhookSinkConfig is an endpoint VM events are POSTed to as JSON
+type WebhookSinkConfig struct {
+	Name           string        `yaml:"name"`
+	URL            string        `yaml:"url"`
+	SecretFile     string        `yaml:"secret_file"`     // HMAC-SHA256 key signing each delivery
+	EventTypes     []string      `yaml:"event_types"`     // e.g. ["CRASHED", "DELETED"], all if empty
+	Timeout        time.Duration `yaml:"timeout"`         // Per request
+	MaxAttempts    int           `yaml:"max_attempts"`    // Attempts before an event is dropped, 0 = retry until delivered
+	InitialBackoff time.Duration `yaml:"initial_backoff"` // Delay after the first failed attempt
+	MaxBackoff     time.Duration `yaml:"max_backoff"`     // Cap of the doubling delay
 }
 
 type LogConfig struct {
@@ -73,6 +271,52 @@ func Load(path string) (*Config, error) {
 	if cfg.Server.Port == 0 {
 		cfg.Server.Port = 50051
 	}
+	if len(cfg.Server.Listeners) == 0 {
+		cfg.Server.Listeners = []ListenerConfig{{
+			Network: "tcp",
+			Address: net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port)),
+		}}
+	}
+	for i := range cfg.Server.Listeners {
+		if err := cfg.Server.Listeners[i].setDefaults(); err != nil {
+			return nil, fmt.Errorf("server.listeners[%d]: %w", i, err)
+		}
+	}
+	if cfg.Server.Gateway.Address == "" {
+		cfg.Server.Gateway.Address = net.JoinHostPort(cfg.Server.Host, "8080")
+	}
+	if cfg.Server.TLS.ReloadInterval == 0 {
+		cfg.Server.TLS.ReloadInterval = 30 * time.Second
+	}
+	if cfg.Server.TLS.Enabled {
+		if cfg.Server.TLS.CertFile == "" || cfg.Server.TLS.KeyFile == "" {
+			return nil, fmt.Errorf("server.tls requires cert_file and key_file")
+		}
+		if cfg.Server.TLS.RequireClientCert && cfg.Server.TLS.ClientCAFile == "" {
+			return nil, fmt.Errorf("server.tls.require_client_cert requires client_ca_file")
+		}
+	}
+	if cfg.Authorization.Enabled && cfg.Authorization.PolicyFile == "" {
+		return nil, fmt.Errorf("authorization requires policy_file")
+	}
+	if err := cfg.Authentication.setDefaults(); err != nil {
+		return nil, err
+	}
+	if cfg.Audit.Path == "" {
+		cfg.Audit.Path = "/var/log/fc-agent/audit.jsonl"
+	}
+	if cfg.Audit.MaxSizeMB == 0 {
+		cfg.Audit.MaxSizeMB = 100
+	}
+	if cfg.Audit.MaxFiles == 0 {
+		cfg.Audit.MaxFiles = 10
+	}
+	if err := cfg.RateLimit.setDefaults(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Webhooks.setDefaults(); err != nil {
+		return nil, err
+	}
 	if cfg.Log.Level == "" {
 		cfg.Log.Level = "info"
 	}
@@ -94,6 +338,57 @@ func Load(path string) (*Config, error) {
 	if cfg.Monitoring.MetricsPort == 0 {
 		cfg.Monitoring.MetricsPort = 9090
 	}
+	if cfg.Monitoring.VMMetricsInterval == 0 {
+		cfg.Monitoring.VMMetricsInterval = 15 * time.Second
+	}
+	if cfg.Monitoring.VMStatsInterval == 0 {
+		cfg.Monitoring.VMStatsInterval = 10 * time.Second
+	}
+	if cfg.Capacity.CPUOvercommitRatio == 0 {
+		cfg.Capacity.CPUOvercommitRatio = 4.0
+	}
+	if cfg.Capacity.MemoryOvercommitRatio == 0 {
+		cfg.Capacity.MemoryOvercommitRatio = 1.0 // Guest memory is not overcommitted by default
+	}
+	if cfg.Capacity.ReservedMemoryMB == 0 {
+		cfg.Capacity.ReservedMemoryMB = 1024
+	}
+	if cfg.Idempotency.TTL == 0 {
+		cfg.Idempotency.TTL = 10 * time.Minute
+	}
+	if cfg.Idempotency.MaxKeys == 0 {
+		cfg.Idempotency.MaxKeys = 10000
+	}
+	if cfg.Idempotency.TTL < 0 || cfg.Idempotency.MaxKeys < 0 {
+		return nil, fmt.Errorf("idempotency.ttl and idempotency.max_keys must be positive")
+	}
+	if cfg.Health.CheckInterval == 0 {
+		cfg.Health.CheckInterval = 15 * time.Second
+	}
+	if cfg.Health.MinFreeDiskMB == 0 {
+		cfg.Health.MinFreeDiskMB = 1024
+	}
+	if cfg.Health.KVMDevice == "" {
+		cfg.Health.KVMDevice = "/dev/kvm"
+	}
+	if cfg.Health.ReconcileInterval == 0 {
+		cfg.Health.ReconcileInterval = 30 * time.Second
+	}
+	if cfg.Events.JournalDir == "" {
+		cfg.Events.JournalDir = "/var/lib/fc-agent/events"
+	}
+	if cfg.Events.MaxEvents == 0 {
+		cfg.Events.MaxEvents = 10000
+	}
+	if cfg.Events.SubscriberBuffer == 0 {
+		cfg.Events.SubscriberBuffer = 100
+	}
+	if cfg.Events.MaxEvents < 0 || cfg.Events.SubscriberBuffer < 0 {
+		return nil, fmt.Errorf("events.max_events and events.subscriber_buffer must be positive")
+	}
+	if cfg.Placement.SysfsRoot == "" {
+		cfg.Placement.SysfsRoot = "/sys"
+	}
 	// Default jailer configuration - enabled by default for security
 	if cfg.Firecracker.UseJailer == nil {
 		defaultTrue := true
@@ -105,6 +400,163 @@ func Load(path string) (*Config, error) {
 	if cfg.Firecracker.JailGID == 0 {
 		cfg.Firecracker.JailGID = 1000 // Default to non-privileged group
 	}
+	if cfg.Firecracker.CgroupRoot == "" {
+		cfg.Firecracker.CgroupRoot = "/sys/fs/cgroup"
+	}
+	if cfg.Firecracker.CgroupParent == "" {
+		cfg.Firecracker.CgroupParent = "firecracker"
+	}
+	// The jailer always places VMs in a cgroup; without it the agent only
+	// creates one when limits are configured or requested
+	limits := &cfg.Firecracker.ResourceLimits
+	if *cfg.Firecracker.UseJailer || *limits != (ResourceLimitsConfig{}) {
+		if limits.CPUQuotaPercent == 0 {
+			limits.CPUQuotaPercent = 100 // One host CPU per vCPU
+		}
+		if limits.CPUPeriodUs == 0 {
+			limits.CPUPeriodUs = 100000
+		}
+		if limits.MemoryOverheadMB == 0 {
+			limits.MemoryOverheadMB = 64 // VMM overhead on top of guest memory
+		}
+	}
 
 	return &cfg, nil
 }
+
+// setDefaults fills in and validates a listener
+func (l *ListenerConfig) setDefaults() error {
+	if l.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+
+	switch l.Network {
+	case "tcp":
+		if l.Mode != "" || l.Group != "" {
+			return fmt.Errorf("mode and group only apply to unix listeners")
+		}
+	case "unix":
+		if !filepath.IsAbs(l.Address) {
+			return fmt.Errorf("unix socket path must be absolute")
+		}
+		if l.Mode == "" {
+			l.Mode = "0660"
+		}
+		if _, err := l.FileMode(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported network %q, must be tcp or unix", l.Network)
+	}
+
+	return nil
+}
+
+// setDefaults fills in and validates the authentication settings
+func (a *AuthenticationConfig) setDefaults() error {
+	for _, key := range a.APIKeys {
+		if key.Name == "" {
+			return fmt.Errorf("authentication.api_keys entries require a name")
+		}
+		if len(key.SHA256) != 64 {
+			return fmt.Errorf("API key %q: sha256 must be a hex encoded SHA-256 hash", key.Name)
+		}
+	}
+
+	if a.JWT.Enabled && a.JWT.JWKSFile == "" {
+		return fmt.Errorf("authentication.jwt requires jwks_file")
+	}
+	if a.JWT.SubjectClaim == "" {
+		a.JWT.SubjectClaim = "sub"
+	}
+	if a.JWT.GroupsClaim == "" {
+		a.JWT.GroupsClaim = "groups"
+	}
+	if a.JWT.Leeway == 0 {
+		a.JWT.Leeway = 30 * time.Second
+	}
+
+	return nil
+}
+
+// setDefaults fills in and validates the rate limit settings
+func (r *RateLimitConfig) setDefaults() error {
+	if r.PerPrincipal.Rate == 0 && r.PerPrincipal.Burst == 0 {
+		r.PerPrincipal = RateLimit{Rate: 10, Burst: 20}
+	}
+	if err := r.PerPrincipal.setDefaults(); err != nil {
+		return fmt.Errorf("rate_limit.per_principal: %w", err)
+	}
+	for method, limit := range r.PerMethod {
+		if err := limit.setDefaults(); err != nil {
+			return fmt.Errorf("rate_limit.per_method.%s: %w", method, err)
+		}
+		r.PerMethod[method] = limit
+	}
+
+	if r.MaxConcurrentMutations < 0 {
+		return fmt.Errorf("rate_limit.max_concurrent_mutations must not be negative")
+	}
+	if r.MaxConcurrentMutations == 0 {
+		r.MaxConcurrentMutations = 4
+	}
+
+	return nil
+}
+
+// setDefaults validates a token bucket and defaults its burst to one second
+// worth of requests
+func (l *RateLimit) setDefaults() error {
+	if l.Rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	if l.Burst == 0 {
+		l.Burst = int(math.Ceil(l.Rate))
+	}
+	return nil
+}
+
+// setDefaults fills in and validates the webhook sinks
+func (w *WebhooksConfig) setDefaults() error {
+	if w.StateDir == "" {
+		w.StateDir = "/var/lib/fc-agent/webhooks"
+	}
+
+	seen := make(map[string]bool)
+	for i := range w.Sinks {
+		sink := &w.Sinks[i]
+		// The name is used as the cursor file name
+		if sink.Name == "" || sink.Name != filepath.Base(sink.Name) || sink.Name[0] == '.' {
+			return fmt.Errorf("webhooks.sinks[%d] requires a name usable as a file name", i)
+		}
+		if seen[sink.Name] {
+			return fmt.Errorf("duplicate webhook sink %q", sink.Name)
+		}
+		seen[sink.Name] = true
+
+		u, err := url.Parse(sink.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook sink %q: url must be an http or https URL", sink.Name)
+		}
+		if sink.SecretFile == "" {
+			return fmt.Errorf("webhook sink %q requires secret_file", sink.Name)
+		}
+		if sink.Timeout < 0 || sink.MaxAttempts < 0 || sink.InitialBackoff < 0 || sink.MaxBackoff < 0 {
+			return fmt.Errorf("webhook sink %q: timeout, max_attempts and backoffs must not be negative", sink.Name)
+		}
+		if sink.Timeout == 0 {
+			sink.Timeout = 10 * time.Second
+		}
+		if sink.InitialBackoff == 0 {
+			sink.InitialBackoff = time.Second
+		}
+		if sink.MaxBackoff == 0 {
+			sink.MaxBackoff = 5 * time.Minute
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -93,6 +94,23 @@ firecracker:
 	assert.Equal(t, "vmtap", cfg.Network.TapPrefix)
 	assert.Equal(t, "/srv/firecracker/vms", cfg.Storage.VMsDir)
 	assert.Equal(t, 9090, cfg.Monitoring.MetricsPort)
+	assert.Equal(t, 15*time.Second, cfg.Monitoring.VMMetricsInterval)
+	assert.Equal(t, 10*time.Second, cfg.Monitoring.VMStatsInterval)
+	assert.Equal(t, "/sys/fs/cgroup", cfg.Firecracker.CgroupRoot)
+	assert.Equal(t, "firecracker", cfg.Firecracker.CgroupParent)
+	assert.Equal(t, 100, cfg.Firecracker.ResourceLimits.CPUQuotaPercent)
+	assert.Equal(t, int64(100000), cfg.Firecracker.ResourceLimits.CPUPeriodUs)
+	assert.Equal(t, int64(64), cfg.Firecracker.ResourceLimits.MemoryOverheadMB)
+	assert.False(t, cfg.Placement.Enabled)
+	assert.Equal(t, "/sys", cfg.Placement.SysfsRoot)
+	assert.Equal(t, 4.0, cfg.Capacity.CPUOvercommitRatio)
+	assert.Equal(t, 1.0, cfg.Capacity.MemoryOvercommitRatio)
+	assert.Zero(t, cfg.Capacity.ReservedHostCPUs)
+	assert.Equal(t, int64(1024), cfg.Capacity.ReservedMemoryMB)
+	assert.False(t, cfg.Audit.Enabled)
+	assert.Equal(t, "/var/log/fc-agent/audit.jsonl", cfg.Audit.Path)
+	assert.Equal(t, 100, cfg.Audit.MaxSizeMB)
+	assert.Equal(t, 10, cfg.Audit.MaxFiles)
 }
 
 func TestLoad_InvalidYAML(t *testing.T) {
@@ -162,3 +180,230 @@ network:
 	assert.Equal(t, "vmtap", cfg.Network.TapPrefix)
 	assert.Equal(t, "info", cfg.Log.Level)
 }
+
+func TestLoad_TLSConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "tls-config.yaml")
+
+	configContent := `
+server:
+  tls:
+    enabled: true
+    cert_file: "/etc/fc-agent/server.crt"
+    key_file: "/etc/fc-agent/server.key"
+    client_ca_file: "/etc/fc-agent/ca.crt"
+    require_client_cert: true
+`
+
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.TLS.Enabled)
+	assert.Equal(t, "/etc/fc-agent/server.crt", cfg.Server.TLS.CertFile)
+	assert.Equal(t, "/etc/fc-agent/ca.crt", cfg.Server.TLS.ClientCAFile)
+	assert.True(t, cfg.Server.TLS.RequireClientCert)
+	assert.Equal(t, 30*time.Second, cfg.Server.TLS.ReloadInterval)
+}
+
+func TestLoad_TLSConfigInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing key": `
+server:
+  tls:
+    enabled: true
+    cert_file: "/etc/fc-agent/server.crt"
+`,
+		"client cert without CA": `
+server:
+  tls:
+    enabled: true
+    cert_file: "/etc/fc-agent/server.crt"
+    key_file: "/etc/fc-agent/server.key"
+    require_client_cert: true
+`,
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+			_, err := Load(configPath)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_AuthenticationConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `
+authentication:
+  api_keys:
+    - name: "ci-bot"
+      groups: ["ci"]
+      sha256: "4bc453b53cb3d914b45f4b250294236adba2c0e09ff6f03793949e7e39fd4cc1"
+  jwt:
+    enabled: true
+    jwks_file: "/etc/fc-agent/jwks.json"
+    audience: "fc-agent"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	require.Len(t, cfg.Authentication.APIKeys, 1)
+	assert.Equal(t, "ci-bot", cfg.Authentication.APIKeys[0].Name)
+	assert.Equal(t, []string{"ci"}, cfg.Authentication.APIKeys[0].Groups)
+	assert.Equal(t, "sub", cfg.Authentication.JWT.SubjectClaim)
+	assert.Equal(t, "groups", cfg.Authentication.JWT.GroupsClaim)
+	assert.Equal(t, 30*time.Second, cfg.Authentication.JWT.Leeway)
+
+	for _, invalid := range []string{
+		"authentication:\n  api_keys:\n    - name: \"k\"\n      sha256: \"abc\"\n",
+		"authentication:\n  api_keys:\n    - sha256: \"4bc453b53cb3d914b45f4b250294236adba2c0e09ff6f03793949e7e39fd4cc1\"\n",
+		"authentication:\n  jwt:\n    enabled: true\n",
+	} {
+		require.NoError(t, os.WriteFile(configPath, []byte(invalid), 0644))
+		_, err := Load(configPath)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLoad_RateLimitConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `
+rate_limit:
+  enabled: true
+  per_method:
+    CreateVM:
+      rate: 0.5
+    ListVMs:
+      rate: 5
+      burst: 10
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.RateLimit.Enabled)
+	assert.Equal(t, RateLimit{Rate: 10, Burst: 20}, cfg.RateLimit.PerPrincipal)
+	assert.Equal(t, RateLimit{Rate: 0.5, Burst: 1}, cfg.RateLimit.PerMethod["CreateVM"])
+	assert.Equal(t, RateLimit{Rate: 5, Burst: 10}, cfg.RateLimit.PerMethod["ListVMs"])
+	assert.Equal(t, 4, cfg.RateLimit.MaxConcurrentMutations)
+
+	for _, invalid := range []string{
+		"rate_limit:\n  per_principal:\n    burst: 5\n",
+		"rate_limit:\n  per_method:\n    CreateVM:\n      rate: -1\n",
+		"rate_limit:\n  max_concurrent_mutations: -1\n",
+	} {
+		require.NoError(t, os.WriteFile(configPath, []byte(invalid), 0644))
+		_, err := Load(configPath)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLoad_ResourceLimitsWithoutJailer(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	// Without the jailer and limits, VMs get no cgroup
+	require.NoError(t, os.WriteFile(configPath, []byte("firecracker:\n  use_jailer: false\n"), 0644))
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, ResourceLimitsConfig{}, cfg.Firecracker.ResourceLimits)
+
+	// Configuring any limit opts in to the defaults
+	require.NoError(t, os.WriteFile(configPath, []byte("firecracker:\n  use_jailer: false\n  resource_limits:\n    pids_max: 128\n"), 0644))
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, ResourceLimitsConfig{
+		CPUQuotaPercent:  100,
+		CPUPeriodUs:      100000,
+		MemoryOverheadMB: 64,
+		PidsMax:          128,
+	}, cfg.Firecracker.ResourceLimits)
+}
+
+func TestLoad_WebhooksConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `
+webhooks:
+  sinks:
+    - name: orchestrator
+      url: https://orchestrator.example.com/hooks/fc
+      secret_file: /etc/fc-agent/webhook.secret
+      event_types: [CRASHED, DELETED]
+      max_attempts: 5
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/fc-agent/webhooks", cfg.Webhooks.StateDir)
+	require.Len(t, cfg.Webhooks.Sinks, 1)
+	sink := cfg.Webhooks.Sinks[0]
+	assert.Equal(t, []string{"CRASHED", "DELETED"}, sink.EventTypes)
+	assert.Equal(t, 5, sink.MaxAttempts)
+	assert.Equal(t, 10*time.Second, sink.Timeout)
+	assert.Equal(t, time.Second, sink.InitialBackoff)
+	assert.Equal(t, 5*time.Minute, sink.MaxBackoff)
+
+	for _, invalid := range []string{
+		"webhooks:\n  sinks:\n    - url: https://a.example.com\n      secret_file: /s\n",
+		"webhooks:\n  sinks:\n    - name: ../a\n      url: https://a.example.com\n      secret_file: /s\n",
+		"webhooks:\n  sinks:\n    - name: a\n      url: ftp://a.example.com\n      secret_file: /s\n",
+		"webhooks:\n  sinks:\n    - name: a\n      url: https://a.example.com\n",
+		"webhooks:\n  sinks:\n    - name: a\n      url: https://a.example.com\n      secret_file: /s\n      max_attempts: -1\n",
+		"webhooks:\n  sinks:\n    - name: a\n      url: https://a.example.com\n      secret_file: /s\n    - name: a\n      url: https://b.example.com\n      secret_file: /s\n",
+	} {
+		require.NoError(t, os.WriteFile(configPath, []byte(invalid), 0644))
+		_, err := Load(configPath)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLoad_ListenersConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	// Without listeners the host and port are used
+	require.NoError(t, os.WriteFile(configPath, []byte("server:\n  host: \"127.0.0.1\"\n  port: 6000\n"), 0644))
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []ListenerConfig{{Network: "tcp", Address: "127.0.0.1:6000"}}, cfg.Server.Listeners)
+	assert.False(t, cfg.Server.Gateway.Enabled)
+	assert.Equal(t, EventsConfig{JournalDir: "/var/lib/fc-agent/events", MaxEvents: 10000, SubscriberBuffer: 100}, cfg.Events)
+	assert.Equal(t, IdempotencyConfig{TTL: 10 * time.Minute, MaxKeys: 10000}, cfg.Idempotency)
+	assert.Equal(t, "127.0.0.1:8080", cfg.Server.Gateway.Address)
+
+	configContent := `
+server:
+  listeners:
+    - network: tcp
+      address: "10.0.0.1:50051"
+    - network: unix
+      address: "/run/fc-agent/agent.sock"
+      group: "fc-agent"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	require.Len(t, cfg.Server.Listeners, 2)
+	unixListener := cfg.Server.Listeners[1]
+	assert.Equal(t, "0660", unixListener.Mode)
+	assert.Equal(t, "fc-agent", unixListener.Group)
+	mode, err := unixListener.FileMode()
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), mode)
+
+	for _, invalid := range []string{
+		"server:\n  listeners:\n    - network: udp\n      address: \":1\"\n",
+		"server:\n  listeners:\n    - network: tcp\n",
+		"server:\n  listeners:\n    - network: unix\n      address: \"agent.sock\"\n",
+		"server:\n  listeners:\n    - network: unix\n      address: \"/run/agent.sock\"\n      mode: \"0999\"\n",
+		"server:\n  listeners:\n    - network: tcp\n      address: \":1\"\n      mode: \"0600\"\n",
+	} {
+		require.NoError(t, os.WriteFile(configPath, []byte(invalid), 0644))
+		_, err := Load(configPath)
+		assert.Error(t, err, invalid)
+	}
+}